@@ -0,0 +1,60 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/report"
+	"time"
+)
+
+// An analyticsEngine totals the cached Sold Items report for the stats and
+// aggregation endpoints. It is chosen with -analytics.
+type analyticsEngine interface {
+	// Statistics() computes the stats served by /api/stats/sold_items.
+	Statistics(from time.Time, to time.Time, n int) (*report.Stats, error)
+	// Items() totals the quantity and sales of each item sold from through to.
+	Items(from time.Time, to time.Time) ([]report.Summary, error)
+}
+
+// analyticsEngines creates each engine by the name given to -analytics.
+// Engines with extra dependencies add themselves when built with their tag.
+var analyticsEngines = map[string]func() (analyticsEngine, error){
+	"go": func() (analyticsEngine, error) { return goEngine{}, nil },
+}
+
+// analyzer is the engine in use.
+var analyzer analyticsEngine = goEngine{}
+
+// goEngine parses every cached version it needs on each request.
+type goEngine struct{}
+
+func (goEngine) Statistics(from time.Time, to time.Time, n int) (*report.Stats, error) {
+	snapshots, err := soldItemsSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	return report.Statistics(snapshots, from, to, n), nil
+}
+
+func (goEngine) Items(from time.Time, to time.Time) ([]report.Summary, error) {
+	snapshots, err := soldItemsSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	window := func(d time.Time) string {
+		if d.Before(from) || d.After(to) {
+			return ""
+		}
+		return from.Format(report.DateLayout)
+	}
+
+	var items []report.Summary
+	for _, s := range report.Summarize(snapshots, window, report.ItemColumns) {
+		if s.Period != "" {
+			s.Period = ""
+			items = append(items, s)
+		}
+	}
+
+	return items, nil
+}
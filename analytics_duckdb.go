@@ -0,0 +1,217 @@
+//go:build duckdb
+// +build duckdb
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	_ "github.com/marcboeker/go-duckdb"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The DuckDB engine is only built with -tags duckdb as it links DuckDB itself.
+func init() {
+	analyticsEngines["duckdb"] = newDuckEngine
+}
+
+// duckEngine totals the cached Sold Items report with DuckDB.
+// Every cached version of a report is registered as a view reading the
+// version CSVs directly, so new downloads are picked up without reloading.
+type duckEngine struct {
+	db  *sql.DB
+	dir string // The absolute path of the cache directory.
+}
+
+func newDuckEngine() (analyticsEngine, error) {
+	dir, err := filepath.Abs(cache.Dir())
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("duckdb", "")
+	if err != nil {
+		return nil, err
+	}
+
+	return &duckEngine{db: db, dir: dir}, nil
+}
+
+func (e *duckEngine) Statistics(from time.Time, to time.Time, n int) (*report.Stats, error) {
+	items, err := e.total(from, to, report.ItemColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	departments, err := e.total(from, to, report.DepartmentColumns)
+	if err != nil {
+		return nil, err
+	}
+
+	return report.NewStats(from, to, items, departments, n), nil
+}
+
+func (e *duckEngine) Items(from time.Time, to time.Time) ([]report.Summary, error) {
+	return e.total(from, to, report.ItemColumns)
+}
+
+// Totals the quantity and sales of each group sold from through to.
+// As with report.Summarize(), each version is counted for the days
+// report.Tile() picked it for that are inside the window, each day a
+// share of its whole range.
+func (e *duckEngine) total(from time.Time, to time.Time, group []string) ([]report.Summary, error) {
+	tiles, err := e.tiles()
+	if err != nil || len(tiles) == 0 {
+		return nil, err
+	}
+
+	if err := e.register("sold_items"); err != nil {
+		return nil, err
+	}
+
+	columns, err := e.columns("sold_items")
+	if err != nil {
+		return nil, err
+	}
+	g, q, a := findColumn(columns, group), findColumn(columns, report.QuantityColumns), findColumn(columns, report.SalesColumns)
+	if g == "" {
+		return nil, nil
+	}
+
+	first, last := duckString(from.Format(report.DateLayout)), duckString(to.Format(report.DateLayout))
+	query := `
+		WITH tiles(path, first, last, days) AS (VALUES ` + strings.Join(tiles, ", ") + `),
+		shares AS (
+			SELECT path, (date_diff('day', greatest(first, DATE ` + first + `), least(last, DATE ` + last + `)) + 1)::DOUBLE
+				/ days AS share
+			FROM tiles
+			WHERE first <= DATE ` + last + ` AND last >= DATE ` + first + `
+		)
+		SELECT s.` + duckName(g) + `, SUM(` + duckNumber(q) + ` * share), SUM(` + duckNumber(a) + ` * share)
+		FROM sold_items s JOIN shares ON s.filename = shares.path
+		GROUP BY 1
+		ORDER BY 1`
+
+	rows, err := e.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []report.Summary
+	for rows.Next() {
+		var s report.Summary
+		var name sql.NullString
+		if err := rows.Scan(&name, &s.Quantity, &s.Sales); err != nil {
+			return nil, err
+		}
+		s.Group = name.String
+		summaries = append(summaries, s)
+	}
+
+	return summaries, rows.Err()
+}
+
+// Returns the runs of consecutive days report.Tile() counts each Sold Items
+// version for as rows of a VALUES list, with the number of days the
+// version covers. Only the manifest is read; DuckDB reads the files.
+func (e *duckEngine) tiles() ([]string, error) {
+	versions, err := cache.Versions("sold_items")
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []report.Snapshot
+	paths := make(map[time.Time]string)
+	for _, v := range versions {
+		start, err1 := businessDate(v.Start)
+		end, err2 := businessDate(v.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		snapshots = append(snapshots, report.Snapshot{Start: start, End: end, Fetched: v.Time})
+		paths[v.Time] = filepath.Join(e.dir, filepath.FromSlash(v.Path))
+	}
+
+	var tiles []string
+	for _, s := range report.Tile(snapshots, businessZone) {
+		days := int(s.End.Sub(s.Start).Hours()/24+0.5) + 1
+		for i := 0; i < len(s.Days); {
+			j := i
+			for j+1 < len(s.Days) && s.Days[j+1].Equal(s.Days[j].AddDate(0, 0, 1)) {
+				j++
+			}
+			tiles = append(tiles, "("+duckString(paths[s.Fetched])+", DATE "+duckString(s.Days[i].Format(report.DateLayout))+
+				", DATE "+duckString(s.Days[j].Format(report.DateLayout))+", "+strconv.Itoa(days)+")")
+			i = j + 1
+		}
+	}
+
+	return tiles, nil
+}
+
+// Registers the versions of report name as a view of the same name.
+// It is repeated before each query since DuckDB refuses to create a view
+// over a report that has not been downloaded yet.
+func (e *duckEngine) register(name string) error {
+	versions := filepath.Join(e.dir, "versions", name, "*.csv")
+	_, err := e.db.Exec(`CREATE OR REPLACE VIEW ` + name + ` AS SELECT * FROM read_csv_auto(` + duckString(versions) +
+		`, filename = true, union_by_name = true, all_varchar = true)`)
+	if err != nil {
+		return errors.New("Failed to register " + name + " with DuckDB. " + err.Error())
+	}
+
+	return nil
+}
+
+// Returns the column names of view.
+func (e *duckEngine) columns(view string) ([]string, error) {
+	rows, err := e.db.Query(`SELECT * FROM ` + view + ` LIMIT 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rows.Columns()
+}
+
+// Returns the first of names in columns, ignoring case, or "" if there is none.
+// This matches report.Table.Find().
+func findColumn(columns []string, names []string) string {
+	for _, n := range names {
+		for _, c := range columns {
+			if strings.EqualFold(c, n) {
+				return c
+			}
+		}
+	}
+
+	return ""
+}
+
+// Quotes a string literal.
+func duckString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// Quotes a column name.
+func duckName(s string) string {
+	return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
+}
+
+// Parses column as a number the way report.Number() does, or 0 if column is "".
+func duckNumber(column string) string {
+	if column == "" {
+		return "0"
+	}
+
+	c := "s." + duckName(column)
+	return `coalesce(
+		CASE WHEN trim(` + c + `) LIKE '(%)' THEN -1 ELSE 1 END *
+		TRY_CAST(regexp_replace(` + c + `, '[$,() ]', '', 'g') AS DOUBLE), 0)`
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	"log"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// The number of earlier versions a report is compared against.
+const anomalyHistory = 8
+
+// checkAnomalies() compares the version of r just stored with its recent
+// history and alerts when its row count or total sales are far off, or when
+// it has no sales for a day the market was open. Alerts are logged,
+// published as report.anomaly events and emailed to -alertto.
+func checkAnomalies(ctx context.Context, r *reportDefinition, v store.Version) {
+	versions, err := cache.Versions(r.Name)
+	if err != nil {
+		return
+	}
+
+	t, err := readTable(cache.FilePath(v))
+	if err != nil {
+		logFor(ctx).Println("Failed to read " + r.Name + " to check it. Error: " + err.Error())
+		return
+	}
+	m := report.Measure(t)
+
+	var history []report.Metrics
+	for i := len(versions) - 1; i >= 0 && len(history) < anomalyHistory; i-- {
+		if versions[i].Time.Equal(v.Time) || !sameSpan(versions[i], v) {
+			continue
+		}
+		if h, err := readTable(cache.FilePath(versions[i])); err == nil {
+			history = append(history, report.Measure(h))
+		}
+	}
+
+	// A report of only days the market was closed is expected to have no
+	// sales, however much the weeks before had.
+	if !coversMarketDay(v) {
+		m.HasSales = false
+	}
+
+	anomalies := report.Deviations(m, history, *anomalyFactor)
+	if m.HasSales && m.Sales == 0 {
+		if day, ok := openMarketDay(v); ok {
+			anomalies = append(anomalies, "No sales although the market was open on "+day)
+		}
+	}
+
+	for _, a := range anomalies {
+		alert(r.Name, a)
+	}
+}
+
+// Reports whether versions a and b cover the same number of days, so their
+// figures can be compared.
+func sameSpan(a store.Version, b store.Version) bool {
+	return spanDays(a) == spanDays(b)
+}
+
+// Returns the number of days v covers, or 0 if it does not cover a range.
+func spanDays(v store.Version) int {
+	start, err1 := time.Parse(report.DateLayout, v.Start)
+	end, err2 := time.Parse(report.DateLayout, v.End)
+	if err1 != nil || err2 != nil {
+		return 0
+	}
+	return int(end.Sub(start).Hours() / 24)
+}
+
+// Reports whether the market was open on any day v covers, or v doesn't
+// cover a range of days.
+func coversMarketDay(v store.Version) bool {
+	start, err1 := time.Parse(report.DateLayout, v.Start)
+	end, err2 := time.Parse(report.DateLayout, v.End)
+	if err1 != nil || err2 != nil {
+		return true
+	}
+
+	for d := start; !d.After(end); d = d.AddDate(0, 0, 1) {
+		if marketOpen(d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a day v covers that the market was open and that has ended.
+// Today is skipped as the market may not have made a sale yet.
+func openMarketDay(v store.Version) (string, bool) {
+	start, err1 := businessDate(v.Start)
+	end, err2 := businessDate(v.End)
+	if err1 != nil || err2 != nil {
+		return "", false
+	}
+
+	today := businessToday()
+	for d := start; !d.After(end) && d.Before(today); d = d.AddDate(0, 0, 1) {
+		if marketOpen(d) {
+			return d.Format(report.DateLayout), true
+		}
+	}
+
+	return "", false
+}
+
+// alert() reports a problem with the contents of report name.
+func alert(name string, message string) {
+	log.Println("Anomaly in " + name + ": " + message)
+	events.publish(event{Type: eventAnomaly, Report: name, Error: message})
+	emailAlert("Problem with the "+name+" report", message)
+}
+
+// emailAlert() emails an alert to -alertto, if it is set.
+func emailAlert(subject string, message string) {
+	if *alertTo == "" || *smtpServer == "" {
+		return
+	}
+
+	to := splitList(strings.Replace(*alertTo, ",", ";", -1))
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", *mailFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "\r\n%s\r\n", message)
+
+	if err := smtp.SendMail(*smtpServer, smtpAuth(), *mailFrom, to, msg.Bytes()); err != nil {
+		log.Println("Failed to email alert. Error: " + err.Error())
+	}
+}
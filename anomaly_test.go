@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"github.com/jfmarket/report-cacher/clock"
+	"github.com/jfmarket/report-cacher/store"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckAnomaliesClosedDays(t *testing.T) {
+	oldCache, oldClock, oldZone := cache, wallClock, businessZone
+	defer func() { cache, wallClock, businessZone = oldCache, oldClock, oldZone }()
+	defer parseMarketDays("", "")
+	if err := parseMarketDays("Sat", ""); err != nil {
+		t.Fatal(err)
+	}
+	wallClock, businessZone = clock.NewFake(time.Date(2014, 3, 24, 9, 0, 0, 0, time.UTC)), time.UTC
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	r := lookupReport("sold_items")
+	busy := []byte("Item,Quantity,Net Sales\nTomatoes,10,$100.00\n")
+	quiet := []byte("Item,Quantity,Net Sales\nTomatoes,0,$0.00\n")
+	fetched := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+	save := func(data []byte, start string, end string) store.Version {
+		fetched = fetched.Add(time.Hour)
+		v, err := cache.Import("sold_items", data, start, end, fetched)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	for i := 0; i < 3; i++ {
+		save(busy, "2014-03-08", "2014-03-11")
+	}
+
+	tests := []struct {
+		start, end string
+		alerts     int
+	}{
+		{"2014-03-17", "2014-03-20", 0}, // Monday to Thursday. The market was closed.
+		{"2014-03-20", "2014-03-23", 2}, // Takes in Saturday: far from average, and no sales.
+	}
+	for _, tt := range tests {
+		ch := events.subscribe()
+		checkAnomalies(context.Background(), r, save(quiet, tt.start, tt.end))
+		events.unsubscribe(ch)
+
+		var alerts []string
+		for e := range ch {
+			if e.Type == eventAnomaly {
+				alerts = append(alerts, e.Error)
+			}
+		}
+		if len(alerts) != tt.alerts {
+			t.Errorf("No sales from %s to %s raised %q, want %d alerts", tt.start, tt.end, alerts, tt.alerts)
+		}
+	}
+}
+
+func TestOpenMarketDay(t *testing.T) {
+	oldClock, oldZone := wallClock, businessZone
+	defer func() { wallClock, businessZone = oldClock, oldZone }()
+	defer parseMarketDays("", "")
+	if err := parseMarketDays("Wed,Sat", ""); err != nil {
+		t.Fatal(err)
+	}
+	wallClock, businessZone = clock.NewFake(time.Date(2014, 3, 22, 9, 0, 0, 0, time.UTC)), time.UTC
+
+	for _, tt := range []struct {
+		start, end string
+		day        string
+		covers     bool
+	}{
+		{"2014-03-16", "2014-03-18", "", false},          // Sunday to Tuesday.
+		{"2014-03-16", "2014-03-22", "2014-03-19", true}, // Wednesday.
+		{"2014-03-20", "2014-03-22", "", true},           // Saturday, but it is still today.
+		{"", "", "", true},                               // Not a range of days.
+	} {
+		v := store.Version{Start: tt.start, End: tt.end}
+		if day, _ := openMarketDay(v); day != tt.day {
+			t.Errorf("openMarketDay(%s to %s) = %q, want %q", tt.start, tt.end, day, tt.day)
+		}
+		if covers := coversMarketDay(v); covers != tt.covers {
+			t.Errorf("coversMarketDay(%s to %s) = %v, want %v", tt.start, tt.end, covers, tt.covers)
+		}
+	}
+}
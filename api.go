@@ -0,0 +1,447 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportsHandler() routes requests under /api/reports.
+//     GET /api/reports
+//     GET /api/reports/sold_items
+//     GET /api/reports/sold_items/diff?from=<ts>&to=<ts>
+//     GET /api/reports/sold_items/view?format=pdf
+func reportsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/reports"), "/"), "/")
+	if parts[0] == "" {
+		writeJSON(w, currentStatus().Reports)
+		return
+	}
+
+	if lookupReport(parts[0]) == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch {
+	case len(parts) == 1:
+		rowsHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "diff":
+		diffHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "view":
+		viewHandler(w, r, parts[0])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// rowsHandler() serves the parsed rows of the current copy of a report.
+// For sold_items, from and to instead select the sales of each item over
+// those days, merged from every cached version. Either may be paged; see
+// paginate(). format=ndjson streams the rows instead; see ndjsonHandler().
+//     GET /api/reports/sold_items?from=2014-03-01&to=2014-03-31
+//     GET /api/reports/stock_items?page=2&page_size=500
+//     GET /api/reports/sold_items?format=ndjson
+func rowsHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if name == "sold_items" && (r.FormValue("from") != "" || r.FormValue("to") != "") {
+		soldItemsWindowHandler(w, r)
+		return
+	}
+	if r.FormValue("format") == "ndjson" {
+		ndjsonHandler(w, r, name)
+		return
+	}
+
+	h, err := currentReport(name)
+	if os.IsNotExist(err) {
+		http.Error(w, name+" has not been downloaded yet", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logFor(r.Context()).Println("Failed to read " + name + ". Error: " + err.Error())
+		http.Error(w, "Failed to read report", http.StatusInternalServerError)
+		return
+	}
+	t := h.Table
+
+	start, end, err := paginate(w, r, len(t.Rows))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows := make([]report.Row, 0, end-start)
+	for i := start; i < end; i++ {
+		rows = append(rows, t.Row(i))
+	}
+
+	setStaleHeaders(w, name)
+	writeJSON(w, struct {
+		Report  string       `json:"report"`
+		Updated time.Time    `json:"updated"`
+		Columns []string     `json:"columns"`
+		Rows    []report.Row `json:"rows"`
+	}{name, h.Version.Time, t.Header, rows})
+}
+
+// ndjsonHandler() streams the rows of the current copy of a report as
+// newline-delimited JSON, one object per row, as they are read from its
+// stored file rather than the hot copy, so serving a year of sold items
+// doesn't build every row at once. Encrypted and gzipped files are read
+// whole to decrypt them, but still parsed a row at a time.
+func ndjsonHandler(w http.ResponseWriter, r *http.Request, name string) {
+	versions, err := cache.Versions(name)
+	if err == nil && len(versions) == 0 {
+		err = os.ErrNotExist
+	}
+	var f io.ReadCloser
+	if err == nil {
+		f, err = cache.Open(cache.FilePath(versions[len(versions)-1]))
+	}
+	if os.IsNotExist(err) {
+		http.Error(w, name+" has not been downloaded yet", http.StatusNotFound)
+		return
+	} else if err != nil {
+		logFor(r.Context()).Println("Failed to read " + name + ". Error: " + err.Error())
+		http.Error(w, "Failed to read report", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	rows, err := report.NewReader(f)
+	if err != nil {
+		logFor(r.Context()).Println("Failed to read " + name + ". Error: " + err.Error())
+		http.Error(w, "Failed to read report", http.StatusInternalServerError)
+		return
+	}
+
+	setStaleHeaders(w, name)
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	for {
+		row, err := rows.Read()
+		if err == io.EOF {
+			return
+		}
+		// Once rows have been sent the status can't change, so failures are only logged.
+		if err == nil {
+			err = enc.Encode(row)
+		}
+		if err != nil {
+			logFor(r.Context()).Println("Failed to stream " + name + ". Error: " + err.Error())
+			return
+		}
+	}
+}
+
+// Serves the quantity and sales of each item sold between the from and to dates.
+func soldItemsWindowHandler(w http.ResponseWriter, r *http.Request) {
+	from, to, err := dateWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	items, err := analyzer.Items(from, to)
+	if err != nil {
+		logFor(r.Context()).Println("Failed to total sold items. Error: " + err.Error())
+		http.Error(w, "Failed to total sold items", http.StatusInternalServerError)
+		return
+	}
+
+	start, end, err := paginate(w, r, len(items))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	rows := []report.Row{}
+	for _, s := range items[start:end] {
+		rows = append(rows, report.Row{
+			"Item":     s.Group,
+			"Quantity": strconv.FormatFloat(s.Quantity, 'f', 2, 64),
+			"Sales":    strconv.FormatFloat(s.Sales, 'f', 2, 64),
+		})
+	}
+
+	writeJSON(w, struct {
+		Report  string       `json:"report"`
+		From    string       `json:"from"`
+		To      string       `json:"to"`
+		Columns []string     `json:"columns"`
+		Rows    []report.Row `json:"rows"`
+	}{"sold_items", from.Format(report.DateLayout), to.Format(report.DateLayout), []string{"Item", "Quantity", "Sales"}, rows})
+}
+
+// diffHandler() compares two cached versions of a report.
+// from and to are RFC 3339 timestamps or Unix seconds; the newest version at
+// or before each is used. When to is omitted the latest version is used, and
+// when from is omitted the version preceding to is used.
+func diffHandler(w http.ResponseWriter, r *http.Request, name string) {
+	versions, err := cache.Versions(name)
+	if err != nil {
+		logFor(r.Context()).Println("Failed to list versions of " + name + ". Error: " + err.Error())
+		http.Error(w, "Failed to list report versions", http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, "No cached versions of "+name, http.StatusNotFound)
+		return
+	}
+
+	to := versions[len(versions)-1]
+	if ts := r.FormValue("to"); ts != "" {
+		to, err = versionAt(name, ts)
+		if err != nil {
+			http.Error(w, "to: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var from store.Version
+	if ts := r.FormValue("from"); ts != "" {
+		from, err = versionAt(name, ts)
+		if err != nil {
+			http.Error(w, "from: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		from, err = cache.VersionAt(name, to.Time.Add(-time.Second))
+		if err != nil {
+			http.Error(w, "There is no version of "+name+" before "+to.Time.Format(time.RFC3339), http.StatusNotFound)
+			return
+		}
+	}
+
+	a, err := readTable(cache.FilePath(from))
+	if err != nil {
+		logFor(r.Context()).Println("Failed to read " + from.Path + ". Error: " + err.Error())
+		http.Error(w, "Failed to read report version", http.StatusInternalServerError)
+		return
+	}
+
+	b, err := readTable(cache.FilePath(to))
+	if err != nil {
+		logFor(r.Context()).Println("Failed to read " + to.Path + ". Error: " + err.Error())
+		http.Error(w, "Failed to read report version", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, struct {
+		Report string    `json:"report"`
+		From   time.Time `json:"from"`
+		To     time.Time `json:"to"`
+		*report.Diff
+	}{name, from.Time, to.Time, report.Compare(a, b, lookupReport(name).Key...)})
+}
+
+// statsHandler() serves summary statistics of the Sold Items report.
+//     GET /api/stats/sold_items?from=2014-03-01&to=2014-03-31&top=10
+// The window defaults to the week ending today and top to 10.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/stats/"), "/") != "sold_items" {
+		http.NotFound(w, r)
+		return
+	}
+
+	from, to, err := dateWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	top := 10
+	if n := r.FormValue("top"); n != "" {
+		top, err = strconv.Atoi(n)
+		if err != nil || top <= 0 {
+			http.Error(w, "top must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	stats, err := analyzer.Statistics(from, to, top)
+	if err != nil {
+		logFor(r.Context()).Println("Failed to compute sold items stats. Error: " + err.Error())
+		http.Error(w, "Failed to compute stats", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, stats)
+}
+
+// The most rows a page may hold.
+const maxPageSize = 10000
+
+// paginate() reads the page and page_size query parameters and returns the
+// range of n rows to serve, setting headers with the total and links to the
+// next and previous pages. Pages are numbered from 1 and page_size defaults
+// to 100. Without either parameter every row is served.
+func paginate(w http.ResponseWriter, r *http.Request, n int) (int, int, error) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(n))
+	if r.FormValue("page") == "" && r.FormValue("page_size") == "" {
+		return 0, n, nil
+	}
+
+	page, size := 1, 100
+	var err error
+	if p := r.FormValue("page"); p != "" {
+		if page, err = strconv.Atoi(p); err != nil || page < 1 {
+			return 0, 0, errors.New("page must be a positive number")
+		}
+	}
+	if p := r.FormValue("page_size"); p != "" {
+		if size, err = strconv.Atoi(p); err != nil || size < 1 || size > maxPageSize {
+			return 0, 0, errors.New("page_size must be between 1 and " + strconv.Itoa(maxPageSize))
+		}
+	}
+
+	pages := (n + size - 1) / size
+	w.Header().Set("X-Page", strconv.Itoa(page))
+	w.Header().Set("X-Page-Size", strconv.Itoa(size))
+	w.Header().Set("X-Total-Pages", strconv.Itoa(pages))
+
+	link := func(page int, rel string) string {
+		q := r.URL.Query()
+		q.Set("page", strconv.Itoa(page))
+		q.Set("page_size", strconv.Itoa(size))
+		return "<" + r.URL.Path + "?" + q.Encode() + `>; rel="` + rel + `"`
+	}
+	var links []string
+	if page < pages {
+		links = append(links, link(page+1, "next"))
+	}
+	if page > 1 && pages > 0 {
+		links = append(links, link(min(page-1, pages), "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	start := min((page-1)*size, n)
+	return start, min(start+size, n), nil
+}
+
+// Reads the from and to query parameters as YYYY-MM-DD dates, at midnight
+// in the market's timezone like the default: the week ending today.
+func dateWindow(r *http.Request) (time.Time, time.Time, error) {
+	today := businessToday()
+	from, to := today.AddDate(0, 0, -7), today
+
+	var err error
+	if d := r.FormValue("to"); d != "" {
+		if to, err = businessDate(d); err != nil {
+			return from, to, errors.New("to must be a date in the form YYYY-MM-DD")
+		}
+		from = to.AddDate(0, 0, -7)
+	}
+	if d := r.FormValue("from"); d != "" {
+		if from, err = businessDate(d); err != nil {
+			return from, to, errors.New("from must be a date in the form YYYY-MM-DD")
+		}
+	}
+
+	if to.Before(from) {
+		return from, to, errors.New("from must not be after to")
+	}
+
+	return from, to, nil
+}
+
+// statusHandler() reports what the download manager is doing and how fresh each report is.
+//     GET /api/status
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, currentStatus())
+}
+
+// refreshHandler() asks the download manager to update every report now,
+// returning the job doing so, whose progress is at /api/jobs/<id>.
+// force=true downloads reports even when a fresh copy is cached, such as
+// after a sale was voided.
+//     POST /api/refresh
+//     POST /api/refresh?force=true
+func refreshHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	j, queued := requestRefresh(r.FormValue("force") == "true")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/api/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	writeJSON(w, struct {
+		refreshJob
+		Queued bool `json:"queued"` // False when a job was already waiting, which is returned instead.
+	}{j, queued})
+}
+
+// pauseHandler() pauses or resumes scheduled updates.
+//     POST /api/pause
+//     POST /api/resume
+func pauseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	setPaused(r.URL.Path == "/api/pause")
+	writeJSON(w, currentStatus())
+}
+
+// Looks up the version of report name that was current at timestamp ts.
+func versionAt(name string, ts string) (store.Version, error) {
+	t, err := parseTimestamp(ts)
+	if err != nil {
+		return store.Version{}, err
+	}
+
+	v, err := cache.VersionAt(name, t)
+	if err == store.ErrNoVersion {
+		return v, errors.New("No version of " + name + " exists at " + ts)
+	}
+
+	return v, err
+}
+
+// Parses an RFC 3339 timestamp or a count of Unix seconds.
+func parseTimestamp(ts string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(ts, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		return t, errors.New("Invalid timestamp " + ts + ". Use RFC 3339 or Unix seconds.")
+	}
+
+	return t, nil
+}
+
+// writeJSON() writes v to w as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("Failed to write JSON response. Error: " + err.Error())
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/jfmarket/report-cacher/clock"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsHandler(t *testing.T) {
+	oldCache, oldClock, oldZone, oldAnalyzer := cache, wallClock, businessZone, analyzer
+	defer func() { cache, wallClock, businessZone, analyzer = oldCache, oldClock, oldZone, oldAnalyzer }()
+	wallClock, businessZone, analyzer = clock.NewFake(time.Date(2014, 3, 17, 9, 0, 0, 0, time.UTC)), time.UTC, goEngine{}
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("Item,Department,Quantity,Net Sales\nTomatoes,Produce,70,$140.00\nHoney,Pantry,7,$70.00\n")
+	if _, err := cache.Import("sold_items", data, "2014-03-10", "2014-03-16", time.Date(2014, 3, 17, 1, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, target string
+		status         int
+	}{
+		{"GET", "/api/stats/sold_items", 200},
+		{"GET", "/api/stats/sold_items?from=2014-03-10&to=2014-03-16&top=1", 200},
+		{"GET", "/api/stats/sold_items?top=0", 400},
+		{"GET", "/api/stats/sold_items?top=-1", 400},
+		{"GET", "/api/stats/sold_items?top=ten", 400},
+		{"GET", "/api/stats/sold_items?from=2014-03-16&to=2014-03-10", 400},
+		{"GET", "/api/stats/sold_items?from=March", 400},
+		{"GET", "/api/stats/customers", 404},
+		{"POST", "/api/stats/sold_items", 405},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		statsHandler(w, httptest.NewRequest(tt.method, tt.target, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s answered %d, want %d. %s", tt.method, tt.target, w.Code, tt.status, w.Body)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	statsHandler(w, httptest.NewRequest("GET", "/api/stats/sold_items?from=2014-03-10&to=2014-03-16&top=1", nil))
+	var st report.Stats
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Items != 2 || st.Sales != 210 || len(st.TopBySales) != 1 || st.TopBySales[0].Group != "Tomatoes" {
+		t.Errorf("Stats = %+v, want 2 items sold for $210, Tomatoes the top seller", st)
+	}
+
+	// The window defaults to the week ending today.
+	w = httptest.NewRecorder()
+	statsHandler(w, httptest.NewRequest("GET", "/api/stats/sold_items", nil))
+	if err := json.Unmarshal(w.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.From != "2014-03-10" || st.To != "2014-03-17" {
+		t.Errorf("Default window is %s to %s, want 2014-03-10 to 2014-03-17", st.From, st.To)
+	}
+}
+
+func TestDateWindow(t *testing.T) {
+	oldClock, oldZone := wallClock, businessZone
+	defer func() { wallClock, businessZone = oldClock, oldZone }()
+	// 01:00 on March 17 in UTC is still March 16 in the market's timezone.
+	wallClock, businessZone = clock.NewFake(time.Date(2014, 3, 17, 1, 0, 0, 0, time.UTC)), time.FixedZone("EDT", -4*60*60)
+	day := func(d int) time.Time { return time.Date(2014, 3, d, 0, 0, 0, 0, businessZone) }
+
+	tests := []struct {
+		query    string
+		from, to time.Time
+		ok       bool
+	}{
+		{"", day(9), day(16), true},
+		{"to=2014-03-16", day(9), day(16), true},
+		{"from=2014-03-09", day(9), day(16), true},
+		{"from=2014-03-01&to=2014-03-05", day(1), day(5), true},
+		{"from=2014-03-05&to=2014-03-05", day(5), day(5), true},
+		{"from=2014-03-20", time.Time{}, time.Time{}, false},
+		{"from=2014-03-06&to=2014-03-05", time.Time{}, time.Time{}, false},
+		{"to=March", time.Time{}, time.Time{}, false},
+		{"from=2014-3-5", time.Time{}, time.Time{}, false},
+	}
+	for _, tt := range tests {
+		from, to, err := dateWindow(httptest.NewRequest("GET", "/api/stats/sold_items?"+tt.query, nil))
+		if (err == nil) != tt.ok {
+			t.Errorf("dateWindow(%q) returned error %v, want ok %v", tt.query, err, tt.ok)
+			continue
+		}
+		if tt.ok && (!from.Equal(tt.from) || !to.Equal(tt.to)) {
+			t.Errorf("dateWindow(%q) = %v to %v, want %v to %v", tt.query, from, to, tt.from, tt.to)
+		}
+	}
+}
+
+func TestNDJSONHandler(t *testing.T) {
+	oldCache := cache
+	defer func() { cache = oldCache }()
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	ndjsonHandler(w, httptest.NewRequest("GET", "/api/reports/sold_items?format=ndjson", nil), "sold_items")
+	if w.Code != 404 {
+		t.Errorf("Before a download, answered %d, want 404", w.Code)
+	}
+
+	// The rows are read from the stored file, decrypted.
+	if _, err := cache.Save("sold_items", []byte("Item,Quantity\nTomatoes,70\nHoney,7\n"), "", ""); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	ndjsonHandler(w, httptest.NewRequest("GET", "/api/reports/sold_items?format=ndjson", nil), "sold_items")
+	want := `{"Item":"Tomatoes","Quantity":"70"}` + "\n" + `{"Item":"Honey","Quantity":"7"}` + "\n"
+	if w.Code != 200 || w.Body.String() != want {
+		t.Errorf("Answered %d %q, want 200 %q", w.Code, w.Body.String(), want)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"github.com/jfmarket/report-cacher/clock"
+	"github.com/jfmarket/report-cacher/store"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestArchiveMonth(t *testing.T) {
+	c := clock.NewFake(time.Date(2014, 4, 10, 12, 0, 0, 0, time.UTC))
+	oldCache, oldClock, oldZone, oldUpload := cache, wallClock, businessZone, *archiveUpload
+	defer func() { cache, wallClock, businessZone, *archiveUpload = oldCache, oldClock, oldZone, oldUpload }()
+	wallClock, businessZone = c, time.UTC
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.SetKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	var uploads [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		uploads = append(uploads, data)
+	}))
+	defer server.Close()
+	*archiveUpload = server.URL
+
+	var versions []store.Version
+	for _, day := range []string{"2014-03-05", "2014-03-28"} {
+		d, _ := time.Parse("2006-01-02", day)
+		v, err := cache.Import("sold_items", []byte("Item\n"+day+"\n"), day, day, d.Add(8*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	march := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Each step archives some versions, expecting the archive to then hold
+	// want and to have been uploaded uploaded times.
+	tests := []struct {
+		versions []store.Version
+		want     int
+		uploaded int
+	}{
+		{versions[:1], 1, 1},
+		{versions, 2, 2},
+		{versions, 2, 2},
+	}
+	for i, tt := range tests {
+		if err := archiveMonth(march, tt.versions); err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := cache.ReadFile(archivePath(march))
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest []store.Version
+		for _, f := range z.File {
+			if f.Name == "manifest.json" {
+				if err := readZipJSON(f, &manifest); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		if len(manifest) != tt.want || len(z.File) != tt.want+1 {
+			m, _ := json.Marshal(manifest)
+			t.Errorf("Step %d: archive holds %d files and manifest %s, want %d versions", i, len(z.File), m, tt.want)
+		}
+
+		// The upload is the archive as stored, encrypted like the cache.
+		if len(uploads) != tt.uploaded {
+			t.Errorf("Step %d: uploaded %d times, want %d", i, len(uploads), tt.uploaded)
+			continue
+		}
+		stored, _ := ioutil.ReadFile(archivePath(march))
+		if !bytes.Equal(uploads[len(uploads)-1], stored) || bytes.Equal(stored, data) {
+			t.Errorf("Step %d: uploaded archive isn't the encrypted one stored", i)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// An auditEntry records a request for report data.
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	User   string    `json:"user,omitempty"` // Empty when logins are disabled.
+	Remote string    `json:"remote"`         // The address of the client that made the request.
+	Report string    `json:"report"`         // The report requested, if one was.
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+}
+
+// auditRequests() wraps h so every request for report data is appended to
+// the audit log given by -audit, recording who downloaded what and when.
+// It must be wrapped by requireLogin() to know the user.
+func auditRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := auditedReport(r.URL.Path)
+		if *auditFile == "" || !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		h.ServeHTTP(sw, r)
+
+		e := auditEntry{
+			Time:   time.Now(),
+			Remote: clientIP(r),
+			Report: name,
+			Path:   r.URL.RequestURI(),
+			Status: sw.status,
+		}
+		if u := currentUser(r); u != nil {
+			e.User = u.name
+		}
+		writeAudit(r.Context(), e)
+	})
+}
+
+// Appends e to the audit log, logging failures to ctx's log.
+func writeAudit(ctx context.Context, e auditEntry) {
+	if err := appendLine(*auditFile, e); err != nil {
+		logFor(ctx).Println("Failed to write the audit log. Error: " + err.Error())
+	}
+}
+
+// Returns the report a request for urlPath reads, and whether it reads
+// report data at all. Dashboard files and the status API are not audited.
+func auditedReport(urlPath string) (string, bool) {
+	p := path.Clean("/" + urlPath)
+	switch {
+	case p == "/" || strings.HasPrefix(p, "/dashboard/") || strings.HasPrefix(p, "/oauth/"):
+		return "", false
+	case strings.HasPrefix(p, "/api/reports/"), strings.HasPrefix(p, "/api/stats/"):
+		return strings.SplitN(strings.TrimPrefix(strings.TrimPrefix(p, "/api/reports/"), "/api/stats/"), "/", 2)[0], true
+	case p == "/api/query":
+		return "", true
+	case strings.HasPrefix(p, "/api/"):
+		return "", false
+	case strings.HasPrefix(p, "/versions/"):
+		return strings.SplitN(strings.TrimPrefix(p, "/versions/"), "/", 2)[0], true
+	}
+
+	// Current copies, summaries and vendor files.
+	name := strings.TrimSuffix(path.Base(p), path.Ext(p))
+	if lookupReport(name) == nil {
+		name = ""
+	}
+	return name, true
+}
+
+// A statusWriter remembers the status code of the response it writes.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// ReadFrom() lets large files, such as archives, still be sent with sendfile.
+func (w *statusWriter) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(w.ResponseWriter, r)
+}
+
+// auditHandler() serves the audit log, newest first. user and report limit
+// it to a user or report; from and to, RFC 3339 timestamps or Unix seconds,
+// to a window of time; and limit to a number of entries, 100 by default.
+// Only administrators may see it.
+//     GET /api/audit?user=treasurer&report=sold_items&from=2014-03-01T00:00:00Z
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if u := currentUser(r); u != nil && u.vendor != "" {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	from, to, limit, err := logWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	name, report := r.FormValue("user"), r.FormValue("report")
+	entries := []auditEntry{}
+	if *auditFile != "" {
+		err = readLines(*auditFile, func(line []byte) {
+			var e auditEntry
+			if json.Unmarshal(line, &e) != nil {
+				return
+			}
+			if (name != "" && e.User != name) || (report != "" && e.Report != report) || e.Time.Before(from) || e.Time.After(to) {
+				return
+			}
+			entries = append([]auditEntry{e}, entries...)
+		})
+	}
+	if err != nil {
+		logFor(r.Context()).Println("Failed to read the audit log. Error: " + err.Error())
+		http.Error(w, "Failed to read the audit log", http.StatusInternalServerError)
+		return
+	}
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	writeJSON(w, entries)
+}
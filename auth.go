@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// A user who may log in to the webserver.
+// Users without a vendor are administrators and may see everything.
+type user struct {
+	name   string
+	hash   []byte // bcrypt hash of the password.
+	vendor string // The vendor whose data the user is limited to, if any.
+}
+
+// users holds everyone who may log in, keyed by username.
+// When it is empty the webserver does not require a login.
+var users = map[string]*user{}
+
+// The context key the logged in user is stored under.
+type userKey struct{}
+
+// loadUsers() reads the users file given by -users. It is a CSV with the
+// columns Username, Password and Vendor, where Password is a bcrypt hash as
+// printed by -hashpassword. Leave Vendor empty for administrators.
+//     Username,Password,Vendor
+//     manager,$2a$10$...,
+//     hillside,$2a$10$...,Hillside Farm
+func loadUsers(p string) error {
+	t, err := report.ReadFile(p)
+	if err != nil {
+		return errors.New("Failed to read users. " + err.Error())
+	}
+
+	name, hash, vendor := t.Column("Username"), t.Column("Password"), t.Column("Vendor")
+	if name < 0 || hash < 0 {
+		return errors.New("Users file must have Username and Password columns")
+	}
+
+	for r := range t.Rows {
+		u := &user{
+			name:   strings.TrimSpace(t.Value(r, name)),
+			hash:   []byte(strings.TrimSpace(t.Value(r, hash))),
+			vendor: strings.TrimSpace(t.Value(r, vendor)),
+		}
+		if u.name == "" {
+			continue
+		}
+		users[u.name] = u
+	}
+
+	return nil
+}
+
+// hashPassword() returns the bcrypt hash of a password for the users file.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// requireLogin() wraps h so every request must carry the credentials of a
+// known user via HTTP Basic Auth, or when -oidcissuer is set, the session
+// cookie of a user logged in through it. Browsers without either are sent
+// to log in there. Vendors are limited to their own files under vendors/
+// and the portal.
+func requireLogin(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(users) == 0 && oidcLogin == nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if oidcLogin != nil && isLoginPath(r.URL.Path) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		u := sessionUser(r)
+		if u == nil {
+			name, password, ok := r.BasicAuth()
+			if ok {
+				u = passwordUser(r.Context(), name, password, clientIP(r))
+			}
+			if u == nil {
+				if !ok && oidcLogin != nil && r.Method == "GET" && !strings.HasPrefix(r.URL.Path, "/api/") {
+					http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusFound)
+					return
+				}
+				w.Header().Set("WWW-Authenticate", `Basic realm="report-cacher"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		if u.vendor != "" && !vendorMayAccess(u, r.URL.Path) {
+			if r.URL.Path == "/" {
+				http.Redirect(w, r, "/portal", http.StatusFound)
+				return
+			}
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	})
+}
+
+// passwordUser() returns the user logging in as name with password, or nil
+// if they gave the wrong password or there is no such user. Failed logins
+// are logged with remote, the client's address.
+func passwordUser(ctx context.Context, name string, password string, remote string) *user {
+	u := users[name]
+	if u == nil || bcrypt.CompareHashAndPassword(u.hash, []byte(password)) != nil {
+		logFor(ctx).Println("Failed login for " + name + " from " + remote)
+		return nil
+	}
+	return u
+}
+
+// Reports whether vendor user u may request urlPath.
+func vendorMayAccess(u *user, urlPath string) bool {
+	p := path.Clean("/" + urlPath)
+	own := "/vendors/" + report.Slug(u.vendor)
+	return p == "/portal" || p == own || strings.HasPrefix(p, own+"/")
+}
+
+// currentUser() returns the user who made request r, or nil when logins are disabled.
+func currentUser(r *http.Request) *user {
+	u, _ := r.Context().Value(userKey{}).(*user)
+	return u
+}
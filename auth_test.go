@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVendorMayAccess(t *testing.T) {
+	u := &user{name: "hillside", vendor: "Hillside Farm"}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/portal", true},
+		{"/portal/", true},
+		{"//portal", true},
+		{"/vendors/hillside_farm", true},
+		{"/vendors/hillside_farm/", true},
+		{"/vendors/hillside_farm/sold_items.csv", true},
+		{"/vendors//hillside_farm/./sold_items.csv", true},
+		{"vendors/hillside_farm/sold_items.csv", true},
+		{"/vendors/other/../hillside_farm/payout.csv", true},
+		{"/", false},
+		{"", false},
+		{"/portals", false},
+		{"/vendors/", false},
+		{"/vendors/other/sold_items.csv", false},
+		{"/vendors/hillside_farm_2/sold_items.csv", false},
+		{"/vendors/hillside_farmers/sold_items.csv", false},
+		{"/vendors/hillside_farm/../other/sold_items.csv", false},
+		{"/vendors/hillside_farm/../../sold_items.csv", false},
+		{"/portal/../sold_items.csv", false},
+		{"/sold_items.csv", false},
+		{"/api/reports", false},
+	}
+
+	for _, tt := range tests {
+		if got := vendorMayAccess(u, tt.path); got != tt.want {
+			t.Errorf("vendorMayAccess(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRequireLogin(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldUsers, oldLogin := users, oidcLogin
+	defer func() { users, oidcLogin = oldUsers, oldLogin }()
+	users = map[string]*user{
+		"manager":  {name: "manager", hash: hash},
+		"hillside": {name: "hillside", hash: hash, vendor: "Hillside Farm"},
+	}
+	oidcLogin = nil
+
+	var served *user
+	h := requireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = currentUser(r)
+	}))
+
+	tests := []struct {
+		name, password string
+		path           string
+		want           int
+	}{
+		{"", "", "/sold_items.csv", http.StatusUnauthorized},
+		{"manager", "guess", "/sold_items.csv", http.StatusUnauthorized},
+		{"nobody", "secret", "/sold_items.csv", http.StatusUnauthorized},
+		{"manager", "secret", "/sold_items.csv", http.StatusOK},
+		{"manager", "secret", "/vendors/other/sold_items.csv", http.StatusOK},
+		{"hillside", "secret", "/", http.StatusFound},
+		{"hillside", "secret", "/portal", http.StatusOK},
+		{"hillside", "secret", "/vendors/hillside_farm/sold_items.csv", http.StatusOK},
+		{"hillside", "secret", "/vendors/other/sold_items.csv", http.StatusForbidden},
+		{"hillside", "secret", "/sold_items.csv", http.StatusForbidden},
+		{"hillside", "secret", "/api/reports", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		served = nil
+		r := httptest.NewRequest("GET", tt.path, nil)
+		if tt.name != "" {
+			r.SetBasicAuth(tt.name, tt.password)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.want {
+			t.Errorf("%s asking for %s was answered %d, want %d", tt.name, tt.path, w.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && (served == nil || served.name != tt.name) {
+			t.Errorf("%s asking for %s was served as %+v", tt.name, tt.path, served)
+		}
+		if tt.want != http.StatusOK && served != nil {
+			t.Errorf("%s asking for %s was served, want refused", tt.name, tt.path)
+		}
+	}
+}
+
+func TestPortalHandler(t *testing.T) {
+	dir := t.TempDir()
+	oldDirectory := *directory
+	defer func() { *directory = oldDirectory }()
+	*directory = dir
+
+	for _, p := range []string{"vendors/hillside_farm/sold_items.csv", "vendors/other/secret.csv"} {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(dir, p)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, p), []byte("Item\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		u        *user
+		want     int
+		contains string
+		omits    string
+	}{
+		{&user{name: "hillside", vendor: "Hillside Farm"}, http.StatusOK, "/vendors/hillside_farm/sold_items.csv", "secret.csv"},
+		{&user{name: "newcomer", vendor: "Newcomer Bakery"}, http.StatusOK, "No reports are available yet.", "sold_items.csv"},
+		{&user{name: "manager"}, http.StatusFound, "", ""},
+		{nil, http.StatusFound, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/portal", nil)
+		if tt.u != nil {
+			r = r.WithContext(context.WithValue(r.Context(), userKey{}, tt.u))
+		}
+		w := httptest.NewRecorder()
+		portalHandler(w, r)
+
+		body := w.Body.String()
+		if w.Code != tt.want || !strings.Contains(body, tt.contains) || (tt.omits != "" && strings.Contains(body, tt.omits)) {
+			t.Errorf("Portal of %+v answered %d with %q, want %d with %q and without %q", tt.u, w.Code, body, tt.want, tt.contains, tt.omits)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"flag"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The names in a backup of the files kept outside the cache directory.
+// The cache directory's files are under cache/.
+const (
+	backupSQLite = "sqlite.db"
+	backupClover = "clover_token.json"
+	backupAudit  = "audit.jsonl"
+)
+
+// backupCommand() copies the cache directory, with its manifest and
+// journal, the SQLite database, the Clover token and the audit log into a
+// zip at dest. Updates wait while it copies, so it is consistent even while
+// a report-cacher is running. It takes the same options as the
+// report-cacher to find the files.
+//     report-cacher backup -directory=files -sqlite=reports.db backup.zip
+func backupCommand(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if flag.NArg() != 1 {
+		return errors.New("Usage: report-cacher backup [options] <dest.zip>")
+	}
+	dest := flag.Arg(0)
+
+	unlock, err := lockWrites(*directory)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.New("Failed to create " + tmp + ". " + err.Error())
+	}
+	defer os.Remove(tmp)
+
+	z := zip.NewWriter(f)
+	err = filepath.Walk(*directory, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		if name := info.Name(); name == ".lock" || name == ".writing" || strings.HasSuffix(name, ".tmp") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(*directory, p)
+		if err != nil {
+			return err
+		}
+		return zipFile(z, "cache/"+filepath.ToSlash(rel), p)
+	})
+
+	for name, p := range map[string]string{backupSQLite: *sqliteFile, backupClover: *cloverTokenFile, backupAudit: *auditFile} {
+		if err != nil || p == "" {
+			continue
+		}
+		if _, statErr := os.Stat(p); os.IsNotExist(statErr) {
+			continue
+		}
+		err = zipFile(z, name, p)
+	}
+
+	if err == nil {
+		err = z.Close()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.New("Failed to back up. " + err.Error())
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return errors.New("Failed to move " + tmp + " to " + dest + ". " + err.Error())
+	}
+
+	log.Println("Backed up " + *directory + " to " + dest)
+	return nil
+}
+
+// restoreCommand() restores a backup made by backupCommand(). The cache
+// directory's files are written to -directory and the others to -sqlite,
+// -clovertokenfile and -audit, replacing any already there. It refuses to
+// run while a report-cacher is using -directory.
+//     report-cacher restore -directory=files -sqlite=reports.db backup.zip
+func restoreCommand(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if flag.NArg() != 1 {
+		return errors.New("Usage: report-cacher restore [options] <src.zip>")
+	}
+
+	r, err := zip.OpenReader(flag.Arg(0))
+	if err != nil {
+		return errors.New("Failed to open " + flag.Arg(0) + ". " + err.Error())
+	}
+	defer r.Close()
+
+	if err := os.MkdirAll(*directory, 0755); err != nil {
+		return errors.New("Failed to create " + *directory + ". " + err.Error())
+	}
+	if err := lockCache(*directory); err != nil {
+		return err
+	}
+
+	restored := 0
+	for _, f := range r.File {
+		p := restorePath(f.Name)
+		if p == "" {
+			log.Println("Skipping " + f.Name + ". There is nowhere configured to restore it to.")
+			continue
+		}
+
+		if err := unzipFile(f, p); err != nil {
+			return errors.New("Failed to restore " + f.Name + ". " + err.Error())
+		}
+		restored++
+	}
+
+	log.Printf("Restored %d files from %s\n", restored, flag.Arg(0))
+	return nil
+}
+
+// Returns where the file in a backup named name is restored to, or "" if
+// it has nowhere to go.
+func restorePath(name string) string {
+	switch name {
+	case backupSQLite:
+		return *sqliteFile
+	case backupClover:
+		return *cloverTokenFile
+	case backupAudit:
+		return *auditFile
+	}
+
+	rel := path.Clean(strings.TrimPrefix(name, "cache/"))
+	if !strings.HasPrefix(name, "cache/") || rel == ".." || strings.HasPrefix(rel, "../") || path.IsAbs(rel) {
+		return ""
+	}
+	return filepath.Join(*directory, filepath.FromSlash(rel))
+}
+
+// Adds the file at path p to z as name.
+func zipFile(z *zip.Writer, name string, p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	h, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	h.Name, h.Method = name, zip.Deflate
+
+	w, err := z.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// Writes the file f from a zip to path p by way of a temporary file.
+func unzipFile(f *zip.File, p string) error {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tmp := p + ".tmp"
+	w, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := w.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, p)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// A blackoutWindow is a time of day scheduled downloads are deferred
+// through, to keep the internet connection free for the card readers.
+type blackoutWindow struct {
+	market  bool         // Only on market days. See marketOpen().
+	weekday time.Weekday // Only on this day, or -1 for every day.
+	start   int          // Minutes after midnight.
+	end     int          // Minutes after midnight. Before start when the window crosses midnight.
+}
+
+// blackouts are the windows given by -blackout.
+var blackouts []blackoutWindow
+
+// parseBlackouts() reads a comma separated list of windows, each a time
+// range optionally preceded by market, for market days, or a weekday.
+//     market 08:00-14:00,Sun 10:00-12:00,23:30-00:30
+func parseBlackouts(s string) error {
+	blackouts = nil
+	for _, w := range strings.Split(s, ",") {
+		fields := strings.Fields(w)
+		if len(fields) == 0 {
+			continue
+		}
+
+		b := blackoutWindow{weekday: -1}
+		if len(fields) == 2 {
+			if strings.EqualFold(fields[0], "market") {
+				b.market = true
+			} else if b.weekday = parseWeekday(fields[0]); b.weekday < 0 {
+				return errors.New("Unknown day " + fields[0] + " in blackout " + w + ". Use market or a weekday.")
+			}
+			fields = fields[1:]
+		}
+
+		times := strings.Split(fields[0], "-")
+		if len(fields) != 1 || len(times) != 2 {
+			return errors.New("Invalid blackout " + w + ". Use a range of times such as market 08:00-14:00.")
+		}
+
+		start, err1 := time.Parse("15:04", times[0])
+		end, err2 := time.Parse("15:04", times[1])
+		if err1 != nil || err2 != nil || start.Equal(end) {
+			return errors.New("Invalid blackout " + w + ". Use a range of times such as market 08:00-14:00.")
+		}
+		b.start, b.end = start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+
+		blackouts = append(blackouts, b)
+	}
+
+	return nil
+}
+
+// Reports whether the window applies on the day of t.
+func (b blackoutWindow) on(t time.Time) bool {
+	return (!b.market || marketOpen(t)) && (b.weekday < 0 || t.Weekday() == b.weekday)
+}
+
+// Returns when the window containing t ends, or false if t is outside it.
+func (b blackoutWindow) until(t time.Time) (time.Time, bool) {
+	y, mo, d := t.Date()
+	midnight := time.Date(y, mo, d, 0, 0, 0, 0, t.Location())
+	m := t.Hour()*60 + t.Minute()
+
+	switch {
+	case b.start < b.end && b.on(t) && m >= b.start && m < b.end:
+		return midnight.Add(time.Duration(b.end) * time.Minute), true
+	case b.start > b.end && b.on(t) && m >= b.start:
+		return midnight.AddDate(0, 0, 1).Add(time.Duration(b.end) * time.Minute), true
+	case b.start > b.end && b.on(t.AddDate(0, 0, -1)) && m < b.end:
+		return midnight.Add(time.Duration(b.end) * time.Minute), true
+	}
+
+	return time.Time{}, false
+}
+
+// blackoutUntil() returns when scheduled downloads may resume if t, in the
+// market's timezone, is within a blackout window, or false if it is not.
+// Overlapping windows are treated as one.
+func blackoutUntil(t time.Time) (time.Time, bool) {
+	end, in := t, false
+	for changed := true; changed; {
+		changed = false
+		for _, b := range blackouts {
+			if until, ok := b.until(end); ok && until.After(end) {
+				end, in, changed = until, true, true
+			}
+		}
+	}
+
+	return end, in
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/download"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The number of consecutive authentication failures that open a circuit.
+const circuitThreshold = 3
+
+// How long logins pause the first time a provider is down for
+// maintenance. It doubles each time it is still down, up to -cooldown.
+const maintenanceBackoff = 15 * time.Minute
+
+// The causes of a circuit opening.
+const (
+	causeCredentials = "credentials" // The provider rejected the credentials.
+	causeCaptcha     = "captcha"     // The provider asked for a captcha.
+	causeMaintenance = "maintenance" // The provider is down for maintenance.
+	causeLocked      = "locked"      // The provider locked the account.
+)
+
+// A circuit stops the cacher logging in to a provider while logging in is
+// doomed to fail. Repeated authentication failures open it for -cooldown,
+// so the account isn't locked out, as do a captcha and a locked account,
+// which a person must clear. A maintenance page opens it for
+// maintenanceBackoff, doubling each time. Once it closes, one more login is tried.
+type circuit struct {
+	failures  int       // Consecutive failures.
+	cause     string    // What caused the last failure.
+	openUntil time.Time // Logins are not attempted until then.
+	reason    string    // The last failure.
+}
+
+// circuits are the circuits of each provider that has failed to log in.
+var circuits = struct {
+	sync.Mutex
+	byProvider map[string]*circuit
+}{byProvider: make(map[string]*circuit)}
+
+// A snapshot of a provider's circuit for the status API.
+type providerStatus struct {
+	Name      string    `json:"name"`
+	Circuit   string    `json:"circuit"`          // closed, or open while logins are paused.
+	Failures  int       `json:"failures"`         // Consecutive failures to log in.
+	Cause     string    `json:"cause,omitempty"`  // credentials, captcha or maintenance.
+	OpenUntil time.Time `json:"open_until"`       // When logins resume, if the circuit is open.
+	Reason    string    `json:"reason,omitempty"` // Why the circuit opened.
+}
+
+// loginFailure() returns why err means logging in to a provider is doomed,
+// or "" if it may be a passing problem such as a dropped connection.
+func loginFailure(err error) string {
+	switch err {
+	case download.ErrInvalidCredentials:
+		return causeCredentials
+	case download.ErrCaptcha:
+		return causeCaptcha
+	case download.ErrMaintenance:
+		return causeMaintenance
+	case download.ErrAccountLocked:
+		return causeLocked
+	}
+	return ""
+}
+
+// circuitOpen() reports whether logins to provider name are paused, and
+// until when.
+func circuitOpen(name string) (time.Time, bool) {
+	circuits.Lock()
+	defer circuits.Unlock()
+
+	c := circuits.byProvider[name]
+	if c == nil || !time.Now().Before(c.openUntil) {
+		return time.Time{}, false
+	}
+	return c.openUntil, true
+}
+
+// recordLogin() notes the outcome of logging in to provider name, opening
+// its circuit when logging in again would be doomed.
+func recordLogin(name string, err error) {
+	cause := loginFailure(err)
+
+	circuits.Lock()
+	c := circuits.byProvider[name]
+	if c == nil {
+		c = &circuit{}
+		circuits.byProvider[name] = c
+	}
+
+	if cause == "" {
+		c.failures, c.cause, c.reason = 0, "", ""
+		circuits.Unlock()
+		return
+	}
+
+	if c.cause != cause {
+		c.failures = 0
+	}
+	c.failures++
+	c.cause, c.reason = cause, err.Error()
+
+	var message string
+	switch {
+	case cause == causeCredentials && c.failures >= circuitThreshold:
+		c.openUntil = time.Now().Add(*cooldown)
+		message = name + " rejected the credentials " + strconv.Itoa(c.failures) + " times in a row. Logins are paused until " + c.openUntil.Format(time.RFC1123) + " to avoid locking the account. Check the email and password."
+	case cause == causeCaptcha:
+		c.openUntil = time.Now().Add(*cooldown)
+		message = name + " is asking for a captcha. Logins are paused until " + c.openUntil.Format(time.RFC1123) + ". Log in through a browser to clear it."
+	case cause == causeLocked:
+		c.openUntil = time.Now().Add(*cooldown)
+		message = name + " says the account is locked. Logins are paused until " + c.openUntil.Format(time.RFC1123) + ". Unlock it through a browser or " + name + "'s support."
+	case cause == causeMaintenance:
+		backoff := maintenanceBackoff << uint(c.failures-1)
+		if backoff > *cooldown || backoff <= 0 {
+			backoff = *cooldown
+		}
+		c.openUntil = time.Now().Add(backoff)
+		message = name + " is down for maintenance. Logins are paused until " + c.openUntil.Format(time.RFC1123) + "."
+	}
+	circuits.Unlock()
+
+	if message != "" {
+		log.Println(message)
+		events.publish(event{Type: eventCircuitOpen, Provider: name, Error: message})
+		emailAlert("Logins to "+name+" are paused", message)
+	}
+}
+
+// providerStatuses() returns the circuit of each configured provider.
+func providerStatuses() []providerStatus {
+	circuits.Lock()
+	defer circuits.Unlock()
+
+	statuses := []providerStatus{}
+	for _, name := range providerNames() {
+		ps := providerStatus{Name: name, Circuit: "closed"}
+		if c := circuits.byProvider[name]; c != nil {
+			ps.Failures, ps.Cause, ps.Reason = c.failures, c.cause, c.reason
+			if time.Now().Before(c.openUntil) {
+				ps.Circuit, ps.OpenUntil = "open", c.openUntil
+			}
+		}
+		statuses = append(statuses, ps)
+	}
+
+	return statuses
+}
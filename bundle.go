@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// bundleHandler() streams a zip of cached reports, with their metadata and a
+// manifest.json listing their versions, such as for a monthly archive.
+//     GET /api/bundle.zip
+//     GET /api/bundle.zip?reports=sold_items,stock_items
+//     GET /api/bundle.zip?from=2014-03-01&to=2014-03-31
+// Without from or to it holds the current copy of each report. With them it
+// holds every version covering any of those days, or for reports that don't
+// cover a range of days, every version downloaded on one of them.
+func bundleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	names := reportNames
+	if list := r.FormValue("reports"); list != "" {
+		names = nil
+		for _, name := range strings.Split(list, ",") {
+			name = strings.TrimSpace(name)
+			if lookupReport(name) == nil {
+				http.Error(w, "No such report: "+name, http.StatusBadRequest)
+				return
+			}
+			names = append(names, name)
+		}
+	}
+
+	ranged := r.FormValue("from") != "" || r.FormValue("to") != ""
+	from, to, err := dateWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	versions, err := bundledVersions(names, ranged, from, to)
+	if err != nil {
+		logFor(r.Context()).Println(err)
+		http.Error(w, "Failed to list versions", http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		http.Error(w, "No cached reports match", http.StatusNotFound)
+		return
+	}
+
+	filename := "reports.zip"
+	if ranged {
+		filename = "reports-" + from.Format(report.DateLayout) + "-" + to.Format(report.DateLayout) + ".zip"
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+
+	// Once the zip has begun the status can't change, so failures are only logged.
+	if err := writeBundle(w, versions, !ranged); err != nil {
+		logFor(r.Context()).Println("Failed to write a bundle. Error: " + err.Error())
+	}
+}
+
+// bundledVersions() returns the versions of reports names to bundle: the
+// newest of each, or when ranged, every one covering the days from through
+// to. See coversDays().
+func bundledVersions(names []string, ranged bool, from time.Time, to time.Time) ([]store.Version, error) {
+	var versions []store.Version
+	for _, name := range names {
+		vs, err := cache.Versions(name)
+		if err != nil {
+			return nil, errors.New("Failed to list versions of " + name + ". " + err.Error())
+		}
+
+		if !ranged {
+			if len(vs) > 0 {
+				versions = append(versions, vs[len(vs)-1])
+			}
+			continue
+		}
+		for _, v := range vs {
+			if coversDays(v, from, to) {
+				versions = append(versions, v)
+			}
+		}
+	}
+
+	return versions, nil
+}
+
+// writeBundle() writes a zip of versions to w, each with its metadata, and
+// a manifest.json listing them. When current is true the current copy of
+// each report is written instead, named after the report.
+func writeBundle(w io.Writer, versions []store.Version, current bool) error {
+	z := zip.NewWriter(w)
+	for _, v := range versions {
+		p, name := cache.FilePath(v), v.Path
+		if current {
+			p, name = cache.Path(v.Report), v.Report+".csv"
+		}
+
+		data, err := cache.ReadFile(p)
+		if err != nil {
+			return errors.New("Failed to read " + p + ". " + err.Error())
+		}
+		if err := addToZip(z, name, data, v.Time); err != nil {
+			return err
+		}
+
+		meta, err := ioutil.ReadFile(store.MetaPath(p))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return errors.New("Failed to read the metadata of " + p + ". " + err.Error())
+		}
+		if err := addToZip(z, store.MetaPath(name), meta, v.Time); err != nil {
+			return err
+		}
+	}
+
+	manifest, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := addToZip(z, "manifest.json", manifest, time.Now()); err != nil {
+		return err
+	}
+
+	return z.Close()
+}
+
+// Adds a file named name holding data, modified at t, to z.
+func addToZip(z *zip.Writer, name string, data []byte, t time.Time) error {
+	f, err := z.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: t})
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Write(data)
+	return err
+}
+
+// coversDays() reports whether version v covers any of the days from
+// through to, or when it does not cover a range of days, whether it was
+// downloaded on one of them in the market's timezone.
+func coversDays(v store.Version, from time.Time, to time.Time) bool {
+	start, err1 := businessDate(v.Start)
+	end, err2 := businessDate(v.End)
+	if err1 != nil || err2 != nil {
+		start, _ = businessDate(v.Time.In(businessZone).Format(report.DateLayout))
+		end = start
+	}
+
+	return !start.After(to) && !end.Before(from)
+}
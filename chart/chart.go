@@ -0,0 +1,277 @@
+// This package draws simple line and bar charts as SVG or PNG images, so
+// pages and emails can show them without any JavaScript.
+//     c := chart.Chart{Title: "Sales by week", Kind: chart.Bar, Labels: weeks, Values: sales}
+//     png, err := c.PNG()
+package chart
+
+import (
+	"bytes"
+	"fmt"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strconv"
+)
+
+// The kinds of chart.
+const (
+	Line = "line"
+	Bar  = "bar"
+)
+
+// The space around the plot for the title and labels, in pixels.
+const (
+	marginTop    = 30
+	marginRight  = 20
+	marginBottom = 30
+	marginLeft   = 70
+)
+
+// About how many gridlines to draw across the plot.
+const gridlines = 4
+
+var (
+	background = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	ink        = color.RGBA{0x22, 0x22, 0x22, 0xff}
+	muted      = color.RGBA{0x77, 0x77, 0x77, 0xff}
+	grid       = color.RGBA{0xdd, 0xdd, 0xdd, 0xff}
+	fill       = color.RGBA{0x4a, 0x8b, 0xc2, 0xff}
+)
+
+// A Chart plots a series of values, each with a label along the bottom.
+type Chart struct {
+	Title  string
+	Kind   string // Line or Bar. Defaults to Bar.
+	Labels []string
+	Values []float64
+	Width  int                  // Defaults to 640 pixels.
+	Height int                  // Defaults to 320 pixels.
+	Format func(float64) string // Formats the values along the side. Defaults to whole numbers.
+}
+
+// A layout is where a chart's parts are drawn.
+type layout struct {
+	width, height int
+	left, right   int // The edges of the plot.
+	top, bottom   int
+	min, max      float64 // The values at the bottom and top of the plot.
+	step          float64 // The difference between gridlines.
+	slot          float64 // The width given to each value.
+}
+
+// Works out where the parts of c are drawn.
+func (c *Chart) layout() layout {
+	l := layout{width: c.Width, height: c.Height}
+	if l.width <= 0 {
+		l.width = 640
+	}
+	if l.height <= 0 {
+		l.height = 320
+	}
+	l.left, l.right = marginLeft, l.width-marginRight
+	l.top, l.bottom = marginTop, l.height-marginBottom
+
+	for _, v := range c.Values {
+		l.min, l.max = math.Min(l.min, v), math.Max(l.max, v)
+	}
+	l.step = nice((l.max - l.min) / gridlines)
+	if l.step == 0 {
+		l.step = 1
+	}
+	l.max = math.Ceil(l.max/l.step) * l.step
+	l.min = math.Floor(l.min/l.step) * l.step
+	if l.max == l.min {
+		l.max = l.min + l.step
+	}
+
+	if len(c.Values) > 0 {
+		l.slot = float64(l.right-l.left) / float64(len(c.Values))
+	}
+	return l
+}
+
+// Returns the y coordinate of value v.
+func (l layout) y(v float64) int {
+	return l.bottom - int(math.Round((v-l.min)/(l.max-l.min)*float64(l.bottom-l.top)))
+}
+
+// Returns the x coordinate of the middle of value i.
+func (l layout) x(i int) int {
+	return l.left + int(l.slot*(float64(i)+0.5))
+}
+
+// Returns the values of the gridlines, from the bottom up.
+func (l layout) gridlines() []float64 {
+	var values []float64
+	for v := l.min; v <= l.max+l.step/2; v += l.step {
+		values = append(values, v)
+	}
+	return values
+}
+
+// Returns how many labels to skip between each drawn, so they don't overlap
+// when each is about width pixels wide.
+func (l layout) labelStep(width int) int {
+	if l.slot <= 0 {
+		return 1
+	}
+	return int(math.Ceil(float64(width+8) / l.slot))
+}
+
+// Rounds n up to 1, 2 or 5 times a power of ten, so gridlines fall on
+// round numbers.
+func nice(n float64) float64 {
+	if n <= 0 {
+		return 0
+	}
+
+	p := math.Pow(10, math.Floor(math.Log10(n)))
+	for _, m := range []float64{1, 2, 5, 10} {
+		if n <= m*p {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// Formats value v along the side.
+func (c *Chart) format(v float64) string {
+	if c.Format != nil {
+		return c.Format(v)
+	}
+	return strconv.FormatFloat(v, 'f', 0, 64)
+}
+
+// Returns the width of the longest label in pixels, assuming 7 per character.
+func (c *Chart) labelWidth() int {
+	n := 0
+	for _, s := range c.Labels {
+		if len(s) > n {
+			n = len(s)
+		}
+	}
+	return n * 7
+}
+
+// Returns the label of value i.
+func (c *Chart) label(i int) string {
+	if i < len(c.Labels) {
+		return c.Labels[i]
+	}
+	return ""
+}
+
+// SVG() draws the chart as an SVG image.
+func (c *Chart) SVG() []byte {
+	l := c.layout()
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="sans-serif" font-size="11">`+"\n", l.width, l.height, l.width, l.height)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/>`+"\n", l.width, l.height)
+	fmt.Fprintf(&b, `<text x="%d" y="18" font-size="14" fill="#222">%s</text>`+"\n", l.left, html.EscapeString(c.Title))
+
+	for _, v := range l.gridlines() {
+		fmt.Fprintf(&b, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#ddd"/>`+"\n", l.left, l.y(v), l.right, l.y(v))
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end" fill="#777">%s</text>`+"\n", l.left-6, l.y(v)+4, html.EscapeString(c.format(v)))
+	}
+
+	step := l.labelStep(c.labelWidth())
+	for i := range c.Values {
+		if i%step == 0 {
+			fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="middle" fill="#777">%s</text>`+"\n", l.x(i), l.bottom+18, html.EscapeString(c.label(i)))
+		}
+	}
+
+	if c.Kind == Line {
+		fmt.Fprintf(&b, `<polyline fill="none" stroke="#4a8bc2" stroke-width="2" points="`)
+		for i, v := range c.Values {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			fmt.Fprintf(&b, "%d,%d", l.x(i), l.y(v))
+		}
+		b.WriteString("\"/>\n")
+	} else {
+		w := int(l.slot * 0.7)
+		for i, v := range c.Values {
+			top, bottom := l.y(math.Max(v, 0)), l.y(math.Min(v, 0))
+			fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%d" height="%d" fill="#4a8bc2"><title>%s: %s</title></rect>`+"\n",
+				l.x(i)-w/2, top, w, bottom-top, html.EscapeString(c.label(i)), html.EscapeString(c.format(v)))
+		}
+	}
+
+	b.WriteString("</svg>\n")
+	return b.Bytes()
+}
+
+// PNG() draws the chart as a PNG image.
+func (c *Chart) PNG() ([]byte, error) {
+	l := c.layout()
+	img := image.NewRGBA(image.Rect(0, 0, l.width, l.height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	text(img, c.Title, l.left, 18, ink, false)
+	for _, v := range l.gridlines() {
+		rect(img, l.left, l.y(v), l.right, l.y(v)+1, grid)
+		text(img, c.format(v), l.left-6, l.y(v)+4, muted, true)
+	}
+
+	step := l.labelStep(c.labelWidth())
+	for i := range c.Values {
+		if i%step == 0 {
+			s := c.label(i)
+			text(img, s, l.x(i)-font.MeasureString(basicfont.Face7x13, s).Round()/2, l.bottom+18, muted, false)
+		}
+	}
+
+	if c.Kind == Line {
+		for i := 1; i < len(c.Values); i++ {
+			line(img, l.x(i-1), l.y(c.Values[i-1]), l.x(i), l.y(c.Values[i]), fill)
+		}
+	} else {
+		w := int(l.slot * 0.7)
+		for i, v := range c.Values {
+			rect(img, l.x(i)-w/2, l.y(math.Max(v, 0)), l.x(i)-w/2+w, l.y(math.Min(v, 0)), fill)
+		}
+	}
+
+	var b bytes.Buffer
+	err := png.Encode(&b, img)
+	return b.Bytes(), err
+}
+
+// Fills the rectangle from x0,y0 to x1,y1 with col.
+func rect(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, col color.Color) {
+	draw.Draw(img, image.Rect(x0, y0, x1, y1), image.NewUniform(col), image.Point{}, draw.Src)
+}
+
+// Draws a line two pixels wide from x0,y0 to x1,y1.
+func line(img *image.RGBA, x0 int, y0 int, x1 int, y1 int, col color.Color) {
+	steps := int(math.Max(math.Abs(float64(x1-x0)), math.Abs(float64(y1-y0))))
+	for s := 0; s <= steps; s++ {
+		t := 0.0
+		if steps > 0 {
+			t = float64(s) / float64(steps)
+		}
+		x := x0 + int(math.Round(t*float64(x1-x0)))
+		y := y0 + int(math.Round(t*float64(y1-y0)))
+		rect(img, x-1, y-1, x+1, y+1, col)
+	}
+}
+
+// Writes s with its baseline at y, starting at x or, when alignRight is
+// true, ending there.
+func text(img *image.RGBA, s string, x int, y int, col color.Color, alignRight bool) {
+	d := &font.Drawer{Dst: img, Src: image.NewUniform(col), Face: basicfont.Face7x13}
+	if alignRight {
+		x -= d.MeasureString(s).Round()
+	}
+	d.Dot = fixed.P(x, y)
+	d.DrawString(s)
+}
@@ -0,0 +1,58 @@
+package chart
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestNice(t *testing.T) {
+	for n, want := range map[float64]float64{0: 0, 7: 10, 12: 20, 180: 200, 450: 500, 1000: 1000, 0.3: 0.5, 85: 100} {
+		if got := nice(n); got != want {
+			t.Errorf("nice(%v) = %v, want %v", n, got, want)
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	c := Chart{Title: "Sales & more", Labels: []string{"Mar 3", "Mar 10", "Mar 17"}, Values: []float64{120, 0, 340}}
+
+	svg := string(c.SVG())
+	if !strings.Contains(svg, "Sales &amp; more") {
+		t.Error("SVG() did not escape the title")
+	}
+	if n := strings.Count(svg, "<rect x="); n != 3 {
+		t.Errorf("SVG() drew %d bars, want 3", n)
+	}
+	if !strings.Contains(svg, ">400</text>") {
+		t.Error("SVG() did not scale to a round 400")
+	}
+
+	c.Kind = Line
+	if svg := string(c.SVG()); !strings.Contains(svg, "<polyline") || strings.Contains(svg, "<rect x=") {
+		t.Error("SVG() did not draw a line chart")
+	}
+}
+
+func TestPNG(t *testing.T) {
+	for _, kind := range []string{Bar, Line} {
+		c := Chart{Kind: kind, Width: 300, Height: 200, Labels: []string{"a", "b"}, Values: []float64{-5, 20}}
+		data, err := c.PNG()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := img.Bounds(); b.Dx() != 300 || b.Dy() != 200 {
+			t.Errorf("PNG() of a %s chart is %v, want 300x200", kind, b)
+		}
+	}
+
+	if _, err := (&Chart{}).PNG(); err != nil {
+		t.Errorf("PNG() of an empty chart failed: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/chart"
+	"github.com/jfmarket/report-cacher/report"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chartsHandler() draws charts of the Sold Items report as PNG or SVG
+// images, by the extension asked for, for pages and emails to embed.
+//     GET /api/charts/sales.png?from=2014-01-01&to=2014-03-31&type=line
+//     GET /api/charts/departments.svg?from=2014-03-01&to=2014-03-31&top=8
+// sales is the sales of each week, for the 12 weeks ending today unless
+// from or to are given. departments is the sales of the top departments,
+// for the week ending today unless from or to are given. type is bar or
+// line; sales defaults to bar and departments are always bars.
+func chartsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	file := path.Base(r.URL.Path)
+	ext := path.Ext(file)
+	if ext != ".png" && ext != ".svg" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var c *chart.Chart
+	var err error
+	switch strings.TrimSuffix(file, ext) {
+	case "sales":
+		from, to := time.Time{}, time.Time{}
+		if r.FormValue("from") == "" && r.FormValue("to") == "" {
+			to = businessToday()
+			from = to.AddDate(0, 0, -12*7+1)
+		} else if from, to, err = dateWindow(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c, err = weeklySalesChart(from, to)
+		if r.FormValue("type") == chart.Line {
+			c.Kind = chart.Line
+		}
+	case "departments":
+		from, to, err := dateWindow(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		top := 8
+		if n := r.FormValue("top"); n != "" {
+			if top, err = strconv.Atoi(n); err != nil || top < 1 {
+				http.Error(w, "top must be a positive number", http.StatusBadRequest)
+				return
+			}
+		}
+		c, err = departmentsChart(from, to, top)
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		logFor(r.Context()).Println("Failed to chart " + file + ". Error: " + err.Error())
+		http.Error(w, "Failed to draw the chart", http.StatusInternalServerError)
+		return
+	}
+
+	if ext == ".svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(c.SVG())
+		return
+	}
+
+	data, err := c.PNG()
+	if err != nil {
+		logFor(r.Context()).Println("Failed to draw " + file + ". Error: " + err.Error())
+		http.Error(w, "Failed to draw the chart", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(data)
+}
+
+// weeklySalesChart() charts the sales of each week from through to, named
+// by the Monday it begins on. Weeks without sales are charted as zero.
+func weeklySalesChart(from time.Time, to time.Time) (*chart.Chart, error) {
+	snapshots, err := soldItemsSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	within := func(d time.Time) string {
+		if d.Before(from) || d.After(to) {
+			return ""
+		}
+		return report.Weekly(d)
+	}
+
+	sales := make(map[string]float64)
+	for _, s := range report.Summarize(snapshots, within, report.ItemColumns) {
+		sales[s.Period] += s.Sales
+	}
+
+	c := &chart.Chart{Title: "Sales by week", Kind: chart.Bar, Format: dollars}
+	first, _ := businessDate(report.Weekly(from))
+	for week := first; !week.After(to); week = week.AddDate(0, 0, 7) {
+		c.Labels = append(c.Labels, week.Format("Jan 2"))
+		c.Values = append(c.Values, sales[report.Weekly(week)])
+	}
+
+	return c, nil
+}
+
+// departmentsChart() charts the sales of the top departments from through to.
+func departmentsChart(from time.Time, to time.Time, top int) (*chart.Chart, error) {
+	stats, err := analyzer.Statistics(from, to, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &chart.Chart{Title: "Top departments", Kind: chart.Bar, Format: dollars}
+	for i, d := range stats.Departments {
+		if i == top {
+			break
+		}
+		c.Labels = append(c.Labels, d.Group)
+		c.Values = append(c.Values, d.Sales)
+	}
+
+	return c, nil
+}
+
+// Formats a whole number of dollars, such as $1200.
+func dollars(n float64) string {
+	return "$" + strconv.FormatFloat(n, 'f', 0, 64)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jfmarket/report-cacher/database"
+	"github.com/jfmarket/report-cacher/report"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A configProblem is something wrong with the options, found by
+// check-config.
+type configProblem struct {
+	Area    string `json:"area"`   // schedule, paths, credentials or destinations.
+	Option  string `json:"option"` // The flag at fault, such as -smtp.
+	Problem string `json:"problem"`
+}
+
+// A configCheck is the summary check-config prints.
+type configCheck struct {
+	OK       bool            `json:"ok"`
+	Checked  []string        `json:"checked"` // The areas checked.
+	Problems []configProblem `json:"problems"`
+}
+
+// The areas check-config looks at, in the order it prints them.
+var configAreas = []string{"schedule", "paths", "credentials", "destinations"}
+
+// How long check-config waits to reach each destination.
+const reachTimeout = 5 * time.Second
+
+// checkConfigCommand() checks the options without starting the
+// report-cacher: that schedules parse, files and directories can be used,
+// credentials are given where needed, and destinations such as the SMTP
+// server can be reached. It lists every problem found rather than stopping
+// at the first, and exits with 1 if there are any. -output=json prints the
+// summary as JSON.
+//     report-cacher check-config -email=user@domain.com -password=mypassword -smtp=mail.example.com:587
+func checkConfigCommand(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+
+	c := configCheck{Checked: configAreas, Problems: []configProblem{}}
+	problem := func(area string, option string, err error) {
+		if err != nil {
+			c.Problems = append(c.Problems, configProblem{Area: area, Option: option, Problem: err.Error()})
+		}
+	}
+	checkSchedule(problem)
+	checkPaths(problem)
+	checkCredentials(problem)
+	checkDestinations(problem)
+	c.OK = len(c.Problems) == 0
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(c); err != nil {
+			return err
+		}
+	} else {
+		for _, area := range configAreas {
+			n := 0
+			for _, p := range c.Problems {
+				if p.Area == area {
+					fmt.Println(area + ": " + p.Option + ": " + p.Problem)
+					n++
+				}
+			}
+			if n == 0 {
+				fmt.Println(area + ": OK")
+			}
+		}
+	}
+
+	if !c.OK {
+		return fmt.Errorf("Found %d problems with the options.", len(c.Problems))
+	}
+	return nil
+}
+
+// checkSchedule() checks the options saying when reports are downloaded
+// and emailed.
+func checkSchedule(problem func(string, string, error)) {
+	const area = "schedule"
+	if *interval <= 0 {
+		problem(area, "-interval", errors.New("Must be longer than 0."))
+	}
+	if *jitter < 0 {
+		problem(area, "-jitter", errors.New("Must not be negative."))
+	}
+	if *downloadTimeout <= 0 {
+		problem(area, "-downloadtimeout", errors.New("Must be longer than 0."))
+	}
+	if *cycleDeadline > *interval {
+		problem(area, "-deadline", errors.New("Is longer than -interval, so updates may run into the next."))
+	}
+	if *timezone != "" {
+		zone, err := time.LoadLocation(*timezone)
+		problem(area, "-timezone", err)
+		if err == nil {
+			businessZone = zone
+		}
+	}
+	problem(area, "-marketdays", parseMarketDays(*marketDays, *season))
+	problem(area, "-blackout", parseBlackouts(*blackout))
+	problem(area, "-shopkeepgroups", registerGroupedSoldItems(*shopkeepGroups))
+	problem(area, "-priorities", parsePriorities(*priorities))
+	problem(area, "-header", applyHeaders())
+	if *mailFile != "" {
+		_, err := loadDeliveries(*mailFile)
+		problem(area, "-mail", err)
+	}
+}
+
+// checkPaths() checks that the files named by options can be read, and the
+// directories written to exist or can be made.
+func checkPaths(problem func(string, string, error)) {
+	const area = "paths"
+	problem(area, "-directory", writableDir(*directory))
+	if *auditFile != "" {
+		problem(area, "-audit", writableDir(filepath.Dir(*auditFile)))
+	}
+	if *cloverClientID != "" {
+		problem(area, "-clovertokenfile", writableDir(filepath.Dir(*cloverTokenFile)))
+	}
+
+	if *usersFile != "" {
+		problem(area, "-users", loadUsers(*usersFile))
+	}
+	if *columnsFile != "" {
+		problem(area, "-columns", loadColumns(*columnsFile))
+	}
+	if *vendorsFile != "" {
+		_, err := report.LoadVendors(*vendorsFile)
+		problem(area, "-vendors", err)
+	}
+	problem(area, "-redact", parseRedaction(*redact, *redactMode))
+
+	key, err := encryptionKey()
+	problem(area, "-encryptkey", err)
+	if key != nil {
+		problem(area, "-encryptkey", encryptedConflict())
+	}
+	if _, ok := analyticsEngines[*analytics]; !ok {
+		problem(area, "-analytics", errors.New("Unknown analytics engine "+*analytics+"."))
+	}
+}
+
+// checkCredentials() checks that the options each feature needs together
+// are given together.
+func checkCredentials(problem func(string, string, error)) {
+	const area = "credentials"
+	require := func(option string, set bool, needs string, given bool) {
+		if set && !given {
+			problem(area, option, errors.New("Requires "+needs+"."))
+		}
+	}
+
+	otherProviders := *squareToken != "" || *cloverToken != "" || *cloverClientID != ""
+	if *shopkeepToken == "" && (*email != "" || *password != "" || !otherProviders) {
+		require("-email", true, "a value unless -shopkeeptoken or another provider is set", *email != "")
+		require("-password", true, "a value unless -shopkeeptoken or another provider is set", *password != "")
+	}
+	require("-clovertoken", *cloverToken != "", "-clovermerchant", *cloverMerchant != "")
+	require("-cloverclientid", *cloverClientID != "", "-cloversecret", *cloverSecret != "")
+	require("-oidcissuer", *oidcIssuer != "", "-oidcclientid and -oidcsecret", *oidcClientID != "" && *oidcSecret != "")
+	require("-smtpuser", *smtpUser != "", "-smtppassword", *smtpPassword != "")
+	require("-mqttuser", *mqttUser != "", "-mqttpassword", *mqttPassword != "")
+	require("-exportdb", *exportDB != "", "-exportdsn", *exportDSN != "")
+
+	var err error
+	_, err = parseNetworks(*allowFrom)
+	problem(area, "-allow", err)
+	_, err = parseNetworks(*denyFrom)
+	problem(area, "-deny", err)
+	_, err = parseNetworks(*proxies)
+	problem(area, "-trustedproxies", err)
+}
+
+// checkDestinations() checks that what reports and notifications are sent
+// to is set up fully and can be reached.
+func checkDestinations(problem func(string, string, error)) {
+	const area = "destinations"
+	if *mailFile != "" || *alertTo != "" {
+		if *smtpServer == "" || *mailFrom == "" {
+			problem(area, "-smtp", errors.New("Emailing reports and alerts requires -smtp and -mailfrom."))
+		}
+	}
+	if *smtpServer != "" {
+		problem(area, "-smtp", reach(*smtpServer))
+	}
+
+	if *exportDB != "" && *exportDSN != "" {
+		if _, err := parseExportTables(*exportTables); err != nil {
+			problem(area, "-exporttables", err)
+		}
+		e, err := database.NewExporter(*exportDB, *exportDSN, nil)
+		problem(area, "-exportdb", err)
+		if err == nil {
+			e.Close()
+		}
+	}
+
+	if *archiveUpload != "" {
+		if !*archive {
+			problem(area, "-archiveupload", errors.New("Requires -archive."))
+		}
+		problem(area, "-archiveupload", reachURL(*archiveUpload))
+	}
+
+	if *kafkaBrokers != "" {
+		for _, b := range strings.Split(*kafkaBrokers, ",") {
+			problem(area, "-kafka", reach(strings.TrimSpace(b)))
+		}
+	}
+	if *natsURL != "" {
+		problem(area, "-nats", reachURL(*natsURL))
+	}
+	if *mqttBroker != "" {
+		problem(area, "-mqtt", reachURL(*mqttBroker))
+	}
+	if *otlpEndpoint != "" {
+		problem(area, "-otlp", reachURL(*otlpEndpoint))
+	}
+}
+
+// Returns an error if d is neither a directory that can be written to nor
+// one that could be made.
+func writableDir(d string) error {
+	for {
+		info, err := os.Stat(d)
+		if os.IsNotExist(err) && filepath.Dir(d) != d {
+			// It would be made inside its parent.
+			d = filepath.Dir(d)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return errors.New(d + " is not a directory.")
+		}
+
+		f, err := ioutil.TempFile(d, ".check-config")
+		if err != nil {
+			return errors.New(d + " can't be written to. " + err.Error())
+		}
+		f.Close()
+		return os.Remove(f.Name())
+	}
+}
+
+// Returns an error if nothing answers at address, host:port.
+func reach(address string) error {
+	conn, err := net.DialTimeout("tcp", address, reachTimeout)
+	if err != nil {
+		return errors.New("Can't be reached. " + err.Error())
+	}
+	return conn.Close()
+}
+
+// Returns an error if nothing answers at the host of address u, such as
+// nats://localhost:4222, using the default port of its scheme if it has
+// none.
+func reachURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Host == "" {
+		return errors.New("Not an address, such as https://host:port.")
+	}
+
+	host := parsed.Host
+	if parsed.Port() == "" {
+		ports := map[string]string{"http": "80", "https": "443", "nats": "4222", "tcp": "1883", "mqtt": "1883", "ssl": "8883"}
+		host = net.JoinHostPort(parsed.Hostname(), ports[parsed.Scheme])
+	}
+	return reach(host)
+}
@@ -0,0 +1,221 @@
+// This package is a client for the report-cacher HTTP/JSON API, for
+// applications that consume cached reports.
+//     c := client.New("http://reports.local:8085")
+//     rows, err := c.Rows("sold_items")
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A Client talks to a running report-cacher.
+// Generally, it should be created with New()
+type Client struct {
+	HTTPClient *http.Client // The client used to make requests. Defaults to one with a 30 second timeout.
+	base       string       // The address of the report-cacher, such as http://localhost:8085
+	username   string
+	password   string
+}
+
+// A Report describes the current copy of a cached report.
+type Report struct {
+	Name        string    `json:"name"`
+	Updated     time.Time `json:"updated"`      // When the current copy was downloaded.
+	Size        int64     `json:"size"`         // The size of the current copy in bytes.
+	LastAttempt time.Time `json:"last_attempt"` // When a download was last attempted.
+	LastError   string    `json:"last_error"`   // Why the last attempt failed, if it did.
+}
+
+// Rows are the parsed contents of a report.
+type Rows struct {
+	Report  string              `json:"report"`
+	Updated time.Time           `json:"updated"`
+	Columns []string            `json:"columns"`
+	Rows    []map[string]string `json:"rows"` // Each row maps column names to values.
+}
+
+// Status describes what the report-cacher is doing.
+type Status struct {
+	Updating   bool      `json:"updating"`
+	Paused     bool      `json:"paused"`
+	LastUpdate time.Time `json:"last_update"`
+	NextUpdate time.Time `json:"next_update"`
+	Reports    []Report  `json:"reports"`
+}
+
+// A Job is a refresh asked for with RefreshJob(). Its Status is queued,
+// running, done or failed.
+type Job struct {
+	ID       string            `json:"id"`
+	Force    bool              `json:"force"`
+	Status   string            `json:"status"`
+	Error    string            `json:"error"`    // Why the update failed, such as a refused login.
+	Errors   map[string]string `json:"errors"`   // Why each report that failed to download did.
+	Created  time.Time         `json:"created"`  // When it was asked for.
+	Started  time.Time         `json:"started"`  // Zero until it starts.
+	Finished time.Time         `json:"finished"` // Zero until it finishes.
+}
+
+// A Changed report is one whose contents changed, as listed by Sync().
+type Changed struct {
+	Name       string    `json:"name"`
+	Updated    time.Time `json:"updated"` // When the current copy was downloaded.
+	Start      string    `json:"start"`   // The first day the report covers, YYYY-MM-DD, if it covers a range.
+	End        string    `json:"end"`     // The last day the report covers, YYYY-MM-DD.
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`    // Hex encoded SHA-256 of the contents.
+	URL        string    `json:"url"`         // The current copy, relative to the report-cacher.
+	VersionURL string    `json:"version_url"` // This version, which never changes.
+}
+
+// An Error is returned when the report-cacher responds with an error status.
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return "report-cacher responded with " + http.StatusText(e.StatusCode) + ": " + e.Message
+}
+
+// Returns a Client for the report-cacher at base, such as http://localhost:8085
+func New(base string) *Client {
+	return &Client{
+		HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		base:       strings.TrimSuffix(base, "/"),
+	}
+}
+
+// SetLogin() sets the username and password used when the report-cacher requires a login.
+func (c *Client) SetLogin(username string, password string) {
+	c.username = username
+	c.password = password
+}
+
+// Reports() lists the cached reports.
+func (c *Client) Reports() ([]Report, error) {
+	var reports []Report
+	err := c.get("/api/reports", nil, &reports)
+	return reports, err
+}
+
+// Rows() fetches the parsed rows of the current copy of report name.
+func (c *Client) Rows(name string) (*Rows, error) {
+	rows := new(Rows)
+	err := c.get("/api/reports/"+url.PathEscape(name), nil, rows)
+	return rows, err
+}
+
+// SoldItems() fetches the quantity and sales of each item sold from the
+// first through the last day given, merged from every cached version of
+// the Sold Items report.
+func (c *Client) SoldItems(from time.Time, to time.Time) (*Rows, error) {
+	rows := new(Rows)
+	err := c.get("/api/reports/sold_items", url.Values{
+		"from": {from.Format("2006-01-02")},
+		"to":   {to.Format("2006-01-02")},
+	}, rows)
+	return rows, err
+}
+
+// Status() reports what the report-cacher is doing.
+func (c *Client) Status() (*Status, error) {
+	s := new(Status)
+	err := c.get("/api/status", nil, s)
+	return s, err
+}
+
+// Refresh() asks the report-cacher to download every report now.
+// It returns false if a refresh was already waiting.
+func (c *Client) Refresh() (bool, error) {
+	var res struct {
+		Queued bool `json:"queued"`
+	}
+	err := c.do("POST", "/api/refresh", nil, &res)
+	return res.Queued, err
+}
+
+// RefreshJob() asks the report-cacher to download every report now, even
+// those with a fresh copy cached when force is true, and returns the job
+// doing so. If a job was already waiting, that job is returned.
+//     j, err := c.RefreshJob(false)
+//     for err == nil && (j.Status == "queued" || j.Status == "running") {
+//         time.Sleep(5 * time.Second)
+//         j, err = c.Job(j.ID)
+//     }
+func (c *Client) RefreshJob(force bool) (*Job, error) {
+	path := "/api/refresh"
+	if force {
+		path += "?force=true"
+	}
+	j := new(Job)
+	err := c.do("POST", path, nil, j)
+	return j, err
+}
+
+// Job() reports how the refresh job with id is going.
+func (c *Client) Job(id string) (*Job, error) {
+	j := new(Job)
+	err := c.get("/api/jobs/"+url.PathEscape(id), nil, j)
+	return j, err
+}
+
+// Sync() lists the reports whose contents changed since a time, and the
+// time to pass on the next call. A zero since lists every report.
+//     changed, next, err := c.Sync(last)
+func (c *Client) Sync(since time.Time) ([]Changed, time.Time, error) {
+	var res struct {
+		AsOf    time.Time `json:"as_of"`
+		Reports []Changed `json:"reports"`
+	}
+	var query url.Values
+	if !since.IsZero() {
+		query = url.Values{"since": {since.Format(time.RFC3339)}}
+	}
+	err := c.get("/api/sync", query, &res)
+	return res.Reports, res.AsOf, err
+}
+
+// Makes a GET request and decodes the JSON response into v.
+func (c *Client) get(path string, query url.Values, v interface{}) error {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return c.do("GET", path, nil, v)
+}
+
+// Makes a request and decodes the JSON response into v.
+func (c *Client) do(method string, path string, body io.Reader, v interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return errors.New("Failed to reach report-cacher. " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return &Error{StatusCode: res.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return errors.New("Failed to decode response from report-cacher. " + err.Error())
+	}
+
+	return nil
+}
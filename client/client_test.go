@@ -0,0 +1,88 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRows(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/reports/sold_items" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"report":"sold_items","columns":["Item","Quantity"],"rows":[{"Item":"Eggs","Quantity":"12"}]}`))
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL)
+
+	rows, err := c.Rows("sold_items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows.Rows) != 1 || rows.Rows[0]["Item"] != "Eggs" {
+		t.Errorf("Rows() = %+v, want a row for Eggs", rows)
+	}
+
+	_, err = c.Rows("customers")
+	if e, ok := err.(*Error); !ok || e.StatusCode != http.StatusNotFound {
+		t.Errorf("Rows() of an unknown report returned %v, want a 404 Error", err)
+	}
+}
+
+func TestSync(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/sync" || r.FormValue("since") != "2014-03-25T00:00:00Z" {
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"as_of":"2014-03-26T08:00:00Z","reports":[{"name":"sold_items","checksum":"abc","url":"/sold_items.csv"}]}`))
+	}))
+	defer ts.Close()
+
+	changed, next, err := New(ts.URL).Sync(time.Date(2014, 3, 25, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changed) != 1 || changed[0].Name != "sold_items" || changed[0].URL != "/sold_items.csv" {
+		t.Errorf("Sync() = %+v, want sold_items", changed)
+	}
+	if !next.Equal(time.Date(2014, 3, 26, 8, 0, 0, 0, time.UTC)) {
+		t.Errorf("Sync() next = %v, want 2014-03-26 08:00", next)
+	}
+}
+
+func TestRefreshJob(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && r.URL.Path == "/api/refresh" && r.FormValue("force") == "true":
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id":"abc","force":true,"status":"queued","created":"2014-03-25T08:00:00Z"}`))
+		case r.Method == "GET" && r.URL.Path == "/api/jobs/abc":
+			w.Write([]byte(`{"id":"abc","force":true,"status":"failed","errors":{"stock_items":"timed out"},"created":"2014-03-25T08:00:00Z","started":"2014-03-25T08:00:01Z","finished":"2014-03-25T08:02:00Z"}`))
+		default:
+			http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	c := New(ts.URL)
+	j, err := c.RefreshJob(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.ID != "abc" || j.Status != "queued" || !j.Started.IsZero() {
+		t.Errorf("RefreshJob() = %+v, want queued job abc", j)
+	}
+
+	j, err = c.Job(j.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Status != "failed" || j.Errors["stock_items"] != "timed out" || j.Finished.IsZero() {
+		t.Errorf("Job() = %+v, want failed with stock_items timed out", j)
+	}
+}
@@ -0,0 +1,191 @@
+// This package puts the system clock behind an interface, so code that waits
+// on timers, such as the update scheduler, can be tested with a Fake clock
+// that only moves when told to.
+//     c := clock.NewFake(time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC))
+//     t := c.NewTimer(time.Hour)
+//     c.Advance(time.Hour) // t.C() now receives 7:00.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// A Clock tells the time and makes timers and tickers.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+}
+
+// A Timer sends the time on C() once, after its duration. See time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// A Ticker sends the time on C() at each period until it is stopped. See
+// time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the system clock.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return realTicker{time.NewTicker(d)} }
+
+type realTimer struct{ t *time.Timer }
+
+func (t realTimer) C() <-chan time.Time        { return t.t.C }
+func (t realTimer) Stop() bool                 { return t.t.Stop() }
+func (t realTimer) Reset(d time.Duration) bool { return t.t.Reset(d) }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// A Fake is a clock that stands still until Advance() or Sleep() moves it,
+// for tests. Its timers and tickers fire as it passes their times.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// A waiter is a fake timer, or a ticker when period is set.
+type waiter struct {
+	clock  *Fake
+	at     time.Time
+	period time.Duration
+	active bool
+	ch     chan time.Time
+}
+
+// NewFake() returns a Fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now() returns the fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTimer() returns a timer firing once the clock has advanced by d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return f.add(d, 0)
+}
+
+// NewTicker() returns a ticker firing each time the clock advances by d.
+// It panics if d is not positive, as time.NewTicker() does.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+func (f *Fake) add(d time.Duration, period time.Duration) *waiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := &waiter{clock: f, at: f.now.Add(d), period: period, active: true, ch: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+// Advance() moves the clock forward by d, firing the timers and tickers due
+// on the way in order. Like the real ones, a ticker whose reader has
+// fallen behind drops ticks.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	end := f.now.Add(d)
+	for {
+		sort.SliceStable(f.waiters, func(i, j int) bool { return f.waiters[i].at.Before(f.waiters[j].at) })
+
+		var next *waiter
+		for _, w := range f.waiters {
+			if w.active && !w.at.After(end) {
+				next = w
+				break
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		f.now = next.at
+		select {
+		case next.ch <- f.now:
+		default:
+		}
+		if next.period > 0 {
+			next.at = next.at.Add(next.period)
+		} else {
+			next.active = false
+		}
+	}
+	f.now = end
+}
+
+// Sleep() moves the clock forward by d without firing anything, as when the
+// machine sleeps. Go's timers pause during sleep, so every timer and ticker
+// is put off by d.
+func (f *Fake) Sleep(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	for _, w := range f.waiters {
+		w.at = w.at.Add(d)
+	}
+}
+
+func (w *waiter) C() <-chan time.Time {
+	return w.ch
+}
+
+func (w *waiter) Stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	active := w.active
+	w.active = false
+	return active
+}
+
+// A fakeTicker is a waiter whose Stop() reports nothing, like time.Ticker's.
+type fakeTicker struct{ *waiter }
+
+func (t fakeTicker) Stop() { t.waiter.Stop() }
+
+// Reset() sets a timer to fire after d, dropping a time it already sent
+// but wasn't received, as time.Timer does since Go 1.23.
+func (w *waiter) Reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	select {
+	case <-w.ch:
+	default:
+	}
+	active := w.active
+	w.at = w.clock.now.Add(d)
+	w.active = true
+	return active
+}
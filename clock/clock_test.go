@@ -0,0 +1,106 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+
+// Returns the time sent on ch, or the zero time if none was.
+func received(ch <-chan time.Time) time.Time {
+	select {
+	case t := <-ch:
+		return t
+	default:
+		return time.Time{}
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	c := NewFake(start)
+	timer := c.NewTimer(time.Hour)
+
+	c.Advance(59 * time.Minute)
+	if got := received(timer.C()); !got.IsZero() {
+		t.Fatalf("fired early at %v", got)
+	}
+
+	c.Advance(2 * time.Minute)
+	if got := received(timer.C()); !got.Equal(start.Add(time.Hour)) {
+		t.Errorf("fired at %v, want %v", got, start.Add(time.Hour))
+	}
+	if !c.Now().Equal(start.Add(61 * time.Minute)) {
+		t.Errorf("Now() = %v", c.Now())
+	}
+
+	c.Advance(time.Hour)
+	if got := received(timer.C()); !got.IsZero() {
+		t.Errorf("fired twice, at %v", got)
+	}
+}
+
+func TestFakeTimerStopAndReset(t *testing.T) {
+	c := NewFake(start)
+	timer := c.NewTimer(time.Hour)
+
+	if !timer.Stop() {
+		t.Error("Stop() of an active timer = false")
+	}
+	c.Advance(2 * time.Hour)
+	if got := received(timer.C()); !got.IsZero() {
+		t.Fatalf("stopped timer fired at %v", got)
+	}
+
+	if timer.Reset(time.Minute) {
+		t.Error("Reset() of a stopped timer = true")
+	}
+	c.Advance(time.Minute)
+	if got := received(timer.C()); !got.Equal(start.Add(2*time.Hour + time.Minute)) {
+		t.Errorf("reset timer fired at %v", got)
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	c := NewFake(start)
+	ticker := c.NewTicker(10 * time.Second)
+
+	for i := 1; i <= 3; i++ {
+		c.Advance(10 * time.Second)
+		if got := received(ticker.C()); !got.Equal(start.Add(time.Duration(i) * 10 * time.Second)) {
+			t.Fatalf("tick %d at %v", i, got)
+		}
+	}
+
+	// Ticks the reader misses are dropped.
+	c.Advance(time.Minute)
+	if got := received(ticker.C()); !got.Equal(start.Add(40 * time.Second)) {
+		t.Errorf("first missed tick at %v", got)
+	}
+	if got := received(ticker.C()); !got.IsZero() {
+		t.Errorf("second tick at %v was kept", got)
+	}
+
+	ticker.Stop()
+	c.Advance(time.Minute)
+	if got := received(ticker.C()); !got.IsZero() {
+		t.Errorf("stopped ticker ticked at %v", got)
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	c := NewFake(start)
+	timer := c.NewTimer(time.Hour)
+
+	// The machine sleeps for three hours half way through.
+	c.Advance(30 * time.Minute)
+	c.Sleep(3 * time.Hour)
+	if got := received(timer.C()); !got.IsZero() {
+		t.Fatalf("fired during sleep at %v", got)
+	}
+
+	c.Advance(30 * time.Minute)
+	if got := received(timer.C()); !got.Equal(start.Add(4 * time.Hour)) {
+		t.Errorf("fired at %v, want %v", got, start.Add(4*time.Hour))
+	}
+}
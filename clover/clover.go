@@ -0,0 +1,212 @@
+// This package downloads sales and inventory from Clover's REST API and
+// formats them as CSV reports laid out like ShopKeep's.
+//     c := clover.New(merchantID, token)
+//     sales, err := c.SalesReport(ctx, start, end)
+// Tokens come from Clover's OAuth flow; see Config.
+package clover
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The number of elements requested per page.
+const pageSize = 1000
+
+// A Client downloads reports for one Clover merchant.
+// Generally, it should be created with New()
+type Client struct {
+	HTTPClient *http.Client // Defaults to one with a 60 second timeout.
+	Site       string       // Defaults to https://api.clover.com. Use https://apisandbox.dev.clover.com for testing.
+	merchant   string
+	token      string
+}
+
+// Returns a Client for merchant authenticating with an access token.
+func New(merchant string, token string) *Client {
+	return &Client{
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+		Site:       "https://api.clover.com",
+		merchant:   merchant,
+		token:      token,
+	}
+}
+
+// Merchant() returns the ID of the merchant c downloads reports for.
+func (c *Client) Merchant() string {
+	return c.merchant
+}
+
+// Formats an amount in cents as dollars and cents, such as 12.50
+func cents(n int64) string {
+	return strconv.FormatFloat(float64(n)/100, 'f', 2, 64)
+}
+
+// SalesReport() returns every line item of the orders created from start
+// until end, one row per line item:
+//     Order ID,Created At,Item,Quantity,Price,Net Sales,Refunded
+// The Item, Quantity and Net Sales columns match ShopKeep's Sold Items report.
+func (c *Client) SalesReport(ctx context.Context, start time.Time, end time.Time) ([]byte, error) {
+	rows := [][]string{{"Order ID", "Created At", "Item", "Quantity", "Price", "Net Sales", "Refunded"}}
+
+	q := url.Values{
+		"filter": {
+			"createdTime>=" + strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10),
+			"createdTime<" + strconv.FormatInt(end.UnixNano()/int64(time.Millisecond), 10),
+		},
+		"expand": {"lineItems"},
+	}
+
+	err := c.pages(ctx, "/orders", q, func(data json.RawMessage) error {
+		var o struct {
+			ID          string `json:"id"`
+			CreatedTime int64  `json:"createdTime"`
+			LineItems   struct {
+				Elements []struct {
+					Name     string `json:"name"`
+					Price    int64  `json:"price"`
+					UnitQty  int64  `json:"unitQty"` // Thousandths of a unit, for items sold by weight.
+					Refunded bool   `json:"refunded"`
+				} `json:"elements"`
+			} `json:"lineItems"`
+		}
+		if err := json.Unmarshal(data, &o); err != nil {
+			return err
+		}
+
+		created := time.Unix(0, o.CreatedTime*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+		for _, li := range o.LineItems.Elements {
+			quantity, net := 1.0, li.Price
+			if li.UnitQty > 0 {
+				quantity = float64(li.UnitQty) / 1000
+				net = int64(float64(li.Price)*quantity + 0.5)
+			}
+			if li.Refunded {
+				net = 0
+			}
+
+			rows = append(rows, []string{
+				o.ID, created, li.Name, strconv.FormatFloat(quantity, 'f', -1, 64),
+				cents(li.Price), cents(net), strconv.FormatBool(li.Refunded),
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("Failed to list orders. " + err.Error())
+	}
+
+	return toCSV(rows)
+}
+
+// InventoryReport() returns every item with its stock:
+//     Item,SKU,Price,Stock,Item ID
+func (c *Client) InventoryReport(ctx context.Context) ([]byte, error) {
+	rows := [][]string{{"Item", "SKU", "Price", "Stock", "Item ID"}}
+
+	err := c.pages(ctx, "/items", url.Values{"expand": {"itemStock"}}, func(data json.RawMessage) error {
+		var item struct {
+			ID        string `json:"id"`
+			Name      string `json:"name"`
+			SKU       string `json:"sku"`
+			Price     int64  `json:"price"`
+			ItemStock *struct {
+				Quantity float64 `json:"quantity"`
+			} `json:"itemStock"`
+		}
+		if err := json.Unmarshal(data, &item); err != nil {
+			return err
+		}
+
+		stock := ""
+		if item.ItemStock != nil {
+			stock = strconv.FormatFloat(item.ItemStock.Quantity, 'f', -1, 64)
+		}
+		rows = append(rows, []string{item.Name, item.SKU, cents(item.Price), stock, item.ID})
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.New("Failed to list items. " + err.Error())
+	}
+
+	return toCSV(rows)
+}
+
+// Calls f with each element of every page of the merchant's collection at path.
+func (c *Client) pages(ctx context.Context, path string, q url.Values, f func(json.RawMessage) error) error {
+	for offset := 0; ; offset += pageSize {
+		q.Set("limit", strconv.Itoa(pageSize))
+		q.Set("offset", strconv.Itoa(offset))
+
+		var res struct {
+			Elements []json.RawMessage `json:"elements"`
+		}
+		if err := c.get(ctx, "/v3/merchants/"+url.PathEscape(c.merchant)+path+"?"+q.Encode(), &res); err != nil {
+			return err
+		}
+
+		for _, e := range res.Elements {
+			if err := f(e); err != nil {
+				return err
+			}
+		}
+
+		if len(res.Elements) < pageSize {
+			return nil
+		}
+	}
+}
+
+// Makes a GET request and decodes the JSON response into v.
+func (c *Client) get(ctx context.Context, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", strings.TrimSuffix(c.Site, "/")+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return responseError(res)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Describes an error response from Clover.
+func responseError(res *http.Response) error {
+	var e struct {
+		Message string `json:"message"`
+	}
+	data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+	if json.Unmarshal(data, &e) == nil && e.Message != "" {
+		return errors.New("Clover responded with " + res.Status + ": " + e.Message)
+	}
+	return errors.New("Clover responded with " + res.Status)
+}
+
+// Formats rows as CSV.
+func toCSV(rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.WriteAll(rows)
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,46 @@
+package clover
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSalesReport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v3/merchants/M1/orders" || r.Header.Get("Authorization") != "Bearer secret" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"elements":[{"id":"O1","createdTime":1394272800000,"lineItems":{"elements":[
+			{"name":"Tomatoes","price":400,"unitQty":1500},
+			{"name":"Eggs","price":450},
+			{"name":"Honey","price":800,"refunded":true}]}}]}`))
+	}))
+	defer ts.Close()
+
+	c := New("M1", "secret")
+	c.Site = ts.URL
+
+	data, err := c.SalesReport(context.Background(), time.Now().AddDate(0, 0, -7), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Order ID,Created At,Item,Quantity,Price,Net Sales,Refunded\n" +
+		"O1,2014-03-08T10:00:00Z,Tomatoes,1.5,4.00,6.00,false\n" +
+		"O1,2014-03-08T10:00:00Z,Eggs,1,4.50,4.50,false\n" +
+		"O1,2014-03-08T10:00:00Z,Honey,1,8.00,0.00,true\n"
+	if string(data) != want {
+		t.Errorf("SalesReport() =\n%s\nwant\n%s", data, want)
+	}
+
+	c = New("M2", "secret")
+	c.Site = ts.URL
+	if _, err := c.SalesReport(context.Background(), time.Now(), time.Now()); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("SalesReport() for an unknown merchant returned %v, want a 404 error", err)
+	}
+}
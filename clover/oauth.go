@@ -0,0 +1,102 @@
+package clover
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Config is a Clover app's OAuth credentials, used to get and refresh the
+// tokens merchants grant the app.
+type Config struct {
+	ClientID     string // The app ID.
+	ClientSecret string // The app secret.
+	Site         string // Defaults to https://www.clover.com. Use https://sandbox.dev.clover.com for testing.
+}
+
+// A Token grants access to a merchant's data.
+type Token struct {
+	Merchant      string    `json:"merchant_id"`
+	AccessToken   string    `json:"access_token"`
+	AccessExpiry  time.Time `json:"access_expiry"`
+	RefreshToken  string    `json:"refresh_token"`
+	RefreshExpiry time.Time `json:"refresh_expiry"`
+}
+
+// Expired() reports whether the access token has expired, or will within a minute.
+func (t *Token) Expired() bool {
+	return !t.AccessExpiry.IsZero() && time.Now().Add(time.Minute).After(t.AccessExpiry)
+}
+
+// Returns the site OAuth requests are made to.
+func (c *Config) site() string {
+	if c.Site == "" {
+		return "https://www.clover.com"
+	}
+	return strings.TrimSuffix(c.Site, "/")
+}
+
+// AuthURL() returns the address merchants are sent to to grant the app access.
+// Clover then redirects them to redirect with a code and their merchant ID.
+func (c *Config) AuthURL(redirect string) string {
+	return c.site() + "/oauth/v2/authorize?" + url.Values{
+		"client_id":    {c.ClientID},
+		"redirect_uri": {redirect},
+	}.Encode()
+}
+
+// Exchange() trades the code Clover redirected a merchant back with for a token.
+func (c *Config) Exchange(merchant string, code string) (*Token, error) {
+	return c.token("/oauth/v2/token", merchant, map[string]string{
+		"client_id":     c.ClientID,
+		"client_secret": c.ClientSecret,
+		"code":          code,
+	})
+}
+
+// Refresh() trades a token's refresh token for a new token.
+func (c *Config) Refresh(t *Token) (*Token, error) {
+	return c.token("/oauth/v2/refresh", t.Merchant, map[string]string{
+		"client_id":     c.ClientID,
+		"refresh_token": t.RefreshToken,
+	})
+}
+
+// Requests a token from path.
+func (c *Config) token(path string, merchant string, body map[string]string) (*Token, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.Post(c.site()+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, responseError(res)
+	}
+
+	var t struct {
+		AccessToken            string `json:"access_token"`
+		AccessTokenExpiration  int64  `json:"access_token_expiration"`
+		RefreshToken           string `json:"refresh_token"`
+		RefreshTokenExpiration int64  `json:"refresh_token_expiration"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&t); err != nil {
+		return nil, err
+	}
+
+	return &Token{
+		Merchant:      merchant,
+		AccessToken:   t.AccessToken,
+		AccessExpiry:  time.Unix(t.AccessTokenExpiration, 0),
+		RefreshToken:  t.RefreshToken,
+		RefreshExpiry: time.Unix(t.RefreshTokenExpiration, 0),
+	}, nil
+}
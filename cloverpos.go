@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jfmarket/report-cacher/clover"
+	"github.com/jfmarket/report-cacher/report"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Clover and the reports the cacher keeps from it.
+func init() {
+	registerProvider("clover", cloverProvider{})
+
+	registerReport(reportDefinition{
+		Name:     "clover_sales",
+		Provider: "clover",
+		Type:     "sales",
+		Priority: prioritySales,
+		Key:      []string{"Order ID", "Item"},
+		Fetch:    fetchCloverSales,
+		Source:   cloverSource("/orders"),
+		Dates:    pastWeek,
+	})
+
+	registerReport(reportDefinition{
+		Name:     "clover_inventory",
+		Provider: "clover",
+		Type:     "inventory",
+		Priority: priorityInventory,
+		Key:      []string{"Item ID"},
+		Fetch:    fetchCloverInventory,
+		Source:   cloverSource("/items"),
+	})
+}
+
+// cloverProvider downloads reports from Clover's API. It uses -clovertoken
+// if it is set, or else the token saved when the merchant connected the
+// cacher to Clover through /oauth/clover. Its sessions are a *clover.Client.
+type cloverProvider struct{}
+
+func (cloverProvider) configured() bool {
+	if *cloverToken != "" {
+		return true
+	}
+	if *cloverClientID == "" {
+		return false
+	}
+
+	// Until the merchant connects there is nothing to download.
+	_, err := os.Stat(*cloverTokenFile)
+	return err == nil
+}
+
+func (cloverProvider) login(ctx context.Context) (session, error) {
+	var c *clover.Client
+	if *cloverToken != "" {
+		c = clover.New(*cloverMerchant, *cloverToken)
+	} else {
+		t, err := cloverOAuthToken()
+		if err != nil {
+			return nil, err
+		}
+		c = clover.New(t.Merchant, t.AccessToken)
+	}
+
+	if *cloverSite != "" {
+		c.Site = *cloverSite
+	}
+
+	return c, nil
+}
+
+// Returns the Clover app's OAuth credentials.
+func cloverConfig() *clover.Config {
+	return &clover.Config{ClientID: *cloverClientID, ClientSecret: *cloverSecret, Site: *cloverAuthSite}
+}
+
+// Serializes refreshing the saved token.
+var cloverTokenLock sync.Mutex
+
+// Returns the saved OAuth token, refreshing it first if it has expired.
+func cloverOAuthToken() (*clover.Token, error) {
+	cloverTokenLock.Lock()
+	defer cloverTokenLock.Unlock()
+
+	data, err := ioutil.ReadFile(*cloverTokenFile)
+	if err != nil {
+		return nil, errors.New("Clover is not connected. Visit /oauth/clover to connect it. " + err.Error())
+	}
+
+	t := new(clover.Token)
+	if err := json.Unmarshal(data, t); err != nil {
+		return nil, errors.New("Failed to read the Clover token. " + err.Error())
+	}
+
+	if t.Expired() {
+		log.Println("Refreshing the Clover token...")
+		if t, err = cloverConfig().Refresh(t); err != nil {
+			return nil, errors.New("Failed to refresh the Clover token. Visit /oauth/clover to reconnect. " + err.Error())
+		}
+		if err := saveCloverToken(t); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// Saves t to -clovertokenfile, readable only by the cacher.
+func saveCloverToken(t *clover.Token) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	tmp := *cloverTokenFile + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return errors.New("Failed to save the Clover token. " + err.Error())
+	}
+
+	return os.Rename(tmp, *cloverTokenFile)
+}
+
+// cloverOAuthHandler() connects the cacher to a Clover merchant.
+//     GET /oauth/clover            Redirects to Clover to grant access.
+//     GET /oauth/clover/callback   Where Clover redirects back to with a code.
+func cloverOAuthHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	callback := requestScheme(r) + "://" + r.Host + "/oauth/clover/callback"
+
+	if r.URL.Path != "/oauth/clover/callback" {
+		http.Redirect(w, r, cloverConfig().AuthURL(callback), http.StatusFound)
+		return
+	}
+
+	merchant, code := r.FormValue("merchant_id"), r.FormValue("code")
+	if merchant == "" || code == "" {
+		http.Error(w, "Clover did not grant access", http.StatusBadRequest)
+		return
+	}
+
+	t, err := cloverConfig().Exchange(merchant, code)
+	if err != nil {
+		log.Println("Failed to connect Clover. Error: " + err.Error())
+		http.Error(w, "Failed to connect Clover. "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	cloverTokenLock.Lock()
+	err = saveCloverToken(t)
+	cloverTokenLock.Unlock()
+	if err != nil {
+		log.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Connected Clover merchant " + merchant)
+	requestRefresh(false)
+	w.Write([]byte("Clover is connected. Its reports will be downloaded shortly."))
+}
+
+// cloverSource() returns the Source of a Clover report downloaded from the
+// merchant's path, such as /orders.
+func cloverSource(path string) func(session) string {
+	return func(s session) string {
+		c := s.(*clover.Client)
+		return strings.TrimSuffix(c.Site, "/") + "/v3/merchants/" + c.Merchant() + path
+	}
+}
+
+// fetchCloverSales() downloads the line items of the past week's orders.
+func fetchCloverSales(ctx context.Context, s session) ([]byte, string, string, error) {
+	now := businessNow()
+	y, m, d := now.AddDate(0, 0, -7).Date()
+	start := time.Date(y, m, d, 0, 0, 0, 0, now.Location())
+
+	data, err := s.(*clover.Client).SalesReport(ctx, start, now)
+	return data, start.Format(report.DateLayout), now.Format(report.DateLayout), err
+}
+
+// fetchCloverInventory() downloads every item with its stock.
+func fetchCloverInventory(ctx context.Context, s session) ([]byte, string, string, error) {
+	data, err := s.(*clover.Client).InventoryReport(ctx)
+	return data, "", "", err
+}
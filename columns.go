@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"strings"
+)
+
+// columnTransforms are the columns dropped and renamed from each report
+// before it is stored, by report name. See loadColumns().
+var columnTransforms = make(map[string]report.Transform)
+
+// loadColumns() reads the CSV at path p of columns to drop or rename
+// before reports are stored, one per row. A column without a Rename is
+// dropped:
+//     Report,Column,Rename
+//     square_orders,Customer Email,
+//     square_orders,Customer Name,Customer
+func loadColumns(p string) error {
+	t, err := report.ReadFile(p)
+	if err != nil {
+		return errors.New("Failed to read columns. " + err.Error())
+	}
+
+	name, column, rename := t.Column("Report"), t.Column("Column"), t.Column("Rename")
+	if name < 0 || column < 0 {
+		return errors.New("Columns file must have Report and Column columns")
+	}
+
+	for r := range t.Rows {
+		n, c := strings.TrimSpace(t.Value(r, name)), strings.TrimSpace(t.Value(r, column))
+		if n == "" || c == "" {
+			continue
+		}
+		if lookupReport(n) == nil {
+			return errors.New("Columns file names unknown report " + n)
+		}
+
+		x := columnTransforms[n]
+		if to := strings.TrimSpace(t.Value(r, rename)); to != "" {
+			if x.Rename == nil {
+				x.Rename = make(map[string]string)
+			}
+			x.Rename[c] = to
+		} else {
+			x.Drop = append(x.Drop, c)
+		}
+		columnTransforms[n] = x
+	}
+
+	return nil
+}
+
+// transformColumns() drops and renames the columns of the downloaded report
+// name, held in data, as -columns says, then hides those -redact names.
+func transformColumns(name string, data []byte) ([]byte, error) {
+	x, ok := columnTransforms[name]
+	if !ok && len(redacted) == 0 {
+		return data, nil
+	}
+
+	t, err := report.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if ok {
+		t = x.Apply(t)
+	}
+	t.Redact(redacted, redactor)
+
+	return t.CSV()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"net/http"
+	"time"
+)
+
+// compareHandler() compares the sales of two periods, such as a month with
+// the same month a year earlier, in total and for each item.
+//     GET /api/compare?report=sold_items&a=2014-03&b=2013-03
+// Periods are a year, 2014, a month, 2014-03, or a day, 2014-03-01. b
+// defaults to the same period a year before a. Only sold_items can be
+// compared so far.
+func compareHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if name := r.FormValue("report"); name != "" && name != "sold_items" {
+		http.Error(w, "Only sold_items can be compared", http.StatusBadRequest)
+		return
+	}
+
+	aFrom, aTo, err := parsePeriod(r.FormValue("a"))
+	if err != nil {
+		http.Error(w, "a: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// b defaults to the same period a year earlier, written like a.
+	b := r.FormValue("b")
+	if b == "" {
+		b = aFrom.AddDate(-1, 0, 0).Format(report.DateLayout[:len(r.FormValue("a"))])
+	}
+	bFrom, bTo, err := parsePeriod(b)
+	if err != nil {
+		http.Error(w, "b: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	itemsA, err := analyzer.Items(aFrom, aTo)
+	if err != nil {
+		logFor(r.Context()).Println("Failed to compare sold items. Error: " + err.Error())
+		http.Error(w, "Failed to compare periods", http.StatusInternalServerError)
+		return
+	}
+	itemsB, err := analyzer.Items(bFrom, bTo)
+	if err != nil {
+		logFor(r.Context()).Println("Failed to compare sold items. Error: " + err.Error())
+		http.Error(w, "Failed to compare periods", http.StatusInternalServerError)
+		return
+	}
+
+	c := report.ComparePeriods(itemsA, itemsB)
+	c.A.Period, c.A.From, c.A.To = r.FormValue("a"), aFrom.Format(report.DateLayout), aTo.Format(report.DateLayout)
+	c.B.Period, c.B.From, c.B.To = b, bFrom.Format(report.DateLayout), bTo.Format(report.DateLayout)
+	writeJSON(w, c)
+}
+
+// parsePeriod() returns the first and last days of a period given as a
+// year, 2014, a month, 2014-03, or a day, 2014-03-01.
+func parsePeriod(s string) (time.Time, time.Time, error) {
+	for _, p := range []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{{"2006", 1, 0, 0}, {"2006-01", 0, 1, 0}, {report.DateLayout, 0, 0, 1}} {
+		if len(s) != len(p.layout) {
+			continue
+		}
+		if from, err := time.Parse(p.layout, s); err == nil {
+			return from, from.AddDate(p.years, p.months, p.days-1), nil
+		}
+	}
+
+	return time.Time{}, time.Time{}, errors.New("Periods must be a year, month or day, such as 2014, 2014-03 or 2014-03-01")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"regexp"
+)
+
+// The context keys the IDs of an update cycle and of a request are stored
+// under.
+type (
+	cycleKey   struct{}
+	requestKey struct{}
+)
+
+// The request IDs kept when a trusted proxy sends one, such as nginx's
+// $request_id. Others are replaced so the logs stay greppable.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// newID() returns a random ID for a cycle or request, such as 3f9a1c2b7d4e.
+func newID() string {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// withCycle() returns a context for a new update cycle and its ID.
+func withCycle(ctx context.Context) (context.Context, string) {
+	id := newID()
+	return context.WithValue(ctx, cycleKey{}, id), id
+}
+
+// cycleID() returns the ID of the update cycle ctx belongs to, or "".
+func cycleID(ctx context.Context) string {
+	id, _ := ctx.Value(cycleKey{}).(string)
+	return id
+}
+
+// requestID() returns the ID of the request ctx belongs to, or "".
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestKey{}).(string)
+	return id
+}
+
+// logFor() returns a logger for ctx that writes to the standard logger,
+// starting each line with the IDs of the update cycle and request ctx
+// belongs to, so every line of a failed refresh or request can be found
+// with grep.
+//     2014/03/25 06:00:04 cycle=3f9a1c2b7d4e Failed to download sold_items. Error: ...
+//     2014/03/25 09:12:40 request=9b0e44d1a6f2 Failed to read sold_items. Error: ...
+func logFor(ctx context.Context) *log.Logger {
+	prefix := ""
+	if id := cycleID(ctx); id != "" {
+		prefix += "cycle=" + id + " "
+	}
+	if id := requestID(ctx); id != "" {
+		prefix += "request=" + id + " "
+	}
+	return log.New(log.Writer(), prefix, log.Flags()|log.Lmsgprefix)
+}
+
+// tagRequests() wraps h to give every request an ID, sent back in the
+// X-Request-ID header and logged with its errors. An X-Request-ID sent by
+// a trusted proxy is used instead, so its logs and ours line up.
+func tagRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID.MatchString(id) || !containsIP(trustedProxies, net.ParseIP(host)) {
+			id = newID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestKey{}, id)))
+	})
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// The dashboard's HTML, scripts and styles are built into the binary.
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardHandler() serves the dashboard from /dashboard/.
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err)
+	}
+
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+}
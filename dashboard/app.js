@@ -0,0 +1,132 @@
+// The dashboard polls the API for the status of each report and draws
+// recent sales from the parsed Sold Items data.
+(function() {
+  var DAY = 24 * 60 * 60 * 1000;
+
+  function $(id) { return document.getElementById(id); }
+
+  function get(url) {
+    return fetch(url, {credentials: "same-origin"}).then(function(res) {
+      if (!res.ok) { throw new Error(res.status + " " + res.statusText); }
+      return res.json();
+    });
+  }
+
+  function never(t) { return !t || t.indexOf("0001-") === 0; }
+
+  function when(t) {
+    if (never(t)) { return "never"; }
+    return new Date(t).toLocaleString();
+  }
+
+  function money(n) { return "$" + n.toFixed(2); }
+
+  function day(d) { return d.toISOString().slice(0, 10); }
+
+  function cell(row, text, cls) {
+    var td = document.createElement("td");
+    td.textContent = text;
+    if (cls) { td.className = cls; }
+    row.appendChild(td);
+    return td;
+  }
+
+  function loadStatus() {
+    return get("/api/status").then(function(s) {
+      $("state").textContent = s.updating ? "Updating..." : "Last update " + when(s.last_update);
+      $("refresh").disabled = s.updating;
+      $("pause").textContent = s.paused ? "Resume schedule" : "Pause schedule";
+      $("pause").dataset.paused = s.paused ? "1" : "";
+      $("schedule").textContent = never(s.next_update) ? "" : "Next scheduled update " + when(s.next_update);
+
+      var body = $("reports").tBodies[0];
+      body.innerHTML = "";
+      s.reports.forEach(function(r) {
+        var row = body.insertRow();
+        cell(row, r.name.replace(/_/g, " "));
+        cell(row, when(r.updated));
+        cell(row, (r.size / 1024).toFixed(1) + " KB");
+        if (r.last_error) {
+          cell(row, r.last_error, "error");
+        } else if (never(r.updated)) {
+          cell(row, "Not downloaded", "stale");
+        } else if (r.stale) {
+          cell(row, "Stale", "stale");
+        } else {
+          cell(row, "OK", "ok");
+        }
+        var link = document.createElement("a");
+        link.href = "/" + r.name + ".csv";
+        link.textContent = "Download";
+        cell(row, "").appendChild(link);
+      });
+    });
+  }
+
+  function loadTrend() {
+    var weeks = [];
+    var end = new Date();
+    for (var i = 0; i < 8; i++) {
+      var to = new Date(end.getTime() - i * 7 * DAY);
+      var from = new Date(to.getTime() - 6 * DAY);
+      weeks.unshift({from: day(from), to: day(to)});
+    }
+
+    return Promise.all(weeks.map(function(w) {
+      return get("/api/stats/sold_items?top=10&from=" + w.from + "&to=" + w.to);
+    })).then(function(stats) {
+      var max = Math.max.apply(null, stats.map(function(s) { return s.sales; })) || 1;
+      var trend = $("trend");
+      trend.innerHTML = "";
+      stats.forEach(function(s) {
+        var bar = document.createElement("div");
+        bar.className = "bar";
+        bar.style.height = (s.sales / max * 100) + "%";
+        bar.title = s.from + " to " + s.to;
+        bar.innerHTML = "<b></b><span></span>";
+        bar.querySelector("b").textContent = money(s.sales);
+        bar.querySelector("span").textContent = s.to.slice(5);
+        trend.appendChild(bar);
+      });
+
+      var body = $("top").tBodies[0];
+      body.innerHTML = "";
+      stats[stats.length - 1].top_by_sales.forEach(function(item) {
+        var row = body.insertRow();
+        cell(row, item.name);
+        cell(row, item.quantity.toFixed(0));
+        cell(row, money(item.sales));
+      });
+    });
+  }
+
+  $("refresh").addEventListener("click", function() {
+    $("refresh").disabled = true;
+    fetch("/api/refresh", {method: "POST", credentials: "same-origin"}).then(loadStatus);
+  });
+
+  $("pause").addEventListener("click", function() {
+    var url = $("pause").dataset.paused ? "/api/resume" : "/api/pause";
+    fetch(url, {method: "POST", credentials: "same-origin"}).then(loadStatus);
+  });
+
+  loadStatus();
+  loadTrend();
+
+  // Reload when the cache changes. Fall back to polling when the browser
+  // cannot receive events.
+  if (window.EventSource) {
+    var source = new EventSource("/api/events");
+    ["update.started", "report.refreshed", "report.failed", "scheduler.paused", "scheduler.resumed"].forEach(function(type) {
+      source.addEventListener(type, loadStatus);
+    });
+    source.addEventListener("update.finished", function() {
+      loadStatus();
+      loadTrend();
+      $("departments").src = "/api/charts/departments.svg?t=" + Date.now();
+    });
+  } else {
+    setInterval(loadStatus, 10000);
+    setInterval(loadTrend, 5 * 60000);
+  }
+})();
@@ -0,0 +1,265 @@
+// This package keeps a SQLite copy of every cached report version so
+// historical data can be queried with SQL.
+// Each report gets a table of its own, named after it, holding every row of
+// every version along with the version's fetched, start and end columns.
+//     SELECT Item, SUM(Quantity) FROM sold_items WHERE start >= '2014-03-01' GROUP BY Item
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	_ "github.com/mattn/go-sqlite3"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The most rows Query() returns.
+const MaxRows = 10000
+
+// A DB is a SQLite database of cached reports.
+// Generally, it should be created with Open()
+type DB struct {
+	mu       sync.Mutex // Serializes loading.
+	db       *sql.DB
+	readOnly *sql.DB // Used for queries so they cannot change the data.
+}
+
+// Opens or creates the SQLite database at path p, upgrading its schema if
+// it was made by an older report-cacher. See migrate().
+func Open(p string) (*DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db, p); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	readOnly, err := sql.Open("sqlite3", "file:"+p+"?mode=ro")
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &DB{db: db, readOnly: readOnly}, nil
+}
+
+// Close() closes the database.
+func (d *DB) Close() error {
+	d.readOnly.Close()
+	return d.db.Close()
+}
+
+// Loaded() reports whether version v is already in the database.
+func (d *DB) Loaded(v store.Version) (bool, error) {
+	var n int
+	err := d.db.QueryRow(`SELECT COUNT(*) FROM versions WHERE path = ?`, v.Path).Scan(&n)
+	return n > 0, err
+}
+
+// Load() adds the rows of table t, the contents of version v, to the table
+// named after its report. Columns are added to the table as new ones appear.
+func (d *DB) Load(v store.Version, t *report.Table) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	table := quote(v.Report)
+	_, err = tx.Exec(`CREATE TABLE IF NOT EXISTS ` + table + ` (fetched TEXT NOT NULL, start TEXT, end TEXT)`)
+	if err != nil {
+		return errors.New("Failed to create table " + v.Report + ". " + err.Error())
+	}
+
+	if err := addColumns(tx, table, t.Header); err != nil {
+		return errors.New("Failed to add columns to " + v.Report + ". " + err.Error())
+	}
+
+	columns := []string{"fetched", "start", "end"}
+	marks := []string{"?", "?", "?"}
+	for _, c := range t.Header {
+		columns = append(columns, quote(c))
+		marks = append(marks, "?")
+	}
+	insert, err := tx.Prepare(`INSERT INTO ` + table + ` (` + strings.Join(columns, ", ") + `) VALUES (` + strings.Join(marks, ", ") + `)`)
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	fetched := v.Time.UTC().Format(time.RFC3339)
+	for r := range t.Rows {
+		values := []interface{}{fetched, v.Start, v.End}
+		for i := range t.Header {
+			values = append(values, t.Value(r, i))
+		}
+		if _, err := insert.Exec(values...); err != nil {
+			return errors.New("Failed to insert into " + v.Report + ". " + err.Error())
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO versions (report, path, fetched, start, end) VALUES (?, ?, ?, ?, ?)`,
+		v.Report, v.Path, fetched, v.Start, v.End)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Adds any of columns missing from table.
+func addColumns(tx *sql.Tx, table string, columns []string) error {
+	rows, err := tx.Query(`PRAGMA table_info(` + table + `)`)
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, kind string
+		var dflt interface{}
+		if err := rows.Scan(&cid, &name, &kind, &notNull, &dflt, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	rows.Close()
+
+	for _, c := range columns {
+		if existing[strings.ToLower(c)] {
+			continue
+		}
+		if _, err := tx.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + quote(c) + ` TEXT`); err != nil {
+			return err
+		}
+		existing[strings.ToLower(c)] = true
+	}
+
+	return nil
+}
+
+// Quotes a table or column name.
+func quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// Result holds the rows returned by a query.
+type Result struct {
+	Columns   []string                 `json:"columns"`
+	Rows      []map[string]interface{} `json:"rows"`      // Each row maps column names to values.
+	Truncated bool                     `json:"truncated"` // True when more than MaxRows rows matched.
+}
+
+// Query() runs a read-only SQL statement and returns at most MaxRows rows.
+// Statements other than a single SELECT are rejected.
+func (d *DB) Query(ctx context.Context, statement string) (*Result, error) {
+	statement, err := ReadOnly(statement)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := d.readOnly.QueryContext(ctx, statement)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Columns: columns, Rows: []map[string]interface{}{}}
+	for rows.Next() {
+		if len(result.Rows) == MaxRows {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[c] = values[i]
+		}
+		result.Rows = append(result.Rows, row)
+	}
+
+	return result, rows.Err()
+}
+
+// ReadOnly() checks that statement is a single SELECT, possibly starting
+// with a WITH clause, and returns it without a trailing semicolon.
+// The database is also opened read-only for queries; this check gives a
+// clearer error and keeps out PRAGMA, ATTACH and the like.
+func ReadOnly(statement string) (string, error) {
+	statement = strings.TrimSpace(statement)
+	statement = strings.TrimSpace(strings.TrimSuffix(statement, ";"))
+	if statement == "" {
+		return "", errors.New("The query is empty.")
+	}
+
+	if strings.Contains(stripLiterals(statement), ";") {
+		return "", errors.New("Only a single statement may be run.")
+	}
+
+	fields := strings.Fields(statement)
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH":
+	default:
+		return "", errors.New("Only SELECT statements may be run.")
+	}
+
+	for _, f := range strings.Fields(strings.ToUpper(stripLiterals(statement))) {
+		switch f {
+		case "INSERT", "UPDATE", "DELETE", "REPLACE", "CREATE", "DROP", "ALTER", "ATTACH", "DETACH", "PRAGMA", "VACUUM", "REINDEX":
+			return "", errors.New(f + " is not allowed in queries.")
+		}
+	}
+
+	return statement, nil
+}
+
+// Blanks out quoted strings and identifiers so their contents are not
+// mistaken for SQL.
+func stripLiterals(statement string) string {
+	var b strings.Builder
+	var quote rune
+	for _, c := range statement {
+		switch {
+		case quote != 0 && c == quote:
+			quote = 0
+			b.WriteRune(' ')
+		case quote != 0:
+			b.WriteRune(' ')
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			b.WriteRune(' ')
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	allowed := []string{
+		"SELECT * FROM sold_items",
+		"select Item from sold_items;",
+		"WITH t AS (SELECT 1) SELECT * FROM t",
+		"SELECT * FROM sold_items WHERE Item = 'Drop; the beans'",
+	}
+	for _, q := range allowed {
+		if _, err := ReadOnly(q); err != nil {
+			t.Errorf("ReadOnly(%q) returned %v, want it allowed", q, err)
+		}
+	}
+
+	rejected := []string{
+		"",
+		"DELETE FROM sold_items",
+		"SELECT 1; DROP TABLE sold_items",
+		"PRAGMA table_info(sold_items)",
+		"WITH t AS (SELECT 1) DELETE FROM sold_items",
+	}
+	for _, q := range rejected {
+		if _, err := ReadOnly(q); err == nil {
+			t.Errorf("ReadOnly(%q) allowed the statement, want an error", q)
+		}
+	}
+}
+
+func TestLoadAndQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := Open(filepath.Join(dir, "reports.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	v := store.Version{Report: "sold_items", Time: time.Date(2014, 3, 8, 0, 0, 0, 0, time.UTC), Path: "versions/sold_items/20140308T000000Z.csv", Start: "2014-03-01", End: "2014-03-07"}
+	table := &report.Table{
+		Header: []string{"Item", "Quantity"},
+		Rows:   [][]string{{"Eggs", "12"}, {"Tomatoes", "3"}},
+	}
+	if err := db.Load(v, table); err != nil {
+		t.Fatal(err)
+	}
+
+	if loaded, err := db.Loaded(v); err != nil || !loaded {
+		t.Errorf("Loaded() = %v, %v, want true", loaded, err)
+	}
+
+	res, err := db.Query(context.Background(), "SELECT Item, start FROM sold_items WHERE Quantity = '12'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Rows) != 1 || res.Rows[0]["Item"] != "Eggs" || res.Rows[0]["start"] != "2014-03-01" {
+		t.Errorf("Query() = %+v, want one row for Eggs", res.Rows)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "database")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := filepath.Join(dir, "reports.db")
+
+	// A database from before migrations, holding a version.
+	old, err := sql.Open("sqlite3", "file:"+p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = old.Exec(`CREATE TABLE versions (report TEXT NOT NULL, path TEXT PRIMARY KEY, fetched TEXT NOT NULL, start TEXT, end TEXT);
+		INSERT INTO versions VALUES ('sold_items', 'versions/sold_items/20140325T080000Z.csv', '2014-03-25T08:00:00Z', '', '')`)
+	old.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var version int
+	d.db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+	if loaded, err := d.Loaded(store.Version{Path: "versions/sold_items/20140325T080000Z.csv"}); !loaded || err != nil {
+		t.Errorf("Loaded() after upgrading = %v, %v, want the old version kept", loaded, err)
+	}
+	d.db.Exec(`PRAGMA user_version = ` + strconv.Itoa(len(migrations)+1))
+	d.Close()
+
+	if _, err := os.Stat(p + ".v0.bak"); err != nil {
+		t.Errorf("No backup was made before upgrading. %v", err)
+	}
+	if _, err := Open(p); err == nil {
+		t.Error("Open() accepted a database from a newer report-cacher")
+	}
+}
@@ -0,0 +1,198 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	_ "github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An Exporter upserts report rows into an external Postgres or MySQL database.
+// Generally, it should be created with NewExporter()
+//
+// Each report is written to a table, named after the report unless mapped
+// otherwise, with a column for each report column named by report.Slug():
+// "Net Sales" becomes net_sales. Rows are keyed by the days the report
+// covers and its key columns, so refreshing a report updates the rows it
+// wrote before. Missing tables are created.
+type Exporter struct {
+	db      *sql.DB
+	driver  string            // postgres or mysql
+	tables  map[string]string // Maps report names to table names.
+	created map[string]bool   // Tables known to exist.
+}
+
+// Returns an Exporter writing to the database at dsn using driver, which is
+// postgres or mysql. tables maps report names to table names and may be nil.
+func NewExporter(driver string, dsn string, tables map[string]string) (*Exporter, error) {
+	if driver != "postgres" && driver != "mysql" {
+		return nil, errors.New("Unsupported export database " + driver + ". Use postgres or mysql.")
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, errors.New("Failed to connect to the export database. " + err.Error())
+	}
+
+	return &Exporter{db: db, driver: driver, tables: tables, created: make(map[string]bool)}, nil
+}
+
+// Close() closes the connection to the export database.
+func (e *Exporter) Close() error {
+	return e.db.Close()
+}
+
+// Export() upserts the rows of table t, the contents of version v.
+// key names the report columns identifying a row; those t lacks are ignored.
+func (e *Exporter) Export(v store.Version, t *report.Table, key []string) error {
+	table := v.Report
+	if mapped, ok := e.tables[v.Report]; ok {
+		table = mapped
+	}
+
+	columns := []string{"report_start", "report_end", "fetched"}
+	keys := []string{"report_start", "report_end"}
+	seen := map[string]bool{"report_start": true, "report_end": true, "fetched": true}
+	var indexes []int
+	for i, h := range t.Header {
+		c := report.Slug(h)
+		if c == "" || seen[c] {
+			continue
+		}
+		seen[c] = true
+		columns = append(columns, c)
+		indexes = append(indexes, i)
+	}
+	for _, k := range key {
+		if i := t.Column(k); i >= 0 && seen[report.Slug(t.Header[i])] {
+			keys = append(keys, report.Slug(t.Header[i]))
+		}
+	}
+
+	if err := e.create(table, columns, keys); err != nil {
+		return errors.New("Failed to create export table " + table + ". " + err.Error())
+	}
+
+	tx, err := e.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	upsert, err := tx.Prepare(e.upsert(table, columns, keys))
+	if err != nil {
+		return err
+	}
+	defer upsert.Close()
+
+	fetched := v.Time.UTC().Format(time.RFC3339)
+	for r := range t.Rows {
+		values := []interface{}{v.Start, v.End, fetched}
+		for _, i := range indexes {
+			values = append(values, t.Value(r, i))
+		}
+		if _, err := upsert.Exec(values...); err != nil {
+			return errors.New("Failed to export " + v.Report + " to " + table + ". " + err.Error())
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Creates table if it does not exist.
+func (e *Exporter) create(table string, columns []string, keys []string) error {
+	if e.created[table] {
+		return nil
+	}
+
+	isKey := make(map[string]bool)
+	for _, k := range keys {
+		isKey[k] = true
+	}
+
+	var defs []string
+	for _, c := range columns {
+		kind := "TEXT"
+		if e.driver == "mysql" && isKey[c] {
+			// MySQL cannot index TEXT columns without a prefix length.
+			kind = "VARCHAR(255)"
+		}
+		defs = append(defs, e.quote(c)+" "+kind+" NOT NULL DEFAULT ''")
+	}
+
+	var quoted []string
+	for _, k := range keys {
+		quoted = append(quoted, e.quote(k))
+	}
+	defs = append(defs, "PRIMARY KEY ("+strings.Join(quoted, ", ")+")")
+
+	if e.driver == "mysql" {
+		// MySQL does not allow defaults on TEXT columns.
+		for i := range defs {
+			defs[i] = strings.Replace(defs[i], "TEXT NOT NULL DEFAULT ''", "TEXT", 1)
+		}
+	}
+
+	_, err := e.db.Exec("CREATE TABLE IF NOT EXISTS " + e.quote(table) + " (" + strings.Join(defs, ", ") + ")")
+	if err != nil {
+		return err
+	}
+
+	e.created[table] = true
+	return nil
+}
+
+// Returns the statement inserting a row into table, or updating it when a
+// row with the same keys exists.
+func (e *Exporter) upsert(table string, columns []string, keys []string) string {
+	isKey := make(map[string]bool)
+	for _, k := range keys {
+		isKey[k] = true
+	}
+
+	var names, marks, updates []string
+	for i, c := range columns {
+		names = append(names, e.quote(c))
+		if e.driver == "postgres" {
+			marks = append(marks, "$"+strconv.Itoa(i+1))
+		} else {
+			marks = append(marks, "?")
+		}
+
+		if isKey[c] {
+			continue
+		}
+		if e.driver == "postgres" {
+			updates = append(updates, e.quote(c)+" = EXCLUDED."+e.quote(c))
+		} else {
+			updates = append(updates, e.quote(c)+" = VALUES("+e.quote(c)+")")
+		}
+	}
+
+	statement := "INSERT INTO " + e.quote(table) + " (" + strings.Join(names, ", ") + ") VALUES (" + strings.Join(marks, ", ") + ")"
+	if e.driver == "postgres" {
+		var quoted []string
+		for _, k := range keys {
+			quoted = append(quoted, e.quote(k))
+		}
+		return statement + " ON CONFLICT (" + strings.Join(quoted, ", ") + ") DO UPDATE SET " + strings.Join(updates, ", ")
+	}
+	return statement + " ON DUPLICATE KEY UPDATE " + strings.Join(updates, ", ")
+}
+
+// Quotes a table or column name.
+func (e *Exporter) quote(name string) string {
+	if e.driver == "mysql" {
+		return "`" + strings.Replace(name, "`", "``", -1) + "`"
+	}
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,22 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestUpsert(t *testing.T) {
+	columns := []string{"report_start", "report_end", "item", "quantity"}
+	keys := []string{"report_start", "report_end", "item"}
+
+	pg := &Exporter{driver: "postgres"}
+	want := `INSERT INTO "sales" ("report_start", "report_end", "item", "quantity") VALUES ($1, $2, $3, $4) ON CONFLICT ("report_start", "report_end", "item") DO UPDATE SET "quantity" = EXCLUDED."quantity"`
+	if got := pg.upsert("sales", columns, keys); got != want {
+		t.Errorf("postgres upsert =\n%s\nwant\n%s", got, want)
+	}
+
+	my := &Exporter{driver: "mysql"}
+	want = "INSERT INTO `sales` (`report_start`, `report_end`, `item`, `quantity`) VALUES (?, ?, ?, ?) ON DUPLICATE KEY UPDATE `quantity` = VALUES(`quantity`)"
+	if got := my.upsert("sales", columns, keys); got != want {
+		t.Errorf("mysql upsert =\n%s\nwant\n%s", got, want)
+	}
+}
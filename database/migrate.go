@@ -0,0 +1,92 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+)
+
+// A migration changes the schema of the database from one version to the
+// next.
+type migration struct {
+	description string
+	apply       func(tx *sql.Tx) error
+}
+
+// migrations are applied in order when a database is opened. A database's
+// version, kept in its user_version, is the number of them applied. Once
+// released, a migration must never be changed or removed; add another.
+var migrations = []migration{
+	{"Create the versions table", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE IF NOT EXISTS versions (
+			report TEXT NOT NULL,
+			path TEXT PRIMARY KEY,
+			fetched TEXT NOT NULL,
+			start TEXT,
+			end TEXT
+		)`)
+		return err
+	}},
+	{"Index versions by report", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE INDEX IF NOT EXISTS versions_report ON versions (report, fetched)`)
+		return err
+	}},
+}
+
+// Brings the database at path p up to date by applying the migrations it
+// lacks, each in a transaction. An existing database is first copied to
+// p.v<version>.bak, so a failed upgrade can be undone by restoring it. A
+// database from a newer report-cacher is refused rather than changed.
+func migrate(db *sql.DB, p string) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return errors.New("Failed to read the database's version. " + err.Error())
+	}
+	switch {
+	case version > len(migrations):
+		return errors.New("The database is version " + strconv.Itoa(version) + ", newer than this report-cacher knows. Upgrade the report-cacher or restore a backup.")
+	case version == len(migrations):
+		return nil
+	}
+
+	var tables int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master`).Scan(&tables); err != nil {
+		return errors.New("Failed to read the database's schema. " + err.Error())
+	}
+	if tables > 0 {
+		backup := p + ".v" + strconv.Itoa(version) + ".bak"
+		if err := os.Remove(backup); err != nil && !os.IsNotExist(err) {
+			return errors.New("Failed to replace " + backup + ". " + err.Error())
+		}
+		if _, err := db.Exec(`VACUUM INTO ?`, backup); err != nil {
+			return errors.New("Failed to back up the database to " + backup + " before upgrading it. " + err.Error())
+		}
+		log.Println("Backed up the database to " + backup + " before upgrading it.")
+	}
+
+	for ; version < len(migrations); version++ {
+		m := migrations[version]
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := m.apply(tx); err != nil {
+			tx.Rollback()
+			return errors.New("Failed to upgrade the database to version " + strconv.Itoa(version+1) + ": " + m.description + ". " + err.Error())
+		}
+		if _, err := tx.Exec(`PRAGMA user_version = ` + strconv.Itoa(version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if tables > 0 {
+			log.Println("Upgraded the database to version " + strconv.Itoa(version+1) + ": " + m.description + ".")
+		}
+	}
+
+	return nil
+}
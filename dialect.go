@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// parseDialect() reads a field delimiter, such as ; or tab, and a decimal
+// separator, . or ,. Either may be empty for the usual comma or point.
+func parseDialect(delimiter string, decimal string) (report.Dialect, error) {
+	var d report.Dialect
+
+	if strings.EqualFold(delimiter, "tab") {
+		delimiter = "\t"
+	}
+	if delimiter != "" {
+		r, n := utf8.DecodeRuneInString(delimiter)
+		if n != len(delimiter) || r == '"' || r == '\r' || r == '\n' {
+			return d, errors.New("Invalid delimiter " + delimiter + ". Use a single character such as ; or tab.")
+		}
+		d.Delimiter = r
+	}
+
+	switch decimal {
+	case "":
+	case ".", ",":
+		d.Decimal = rune(decimal[0])
+	default:
+		return d, errors.New("Invalid decimal separator " + decimal + ". Use . or ,")
+	}
+
+	return d, nil
+}
+
+// dialectHandler() serves the CSVs served by h, such as /sold_items.csv, in
+// the dialect given by the delimiter and decimal query parameters. Converted
+// files still answer Range requests.
+//     GET /sold_items.csv?delimiter=%3B&decimal=,
+func dialectHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, ".csv") || (r.FormValue("delimiter") == "" && r.FormValue("decimal") == "") {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		d, err := parseDialect(r.FormValue("delimiter"), r.FormValue("decimal"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		data, modified, err := servedFile(r.URL.Path)
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err == nil {
+			data, err = d.Convert(data)
+		}
+		if err != nil {
+			logFor(r.Context()).Println("Failed to convert " + r.URL.Path + ". Error: " + err.Error())
+			http.Error(w, "Failed to convert the report", http.StatusInternalServerError)
+			return
+		}
+
+		// The ETag set for the stored file doesn't describe the converted one.
+		w.Header().Del("ETag")
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		http.ServeContent(w, r, r.URL.Path, modified, bytes.NewReader(data))
+	})
+}
+
+// Returns the contents of the file served at urlPath and when it was last
+// changed. Current copies of reports come from memory; see snapshotHandler().
+func servedFile(urlPath string) ([]byte, time.Time, error) {
+	if name := currentReportName(urlPath); name != "" {
+		if c, err := currentReport(name); err == nil {
+			return c.Data, c.Version.Time, nil
+		}
+	}
+
+	f, err := cache.FileSystem().Open(urlPath)
+	if err != nil {
+		return nil, time.Time{}, os.ErrNotExist
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	data, err := ioutil.ReadAll(f)
+	return data, info.ModTime(), err
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+)
+
+// checkDiskSpace() returns an error if the volume holding the cache has
+// less than -minfree megabytes free, so an update is skipped rather than
+// failing part way through writing a report. The status is marked degraded
+// while space is low, and an alert is sent when it first runs low.
+func checkDiskSpace(ctx context.Context) error {
+	if *minFree <= 0 {
+		return nil
+	}
+
+	free, err := freeSpace(cache.Dir())
+	if err != nil {
+		logFor(ctx).Println("Failed to check free disk space. " + err.Error())
+		return nil
+	}
+
+	var problem string
+	if free < uint64(*minFree)<<20 {
+		problem = "Only " + strconv.FormatUint(free>>20, 10) + " MB is free where reports are kept, less than -minfree=" + strconv.Itoa(*minFree) + ". Downloads are skipped until space is freed."
+	}
+
+	status.Lock()
+	first := problem != "" && status.degraded == ""
+	status.degraded = problem
+	status.Unlock()
+
+	if problem == "" {
+		return nil
+	}
+	if first {
+		logFor(ctx).Println(problem)
+		events.publish(event{Type: eventDiskLow, Error: problem})
+		emailAlert("The report-cacher is low on disk space", problem)
+	}
+	return errors.New(problem)
+}
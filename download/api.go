@@ -0,0 +1,131 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ErrAPIUnavailable is returned when a ShopKeep site does not offer the API,
+// or does not accept the token.
+var ErrAPIUnavailable = errors.New("The ShopKeep API is not available")
+
+// A Source downloads ShopKeep reports. A Downloader scrapes them from the
+// BackOffice website and an APIClient uses ShopKeep's API.
+type Source interface {
+	SoldItemsReport(ctx context.Context, startDate time.Time, endDate time.Time, o ReportOptions) ([]byte, error)
+	StockItemsReport(ctx context.Context) ([]byte, error)
+}
+
+// The ways Connect() can download reports.
+const (
+	ModeAuto   = "auto"   // Use the API when a token is given and the site accepts it, and scrape otherwise.
+	ModeAPI    = "api"    // Only use the API.
+	ModeScrape = "scrape" // Only scrape the BackOffice website.
+)
+
+// Connect() returns a Source for the ShopKeep site s, using the API with
+// token or logging in with username u and password p, as mode allows.
+// Connecting is canceled with ctx.
+func Connect(ctx context.Context, mode string, s string, token string, u string, p string) (Source, error) {
+	switch mode {
+	case ModeScrape:
+		return New(ctx, s, u, p)
+	case ModeAPI:
+		a := NewAPIClient(s, token)
+		return a, a.Ping(ctx)
+	case ModeAuto, "":
+		if token == "" {
+			return New(ctx, s, u, p)
+		}
+
+		a := NewAPIClient(s, token)
+		err := a.Ping(ctx)
+		if err == ErrAPIUnavailable && u != "" {
+			log.Println("The ShopKeep API is not available. Falling back to the BackOffice website.")
+			return New(ctx, s, u, p)
+		}
+		return a, err
+	default:
+		return nil, errors.New("Unknown ShopKeep mode " + mode + ". Use auto, api or scrape.")
+	}
+}
+
+// An APIClient downloads reports using an API token rather than scraping.
+// Generally, it should be created with NewAPIClient()
+type APIClient struct {
+	client *http.Client
+	site   string // The url of the shopkeep site: https://jonesboroughfarmersmkt.shopkeepapp.com
+	token  string
+}
+
+// Returns an APIClient for the ShopKeep site s authenticating with token.
+func NewAPIClient(s string, token string) *APIClient {
+	return &APIClient{
+		client: &http.Client{Timeout: 5 * time.Minute, Transport: &headerTransport{base: &compressedTransport{}}},
+		site:   strings.TrimSuffix(s, "/"),
+		token:  token,
+	}
+}
+
+// Ping() checks that the site offers the API and accepts the token.
+// It returns ErrAPIUnavailable if it does not.
+func (a *APIClient) Ping(ctx context.Context) error {
+	_, err := a.get(ctx, "/api/v2/account", nil)
+	return err
+}
+
+// Returns the contents of the Sold Items report from startDate to endDate,
+// as o asks. Only the days of the dates are used, in their own locations.
+// See CheckDates().
+func (a *APIClient) SoldItemsReport(ctx context.Context, startDate time.Time, endDate time.Time, o ReportOptions) ([]byte, error) {
+	if err := CheckDates(startDate, endDate); err != nil {
+		return nil, err
+	}
+
+	query := url.Values{
+		"start_date": {startDate.Format(DateLayout)},
+		"end_date":   {endDate.Format(DateLayout)},
+	}
+	if o.GroupedBy != Ungrouped {
+		query.Set("grouped_by", string(o.GroupedBy))
+	}
+	return a.get(ctx, "/api/v2/exports/sold_items.csv", query)
+}
+
+// Returns the contents of the Stock Items report.
+func (a *APIClient) StockItemsReport(ctx context.Context) ([]byte, error) {
+	return a.get(ctx, "/api/v2/exports/stock_items.csv", nil)
+}
+
+// Makes an authenticated GET request and returns the response body.
+func (a *APIClient) get(ctx context.Context, path string, query url.Values) ([]byte, error) {
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", a.site+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+a.token)
+
+	res, err := a.client.Do(req)
+	if err != nil {
+		return nil, errors.New("Failed GETing " + path + ". " + err.Error())
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusUnauthorized || res.StatusCode == http.StatusForbidden:
+		return nil, ErrAPIUnavailable
+	case res.StatusCode != 200:
+		return nil, errors.New(path + " responded with " + res.Status)
+	}
+
+	return readReport(res)
+}
@@ -0,0 +1,80 @@
+package download
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// compressedTransport asks for responses compressed with gzip or deflate
+// and decompresses them, so big exports take less time to download over a
+// slow connection. Go's transport only does this for gzip. The decompressed
+// response has no Content-Encoding or Content-Length.
+type compressedTransport struct {
+	base http.RoundTripper // When nil, http.DefaultTransport.
+}
+
+func (t *compressedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Accept-Encoding", "gzip, deflate")
+	}
+
+	res, err := base.RoundTrip(req)
+	if err != nil || req.Method == "HEAD" {
+		return res, err
+	}
+
+	var body io.ReadCloser
+	switch strings.ToLower(strings.TrimSpace(res.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		body, err = gzip.NewReader(res.Body)
+	case "deflate":
+		body, err = inflate(res.Body)
+	default:
+		return res, nil
+	}
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+
+	res.Body = &decompressedBody{ReadCloser: body, compressed: res.Body}
+	res.Header.Del("Content-Encoding")
+	res.Header.Del("Content-Length")
+	res.ContentLength = -1
+	res.Uncompressed = true
+	return res, nil
+}
+
+// Returns a reader decompressing r, a deflate response body. Servers
+// disagree on whether deflate means zlib or a bare deflate stream, so the
+// zlib header is looked for.
+func inflate(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(2)
+	if err == nil && head[0]&0x0f == 8 && (uint(head[0])<<8|uint(head[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// A decompressedBody closes both the decompressor and the compressed body
+// it reads.
+type decompressedBody struct {
+	io.ReadCloser
+	compressed io.Closer
+}
+
+func (b *decompressedBody) Close() error {
+	b.ReadCloser.Close()
+	return b.compressed.Close()
+}
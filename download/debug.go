@@ -0,0 +1,84 @@
+package download
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/jfmarket/report-cacher/download/fixture"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DebugDir is a directory pages ShopKeep sends in place of what was
+// expected are saved to, such as an export page without a download link,
+// so changes to its site can be diagnosed from the field. Tokens and email
+// addresses are replaced as fixture.Sanitize() does. Empty, the default,
+// saves nothing.
+var DebugDir string
+
+// DebugLimit is the most pages kept in DebugDir. The oldest are removed to
+// make room for new ones.
+var DebugLimit = 20
+
+// Serializes saving and pruning pages in DebugDir.
+var debugLock sync.Mutex
+
+// Saves the page in doc to DebugDir, if it is set. what names what the
+// page should have been, such as sold_items_export.
+func dumpDoc(what string, doc *goquery.Document) {
+	if DebugDir == "" {
+		return
+	}
+
+	page, err := doc.Html()
+	if err != nil {
+		log.Println("Failed to save the " + what + " page for debugging. " + err.Error())
+		return
+	}
+	dumpPage(what, []byte(page))
+}
+
+// Saves page to DebugDir, sanitized, if it is set, removing the oldest
+// pages past DebugLimit. Files are named for when they were saved and what,
+// such as 20140325T101500.000-sold_items_export.html.
+func dumpPage(what string, page []byte) {
+	if DebugDir == "" {
+		return
+	}
+
+	debugLock.Lock()
+	defer debugLock.Unlock()
+
+	if err := os.MkdirAll(DebugDir, 0700); err != nil {
+		log.Println("Failed to save the " + what + " page for debugging. " + err.Error())
+		return
+	}
+
+	name := time.Now().UTC().Format("20060102T150405.000") + "-" + what + ".html"
+	p := filepath.Join(DebugDir, name)
+	if err := ioutil.WriteFile(p, fixture.Sanitize(page), 0600); err != nil {
+		log.Println("Failed to save the " + what + " page for debugging. " + err.Error())
+		return
+	}
+	log.Println("Saved the unexpected " + what + " page to " + p)
+
+	pruneDebugDir()
+}
+
+// Removes the oldest pages in DebugDir until at most DebugLimit are left.
+func pruneDebugDir() {
+	matches, err := filepath.Glob(filepath.Join(DebugDir, "*.html"))
+	if err != nil || len(matches) <= DebugLimit {
+		return
+	}
+
+	sort.Strings(matches)
+	for _, p := range matches[:len(matches)-DebugLimit] {
+		if err := os.Remove(p); err != nil {
+			log.Println("Failed to remove " + p + ". " + err.Error())
+		}
+	}
+}
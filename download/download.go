@@ -4,15 +4,66 @@ package download
 
 import (
 	// "code.google.com/p/go.net/html"
+	"bytes"
+	"context"
 	"errors"
 	"github.com/PuerkitoBio/goquery"
+	"io"
 	"io/ioutil"
 	"log"
+	"mime"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Errors returned when ShopKeep will not let the Downloader log in.
+var (
+	ErrInvalidCredentials = errors.New("Invalid username or password")
+	ErrCaptcha            = errors.New("ShopKeep is asking for a captcha. Log in through a browser to clear it.")
+	ErrMaintenance        = errors.New("ShopKeep is down for maintenance")
+	ErrAccountLocked      = errors.New("The ShopKeep account is locked. Unlock it through a browser or ShopKeep support.")
+)
+
+// Errors returned when logging in goes wrong before ShopKeep has judged the
+// credentials. They are returned with more detail appended, such as the
+// address tried or the title of the page ShopKeep sent.
+var (
+	ErrUnreachable    = errors.New("Could not reach ShopKeep")
+	ErrNoLoginForm    = errors.New("No login form was found. Check the site URL")
+	ErrUnexpectedPage = errors.New("ShopKeep answered the login with an unexpected page")
+)
+
+// How long a confirmed login is trusted before LoggedIn() checks the
+// homepage again.
+const loginCheckTTL = 5 * time.Minute
+
+// DateLayout is the form ShopKeep takes dates in, YYYY-MM-DD.
+const DateLayout = "2006-01-02"
+
+// Errors returned when the days asked for can't be downloaded.
+var (
+	ErrMissingDate = errors.New("A start and end date are required")
+	ErrDateOrder   = errors.New("The start date is after the end date")
+	ErrFutureDate  = errors.New("The end date is in the future")
+)
+
+// MaxReportSize is the most bytes a report downloaded by this package may
+// hold. Larger downloads are abandoned with ErrTooLarge rather than read
+// into memory. Zero means no limit.
+var MaxReportSize int64
+
+// ErrTooLarge is returned when a report is larger than MaxReportSize, such
+// as an export of every sale ever made.
+var ErrTooLarge = errors.New("The report is larger than the size limit")
+
+// ErrNotCSV is returned when ShopKeep sends a web page, such as an error
+// page, in place of a report.
+var ErrNotCSV = errors.New("ShopKeep sent a web page instead of the report")
+
 // This struct is used to interface with ShopKeep and download reports.
 // Generally, it should be created with New()
 type Downloader struct {
@@ -21,12 +72,16 @@ type Downloader struct {
 	username           string
 	password           string
 	authenticity_token string // The authenticity token used by ShopKeep for form submissions. Obtained at login.
+
+	mu         sync.Mutex
+	loggedInAt time.Time // When the login was last confirmed. Zero when logged out.
 }
 
 // Returns a reference to a Downloader that is logged in and ready to begin
 // downloading reports.
-// Takes the site url, a username and password.
-func New(s string, u string, p string) (*Downloader, error) {
+// Takes the site url, a username and password. Logging in is canceled
+// with ctx.
+func New(ctx context.Context, s string, u string, p string) (*Downloader, error) {
 	cj, err := cookiejar.New(nil)
 	if err != nil {
 		return nil, err
@@ -35,7 +90,9 @@ func New(s string, u string, p string) (*Downloader, error) {
 	// Initialize the object
 	d := &Downloader{
 		client: &http.Client{
-			Jar: cj,
+			Jar:       cj,
+			Timeout:   5 * time.Minute,
+			Transport: &headerTransport{base: &compressedTransport{}},
 		},
 		site:     s,
 		username: u,
@@ -43,40 +100,54 @@ func New(s string, u string, p string) (*Downloader, error) {
 	}
 
 	// Go ahead and login
-	err = d.Login()
-	if err != nil {
-		return nil, errors.New("Login Failed. " + err.Error())
+	if err := d.Login(ctx); err != nil {
+		return nil, err
 	}
 
 	return d, nil
 }
 
 // Login() authenticates with ShopKeep.
-// Returns a non-nil error value if login fails.
-func (d *Downloader) Login() error {
+// Returns a non-nil error value if login fails, saying why: ShopKeep
+// couldn't be reached (ErrUnreachable), the site isn't a ShopKeep login page
+// (ErrNoLoginForm), the credentials were rejected (ErrInvalidCredentials),
+// the account is locked (ErrAccountLocked), ShopKeep is blocking logins
+// (ErrCaptcha or ErrMaintenance), or it sent something else
+// (ErrUnexpectedPage). It is canceled with ctx.
+func (d *Downloader) Login(ctx context.Context) error {
 	// Get the login page
-	lp, err := d.client.Get(d.site)
+	lp, err := d.get(ctx, d.site)
 	if err != nil {
-		return errors.New("Could not get: " + d.site)
+		return errors.New(ErrUnreachable.Error() + " at " + d.site + ": " + err.Error())
 	}
 	defer lp.Body.Close()
 
 	// Pull the login page into a goquery.Document
 	loginPage, err := goquery.NewDocumentFromReader(lp.Body)
 	if err != nil {
-		return errors.New("Failed to login: Could not read response body.")
+		return errors.New(ErrUnreachable.Error() + " at " + d.site + ": " + err.Error())
+	}
+
+	// ShopKeep may serve a captcha or maintenance page instead.
+	if err := blocked(lp.StatusCode, loginPage); err != nil {
+		return err
+	}
+
+	if !loginForm(loginPage) {
+		dumpDoc("login", loginPage)
+		return errors.New(ErrNoLoginForm.Error() + ". " + d.site + " answered with " + describePage(lp.Status, loginPage))
 	}
 
 	// Determine what the authenticity token is.
 	at := authToken(loginPage)
 	if at == "" {
-		return errors.New("Failed to find authenticity_token.")
+		dumpDoc("login", loginPage)
+		return errors.New(ErrUnexpectedPage.Error() + ": the login form has no authenticity_token")
 	}
 	d.authenticity_token = at
-	log.Println("Found authenticity_token: " + d.authenticity_token)
 
 	// Get the homepage by posting login credentials
-	hp, err := d.client.PostForm(d.site+"/session",
+	hp, err := d.postForm(ctx, d.site+"/session",
 		url.Values{
 			"authenticity_token": {d.authenticity_token},
 			"utf8":               {"✓"},
@@ -85,158 +156,301 @@ func (d *Downloader) Login() error {
 			"commit":             {"Sign in"},
 		})
 	if err != nil {
-		return errors.New("Failed POSTing login form: " + err.Error())
+		return errors.New(ErrUnreachable.Error() + " at " + d.site + "/session: " + err.Error())
 	}
 	defer hp.Body.Close()
 
 	// Pull the homepage response into a goquery.Document
 	homePage, err := goquery.NewDocumentFromReader(hp.Body)
 	if err != nil {
-		return errors.New("Failed to access homepage: " + err.Error())
+		return errors.New(ErrUnreachable.Error() + " at " + d.site + "/session: " + err.Error())
+	}
+
+	if err := blocked(hp.StatusCode, homePage); err != nil {
+		return err
 	}
 
 	// Check the login status.
 	// Can't simply check response status (ShopKeep returns 200 whether login was successful or not).
 	// Can't check location header as it is not included in the response.
 	if loginStatus(homePage) == false {
-		return errors.New("Invalid username or password")
+		return loginError(hp.Status, homePage)
 	}
 
 	log.Println("Login successful!")
+	d.confirmLogin(time.Now())
 
 	return nil
 }
 
-// Downloads the Sold Items report from startDate to endDate to path p.
-// Dates must be in the form YYYY-MM-DD.
-func (d *Downloader) GetSoldItemsReport(p string, startDate string, endDate string) error {
-	if d.LoggedIn() == false {
-		return errors.New("Not logged in. Perhaps call Login()?")
-	}
+// Logout() ends the session with ShopKeep and forgets its cookies, so
+// sessions don't pile up on the account. Call Login() to use the
+// Downloader again.
+func (d *Downloader) Logout() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
 
-	// Get the Sold Items download page by POSTing relevant information.
-	sip, err := d.client.PostForm(d.site+"/sold_items/create_export",
+	res, err := d.postForm(ctx, d.site+"/session",
 		url.Values{
 			"authenticity_token": {d.authenticity_token},
-			"utf8":               {"✓"},
-			"start_date":         {startDate},
-			"end_date":           {endDate},
-			"chart_requested":    {},
-			"grouped_by":         {},
-			"commit":             {"Retrieve"},
+			"_method":            {"delete"},
 		})
-	if err != nil {
-		return errors.New("Failed POSTing sold_items/create_export form. " + err.Error())
-	}
-	defer sip.Body.Close()
 
-	// Return an error if the status code is not success.
-	// This is useful when parameters are POSTed incorrectly.
-	if sip.StatusCode != 200 {
-		return errors.New("sold_items/create_export responded with " + sip.Status)
-	}
+	// Forget the session even if ShopKeep couldn't be told.
+	cj, _ := cookiejar.New(nil)
+	d.client.Jar = cj
+	d.authenticity_token = ""
+	d.confirmLogin(time.Time{})
 
-	// Pull the export respones into a goquery.Document
-	soldItemsPage, err := goquery.NewDocumentFromReader(sip.Body)
 	if err != nil {
-		return errors.New("Failed to access sold_items/create_export results. " + err.Error())
+		return errors.New("Failed to log out. " + err.Error())
 	}
+	defer res.Body.Close()
 
-	// Find the URL of the export
-	reportURL, exists := soldItemsPage.Find(`#download_button input.button[type="submit"]`).Attr("data_reportfile")
-	if !exists {
-		return errors.New("Failed to find a download link for the Sold Items export")
+	if res.StatusCode >= 400 {
+		return errors.New("Failed to log out. session responded with " + res.Status)
 	}
 
-	// Get the CSV file
-	reportRes, err := d.client.Get(reportURL)
-	if err != nil {
-		return errors.New("Failed to download the report from " + reportURL + " " + err.Error())
-	}
-	defer reportRes.Body.Close()
+	return nil
+}
 
-	// Read the CSV
-	report, err := ioutil.ReadAll(reportRes.Body)
+// Downloads the Sold Items report from startDate to endDate to path p.
+// Only the days of the dates are used, in their own locations.
+func (d *Downloader) GetSoldItemsReport(p string, startDate time.Time, endDate time.Time) error {
+	report, err := d.SoldItemsReport(context.Background(), startDate, endDate, ReportOptions{})
 	if err != nil {
-		return errors.New("Failed to read report. " + err.Error())
+		return err
 	}
 
-	// Write the CSV to the given file
-	err = ioutil.WriteFile(p, report, 0644)
-	if err != nil {
-		return errors.New("Failed to write file to " + p + " Error: " + err.Error())
+	return writeReport(p, report)
+}
+
+// Returns the contents of the Sold Items report from startDate to endDate,
+// as o asks. Only the days of the dates are used, in their own locations.
+// See CheckDates().
+func (d *Downloader) SoldItemsReport(ctx context.Context, startDate time.Time, endDate time.Time, o ReportOptions) ([]byte, error) {
+	if err := CheckDates(startDate, endDate); err != nil {
+		return nil, err
+	}
+	if d.LoggedIn(ctx) == false {
+		return nil, errors.New("Not logged in. Perhaps call Login()?")
 	}
 
-	return nil
+	// Get the Sold Items download page by POSTing relevant information.
+	form := o.values()
+	form.Set("start_date", startDate.Format(DateLayout))
+	form.Set("end_date", endDate.Format(DateLayout))
+	key := "sold_items/create_export?" + form.Encode()
+	form.Set("authenticity_token", d.authenticity_token)
+	form.Set("utf8", "✓")
+	form.Set("commit", "Retrieve")
+
+	return d.exportReport(ctx, key, func() (string, error) {
+		sip, err := d.postForm(ctx, d.site+"/sold_items/create_export", form)
+		if err != nil {
+			return "", errors.New("Failed POSTing sold_items/create_export form. " + err.Error())
+		}
+		defer sip.Body.Close()
+
+		// Return an error if the status code is not success.
+		// This is useful when parameters are POSTed incorrectly.
+		if sip.StatusCode != 200 {
+			return "", errors.New("sold_items/create_export responded with " + sip.Status)
+		}
+
+		// Pull the export respones into a goquery.Document
+		soldItemsPage, err := goquery.NewDocumentFromReader(sip.Body)
+		if err != nil {
+			return "", errors.New("Failed to access sold_items/create_export results. " + err.Error())
+		}
+
+		// Find the URL of the export
+		reportURL, exists := soldItemsPage.Find(`#download_button input.button[type="submit"]`).Attr("data_reportfile")
+		if !exists {
+			dumpDoc("sold_items_export", soldItemsPage)
+			return "", errors.New("Failed to find a download link for the Sold Items export")
+		}
+
+		return reportURL, nil
+	})
 }
 
 // Downloads the Stock Items report to path p.
-// Dates must be in the form YYYY-MM-DD.
 func (d *Downloader) GetStockItemsReport(p string) error {
-	if d.LoggedIn() == false {
-		return errors.New("Not logged in. Perhaps call Login()?")
+	report, err := d.StockItemsReport(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return writeReport(p, report)
+}
+
+// Returns the contents of the Stock Items report.
+func (d *Downloader) StockItemsReport(ctx context.Context) ([]byte, error) {
+	if d.LoggedIn(ctx) == false {
+		return nil, errors.New("Not logged in. Perhaps call Login()?")
 	}
 
 	// Get the Stock Items download page by POSTing relevant information.
-	sip, err := d.client.Get(d.site+"/create_stock_items_export")
-	if err != nil {
-		return errors.New("Failed GETing create_stock_items_export. " + err.Error())
+	return d.exportReport(ctx, "create_stock_items_export", func() (string, error) {
+		sip, err := d.get(ctx, d.site+"/create_stock_items_export")
+		if err != nil {
+			return "", errors.New("Failed GETing create_stock_items_export. " + err.Error())
+		}
+		defer sip.Body.Close()
+
+		// Return an error if the status code is not success.
+		if sip.StatusCode != 200 {
+			return "", errors.New("create_stock_items_export responded with " + sip.Status)
+		}
+
+		// Pull the export respones into a goquery.Document
+		stockItemsPage, err := goquery.NewDocumentFromReader(sip.Body)
+		if err != nil {
+			return "", errors.New("Failed to access create_stock_items_export results. " + err.Error())
+		}
+
+		// Find the URL of the export
+		reportURL, exists := stockItemsPage.Find(`input.button[type="submit"]`).Attr("data_reportfile")
+		if !exists {
+			dumpDoc("stock_items_export", stockItemsPage)
+			return "", errors.New("Failed to find a download link for the Stock Items export")
+		}
+
+		return reportURL, nil
+	})
+}
+
+// Checks to see if the Downloader is currently logged in.
+// It is cheap to call often: without session cookies it is logged out, and
+// a login confirmed in the last few minutes is trusted. Only otherwise is
+// the homepage fetched to check, canceled with ctx.
+func (d *Downloader) LoggedIn(ctx context.Context) bool {
+	site, err := url.Parse(d.site)
+	if err != nil || len(d.client.Jar.Cookies(site)) == 0 {
+		// The jar drops cookies once they expire.
+		return false
 	}
-	defer sip.Body.Close()
 
-	// Return an error if the status code is not success.
-	if sip.StatusCode != 200 {
-		return errors.New("create_stock_items_export responded with " + sip.Status)
+	d.mu.Lock()
+	confirmed := d.loggedInAt
+	d.mu.Unlock()
+	if !confirmed.IsZero() && time.Since(confirmed) < loginCheckTTL {
+		return true
 	}
 
-	// Pull the export respones into a goquery.Document
-	stockItemsPage, err := goquery.NewDocumentFromReader(sip.Body)
+	hp, err := d.get(ctx, d.site)
 	if err != nil {
-		return errors.New("Failed to access create_stock_items_export results. " + err.Error())
+		return false
 	}
+	defer hp.Body.Close()
 
-	// Find the URL of the export
-	reportURL, exists := stockItemsPage.Find(`input.button[type="submit"]`).Attr("data_reportfile")
-	if !exists {
-		return errors.New("Failed to find a download link for the Stock Items export")
+	homePage, err := goquery.NewDocumentFromReader(hp.Body)
+	if err != nil {
+		return false
 	}
 
-	// Get the CSV file
-	reportRes, err := d.client.Get(reportURL)
-	if err != nil {
-		return errors.New("Failed to download the report from " + reportURL + " " + err.Error())
+	if !loginStatus(homePage) {
+		d.confirmLogin(time.Time{})
+		return false
 	}
-	defer reportRes.Body.Close()
+	d.confirmLogin(time.Now())
+	return true
+}
+
+// Records when the login was last confirmed, or that it is over if t is zero.
+func (d *Downloader) confirmLogin(t time.Time) {
+	d.mu.Lock()
+	d.loggedInAt = t
+	d.mu.Unlock()
+}
 
-	// Read the CSV
-	report, err := ioutil.ReadAll(reportRes.Body)
+// Makes a GET request of u that is canceled with ctx.
+func (d *Downloader) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		return errors.New("Failed to read report. " + err.Error())
+		return nil, err
 	}
+	return d.client.Do(req)
+}
 
-	// Write the CSV to the given file
-	err = ioutil.WriteFile(p, report, 0644)
+// POSTs the form data to u in a request that is canceled with ctx.
+func (d *Downloader) postForm(ctx context.Context, u string, data url.Values) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", u, strings.NewReader(data.Encode()))
 	if err != nil {
-		return errors.New("Failed to write file to " + p + " Error: " + err.Error())
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return d.client.Do(req)
+}
+
+// CheckDates() returns an error if the days from startDate to endDate can't
+// be downloaded: either is missing, they are out of order, or endDate is
+// after today where it is.
+func CheckDates(startDate time.Time, endDate time.Time) error {
+	if startDate.IsZero() || endDate.IsZero() {
+		return ErrMissingDate
+	}
+
+	start, end := startDate.Format(DateLayout), endDate.Format(DateLayout)
+	if start > end {
+		return errors.New(ErrDateOrder.Error() + ": " + start + " to " + end)
+	}
+	if end > time.Now().In(endDate.Location()).Format(DateLayout) {
+		return errors.New(ErrFutureDate.Error() + ": " + end)
 	}
 
 	return nil
 }
 
-// Checks to see if the Downloader is currently logged in.
-func (d *Downloader) LoggedIn() bool {
-	hp, err := d.client.Get(d.site)
+// Reads the body of res, a downloaded report, failing with ErrTooLarge as
+// soon as it is known to exceed MaxReportSize, and with ErrNotCSV if it is a
+// web page.
+func readReport(res *http.Response) ([]byte, error) {
+	var body io.Reader = res.Body
+	if MaxReportSize > 0 {
+		if res.ContentLength > MaxReportSize {
+			return nil, ErrTooLarge
+		}
+		body = io.LimitReader(res.Body, MaxReportSize+1)
+	}
+
+	report, err := ioutil.ReadAll(body)
 	if err != nil {
-		return false
+		return nil, errors.New("Failed to read report. " + err.Error())
+	}
+	if MaxReportSize > 0 && int64(len(report)) > MaxReportSize {
+		return nil, ErrTooLarge
 	}
-	defer hp.Body.Close()
 
-	homePage, err := goquery.NewDocumentFromReader(hp.Body)
+	if t, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); t == "text/html" {
+		return nil, pageError(report)
+	}
+	return report, nil
+}
+
+// Returns ErrNotCSV, naming the title of page if it has one, since that
+// usually says what went wrong. page is saved to DebugDir.
+func pageError(page []byte) error {
+	dumpPage("report", page)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
 	if err != nil {
-		return false
+		return ErrNotCSV
+	}
+	if title := strings.TrimSpace(doc.Find("title").First().Text()); title != "" {
+		return errors.New(ErrNotCSV.Error() + ": " + title)
+	}
+	return ErrNotCSV
+}
+
+// Writes a downloaded report to path p.
+func writeReport(p string, report []byte) error {
+	err := ioutil.WriteFile(p, report, 0644)
+	if err != nil {
+		return errors.New("Failed to write file to " + p + " Error: " + err.Error())
 	}
 
-	return loginStatus(homePage)
+	return nil
 }
 
 // Gets the authenticity token from a form in a goquery.Document.
@@ -245,6 +459,21 @@ func authToken(doc *goquery.Document) string {
 	return at
 }
 
+// Returns ErrCaptcha or ErrMaintenance if a page ShopKeep responded with
+// status is a captcha or maintenance page, or nil if it is neither.
+func blocked(status int, doc *goquery.Document) error {
+	if doc.Find(`.g-recaptcha, .h-captcha, #challenge-form, iframe[src*="captcha"], script[src*="captcha"]`).Length() > 0 {
+		return ErrCaptcha
+	}
+
+	title := strings.ToLower(doc.Find("title").Text())
+	if status == http.StatusServiceUnavailable || strings.Contains(title, "maintenance") {
+		return ErrMaintenance
+	}
+
+	return nil
+}
+
 // Determines whether or not the client is currently logged in based on a goquery.Document.
 func loginStatus(doc *goquery.Document) bool {
 	if doc.Find(`#user-controls`).Length() > 0 {
@@ -253,3 +482,34 @@ func loginStatus(doc *goquery.Document) bool {
 
 	return false
 }
+
+// Determines whether a goquery.Document holds ShopKeep's login form.
+func loginForm(doc *goquery.Document) bool {
+	return doc.Find(`form input[type="password"]`).Length() > 0
+}
+
+// Returns why ShopKeep sent doc, with status, in answer to the login form
+// rather than the homepage: the account is locked, the credentials were
+// wrong and the form was sent again, or something else entirely.
+func loginError(status string, doc *goquery.Document) error {
+	flash := strings.ToLower(doc.Find(`.flash, .alert, .notice, .error, #flash, #error_explanation`).Text())
+	if strings.Contains(flash, "locked") {
+		return ErrAccountLocked
+	}
+
+	if loginForm(doc) {
+		return ErrInvalidCredentials
+	}
+
+	dumpDoc("session", doc)
+	return errors.New(ErrUnexpectedPage.Error() + ": " + describePage(status, doc))
+}
+
+// Describes a page ShopKeep sent by its status and title, such as
+// "404 Not Found (Page not found)", for errors.
+func describePage(status string, doc *goquery.Document) string {
+	if title := strings.TrimSpace(doc.Find("title").First().Text()); title != "" {
+		return status + " (" + title + ")"
+	}
+	return status
+}
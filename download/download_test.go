@@ -1,27 +1,40 @@
-package download
+package download_test
+
+import (
+	"context"
+	"github.com/jfmarket/report-cacher/download"
+	"log"
+	"time"
+)
 
 func Example() {
-	downloader, err := download.New("https://jonesboroughfarmersmkt.shopkeepapp.com", "chad@snapstudent.com", "password")
+	downloader, err := download.New(context.Background(), "https://jonesboroughfarmersmkt.shopkeepapp.com", "chad@snapstudent.com", "password")
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	err = downloader.GetSoldItemsReport("files/sold_items.csv", "2014-02-28", "2014-03-29")
+	err = downloader.GetSoldItemsReport("files/sold_items.csv", time.Date(2014, 2, 28, 0, 0, 0, 0, time.Local), time.Date(2014, 3, 29, 0, 0, 0, 0, time.Local))
 	if err != nil {
 		log.Fatalln(err)
 	}
 }
 
 func ExampleDownloader_GetSoldItemsReport() {
-	err := downloader.GetSoldItemsReport("files/sold_items.csv", "2014-02-28", "2014-03-29")
+	downloader, err := download.New(context.Background(), "https://jonesboroughfarmersmkt.shopkeepapp.com", "chad@snapstudent.com", "password")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = downloader.GetSoldItemsReport("files/sold_items.csv", time.Date(2014, 2, 28, 0, 0, 0, 0, time.Local), time.Date(2014, 3, 29, 0, 0, 0, 0, time.Local))
 	if err != nil {
 		log.Fatalln(err)
 	}
 }
 
 func ExampleNew() {
-	downloader, err := download.New("https://jonesboroughfarmersmkt.shopkeepapp.com", "chad@snapstudent.com", "password")
+	downloader, err := download.New(context.Background(), "https://jonesboroughfarmersmkt.shopkeepapp.com", "chad@snapstudent.com", "password")
 	if err != nil {
 		log.Fatalln(err)
 	}
+	defer downloader.Logout()
 }
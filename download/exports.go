@@ -0,0 +1,184 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How long an export ShopKeep made is reused for when its report hasn't
+// been downloaded yet, before it is assumed stale or expired.
+const exportTTL = 15 * time.Minute
+
+// A pendingExport is an export ShopKeep was asked to make whose report
+// hasn't been downloaded yet. Asking for the same report again, such as
+// when a failed download is retried, reuses it rather than queueing another.
+type pendingExport struct {
+	sync.Mutex
+	url     string    // The address of the export's report. Empty once it has been downloaded.
+	created time.Time // When ShopKeep was asked to make it.
+}
+
+// pendingExports are the exports asked for by every Downloader, by the
+// site, account and request that asked for them, so a Downloader made for
+// the next update can reuse them too.
+var pendingExports struct {
+	sync.Mutex
+	byKey map[string]*pendingExport
+}
+
+// Returns the pendingExport for the export of the request key, made with
+// d's account.
+func (d *Downloader) pendingExport(key string) *pendingExport {
+	key = d.site + " " + d.username + " " + key
+
+	pendingExports.Lock()
+	defer pendingExports.Unlock()
+
+	if pendingExports.byKey == nil {
+		pendingExports.byKey = make(map[string]*pendingExport)
+	}
+	pe := pendingExports.byKey[key]
+	if pe == nil {
+		pe = &pendingExport{}
+		pendingExports.byKey[key] = pe
+	}
+	return pe
+}
+
+// Returns the report of the export asked for by key, the page and form
+// used, such as sold_items/create_export?end_date=2014-03-25&start_date=2014-03-18.
+// An export already made for key in the last exportTTL whose report wasn't
+// downloaded is reused. Otherwise create is called to ask ShopKeep for one,
+// returning the address of its report. Requests for the same key wait for
+// each other, so only one export is made at a time.
+func (d *Downloader) exportReport(ctx context.Context, key string, create func() (string, error)) ([]byte, error) {
+	pe := d.pendingExport(key)
+	pe.Lock()
+	defer pe.Unlock()
+
+	if pe.url != "" && time.Since(pe.created) < exportTTL {
+		log.Println("Reusing the export of " + key + " made at " + pe.created.Format(time.Kitchen))
+		report, err := d.fetchReport(ctx, pe.url)
+		if err == nil || ctx.Err() != nil {
+			if err == nil {
+				pe.url = ""
+			}
+			return report, err
+		}
+		log.Println("Failed to download the export of " + key + " again. Asking for another. Error: " + err.Error())
+	}
+	pe.url = ""
+
+	u, err := create()
+	if err != nil {
+		return nil, err
+	}
+	pe.url, pe.created = u, time.Now()
+
+	report, err := d.fetchReport(ctx, u)
+	if err == nil {
+		pe.url = ""
+	}
+	return report, err
+}
+
+// ErrNoExportsPage is returned when the ShopKeep site has no page listing
+// its exports, so they can't be listed or canceled.
+var ErrNoExportsPage = errors.New("ShopKeep doesn't list exports on this site")
+
+// An Export is an export listed on ShopKeep's BackOffice exports page.
+type Export struct {
+	ID      string
+	Name    string    // Such as Sold Items.
+	Status  string    // As ShopKeep shows it, in lower case, such as processing.
+	Created time.Time // Zero if the page doesn't say.
+}
+
+// Pending() reports whether ShopKeep is still making e, taking up one of
+// the account's export slots.
+func (e Export) Pending() bool {
+	switch e.Status {
+	case "complete", "completed", "ready", "done", "failed", "error", "canceled", "cancelled", "expired":
+		return false
+	}
+	return true
+}
+
+// Returns the exports ShopKeep is still making for the account, such as
+// ones asked for by a run that crashed before downloading them. Returns
+// ErrNoExportsPage where the BackOffice doesn't list exports.
+func (d *Downloader) PendingExports(ctx context.Context) ([]Export, error) {
+	if d.LoggedIn(ctx) == false {
+		return nil, errors.New("Not logged in. Perhaps call Login()?")
+	}
+
+	res, err := d.get(ctx, d.site+"/exports")
+	if err != nil {
+		return nil, errors.New("Failed GETing exports. " + err.Error())
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, ErrNoExportsPage
+	}
+	if res.StatusCode != 200 {
+		return nil, errors.New("exports responded with " + res.Status)
+	}
+
+	page, err := goquery.NewDocumentFromReader(res.Body)
+	if err != nil {
+		return nil, errors.New("Failed to access exports. " + err.Error())
+	}
+
+	exports := []Export{}
+	page.Find(`[data-export-id]`).Each(func(_ int, s *goquery.Selection) {
+		e := Export{
+			ID:     s.AttrOr("data-export-id", ""),
+			Name:   strings.TrimSpace(s.Find(`.export-name, .name`).First().Text()),
+			Status: strings.ToLower(strings.TrimSpace(s.Find(`.export-status, .status`).First().Text())),
+		}
+		if t, err := time.Parse(time.RFC3339, s.Find(`time[datetime]`).First().AttrOr("datetime", "")); err == nil {
+			e.Created = t
+		}
+		if e.Pending() {
+			exports = append(exports, e)
+		}
+	})
+
+	return exports, nil
+}
+
+// Cancels the export with id on ShopKeep, freeing its slot. See
+// PendingExports().
+func (d *Downloader) CancelExport(ctx context.Context, id string) error {
+	if d.LoggedIn(ctx) == false {
+		return errors.New("Not logged in. Perhaps call Login()?")
+	}
+
+	res, err := d.postForm(ctx, d.site+"/exports/"+url.PathEscape(id), url.Values{
+		"authenticity_token": {d.authenticity_token},
+		"utf8":               {"✓"},
+		"_method":            {"delete"},
+	})
+	if err != nil {
+		return errors.New("Failed to cancel export " + id + ". " + err.Error())
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return errors.New("Failed to cancel export " + id + ". ShopKeep has no such export.")
+	case res.StatusCode >= 400:
+		return errors.New("Failed to cancel export " + id + ". exports responded with " + res.Status)
+	}
+
+	log.Println("Canceled export " + id)
+	return nil
+}
@@ -0,0 +1,179 @@
+// This package records responses from ShopKeep to files and serves them
+// back, so changes to the scraper can be worked on offline against real
+// pages. A Recorder saves each response, with passwords, tokens, cookies and
+// email addresses replaced, and a Replayer answers requests from the saved
+// files instead of the network.
+//     http.DefaultTransport = &fixture.Recorder{Base: http.DefaultTransport, Dir: "testdata/session"}
+//     http.DefaultTransport = &fixture.Replayer{Dir: "testdata/session"}
+package fixture
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Replaced is what secrets are replaced with in recorded responses.
+const Replaced = "fixture"
+
+// What is replaced in recorded bodies and headers, and with what.
+var replacements = []struct {
+	pattern *regexp.Regexp
+	with    string
+}{
+	{regexp.MustCompile(`(name="authenticity_token"[^>]*value=")[^"]*`), "${1}" + Replaced},
+	{regexp.MustCompile(`(value=")[^"]*("[^>]*name="authenticity_token")`), "${1}" + Replaced + "${2}"},
+	{regexp.MustCompile(`(name="csrf-token"[^>]*content=")[^"]*`), "${1}" + Replaced},
+	{regexp.MustCompile(`(?i)((?:access_token|token|password|secret|signature|x-amz-signature|x-amz-credential|x-amz-security-token)=)[^&"'\s<]+`), "${1}" + Replaced},
+	{regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), "user@example.com"},
+}
+
+// Sanitize() returns data with the secrets and email addresses in it
+// replaced.
+func Sanitize(data []byte) []byte {
+	for _, r := range replacements {
+		data = r.pattern.ReplaceAll(data, []byte(r.with))
+	}
+	return data
+}
+
+// Name() returns the name of the file holding the nth response, from 0, to
+// a request for method and path, such as GET_sold_items_create_export.1.http.
+// Queries are left out, so their tokens don't matter.
+func Name(method string, path string, n int) string {
+	slug := strings.Trim(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(path, "_"), "_")
+	if slug == "" {
+		slug = "root"
+	}
+	return method + "_" + slug + "." + strconv.Itoa(n) + ".http"
+}
+
+// A Recorder is an http.RoundTripper that makes requests with Base and
+// saves each response in Dir, sanitized. Repeated requests for a path are
+// numbered in order, since the same page differs before and after logging in.
+type Recorder struct {
+	Base http.RoundTripper
+	Dir  string
+
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func (rec *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Leave compression to Base, so what is saved can be read.
+	if req.Header.Get("Accept-Encoding") != "" {
+		req = req.Clone(req.Context())
+		req.Header.Del("Accept-Encoding")
+	}
+
+	res, err := rec.Base.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	rec.mu.Lock()
+	if rec.count == nil {
+		rec.count = make(map[string]int)
+	}
+	key := req.Method + " " + req.URL.Path
+	n := rec.count[key]
+	rec.count[key]++
+	rec.mu.Unlock()
+
+	if err := rec.save(Name(req.Method, req.URL.Path, n), res, body); err != nil {
+		return nil, errors.New("Failed to record " + key + ". " + err.Error())
+	}
+	return res, nil
+}
+
+// Writes res, whose body is body, to the file name in Dir.
+func (rec *Recorder) save(name string, res *http.Response, body []byte) error {
+	if err := os.MkdirAll(rec.Dir, 0755); err != nil {
+		return err
+	}
+
+	saved := *res
+	saved.Header = res.Header.Clone()
+	saved.Header.Del("Content-Length")
+	saved.Header.Del("Content-Encoding")
+	saved.Header.Del("Transfer-Encoding")
+	if cookies := res.Cookies(); len(cookies) > 0 {
+		saved.Header.Del("Set-Cookie")
+		for _, c := range cookies {
+			c.Value = Replaced
+			saved.Header.Add("Set-Cookie", c.String())
+		}
+	}
+	if l := saved.Header.Get("Location"); l != "" {
+		saved.Header.Set("Location", string(Sanitize([]byte(l))))
+	}
+
+	body = Sanitize(body)
+	saved.Body = ioutil.NopCloser(bytes.NewReader(body))
+	saved.ContentLength = int64(len(body))
+	saved.TransferEncoding = nil
+	saved.Uncompressed = false
+
+	data, err := httputil.DumpResponse(&saved, true)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(rec.Dir, name), data, 0644)
+}
+
+// A Replayer is an http.RoundTripper answering requests with the responses
+// a Recorder saved in Dir, whatever host they are for. The nth request for
+// a path gets the nth response recorded for it, or the last if there were
+// fewer. Requests nothing was recorded for get an error.
+type Replayer struct {
+	Dir string
+
+	mu    sync.Mutex
+	count map[string]int
+}
+
+func (rep *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+
+	rep.mu.Lock()
+	if rep.count == nil {
+		rep.count = make(map[string]int)
+	}
+	key := req.Method + " " + req.URL.Path
+	n := rep.count[key]
+	rep.count[key]++
+	rep.mu.Unlock()
+
+	var data []byte
+	var err error
+	for ; n >= 0; n-- {
+		data, err = ioutil.ReadFile(filepath.Join(rep.Dir, Name(req.Method, req.URL.Path, n)))
+		if !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Nothing was recorded for %s. %v", key, err)
+	}
+
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+}
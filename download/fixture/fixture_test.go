@@ -0,0 +1,77 @@
+package fixture
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const loginPage = `<html><head><meta name="csrf-token" content="c5rf" /></head><body>
+<form action="/session" method="post">
+<input name="authenticity_token" type="hidden" value="s3cret" />
+<p>Signed in as manager@jfmarket.org</p>
+<a href="https://bucket.s3.amazonaws.com/sold_items.csv?X-Amz-Signature=abc123&X-Amz-Expires=60">Download</a>
+</form></body></html>`
+
+func TestSanitize(t *testing.T) {
+	got := string(Sanitize([]byte(loginPage)))
+	for _, secret := range []string{"c5rf", "s3cret", "manager@jfmarket.org", "abc123"} {
+		if strings.Contains(got, secret) {
+			t.Errorf("%q was not replaced:\n%s", secret, got)
+		}
+	}
+	if !strings.Contains(got, "X-Amz-Expires=60") || !strings.Contains(got, `value="fixture"`) {
+		t.Errorf("Sanitize() changed too much:\n%s", got)
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	visits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		visits++
+		http.SetCookie(w, &http.Cookie{Name: "_session", Value: "real-session", Path: "/"})
+		if visits == 1 {
+			w.Write([]byte(loginPage))
+		} else {
+			w.Write([]byte(`<div id="user-controls"></div>`))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorded := &http.Client{Transport: &Recorder{Base: http.DefaultTransport, Dir: dir}}
+	for i := 0; i < 2; i++ {
+		res, err := recorded.Get(server.URL + "/?token=abc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if i == 0 && !strings.Contains(string(body), "s3cret") {
+			t.Error("Recorder changed the response it returned")
+		}
+	}
+
+	replayed := &http.Client{Transport: &Replayer{Dir: dir}}
+	want := []string{`value="fixture"`, "user-controls", "user-controls"}
+	for i, w := range want {
+		res, err := replayed.Get("https://shopkeep.example/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.Contains(string(body), w) {
+			t.Errorf("replay %d = %s, want %s", i, body, w)
+		}
+		if c := res.Cookies(); len(c) != 1 || c[0].Value != Replaced {
+			t.Errorf("replay %d cookies = %v", i, c)
+		}
+	}
+
+	if _, err := replayed.Get("https://shopkeep.example/missing"); err == nil {
+		t.Error("replaying an unrecorded path succeeded")
+	}
+}
@@ -0,0 +1,42 @@
+package download
+
+import (
+	"net/http"
+)
+
+// UserAgent is the User-Agent sent with every request to ShopKeep, such as
+// a browser's, since ShopKeep treats some clients differently from others.
+// Empty sends Go's.
+var UserAgent string
+
+// Header holds extra headers sent with every request to ShopKeep, such as
+// Accept-Language. Headers a request sets itself, such as Range, are left
+// as they are.
+var Header = http.Header{}
+
+// headerTransport adds UserAgent and Header to each request.
+type headerTransport struct {
+	base http.RoundTripper // When nil, http.DefaultTransport.
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if UserAgent == "" && len(Header) == 0 {
+		return base.RoundTrip(req)
+	}
+
+	req = req.Clone(req.Context())
+	if UserAgent != "" {
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	for name, values := range Header {
+		if req.Header.Get(name) == "" {
+			req.Header[name] = values
+		}
+	}
+
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,59 @@
+package download
+
+import (
+	"errors"
+	"net/url"
+)
+
+// A Grouping is how the rows of the Sold Items report are grouped, as on
+// ShopKeep's Sold Items page.
+type Grouping string
+
+// The groupings ShopKeep supports. Ungrouped lists each item once.
+const (
+	Ungrouped    Grouping = ""
+	ByDepartment Grouping = "department"
+	ByCategory   Grouping = "category"
+	BySupplier   Grouping = "supplier"
+)
+
+// The column each grouping adds to the report.
+var groupingColumns = map[Grouping]string{
+	ByDepartment: "Department",
+	ByCategory:   "Category",
+	BySupplier:   "Supplier",
+}
+
+// ParseGrouping() returns the Grouping named s, such as department.
+func ParseGrouping(s string) (Grouping, error) {
+	g := Grouping(s)
+	if _, ok := groupingColumns[g]; !ok && g != Ungrouped {
+		return Ungrouped, errors.New("Unknown grouping " + s + ". Use department, category or supplier.")
+	}
+	return g, nil
+}
+
+// Column() returns the name of the column rows are grouped by, such as
+// Department, or "" when they aren't grouped.
+func (g Grouping) Column() string {
+	return groupingColumns[g]
+}
+
+// ReportOptions change what ShopKeep puts in an export. The zero value
+// asks for the plain report.
+type ReportOptions struct {
+	GroupedBy Grouping
+	Chart     bool // Asks ShopKeep to draw its chart too. The CSV is the same either way.
+}
+
+// Returns the fields ShopKeep's export form takes for the options.
+func (o ReportOptions) values() url.Values {
+	v := url.Values{
+		"chart_requested": {},
+		"grouped_by":      {string(o.GroupedBy)},
+	}
+	if o.Chart {
+		v.Set("chart_requested", "true")
+	}
+	return v
+}
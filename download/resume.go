@@ -0,0 +1,131 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// How many times a download whose connection drops is resumed before
+// giving up on it.
+const resumeAttempts = 3
+
+// Downloads the export at u, ShopKeep's link to a finished report. It is
+// written to a .part file in the temporary directory as it arrives, and if
+// the connection drops, the download is resumed from where it stopped with
+// a Range request, where the server allows it, rather than started over.
+// Fails like readReport().
+func (d *Downloader) fetchReport(ctx context.Context, u string) ([]byte, error) {
+	part, err := ioutil.TempFile("", "report-*.part")
+	if err != nil {
+		return nil, errors.New("Failed to create a .part file. " + err.Error())
+	}
+	defer os.Remove(part.Name())
+	defer part.Close()
+
+	var written int64
+	var validator string
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if written > 0 {
+			req.Header.Set("Range", "bytes="+strconv.FormatInt(written, 10)+"-")
+			if validator != "" {
+				req.Header.Set("If-Range", validator)
+			}
+		}
+
+		res, err := d.client.Do(req)
+		if err != nil {
+			if written > 0 && attempt < resumeAttempts && ctx.Err() == nil {
+				continue
+			}
+			return nil, errors.New("Failed to download the report from " + u + " " + err.Error())
+		}
+
+		switch {
+		case written > 0 && res.StatusCode == http.StatusPartialContent && rangeStart(res) == written:
+		case res.StatusCode == http.StatusOK:
+			// A fresh copy, either the first or because the export changed.
+			written = 0
+			if err := part.Truncate(0); err != nil {
+				res.Body.Close()
+				return nil, err
+			}
+			if MaxReportSize > 0 && res.ContentLength > MaxReportSize {
+				res.Body.Close()
+				return nil, ErrTooLarge
+			}
+		default:
+			res.Body.Close()
+			return nil, errors.New("The report download responded with " + res.Status)
+		}
+		if _, err := part.Seek(written, io.SeekStart); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+
+		// Compressed downloads can't be resumed part way through, since
+		// the offset of what was decompressed isn't known.
+		resumable := !res.Uncompressed && (res.StatusCode == http.StatusPartialContent || res.Header.Get("Accept-Ranges") == "bytes")
+		if v := res.Header.Get("ETag"); v != "" && !strings.HasPrefix(v, "W/") {
+			validator = v
+		} else {
+			validator = res.Header.Get("Last-Modified")
+		}
+		html := false
+		if t, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type")); t == "text/html" {
+			html = true
+		}
+
+		var body io.Reader = res.Body
+		if MaxReportSize > 0 {
+			body = io.LimitReader(res.Body, MaxReportSize-written+1)
+		}
+		n, err := io.Copy(part, body)
+		res.Body.Close()
+		written += n
+		if MaxReportSize > 0 && written > MaxReportSize {
+			return nil, ErrTooLarge
+		}
+
+		if err == nil {
+			report, err := ioutil.ReadFile(part.Name())
+			if err != nil {
+				return nil, errors.New("Failed to read report. " + err.Error())
+			}
+			if html {
+				return nil, pageError(report)
+			}
+			return report, nil
+		}
+		if !resumable || attempt >= resumeAttempts || ctx.Err() != nil {
+			return nil, errors.New("Failed to read report. " + err.Error())
+		}
+		log.Println("The connection dropped after " + strconv.FormatInt(written, 10) + " bytes of the report. Resuming. Error: " + err.Error())
+	}
+}
+
+// Returns the offset the partial content of res starts at, from its
+// Content-Range header, or -1 if it has none.
+func rangeStart(res *http.Response) int64 {
+	r := strings.TrimPrefix(res.Header.Get("Content-Range"), "bytes ")
+	i := strings.Index(r, "-")
+	if i < 0 {
+		return -1
+	}
+	start, err := strconv.ParseInt(r[:i], 10, 64)
+	if err != nil {
+		return -1
+	}
+	return start
+}
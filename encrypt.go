@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// encryptionKey() returns the key cached files are encrypted with: the hex
+// encoded 32 bytes in the file given by -encryptkey, or in the
+// REPORT_CACHER_KEY environment variable. It returns nil if neither is set.
+//     openssl rand -hex 32 > cache.key
+func encryptionKey() ([]byte, error) {
+	encoded := os.Getenv("REPORT_CACHER_KEY")
+	if *encryptKeyFile != "" {
+		data, err := ioutil.ReadFile(*encryptKeyFile)
+		if err != nil {
+			return nil, errors.New("Failed to read the encryption key. " + err.Error())
+		}
+		encoded = string(data)
+	}
+
+	if encoded = strings.TrimSpace(encoded); encoded == "" {
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("The encryption key must be 64 hexadecimal characters, such as from openssl rand -hex 32")
+	}
+	return key, nil
+}
+
+// encryptedConflict() returns an error when an option that would copy
+// reports somewhere unencrypted is set alongside an encryption key: the
+// duckdb analytics engine, which can't read encrypted reports, -sqlite and
+// -exportdb.
+func encryptedConflict() error {
+	switch {
+	case *analytics == "duckdb":
+		return errors.New("The duckdb analytics engine cannot read encrypted reports. Use -analytics=go.")
+	case *sqliteFile != "":
+		return errors.New("-sqlite would store reports unencrypted. It cannot be used with -encryptkey.")
+	case *exportDB != "":
+		return errors.New("-exportdb would copy reports to a database unencrypted. It cannot be used with -encryptkey.")
+	}
+	return nil
+}
+
+// readTable() parses the cached report at path p, decrypting it if needed.
+func readTable(p string) (*report.Table, error) {
+	data, err := cache.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return report.Parse(bytes.NewReader(data))
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// The types of event published as the cache changes.
+const (
+	eventUpdateStarted  = "update.started"        // An update of every report began.
+	eventUpdateFinished = "update.finished"       // An update of every report ended.
+	eventRefreshed      = "report.refreshed"      // A report was downloaded, stored and post-processed. Version says which.
+	eventFailed         = "report.failed"         // A report could not be downloaded or stored.
+	eventAnomaly        = "report.anomaly"        // A report's contents look wrong. Error describes why.
+	eventCircuitOpen    = "provider.circuit_open" // Logins to a provider were paused. Error says why.
+	eventDiskLow        = "cache.disk_low"        // Updates are skipped for lack of disk space. Error says how much is free.
+	eventPaused         = "scheduler.paused"      // Scheduled updates were paused.
+	eventResumed        = "scheduler.resumed"     // Scheduled updates were resumed.
+)
+
+// An event describes a change to the cache.
+type event struct {
+	ID       int64      `json:"id"`
+	Type     string     `json:"type"`
+	Time     time.Time  `json:"time"`
+	Report   string     `json:"report,omitempty"`
+	Provider string     `json:"provider,omitempty"`
+	Cycle    string     `json:"cycle,omitempty"`   // The ID of the update an update.started or update.finished event is about.
+	Version  *time.Time `json:"version,omitempty"` // When the version a report.refreshed event is about was downloaded.
+	Error    string     `json:"error,omitempty"`
+}
+
+// events delivers every published event to its subscribers.
+var events = &broker{subscribers: make(map[chan event]bool)}
+
+// A broker fans published events out to subscribers.
+type broker struct {
+	sync.Mutex
+	lastID      int64
+	subscribers map[chan event]bool
+}
+
+// subscribe() returns a channel that receives every event published until
+// unsubscribe() is called with it.
+func (b *broker) subscribe() chan event {
+	ch := make(chan event, 16)
+
+	b.Lock()
+	b.subscribers[ch] = true
+	b.Unlock()
+
+	return ch
+}
+
+// unsubscribe() stops delivering events to ch and closes it.
+func (b *broker) unsubscribe(ch chan event) {
+	b.Lock()
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+	b.Unlock()
+}
+
+// publish() sends an event to every subscriber. Subscribers that have fallen
+// too far behind miss the event rather than holding up the publisher.
+func (b *broker) publish(e event) {
+	b.Lock()
+	defer b.Unlock()
+
+	b.lastID++
+	e.ID = b.lastID
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"github.com/jfmarket/report-cacher/database"
+	"strings"
+)
+
+// exporter keeps an external database in sync when -exportdb is set. Otherwise it is nil.
+var exporter *database.Exporter
+
+// Parses -exporttables, a comma separated list of report=table pairs.
+//     sold_items=pos_sold_items,stock_items=pos_stock
+func parseExportTables(s string) (map[string]string, error) {
+	tables := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		i := strings.Index(pair, "=")
+		if i <= 0 || i == len(pair)-1 {
+			return nil, errors.New("Invalid export table mapping " + pair + ". Use report=table.")
+		}
+		tables[strings.TrimSpace(pair[:i])] = strings.TrimSpace(pair[i+1:])
+	}
+
+	return tables, nil
+}
+
+// exportReports() upserts the latest version of every report into the export database.
+func exportReports() error {
+	for _, r := range reports {
+		versions, err := cache.Versions(r.Name)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			continue
+		}
+		latest := versions[len(versions)-1]
+
+		t, err := readTable(cache.FilePath(latest))
+		if err != nil {
+			return errors.New("Failed to read " + latest.Path + ". " + err.Error())
+		}
+		if err := exporter.Export(latest, t, r.Key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
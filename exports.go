@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jfmarket/report-cacher/download"
+	"time"
+)
+
+// exportsCommand() lists the exports ShopKeep is still making for the
+// account, such as ones left by a run that crashed, and -cancel cancels
+// one by its ID, or every one with -cancel=all, freeing their slots. It
+// logs in to the BackOffice website with -site, -email and -password.
+//     report-cacher exports -cancel=all -email=user@domain.com -password=mypassword
+func exportsCommand(args []string) error {
+	flags := flag.NewFlagSet("exports", flag.ContinueOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	cancel := flags.String("cancel", "", "The ID of an export to cancel, or all to cancel every pending export.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: report-cacher exports [-cancel=<id>|all] [options]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *email == "" || *password == "" {
+		return errors.New("An email and password are required to see ShopKeep's exports.")
+	}
+
+	applyOptions()
+	ctx := context.Background()
+	d, err := download.New(ctx, *site, *email, *password)
+	if err != nil {
+		return errors.New("Failed to log in to shopkeep: " + err.Error())
+	}
+	defer d.Logout()
+
+	if *cancel != "" && *cancel != "all" {
+		return d.CancelExport(ctx, *cancel)
+	}
+
+	exports, err := d.PendingExports(ctx)
+	if err != nil {
+		return err
+	}
+	if len(exports) == 0 {
+		fmt.Println("No exports are pending.")
+		return nil
+	}
+
+	for _, e := range exports {
+		created := "-"
+		if !e.Created.IsZero() {
+			created = e.Created.In(businessZone).Format(time.RFC1123)
+		}
+		fmt.Println(e.ID + "\t" + e.Name + "\t" + e.Status + "\t" + created)
+		if *cancel == "all" {
+			if err := d.CancelExport(ctx, e.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// cancelStaleExports() cancels the exports ShopKeep has been making for
+// longer than -staleexports through session s, which were most likely
+// left by a run that crashed and would otherwise hold the account's
+// export slots. Exports without a creation time are left alone.
+func cancelStaleExports(ctx context.Context, s session) {
+	d, ok := s.(*download.Downloader)
+	if !ok || *staleExports <= 0 {
+		return
+	}
+
+	exports, err := d.PendingExports(ctx)
+	if err != nil {
+		if err != download.ErrNoExportsPage {
+			logFor(ctx).Println("Failed to list ShopKeep's exports. Error: " + err.Error())
+		}
+		return
+	}
+
+	for _, e := range exports {
+		if e.Created.IsZero() || time.Since(e.Created) < *staleExports {
+			continue
+		}
+		logFor(ctx).Println("Canceling the " + e.Name + " export " + e.ID + ", pending since " + e.Created.In(businessZone).Format(time.Kitchen))
+		if err := d.CancelExport(ctx, e.ID); err != nil {
+			logFor(ctx).Println(err)
+		}
+	}
+}
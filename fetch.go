@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// The exit codes of the fetch command, so wrapper scripts can tell why it
+// failed. When reports fail in different ways, the first kind listed wins.
+const (
+	exitOK      = 0
+	exitError   = 1 // Anything else, such as a bad option.
+	exitAuth    = 3
+	exitNetwork = 4
+	exitScrape  = 5
+	exitWrite   = 6
+)
+
+// The exit code of each kind of failure, in the order they take precedence.
+var failureExitCodes = []struct {
+	failure string
+	code    int
+}{
+	{failedAuth, exitAuth},
+	{failedNetwork, exitNetwork},
+	{failedScrape, exitScrape},
+	{failedWrite, exitWrite},
+}
+
+// An exitCodeError is returned by a command to exit with a code other than 1.
+type exitCodeError struct {
+	error
+	code int
+}
+
+// A fetchResult is the outcome of the fetch command, printed with
+// -output=json.
+type fetchResult struct {
+	OK       bool                `json:"ok"`
+	ExitCode int                 `json:"exit_code"`
+	Reports  []fetchReportResult `json:"reports"`
+}
+
+// The outcome of one report in a fetchResult.
+type fetchReportResult struct {
+	Report   string  `json:"report"`
+	Provider string  `json:"provider"`
+	Status   string  `json:"status"`            // downloaded, failed, or skipped when a fresh copy was kept.
+	Failure  string  `json:"failure,omitempty"` // auth, network, scrape or write.
+	Error    string  `json:"error,omitempty"`
+	Start    string  `json:"start,omitempty"`
+	End      string  `json:"end,omitempty"`
+	Bytes    int64   `json:"bytes,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // In seconds.
+}
+
+// fetchCommand() downloads the reports that are due once and exits, such
+// as from cron. It takes the same options as the report-cacher; -force
+// downloads every report even when a fresh copy is cached. Its exit code
+// says why it failed, if it did: 3 when a login was refused, 4 when a
+// provider couldn't be reached, 5 when a report couldn't be read, and 6
+// when one couldn't be stored. -output=json prints what became of each report.
+//     report-cacher fetch -force -output=json -email=user@domain.com -password=mypassword
+func fetchCommand(args []string) error {
+	if err := flag.CommandLine.Parse(args); err != nil {
+		return err
+	}
+	if *output != "text" && *output != "json" {
+		return errors.New("Unknown output " + *output + ". Use text or json.")
+	}
+
+	if *dryRun {
+		return planUpdate()
+	}
+
+	setup()
+	began := time.Now()
+	updateErr := update(*force)
+
+	result, err := fetchResults(began)
+	if err != nil {
+		return err
+	}
+	if updateErr != nil && result.ExitCode == exitOK {
+		result.ExitCode, result.OK = exitError, false
+	}
+
+	if *output == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range result.Reports {
+			line := r.Report + ": " + r.Status
+			if r.Error != "" {
+				line += " (" + r.Failure + "). " + r.Error
+			}
+			fmt.Println(line)
+		}
+	}
+
+	if result.OK {
+		return nil
+	}
+	return exitCodeError{errors.New("Fetch failed."), result.ExitCode}
+}
+
+// fetchResults() sums up what became of each report in the update that
+// began at began, from the download journal.
+func fetchResults(began time.Time) (fetchResult, error) {
+	entries, err := readJournal("", began, time.Now())
+	if err != nil {
+		return fetchResult{}, errors.New("Failed to read the download journal. " + err.Error())
+	}
+
+	latest := make(map[string]journalEntry)
+	for _, e := range entries {
+		if _, ok := latest[e.Report]; !ok {
+			latest[e.Report] = e
+		}
+	}
+
+	result := fetchResult{OK: true, Reports: []fetchReportResult{}}
+	failures := make(map[string]bool)
+	for _, r := range reports {
+		if p := providers[r.Provider]; p == nil || !p.configured() {
+			continue
+		}
+
+		rr := fetchReportResult{Report: r.Name, Provider: r.Provider, Status: "skipped"}
+		if e, ok := latest[r.Name]; ok {
+			rr.Start, rr.End, rr.Bytes, rr.Duration = e.Start, e.End, e.Bytes, e.Duration
+			rr.Status = "downloaded"
+			if !e.OK {
+				rr.Status, rr.Failure, rr.Error = "failed", e.Failure, e.Error
+			}
+		} else if lastError, attempted := attemptSince(r.Name, began); attempted && lastError != "" {
+			// Logins to the provider are paused after repeated refusals.
+			rr.Status, rr.Failure, rr.Error = "failed", failedAuth, lastError
+		}
+
+		if rr.Status == "failed" {
+			result.OK = false
+			failures[rr.Failure] = true
+		}
+		result.Reports = append(result.Reports, rr)
+	}
+
+	if !result.OK {
+		result.ExitCode = exitError
+		for _, f := range failureExitCodes {
+			if failures[f.failure] {
+				result.ExitCode = f.code
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// Returns the error of the last attempt to download report name, and
+// whether it was attempted since t.
+func attemptSince(name string, t time.Time) (string, bool) {
+	status.RLock()
+	defer status.RUnlock()
+	return status.errors[name], !status.attempts[name].Before(t)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// currentReportName() returns the report whose current copy is served at
+// urlPath, such as /sold_items.csv, or "" if it is some other file.
+func currentReportName(urlPath string) string {
+	name := strings.TrimPrefix(urlPath, "/")
+	if !strings.HasSuffix(name, ".csv") || strings.Contains(name, "/") {
+		return ""
+	}
+
+	name = strings.TrimSuffix(name, ".csv")
+	if lookupReport(name) == nil {
+		return ""
+	}
+	return name
+}
+
+// snapshotHandler() serves the current copies of reports, such as
+// /sold_items.csv, from memory (see currentReport()) and every other file
+// from h, so a download being saved is never seen half-written.
+func snapshotHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := currentReportName(r.URL.Path)
+		if name == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		c, err := currentReport(name)
+		if err != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("ETag", `"`+c.Version.Checksum+`"`)
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		http.ServeContent(w, r, path.Base(r.URL.Path), c.Version.Time, bytes.NewReader(c.Data))
+	})
+}
+
+// etagHandler() sets an ETag on the cached files served by h, from the size
+// and modification time of the file on disk, so clients resuming a download
+// with Range and If-Range start again if the file has since been replaced.
+// h must honor ETags itself, as http.FileServer() does.
+func etagHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := filepath.Join(cache.Dir(), filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if info, err := os.Stat(p); err == nil && !info.IsDir() {
+			w.Header().Set("ETag", fileETag(info))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Returns a strong ETag for the file described by info.
+func fileETag(info os.FileInfo) string {
+	return `"` + strconv.FormatInt(info.ModTime().UnixNano(), 36) + "-" + strconv.FormatInt(info.Size(), 36) + `"`
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/jfmarket/report-cacher/rpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+)
+
+// The size of the pieces reports are streamed in.
+const chunkSize = 64 * 1024
+
+// rpcServer implements the ReportCache gRPC service.
+type rpcServer struct{}
+
+// serveRPC() serves the ReportCache gRPC service on the given port. Calls
+// need the same login as the webserver. See newRPCServer().
+func serveRPC(port int) error {
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return err
+	}
+
+	s := newRPCServer()
+	rpc.RegisterReportCacheServer(s, rpcServer{})
+	log.Printf("gRPC listening on port %d.", port)
+	return s.Serve(restrictedListener{l})
+}
+
+// ListReports() lists every report and the details of its current copy.
+func (rpcServer) ListReports(ctx context.Context, _ *rpc.Empty) (*rpc.ListReportsResponse, error) {
+	res := &rpc.ListReportsResponse{Reports: []rpc.ReportInfo{}}
+	for _, rs := range currentStatus().Reports {
+		res.Reports = append(res.Reports, reportInfo(rs.Name, rs.LastError))
+	}
+	return res, nil
+}
+
+// GetReport() streams the current copy of a report.
+func (rpcServer) GetReport(req *rpc.GetReportRequest, stream rpc.ReportCache_GetReportServer) error {
+	if lookupReport(req.Name) == nil {
+		return grpcstatus.Error(codes.NotFound, "Unknown report "+req.Name)
+	}
+
+	h, err := currentReport(req.Name)
+	if os.IsNotExist(err) {
+		return grpcstatus.Error(codes.NotFound, req.Name+" has not been downloaded yet")
+	} else if err != nil {
+		return grpcstatus.Error(codes.Internal, err.Error())
+	}
+	f := bytes.NewReader(h.Data)
+
+	// Only the first chunk carries the report's details.
+	info := reportInfo(req.Name, "")
+	first := &info
+	buf := make([]byte, chunkSize)
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			if err := stream.Send(&rpc.ReportChunk{Info: first, Data: buf[:n]}); err != nil {
+				return err
+			}
+			first = nil
+		}
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return grpcstatus.Error(codes.Internal, err.Error())
+		}
+	}
+}
+
+// TriggerRefresh() asks the download manager to update now.
+func (rpcServer) TriggerRefresh(ctx context.Context, _ *rpc.Empty) (*rpc.TriggerRefreshResponse, error) {
+	_, queued := requestRefresh(false)
+	return &rpc.TriggerRefreshResponse{Queued: queued}, nil
+}
+
+// WatchEvents() streams cache events until the client goes away.
+func (rpcServer) WatchEvents(_ *rpc.Empty, stream rpc.ReportCache_WatchEventsServer) error {
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			err := stream.Send(&rpc.Event{ID: e.ID, Type: e.Type, Time: e.Time, Report: e.Report, Error: e.Error})
+			if err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// Describes the current copy of report name.
+func reportInfo(name string, lastError string) rpc.ReportInfo {
+	info := rpc.ReportInfo{Name: name, LastError: lastError}
+	if versions, _ := cache.Versions(name); len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		info.Updated = latest.Time
+		info.Size = latest.Size
+		info.Checksum = latest.Checksum
+	}
+	return info
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"github.com/jfmarket/report-cacher/download"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// headerList is a flag that may be given more than once, collecting
+// headers of the form "Name: value".
+type headerList []string
+
+func (h *headerList) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerList) Set(s string) error {
+	*h = append(*h, s)
+	return nil
+}
+
+// extraHeaders are the headers given with -header.
+var extraHeaders headerList
+
+func init() {
+	flag.Var(&extraHeaders, "header", "A header sent with every request to ShopKeep, such as \"Accept-Language: en-US\". May be given more than once.")
+}
+
+// applyHeaders() sets the User-Agent and headers sent to ShopKeep from
+// -useragent and -header.
+func applyHeaders() error {
+	download.UserAgent, download.Header = *userAgent, http.Header{}
+	for _, h := range extraHeaders {
+		i := strings.Index(h, ":")
+		if i < 1 {
+			return errors.New("Invalid -header " + h + ". Use Name: value.")
+		}
+		name := textproto.CanonicalMIMEHeaderKey(strings.TrimSpace(h[:i]))
+		if strings.ContainsAny(name, " \t") {
+			return errors.New("Invalid -header " + h + ". Header names can't have spaces.")
+		}
+		download.Header.Add(name, strings.TrimSpace(h[i+1:]))
+	}
+	return nil
+}
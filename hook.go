@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a hook may run before it is killed.
+const hookTimeout = 10 * time.Minute
+
+// hookNotifier runs a shell command each time a report is refreshed.
+// The refreshed version is described by environment variables:
+//     REPORT_NAME      sold_items
+//     REPORT_PATH      The absolute path of the version file.
+//     REPORT_CURRENT   The absolute path of the current copy of the report.
+//     REPORT_VERSION   When the version was downloaded, RFC 3339.
+//     REPORT_START     The first day the report covers, YYYY-MM-DD, if it covers a range.
+//     REPORT_END       The last day the report covers.
+//     REPORT_SIZE      The size of the version in bytes.
+//     REPORT_CHECKSUM  Hex encoded SHA-256 of the version.
+type hookNotifier struct {
+	command string
+}
+
+func (h hookNotifier) notify(m refreshMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), hookTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", h.command)
+	} else {
+		cmd = exec.CommandContext(ctx, "/bin/sh", "-c", h.command)
+	}
+
+	current, err := filepath.Abs(cache.Path(m.Report))
+	if err != nil {
+		return err
+	}
+
+	cmd.Env = append(os.Environ(),
+		"REPORT_NAME="+m.Report,
+		"REPORT_PATH="+m.Location,
+		"REPORT_CURRENT="+current,
+		"REPORT_VERSION="+m.Version.Format(time.RFC3339),
+		"REPORT_START="+m.Start,
+		"REPORT_END="+m.End,
+		"REPORT_SIZE="+strconv.FormatInt(m.Size, 10),
+		"REPORT_CHECKSUM="+m.Checksum,
+	)
+
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		log.Println("Hook output for " + m.Report + ": " + strings.TrimSpace(string(out)))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return errors.New("Hook for " + m.Report + " did not finish within " + hookTimeout.String())
+	}
+	if err != nil {
+		return errors.New("Hook for " + m.Report + " failed. " + err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	"os"
+	"sync"
+)
+
+// A hotReport is the current copy of a report, held in memory so API reads
+// don't touch the disk. Reads are served from the snapshot in place while a
+// new version is downloaded, checked and saved, and only then is it swapped
+// for the new one, so clients never see a half-written or broken report.
+type hotReport struct {
+	Version store.Version
+	Data    []byte        // The CSV, decrypted.
+	Table   *report.Table // Data parsed. It is shared, so must not be changed.
+}
+
+// The current copy of each report read so far, by report name.
+var hot = struct {
+	sync.RWMutex
+	reports map[string]*hotReport
+}{reports: make(map[string]*hotReport)}
+
+// currentReport() returns the current copy of report name. It is read from
+// the cache directory the first time it is asked for and whenever a newer
+// version has been saved since, such as by the leader sharing the directory.
+// The error satisfies os.IsNotExist() if name has not been downloaded.
+func currentReport(name string) (*hotReport, error) {
+	versions, err := cache.Versions(name)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, os.ErrNotExist
+	}
+	latest := versions[len(versions)-1]
+
+	hot.RLock()
+	h := hot.reports[name]
+	hot.RUnlock()
+	if h != nil && h.Version.Path == latest.Path {
+		return h, nil
+	}
+
+	// The version's own file is read rather than the current copy, which
+	// may already have been replaced by the next version.
+	data, err := cache.ReadFile(cache.FilePath(latest))
+	if err != nil {
+		return nil, err
+	}
+	t, err := report.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return swapCurrentReport(&hotReport{Version: latest, Data: data, Table: t}), nil
+}
+
+// swapCurrentReport() makes h the current copy of its report and returns
+// it. Readers holding the previous copy keep it. An older version never
+// replaces a newer one, which is returned instead.
+func swapCurrentReport(h *hotReport) *hotReport {
+	hot.Lock()
+	defer hot.Unlock()
+	if old := hot.reports[h.Version.Report]; old != nil && old.Version.Time.After(h.Version.Time) {
+		return old
+	}
+	hot.reports[h.Version.Report] = h
+	return h
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jfmarket/report-cacher/report"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// importCommand() stores CSVs exported by hand, such as sales from before
+// the cache was set up, as versions of a report, so they are summarized,
+// loaded into -sqlite and served by the API like downloaded ones. Each file
+// is cleaned up as downloads are, and recorded as downloaded when it was
+// last modified. -range gives the days a report covering a range of days
+// holds. It takes the same options as the report-cacher, and refuses to run
+// while a report-cacher is using -directory.
+//     report-cacher import -report=sold_items -range=2013-01-01:2013-12-31 -directory=files sales-2013.csv
+func importCommand(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	name := flags.String("report", "", "The report the files are versions of, such as sold_items. (Required)")
+	days := flags.String("range", "", "The days the files cover, YYYY-MM-DD:YYYY-MM-DD. Required for reports covering a range of days, such as sold_items.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: report-cacher import -report=<report> [-range=<start>:<end>] [options] <file.csv>...")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *name == "" || flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("A report and at least one file are required.")
+	}
+
+	applyOptions()
+	r := lookupReport(*name)
+	if r == nil {
+		return errors.New("Unknown report " + *name + ". Use one of " + strings.Join(reportNames, ", ") + ".")
+	}
+	start, end, err := parseImportRange(*days)
+	if err != nil {
+		return err
+	}
+	if r.Dates != nil && start == "" {
+		return errors.New(r.Name + " covers a range of days. Give them with -range=YYYY-MM-DD:YYYY-MM-DD.")
+	}
+
+	openCache()
+	for _, p := range flags.Args() {
+		if err := importFile(r, p, start, end); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range r.PostProcess {
+		if err := p(); err != nil {
+			log.Println("Failed to process " + r.Name + ". Error: " + err.Error())
+		}
+	}
+	if db != nil {
+		if err := loadDatabase(); err != nil {
+			return errors.New("Failed to load reports into the database. " + err.Error())
+		}
+	}
+	if err := mergeProviders(); err != nil {
+		log.Println("Failed to merge reports across providers. Error: " + err.Error())
+	}
+
+	return nil
+}
+
+// Parses -range, YYYY-MM-DD:YYYY-MM-DD, into its first and last days. Both
+// are empty when it is.
+func parseImportRange(days string) (string, string, error) {
+	if days == "" {
+		return "", "", nil
+	}
+
+	parts := strings.Split(days, ":")
+	if len(parts) != 2 {
+		return "", "", errors.New("Invalid range " + days + ". Use YYYY-MM-DD:YYYY-MM-DD.")
+	}
+	start, err1 := time.Parse(report.DateLayout, parts[0])
+	end, err2 := time.Parse(report.DateLayout, parts[1])
+	if err1 != nil || err2 != nil {
+		return "", "", errors.New("Invalid range " + days + ". Use YYYY-MM-DD:YYYY-MM-DD.")
+	}
+	if end.Before(start) {
+		return "", "", errors.New("Invalid range " + days + ". It ends before it starts.")
+	}
+
+	return parts[0], parts[1], nil
+}
+
+// importFile() cleans up the CSV at path p as refresh() does a download and
+// stores it as a version of r covering start to end.
+func importFile(r *reportDefinition, p string, start string, end string) error {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return errors.New("Failed to read " + p + ". " + err.Error())
+	}
+	info, err := os.Stat(p)
+	if err != nil {
+		return err
+	}
+
+	if err := report.CheckCSV(data); err != nil {
+		return errors.New("Failed to import " + p + ". " + err.Error())
+	}
+	if data, err = report.Normalize(data); err != nil {
+		return errors.New("Failed to normalize " + p + ". " + err.Error())
+	}
+	if data, err = transformColumns(r.Name, data); err != nil {
+		return errors.New("Failed to transform the columns of " + p + ". " + err.Error())
+	}
+	t, err := validateReport(data)
+	if err != nil {
+		return errors.New("Failed to import " + p + ". " + err.Error())
+	}
+
+	v, err := cache.Import(r.Name, data, start, end, info.ModTime())
+	if err != nil {
+		return errors.New("Failed to import " + p + ". " + err.Error())
+	}
+	source := "file://" + filepath.ToSlash(p)
+	if abs, err := filepath.Abs(p); err == nil {
+		source = "file://" + filepath.ToSlash(abs)
+	}
+	if err := cache.WriteMeta(v, source, len(t.Rows)); err != nil {
+		return err
+	}
+
+	recordDownload(journalEntry{Time: time.Now(), Report: r.Name, Provider: "import", Start: start, End: end, Bytes: int64(len(data)), OK: true})
+	log.Println("Imported " + p + " as " + v.Path)
+	return nil
+}
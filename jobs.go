@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The states of a refresh job.
+const (
+	jobQueued  = "queued"  // Waiting for the download manager, such as behind a scheduled update.
+	jobRunning = "running" // Downloading.
+	jobDone    = "done"    // Every report due was downloaded.
+	jobFailed  = "failed"  // Logging in or some of the downloads failed. See Error and Errors.
+	jobSkipped = "skipped" // Nothing was downloaded, as another instance leads or the report-cacher is stopping. See Error.
+)
+
+// How many finished jobs are remembered for /api/jobs.
+const maxFinishedJobs = 100
+
+// A refreshJob is an update asked for outside the schedule, such as by
+// POST /api/refresh. The download manager runs jobs one at a time in
+// between scheduled updates.
+type refreshJob struct {
+	ID       string            `json:"id"`
+	Force    bool              `json:"force"` // Reports are downloaded even when a fresh copy is cached.
+	Status   string            `json:"status"`
+	Error    string            `json:"error,omitempty"`  // Why the update failed, such as a refused login.
+	Errors   map[string]string `json:"errors,omitempty"` // Why each report that failed to download did.
+	Created  time.Time         `json:"created"`          // When it was asked for.
+	Started  *time.Time        `json:"started,omitempty"`
+	Finished *time.Time        `json:"finished,omitempty"`
+}
+
+// jobs are the refresh jobs waiting, running and recently finished, by ID.
+var jobs = struct {
+	sync.Mutex
+	byID     map[string]*refreshJob
+	finished []string // The IDs of finished jobs, oldest first.
+	waiting  *refreshJob
+}{
+	byID: make(map[string]*refreshJob),
+}
+
+// refresh passes jobs to the download manager. Only one waits at a time;
+// see requestRefresh().
+var refresh = make(chan *refreshJob, 1)
+
+// requestRefresh() asks the download manager to update now, downloading
+// every report if force is true, and returns the job doing so. When a job
+// is already waiting, that job is returned instead, forced if this
+// request is, with false.
+func requestRefresh(force bool) (refreshJob, bool) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	if j := jobs.waiting; j != nil {
+		j.Force = j.Force || force
+		return *j, false
+	}
+
+	j := &refreshJob{ID: newID(), Force: force, Status: jobQueued, Created: wallClock.Now()}
+	jobs.byID[j.ID] = j
+	jobs.waiting = j
+	refresh <- j
+	return *j, true
+}
+
+// runJob() runs update() for j, recording how it went.
+func runJob(j *refreshJob) {
+	jobs.Lock()
+	if jobs.waiting == j {
+		jobs.waiting = nil
+	}
+	started := wallClock.Now()
+	j.Status, j.Started = jobRunning, &started
+	force := j.Force
+	jobs.Unlock()
+
+	err := runUpdate(force)
+
+	// The reports attempted since the job started and failed.
+	failed := make(map[string]string)
+	status.RLock()
+	for name, msg := range status.errors {
+		if !status.attempts[name].Before(started) {
+			failed[name] = msg
+		}
+	}
+	status.RUnlock()
+
+	jobs.Lock()
+	finished := wallClock.Now()
+	j.Finished, j.Status = &finished, jobDone
+	if err != nil {
+		j.Error = err.Error()
+	}
+	if len(failed) > 0 {
+		j.Errors = failed
+	}
+	if err != nil || len(failed) > 0 {
+		j.Status = jobFailed
+	}
+	if err == errNotLeader || err == errStopping {
+		j.Status = jobSkipped
+	}
+
+	jobs.finished = append(jobs.finished, j.ID)
+	if len(jobs.finished) > maxFinishedJobs {
+		delete(jobs.byID, jobs.finished[0])
+		jobs.finished = jobs.finished[1:]
+	}
+	jobs.Unlock()
+}
+
+// lookupJob() returns a copy of the job with id, or false if there is no
+// such job or it has been forgotten.
+func lookupJob(id string) (refreshJob, bool) {
+	jobs.Lock()
+	defer jobs.Unlock()
+
+	j := jobs.byID[id]
+	if j == nil {
+		return refreshJob{}, false
+	}
+	return *j, true
+}
+
+// jobHandler() reports whether a refresh job is queued, running, done or
+// failed, and why it failed. Jobs are forgotten once 100 newer ones have
+// finished, or when the report-cacher restarts.
+//     GET /api/jobs/3f2a9c0d1e4b5a67
+func jobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	j, ok := lookupJob(strings.TrimPrefix(r.URL.Path, "/api/jobs/"))
+	if !ok {
+		http.Error(w, "No such job", http.StatusNotFound)
+		return
+	}
+
+	writeJSON(w, j)
+}
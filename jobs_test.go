@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jfmarket/report-cacher/clock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Clears the refresh jobs, restoring them when t ends.
+func resetJobs(t *testing.T) {
+	jobs.Lock()
+	oldByID, oldFinished, oldWaiting := jobs.byID, jobs.finished, jobs.waiting
+	jobs.byID, jobs.finished, jobs.waiting = make(map[string]*refreshJob), nil, nil
+	jobs.Unlock()
+
+	t.Cleanup(func() {
+		select {
+		case <-refresh:
+		default:
+		}
+		jobs.Lock()
+		jobs.byID, jobs.finished, jobs.waiting = oldByID, oldFinished, oldWaiting
+		jobs.Unlock()
+	})
+}
+
+func TestRequestRefresh(t *testing.T) {
+	resetJobs(t)
+
+	first, queued := requestRefresh(false)
+	if !queued || first.Status != jobQueued || first.Force {
+		t.Fatalf("requestRefresh(false) = %+v, %v, want an unforced queued job", first, queued)
+	}
+
+	// Further requests while it waits are merged into it.
+	tests := []struct {
+		force     bool
+		wantForce bool
+	}{
+		{false, false},
+		{true, true},
+		{false, true},
+	}
+	for _, tt := range tests {
+		j, queued := requestRefresh(tt.force)
+		if queued || j.ID != first.ID || j.Force != tt.wantForce {
+			t.Errorf("requestRefresh(%v) = %+v, %v, want job %s forced %v, not queued", tt.force, j, queued, first.ID, tt.wantForce)
+		}
+	}
+
+	// Once the download manager takes it, the next request queues another.
+	oldUpdate := runUpdate
+	defer func() { runUpdate = oldUpdate }()
+	var forced bool
+	runUpdate = func(force bool) error {
+		forced = force
+		return nil
+	}
+	runJob(<-refresh)
+	if !forced {
+		t.Error("Job ran unforced, want forced as asked by a merged request")
+	}
+
+	second, queued := requestRefresh(false)
+	if !queued || second.ID == first.ID {
+		t.Errorf("requestRefresh(false) after the job ran = %+v, %v, want a new queued job", second, queued)
+	}
+}
+
+func TestRunJob(t *testing.T) {
+	start := time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	oldClock, oldUpdate := wallClock, runUpdate
+	defer func() { wallClock, runUpdate = oldClock, oldUpdate }()
+	wallClock = c
+
+	status.Lock()
+	oldErrors, oldAttempts := status.errors, status.attempts
+	status.errors, status.attempts = make(map[string]string), make(map[string]time.Time)
+	status.Unlock()
+	defer func() {
+		status.Lock()
+		status.errors, status.attempts = oldErrors, oldAttempts
+		status.Unlock()
+	}()
+
+	// A failure from before the job isn't the job's.
+	recordAttempt("invoice", errors.New("Timed out"))
+	c.Advance(time.Minute)
+
+	// Each update downloads the reports in attempts, failing with the
+	// error given, if one is.
+	tests := []struct {
+		name       string
+		err        error
+		attempts   map[string]string
+		wantStatus string
+		wantErrors map[string]string
+	}{
+		{"done", nil, map[string]string{"sold_items": ""}, jobDone, nil},
+		{"login refused", errors.New("Failed to log in"), nil, jobFailed, nil},
+		{"report failed", nil, map[string]string{"sold_items": "Bad gateway", "invoice": ""}, jobFailed, map[string]string{"sold_items": "Bad gateway"}},
+		{"report retried", nil, map[string]string{"sold_items": ""}, jobDone, nil},
+		{"follower", errNotLeader, nil, jobSkipped, nil},
+		{"shutting down", errStopping, nil, jobSkipped, nil},
+	}
+
+	for _, tt := range tests {
+		resetJobs(t)
+		j, _ := requestRefresh(false)
+		runUpdate = func(bool) error {
+			c.Advance(time.Minute)
+			for name, msg := range tt.attempts {
+				var err error
+				if msg != "" {
+					err = errors.New(msg)
+				}
+				recordAttempt(name, err)
+			}
+			return tt.err
+		}
+		runJob(<-refresh)
+
+		got, ok := lookupJob(j.ID)
+		if !ok {
+			t.Fatalf("%s: job %s was forgotten", tt.name, j.ID)
+		}
+		wantError := ""
+		if tt.err != nil {
+			wantError = tt.err.Error()
+		}
+		if got.Status != tt.wantStatus || got.Error != wantError || len(got.Errors) != len(tt.wantErrors) {
+			t.Errorf("%s: job is %+v, want status %s, error %q and errors %v", tt.name, got, tt.wantStatus, wantError, tt.wantErrors)
+		}
+		for name, msg := range tt.wantErrors {
+			if got.Errors[name] != msg {
+				t.Errorf("%s: job error for %s is %q, want %q", tt.name, name, got.Errors[name], msg)
+			}
+		}
+		if got.Started == nil || got.Finished == nil || !got.Finished.Equal(got.Started.Add(time.Minute)) {
+			t.Errorf("%s: job started %v and finished %v, want a minute apart", tt.name, got.Started, got.Finished)
+		}
+	}
+}
+
+func TestFinishedJobsForgotten(t *testing.T) {
+	resetJobs(t)
+	oldUpdate := runUpdate
+	defer func() { runUpdate = oldUpdate }()
+	runUpdate = func(bool) error { return nil }
+
+	var ids []string
+	for i := 0; i < maxFinishedJobs+1; i++ {
+		j, _ := requestRefresh(false)
+		runJob(<-refresh)
+		ids = append(ids, j.ID)
+	}
+
+	if _, ok := lookupJob(ids[0]); ok {
+		t.Error("Oldest job was kept, want it forgotten")
+	}
+	if _, ok := lookupJob(ids[1]); !ok {
+		t.Error("Second oldest job was forgotten, want it kept")
+	}
+}
+
+func TestJobHandler(t *testing.T) {
+	resetJobs(t)
+	j, _ := requestRefresh(true)
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{"GET", "/api/jobs/" + j.ID, http.StatusOK},
+		{"POST", "/api/jobs/" + j.ID, http.StatusMethodNotAllowed},
+		{"GET", "/api/jobs/unknown", http.StatusNotFound},
+		{"GET", "/api/jobs/", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		jobHandler(w, httptest.NewRequest(tt.method, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("%s %s answered %d, want %d", tt.method, tt.path, w.Code, tt.want)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var got refreshJob
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != j.ID || got.Status != jobQueued || !got.Force || got.Started != nil {
+			t.Errorf("%s %s answered %+v, want the queued forced job %s", tt.method, tt.path, got, j.ID)
+		}
+	}
+}
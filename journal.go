@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"github.com/jfmarket/report-cacher/download"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A journalEntry records one attempt to download a report.
+type journalEntry struct {
+	Time     time.Time `json:"time"` // When the attempt started.
+	Report   string    `json:"report"`
+	Provider string    `json:"provider"`
+	Start    string    `json:"start,omitempty"` // The first day requested, YYYY-MM-DD.
+	End      string    `json:"end,omitempty"`   // The last day requested, YYYY-MM-DD.
+	OK       bool      `json:"ok"`
+	Error    string    `json:"error,omitempty"`
+	Failure  string    `json:"failure,omitempty"` // What kind of failure Error is. See failureKind().
+	Duration float64   `json:"duration"`          // In seconds.
+	Bytes    int64     `json:"bytes"`
+	Cycle    string    `json:"cycle,omitempty"` // The ID of the update the attempt was part of.
+}
+
+// The kinds of failure recorded in the journal, so scripts can tell them
+// apart without reading the errors.
+const (
+	failedAuth    = "auth"    // The provider refused the login.
+	failedNetwork = "network" // The provider couldn't be reached, or didn't answer in time.
+	failedScrape  = "scrape"  // The provider answered with something other than the report.
+	failedWrite   = "write"   // The report couldn't be stored.
+)
+
+// failureKind() returns what kind of failure err, from the given stage of a
+// download, is: logging in, fetching, or reading or storing the report.
+func failureKind(stage string, err error) string {
+	switch {
+	case stage == "write":
+		return failedWrite
+	case isNetworkError(err):
+		return failedNetwork
+	case stage == "login":
+		return failedAuth
+	}
+	return failedScrape
+}
+
+// isNetworkError() reports whether err came from the network rather than
+// what was sent over it. The providers' packages flatten errors into their
+// messages, so those are checked too.
+func isNetworkError(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) || err == download.ErrMaintenance {
+		return true
+	}
+
+	msg := err.Error()
+	for _, s := range []string{"dial tcp", "no such host", "connection refused", "connection reset", "i/o timeout", "Client.Timeout", "TLS handshake", "unexpected EOF", "Gave up after", "ran out of time", download.ErrMaintenance.Error(), download.ErrUnreachable.Error()} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Serializes access to the journal and audit log.
+var journalLock sync.Mutex
+
+// Returns the path of the journal, which is kept in the cache directory.
+func journalPath() string {
+	return filepath.Join(cache.Dir(), "journal.jsonl")
+}
+
+// recordDownload() appends e to the journal.
+func recordDownload(e journalEntry) {
+	if err := appendLine(journalPath(), e); err != nil {
+		log.Println("Failed to record download. Error: " + err.Error())
+	}
+}
+
+// readJournal() returns the entries for report name, or every report if
+// name is empty, that started between from and to, newest first.
+func readJournal(name string, from time.Time, to time.Time) ([]journalEntry, error) {
+	entries := []journalEntry{}
+	err := readLines(journalPath(), func(line []byte) {
+		var e journalEntry
+		if json.Unmarshal(line, &e) != nil {
+			return
+		}
+		if (name != "" && e.Report != name) || e.Time.Before(from) || e.Time.After(to) {
+			return
+		}
+		entries = append([]journalEntry{e}, entries...)
+	})
+
+	return entries, err
+}
+
+// appendLine() appends v as a line of JSON to the file p, creating it if
+// needed. The journal and audit log are only ever appended to.
+func appendLine(p string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	journalLock.Lock()
+	defer journalLock.Unlock()
+
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// readLines() calls fn with each line of the file p, which is treated as
+// empty if it does not exist.
+func readLines(p string, fn func(line []byte)) error {
+	journalLock.Lock()
+	defer journalLock.Unlock()
+
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fn(scanner.Bytes())
+	}
+
+	return scanner.Err()
+}
+
+// historyHandler() serves the download journal, newest first. report
+// limits it to one report; from and to, RFC 3339 timestamps or Unix
+// seconds, to a window of time; and limit to a number of entries, 100 by
+// default.
+//     GET /api/history?report=sold_items&from=2014-03-25T00:00:00Z&to=2014-03-26T00:00:00Z
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	from, to, limit, err := logWindow(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := readJournal(r.FormValue("report"), from, to)
+	if err != nil {
+		logFor(r.Context()).Println("Failed to read the download journal. Error: " + err.Error())
+		http.Error(w, "Failed to read the download journal", http.StatusInternalServerError)
+		return
+	}
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	writeJSON(w, entries)
+}
+
+// Parses the from, to and limit parameters of a request for the journal or
+// audit log.
+func logWindow(r *http.Request) (time.Time, time.Time, int, error) {
+	from, to, limit := time.Time{}, time.Now(), 100
+
+	var err error
+	if ts := r.FormValue("from"); ts != "" {
+		if from, err = parseTimestamp(ts); err != nil {
+			return from, to, limit, err
+		}
+	}
+	if ts := r.FormValue("to"); ts != "" {
+		if to, err = parseTimestamp(ts); err != nil {
+			return from, to, limit, err
+		}
+	}
+	if n := r.FormValue("limit"); n != "" {
+		if limit, err = strconv.Atoi(n); err != nil || limit < 1 {
+			return from, to, limit, errors.New("Invalid limit " + n)
+		}
+	}
+
+	return from, to, limit, nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A lease is the leader's claim on -leaderlock. Token goes up by one each
+// time an instance takes the lock, fencing off a leader that lost it
+// without noticing. See stillLeader().
+type lease struct {
+	Holder string    `json:"holder"`
+	Token  int64     `json:"token"`
+	Since  time.Time `json:"since"`
+}
+
+// leadership tracks whether this instance holds the lease.
+var leadership = struct {
+	sync.RWMutex
+	id      string   // Identifies this instance in the lease, host:pid.
+	file    *os.File // -leaderlock, locked for as long as this instance leads.
+	leading bool
+	token   int64 // The Token this instance wrote when it took the lock.
+}{}
+
+// isLeader() reports whether this instance should download reports. It
+// always does unless -leaderlock is set, in which case only the instance
+// holding the lock on it does. The others only serve the cache.
+func isLeader() bool {
+	if *leaderLock == "" {
+		return true
+	}
+
+	leadership.RLock()
+	defer leadership.RUnlock()
+	return leadership.leading
+}
+
+// stillLeader() reports whether this instance still holds the lease by its
+// fencing token, such as after storage holding the lock dropped it and
+// another instance took over. Updates check it once they hold the write
+// lock, and refresh() again before each write to the cache, as a download
+// may outlast the lease.
+func stillLeader() bool {
+	if *leaderLock == "" {
+		return true
+	}
+
+	leadership.RLock()
+	defer leadership.RUnlock()
+	if !leadership.leading {
+		return false
+	}
+	l, err := readLease(leadership.file)
+	return err == nil && l.Token == leadership.token
+}
+
+// leaderManager() competes for the lock on -leaderlock, a file on storage
+// shared by every instance. The instance holding it leads until it stops,
+// as the lock is released when its process exits. Instances that do not
+// lead try again every third of -leaderttl, and reload the cache's manifest
+// so they serve what the leader downloads. It can be stopped by close()ing
+// the done channel.
+//     go leaderManager(done)
+func leaderManager(done <-chan bool) {
+	ticker := time.NewTicker(*leaderTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		elect()
+
+		if !isLeader() {
+			if err := cache.Reload(); err != nil {
+				log.Println("Failed to reload the cache. Error: " + err.Error())
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-done:
+			release()
+			return
+		}
+	}
+}
+
+// Takes the lock on -leaderlock if no other instance holds it, writing a
+// new fencing token, or checks the token is still ours if we lead.
+func elect() {
+	leadership.Lock()
+	defer leadership.Unlock()
+
+	if leadership.id == "" {
+		host, _ := os.Hostname()
+		leadership.id = host + ":" + strconv.Itoa(os.Getpid())
+	}
+
+	if leadership.leading {
+		l, err := readLease(leadership.file)
+		if err == nil && l.Token == leadership.token {
+			return
+		}
+		log.Println("Another instance is now the leader. Reports will only be served.")
+		leadership.file.Close()
+		leadership.file, leadership.leading = nil, false
+		return
+	}
+
+	f, err := os.OpenFile(*leaderLock, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		log.Println("Failed to open the leader lock. Error: " + err.Error())
+		return
+	}
+	if lockFile(f) != nil {
+		f.Close()
+		return
+	}
+
+	current, err := readLease(f)
+	if err != nil {
+		log.Println("Failed to read the leader lock. Error: " + err.Error())
+		f.Close()
+		return
+	}
+	claim := lease{Holder: leadership.id, Token: current.Token + 1, Since: wallClock.Now()}
+	if err := writeLease(f, claim); err != nil {
+		log.Println("Failed to claim the leader lock. Error: " + err.Error())
+		f.Close()
+		return
+	}
+
+	leadership.file, leadership.leading, leadership.token = f, true, claim.Token
+	log.Println("This instance is now the leader and will download reports.")
+}
+
+// Gives up the lock when this instance stops, so another can lead at once.
+func release() {
+	leadership.Lock()
+	defer leadership.Unlock()
+
+	if !leadership.leading {
+		return
+	}
+	if l, err := readLease(leadership.file); err == nil && l.Token == leadership.token {
+		writeLease(leadership.file, lease{Token: l.Token})
+	}
+	leadership.file.Close()
+	leadership.file, leadership.leading = nil, false
+}
+
+// Reads the lease from f, the leader lock, returning an empty one if it
+// is empty.
+func readLease(f *os.File) (lease, error) {
+	var l lease
+	if _, err := f.Seek(0, 0); err != nil {
+		return l, err
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil || len(data) == 0 {
+		return l, err
+	}
+	if err := json.Unmarshal(data, &l); err != nil {
+		return l, errors.New("Failed to parse the leader lock. " + err.Error())
+	}
+	return l, nil
+}
+
+// Replaces the lease in f, the leader lock. The file is rewritten in place
+// rather than renamed over, which would leave the lock on the old file.
+func writeLease(f *os.File, l lease) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(data, 0); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"github.com/jfmarket/report-cacher/store"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestElect(t *testing.T) {
+	old := *leaderLock
+	*leaderLock = filepath.Join(t.TempDir(), "leader.lock")
+	defer func() { *leaderLock = old }()
+	defer release()
+
+	elect()
+	if !isLeader() || !stillLeader() {
+		t.Fatal("Not the leader after taking a free lock")
+	}
+
+	// No other instance may take the lock while it is held.
+	rival, err := os.OpenFile(*leaderLock, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rival.Close()
+	if lockFile(rival) == nil {
+		t.Fatal("Another instance took the lock while it was held")
+	}
+
+	// Released, another instance takes it with the next token.
+	release()
+	if isLeader() {
+		t.Fatal("Still the leader after releasing the lock")
+	}
+	if err := lockFile(rival); err != nil {
+		t.Fatalf("Another instance couldn't take the released lock. %v", err)
+	}
+	l, err := readLease(rival)
+	if err != nil || l.Token != 1 || l.Holder != "" {
+		t.Fatalf("Released lease is %+v, %v, want token 1 and no holder", l, err)
+	}
+	if err := writeLease(rival, lease{Holder: "rival", Token: l.Token + 1}); err != nil {
+		t.Fatal(err)
+	}
+	elect()
+	if isLeader() {
+		t.Fatal("Became the leader while another instance held the lock")
+	}
+	rival.Close()
+
+	elect()
+	if !isLeader() || leadership.token != 3 {
+		t.Fatalf("Leading %v with token %d after the rival stopped, want token 3", isLeader(), leadership.token)
+	}
+
+	// Storage that dropped the lock let another instance take over. The
+	// fencing token no longer being ours stops updates.
+	if err := ioutil.WriteFile(*leaderLock, []byte(`{"holder":"successor","token":4}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if stillLeader() {
+		t.Error("stillLeader() = true after another instance wrote a newer token")
+	}
+	elect()
+	if isLeader() {
+		t.Error("Still the leader after another instance wrote a newer token")
+	}
+}
+
+func TestUpdateFollower(t *testing.T) {
+	old := *leaderLock
+	*leaderLock = filepath.Join(t.TempDir(), "leader.lock")
+	defer func() { *leaderLock = old }()
+
+	// Without the lock, updates are skipped rather than reported as done.
+	if err := update(false); err != errNotLeader {
+		t.Errorf("update() by a follower = %v, want errNotLeader", err)
+	}
+}
+
+func TestRefreshFenced(t *testing.T) {
+	old, oldCache := *leaderLock, cache
+	*leaderLock = filepath.Join(t.TempDir(), "leader.lock")
+	defer func() { *leaderLock, cache = old, oldCache }()
+	defer release()
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	elect()
+	if !isLeader() {
+		t.Fatal("Not the leader after taking a free lock")
+	}
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	// Another instance takes over while the report downloads.
+	r := &reportDefinition{
+		Name:     "fenced_test",
+		Provider: "test",
+		Fetch: func(context.Context, session) ([]byte, string, string, error) {
+			if err := ioutil.WriteFile(*leaderLock, []byte(`{"holder":"successor","token":9}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+			return []byte("Item,Quantity\nTomatoes,1\n"), "", "", nil
+		},
+	}
+	r.refresh(context.Background(), nil)
+
+	if versions, _ := cache.Versions(r.Name); len(versions) != 0 {
+		t.Errorf("Stored %d versions after losing the lead, want none", len(versions))
+	}
+	select {
+	case e := <-ch:
+		t.Errorf("Published %+v after losing the lead", e)
+	default:
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cacheLock holds the lock on the cache directory for as long as the
+// process runs. Closing it would release the lock.
+var cacheLock *os.File
+
+// lockCache() takes an exclusive lock on the cache directory dir so only
+// one report-cacher downloads into it at a time. The lock file holds the ID
+// of the process holding the lock, and the lock is released when it exits.
+func lockCache(dir string) error {
+	p := filepath.Join(dir, ".lock")
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.New("Failed to open " + p + ". " + err.Error())
+	}
+
+	if err := lockFile(f); err != nil {
+		f.Close()
+		pid, _ := ioutil.ReadFile(p)
+		return errors.New("Another report-cacher, process " + strings.TrimSpace(string(pid)) + ", is using " + dir + ".")
+	}
+
+	f.Truncate(0)
+	f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	cacheLock = f
+	return nil
+}
+
+// lockWrites() takes the lock on writing to the cache directory dir,
+// waiting while another process holds it. Updates hold it while they write
+// and backups while they copy, so a backup is consistent even while a
+// report-cacher is running. Call the returned function to release it.
+func lockWrites(dir string) (func(), error) {
+	p := filepath.Join(dir, ".writing")
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.New("Failed to open " + p + ". " + err.Error())
+	}
+
+	if lockFile(f) != nil {
+		log.Println("Waiting for writes to " + dir + " to finish...")
+		if err := waitLockFile(f); err != nil {
+			f.Close()
+			return nil, errors.New("Failed to lock " + p + ". " + err.Error())
+		}
+	}
+
+	return func() { f.Close() }, nil
+}
+
+// writePIDFile() writes the process ID to -pidfile, if it is set.
+func writePIDFile() error {
+	if *pidFile == "" {
+		return nil
+	}
+
+	if err := ioutil.WriteFile(*pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		return errors.New("Failed to write the PID file. " + err.Error())
+	}
+	return nil
+}
+
+// removePIDFile() removes -pidfile when the report-cacher stops.
+func removePIDFile() {
+	if *pidFile != "" {
+		os.Remove(*pidFile)
+	}
+}
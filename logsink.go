@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+)
+
+// The syslog priorities log lines are sent with.
+const (
+	priorityErr     = 3
+	priorityWarning = 4
+	priorityInfo    = 6
+)
+
+// setupLogOutput() sends log lines to sink: stderr, or syslog or journald
+// for deployments supervised by the operating system. Those keep their own
+// timestamps, so the standard logger's are dropped.
+func setupLogOutput(sink string) error {
+	var err error
+	switch sink {
+	case "", "stderr":
+		return nil
+	case "syslog":
+		err = useSyslog()
+	case "journald":
+		err = useJournald()
+	default:
+		return errors.New("Unknown log output " + sink + ". Use stderr, syslog or journald.")
+	}
+	if err != nil {
+		return errors.New("Failed to log to " + sink + ". " + err.Error())
+	}
+
+	log.SetFlags(0)
+	return nil
+}
+
+// logPriority() guesses the syslog priority of a log line from its wording,
+// since lines are written without one: failures and errors are errors,
+// anomalies and paused logins are warnings, and the rest information.
+func logPriority(line string) int {
+	switch {
+	case strings.Contains(line, "Failed") || strings.Contains(line, "failed") || strings.Contains(line, "Error:"):
+		return priorityErr
+	case strings.Contains(line, "Anomaly") || strings.Contains(line, "paused"):
+		return priorityWarning
+	}
+	return priorityInfo
+}
+
+// splitLogIDs() splits the cycle and request IDs logFor() starts a line
+// with from the rest of it.
+func splitLogIDs(line string) (cycle string, request string, message string) {
+	for {
+		switch {
+		case strings.HasPrefix(line, "cycle="):
+			cycle, line = cutWord(strings.TrimPrefix(line, "cycle="))
+		case strings.HasPrefix(line, "request="):
+			request, line = cutWord(strings.TrimPrefix(line, "request="))
+		default:
+			return cycle, request, line
+		}
+	}
+}
+
+// Returns the first word of s and what follows the space after it.
+func cutWord(s string) (string, string) {
+	if i := strings.IndexByte(s, ' '); i >= 0 {
+		return s[:i], s[i+1:]
+	}
+	return s, ""
+}
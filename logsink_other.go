@@ -0,0 +1,99 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// The socket journald receives entries on.
+const journalSocket = "/run/systemd/journal/socket"
+
+// useSyslog() sends log lines to the local syslog daemon as the daemon
+// facility.
+func useSyslog() error {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "report-cacher")
+	if err != nil {
+		return err
+	}
+	log.SetOutput(syslogWriter{w})
+	return nil
+}
+
+// A syslogWriter writes each log line with the priority logPriority() gives it.
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func (s syslogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	var err error
+	switch logPriority(line) {
+	case priorityErr:
+		err = s.w.Err(line)
+	case priorityWarning:
+		err = s.w.Warning(line)
+	default:
+		err = s.w.Info(line)
+	}
+	return len(p), err
+}
+
+// useJournald() sends log lines to journald through its native protocol,
+// so each carries its priority, and the cycle and request IDs as the
+// CYCLE_ID and REQUEST_ID fields.
+//     journalctl -u report-cacher CYCLE_ID=3f9a1c2b7d4e
+func useJournald() error {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journalSocket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	log.SetOutput(journalWriter{conn})
+	return nil
+}
+
+// A journalWriter writes each log line as a journal entry.
+type journalWriter struct {
+	conn *net.UnixConn
+}
+
+func (j journalWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	cycle, request, message := splitLogIDs(line)
+
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", line)
+	journalField(&b, "PRIORITY", strconv.Itoa(logPriority(message)))
+	journalField(&b, "SYSLOG_IDENTIFIER", "report-cacher")
+	if cycle != "" {
+		journalField(&b, "CYCLE_ID", cycle)
+	}
+	if request != "" {
+		journalField(&b, "REQUEST_ID", request)
+	}
+
+	if _, err := j.conn.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// journalField() appends a field to an entry in journald's native format.
+// Values spanning lines are written with their length before them.
+func journalField(b *bytes.Buffer, name string, value string) {
+	if !strings.Contains(value, "\n") {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
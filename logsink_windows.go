@@ -0,0 +1,18 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+)
+
+// Syslog and journald are only found on Unix. Windows services log to
+// report-cacher.log next to the executable instead.
+func useSyslog() error {
+	return errors.New("Syslog is not supported on Windows.")
+}
+
+func useJournald() error {
+	return errors.New("Journald is not supported on Windows.")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jfmarket/report-cacher/report"
+	"html/template"
+	"io"
+	"log"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A delivery is a scheduled email of one or more reports.
+type delivery struct {
+	to      []string
+	reports []string       // Report names, such as sold_items.
+	day     string         // A weekday such as Monday, or daily.
+	at      string         // The time of day to send, HH:MM.
+	format  string         // attachment or summary.
+	days    int            // The number of days a summary covers, ending yesterday.
+	dialect report.Dialect // How attached reports are written.
+	sent    time.Time      // When the delivery was last sent.
+}
+
+// loadDeliveries() reads the deliveries file given by -mail. It is a CSV
+// with the columns To, Reports, Day, Time, Format and Days, and optionally
+// Delimiter and Decimal. Multiple recipients and reports are separated by
+// semicolons. Format is attachment to attach the reports or summary for an
+// inline summary of sales; Days is how many days a summary covers, ending
+// yesterday. Delimiter, such as tab, and Decimal, . or , change how
+// attached reports are written for software expecting them.
+//     To,Reports,Day,Time,Format,Days,Delimiter,Decimal
+//     board@jfmarket.org;treasurer@jfmarket.org,sold_items,Monday,07:30,summary,3,,
+//     manager@jfmarket.org,sold_items;stock_items,daily,06:00,attachment,,,
+//     bookkeeper@jfmarket.org,sold_items,Monday,06:00,attachment,,;,","
+func loadDeliveries(p string) ([]*delivery, error) {
+	t, err := report.ReadFile(p)
+	if err != nil {
+		return nil, errors.New("Failed to read deliveries. " + err.Error())
+	}
+
+	to, reports, day, at, format, days := t.Column("To"), t.Column("Reports"), t.Column("Day"), t.Column("Time"), t.Column("Format"), t.Column("Days")
+	delimiter, decimal := t.Column("Delimiter"), t.Column("Decimal")
+	if to < 0 || reports < 0 || day < 0 || at < 0 {
+		return nil, errors.New("Deliveries file must have To, Reports, Day and Time columns")
+	}
+
+	var deliveries []*delivery
+	for r := range t.Rows {
+		d := &delivery{
+			to:      splitList(t.Value(r, to)),
+			reports: splitList(t.Value(r, reports)),
+			day:     strings.ToLower(strings.TrimSpace(t.Value(r, day))),
+			at:      strings.TrimSpace(t.Value(r, at)),
+			format:  strings.ToLower(strings.TrimSpace(t.Value(r, format))),
+			days:    7,
+		}
+
+		if len(d.to) == 0 || len(d.reports) == 0 {
+			return nil, errors.New("Delivery on row " + strconv.Itoa(r+2) + " needs recipients and reports")
+		}
+		if d.day != "daily" && parseWeekday(d.day) < 0 {
+			return nil, errors.New("Unknown day " + d.day + ". Use a weekday or daily.")
+		}
+		if _, err := time.Parse("15:04", d.at); err != nil {
+			return nil, errors.New("Invalid time " + d.at + ". Use HH:MM.")
+		}
+		if d.format == "" {
+			d.format = "attachment"
+		}
+		if d.format != "attachment" && d.format != "summary" {
+			return nil, errors.New("Unknown format " + d.format + ". Use attachment or summary.")
+		}
+		if n := strings.TrimSpace(t.Value(r, days)); n != "" {
+			if d.days, err = strconv.Atoi(n); err != nil || d.days < 1 {
+				return nil, errors.New("Invalid number of days " + n)
+			}
+		}
+		if d.dialect, err = parseDialect(t.Value(r, delimiter), strings.TrimSpace(t.Value(r, decimal))); err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, d)
+	}
+
+	return deliveries, nil
+}
+
+// mailManager() sends each delivery when it falls due. It checks once a
+// minute and can be stopped by close()ing the done channel.
+func mailManager(deliveries []*delivery, done <-chan bool) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			now = now.In(businessZone)
+			for _, d := range deliveries {
+				if !d.due(now) {
+					continue
+				}
+
+				d.sent = now
+				if err := d.send(now); err != nil {
+					log.Println("Failed to email " + strings.Join(d.reports, ", ") + ". Error: " + err.Error())
+				} else {
+					log.Println("Emailed " + strings.Join(d.reports, ", ") + " to " + strings.Join(d.to, ", "))
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Reports whether the delivery should be sent at now.
+func (d *delivery) due(now time.Time) bool {
+	if d.day != "daily" && now.Weekday() != parseWeekday(d.day) {
+		return false
+	}
+
+	if now.Format("15:04") < d.at {
+		return false
+	}
+
+	// Only send once a day.
+	y1, m1, d1 := now.Date()
+	y2, m2, d2 := d.sent.Date()
+	return y1 != y2 || m1 != m2 || d1 != d2
+}
+
+// Builds and sends the delivery's email.
+func (d *delivery) send(now time.Time) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+
+	var html bytes.Buffer
+	subject := "Market reports for " + now.Format("Monday, January 2")
+	if d.format == "summary" {
+		to := now.AddDate(0, 0, -1)
+		from := to.AddDate(0, 0, 1-d.days)
+		subject = "Sales summary for " + from.Format("Jan 2") + " - " + to.Format("Jan 2, 2006")
+		if err := d.summary(&html, from, to); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintf(&html, "<p>The latest %s reports are attached.</p>", template.HTMLEscapeString(strings.Join(d.reports, ", ")))
+	}
+
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/html; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	part.Write(html.Bytes())
+
+	if d.format == "attachment" {
+		for _, name := range d.reports {
+			h, err := currentReport(name)
+			if err != nil {
+				return errors.New("Failed to read " + name + ". " + err.Error())
+			}
+			data := h.Data
+			if !d.dialect.Standard() {
+				if data, err = d.dialect.Convert(data); err != nil {
+					return errors.New("Failed to convert " + name + ". " + err.Error())
+				}
+			}
+
+			part, err := w.CreatePart(textproto.MIMEHeader{
+				"Content-Type":              {"text/csv; name=\"" + name + ".csv\""},
+				"Content-Transfer-Encoding": {"base64"},
+				"Content-Disposition":       {"attachment; filename=\"" + name + ".csv\""},
+			})
+			if err != nil {
+				return err
+			}
+			writeBase64(part, data)
+		}
+	}
+	w.Close()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", *mailFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(d.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+	msg.Write(body.Bytes())
+
+	return smtp.SendMail(*smtpServer, smtpAuth(), *mailFrom, d.to, msg.Bytes())
+}
+
+// Returns the credentials for -smtp, or nil if it does not need any.
+func smtpAuth() smtp.Auth {
+	if *smtpUser == "" {
+		return nil
+	}
+
+	host, _, _ := net.SplitHostPort(*smtpServer)
+	return smtp.PlainAuth("", *smtpUser, *smtpPassword, host)
+}
+
+// The inline summary of sales emailed to the board.
+var summaryTemplate = template.Must(template.New("summary").Parse(`<h2>Sales {{.From}} to {{.To}}</h2>
+<p>Total sales: <b>${{printf "%.2f" .Sales}}</b> from {{printf "%.0f" .Quantity}} items sold.</p>
+<h3>Departments</h3>
+<table>
+{{range .Departments}}<tr><td>{{.Group}}</td><td align="right">${{printf "%.2f" .Sales}}</td></tr>
+{{end}}</table>
+<h3>Top sellers</h3>
+<table>
+{{range .TopBySales}}<tr><td>{{.Group}}</td><td align="right">{{printf "%.0f" .Quantity}}</td><td align="right">${{printf "%.2f" .Sales}}</td></tr>
+{{end}}</table>
+`))
+
+// Writes an HTML summary of the sales from through to.
+func (d *delivery) summary(w *bytes.Buffer, from time.Time, to time.Time) error {
+	snapshots, err := soldItemsSnapshots()
+	if err != nil {
+		return err
+	}
+
+	day := func(t time.Time) time.Time {
+		d, _ := businessDate(t.Format(report.DateLayout))
+		return d
+	}
+
+	return summaryTemplate.Execute(w, report.Statistics(snapshots, day(from), day(to), 10))
+}
+
+// Writes data base64 encoded in lines of 76 characters, as MIME requires.
+func writeBase64(w io.Writer, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// Splits a semicolon separated list, dropping empty entries.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// Returns the weekday named s, or -1 if s is not a weekday.
+func parseWeekday(s string) time.Weekday {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), s) || strings.EqualFold(d.String()[:3], s) {
+			return d
+		}
+	}
+	return -1
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// The days the market operates, given by -marketdays and -season.
+// When either is empty, the market is treated as open on every day it allows.
+var (
+	marketWeekdays []time.Weekday
+	seasonStart    time.Month
+	seasonEnd      time.Month
+)
+
+// parseMarketDays() reads the days the market is open, such as Wed,Sat, and
+// the months of its season, such as May-Oct. A season may wrap around the
+// new year, such as Nov-Feb.
+func parseMarketDays(days string, season string) error {
+	marketWeekdays = nil
+	for _, d := range strings.Split(days, ",") {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+		w := parseWeekday(d)
+		if w < 0 {
+			return errors.New("Unknown market day " + d + ". Use weekdays such as Wed,Sat.")
+		}
+		marketWeekdays = append(marketWeekdays, w)
+	}
+
+	seasonStart, seasonEnd = 0, 0
+	if season = strings.TrimSpace(season); season == "" {
+		return nil
+	}
+
+	months := strings.Split(season, "-")
+	if len(months) != 2 {
+		return errors.New("Invalid season " + season + ". Use a range of months such as May-Oct.")
+	}
+	seasonStart, seasonEnd = parseMonth(months[0]), parseMonth(months[1])
+	if seasonStart == 0 || seasonEnd == 0 {
+		return errors.New("Invalid season " + season + ". Use a range of months such as May-Oct.")
+	}
+
+	return nil
+}
+
+// marketOpen() reports whether the market operates on the day of t.
+func marketOpen(t time.Time) bool {
+	if seasonStart != 0 {
+		m := t.Month()
+		if seasonStart <= seasonEnd && (m < seasonStart || m > seasonEnd) {
+			return false
+		}
+		if seasonStart > seasonEnd && m < seasonStart && m > seasonEnd {
+			return false
+		}
+	}
+
+	if len(marketWeekdays) == 0 {
+		return true
+	}
+	for _, w := range marketWeekdays {
+		if t.Weekday() == w {
+			return true
+		}
+	}
+	return false
+}
+
+// marketActive() reports whether scheduled downloads should run at t, which
+// is when the market is open that day or was the day before, so the last
+// sales of a market day are picked up the next morning.
+func marketActive(t time.Time) bool {
+	return marketOpen(t) || marketOpen(t.AddDate(0, 0, -1))
+}
+
+// Returns the month named s, such as May or may, or 0 if s is not a month.
+func parseMonth(s string) time.Month {
+	s = strings.TrimSpace(s)
+	for m := time.January; m <= time.December; m++ {
+		if strings.EqualFold(m.String(), s) || strings.EqualFold(m.String()[:3], s) {
+			return m
+		}
+	}
+	return 0
+}
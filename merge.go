@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"os"
+)
+
+// mergeProviders() combines the current copies of the reports of each Type
+// from every configured provider into merged/sales.csv and the like, with a
+// Provider column naming the provider each row came from. Types only one
+// provider has are not merged. Each provider is one set of credentials, so
+// several stores on one system are not told apart.
+func mergeProviders() error {
+	byType := make(map[string][]*reportDefinition)
+	var types []string
+	for _, r := range reports {
+		if p := providers[r.Provider]; r.Type == "" || p == nil || !p.configured() {
+			continue
+		}
+		if byType[r.Type] == nil {
+			types = append(types, r.Type)
+		}
+		byType[r.Type] = append(byType[r.Type], r)
+	}
+
+	for _, typ := range types {
+		var names []string
+		var tables []*report.Table
+		for _, r := range byType[typ] {
+			h, err := currentReport(r.Name)
+			if os.IsNotExist(err) {
+				continue
+			} else if err != nil {
+				return errors.New("Failed to read " + r.Name + ". " + err.Error())
+			}
+			names = append(names, r.Provider)
+			tables = append(tables, h.Table)
+		}
+		if len(tables) < 2 {
+			continue
+		}
+
+		data, err := report.Merge("Provider", names, tables).CSV()
+		if err != nil {
+			return err
+		}
+		if err := cache.WriteFile("merged/"+typ+".csv", data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"time"
+)
+
+// mqttNotifier publishes refresh messages and the latest sales totals to an
+// MQTT broker, for displays that cannot poll the webserver.
+// Messages are retained so subscribers get the latest as soon as they connect.
+//     <topic>/<report>/refreshed  A refreshMessage
+//     <topic>/sold_items/stats    The stats of the latest Sold Items report
+type mqttNotifier struct {
+	client mqtt.Client
+	topic  string
+}
+
+// Returns a notifier publishing under topic on the MQTT broker at url, such as tcp://localhost:1883.
+func newMQTTNotifier(url string, topic string, username string, password string) (*mqttNotifier, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(url).
+		SetClientID("report-cacher").
+		SetUsername(username).
+		SetPassword(password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true)
+
+	client := mqtt.NewClient(opts)
+	// With retries on, the first connection continues in the background
+	// if the broker cannot be reached in time.
+	t := client.Connect()
+	t.WaitTimeout(30 * time.Second)
+	if err := t.Error(); err != nil {
+		return nil, errors.New("Failed to connect to MQTT broker. " + err.Error())
+	}
+
+	return &mqttNotifier{client: client, topic: topic}, nil
+}
+
+func (n *mqttNotifier) notify(m refreshMessage) error {
+	if err := n.publish(m.Report+"/refreshed", m); err != nil {
+		return err
+	}
+
+	if m.Report != "sold_items" {
+		return nil
+	}
+
+	// The Sold Items report covers the past week, so these are week to date.
+	from, err1 := businessDate(m.Start)
+	to, err2 := businessDate(m.End)
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+
+	stats, err := analyzer.Statistics(from, to, 5)
+	if err != nil {
+		return err
+	}
+
+	return n.publish("sold_items/stats", stats)
+}
+
+// Publishes v as JSON to subtopic of the notifier's topic.
+func (n *mqttNotifier) publish(subtopic string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	t := n.client.Publish(n.topic+"/"+subtopic, 1, true, data)
+	if !t.WaitTimeout(30 * time.Second) {
+		return errors.New("Timed out publishing to MQTT " + n.topic + "/" + subtopic)
+	}
+
+	return t.Error()
+}
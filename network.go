@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The networks the webserver and gRPC service answer, from -allow and
+// -deny. Every address is allowed when allowedNetworks is empty.
+var allowedNetworks, deniedNetworks []*net.IPNet
+
+// The networks of proxies, such as nginx, trusted to say which client they
+// forwarded a request for, from -trustedproxies.
+var trustedProxies []*net.IPNet
+
+// parseNetworks() reads a comma separated list of CIDR ranges and single
+// addresses, such as 192.168.1.0/24,10.8.0.0/16,203.0.113.7
+func parseNetworks(list string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, errors.New("Invalid address " + s + ". Use an IP address or a CIDR range such as 192.168.1.0/24.")
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, errors.New("Invalid range " + s + ". Use a CIDR range such as 192.168.1.0/24.")
+		}
+		networks = append(networks, n)
+	}
+
+	return networks, nil
+}
+
+// Reports whether any of networks contains ip.
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, n := range networks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// networkAllowed() reports whether the client at ip may be answered: it is
+// in none of the denied networks and, if any are allowed, one of those.
+func networkAllowed(ip net.IP) bool {
+	if len(allowedNetworks) == 0 && len(deniedNetworks) == 0 {
+		return true
+	}
+	if ip == nil || containsIP(deniedNetworks, ip) {
+		return false
+	}
+	return len(allowedNetworks) == 0 || containsIP(allowedNetworks, ip)
+}
+
+// restrictNetworks() answers 403 Forbidden to clients h may not serve. See
+// networkAllowed().
+func restrictNetworks(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !networkAllowed(net.ParseIP(clientIP(r))) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientIP() returns the address of the client that made request r. When
+// it came through trusted proxies, that is the last address in
+// X-Forwarded-For that isn't one of them; earlier addresses could have been
+// made up by the client.
+//     X-Forwarded-For: 203.0.113.7, 10.0.0.2
+func clientIP(r *http.Request) string {
+	return clientAddr(r.RemoteAddr, r.Header["X-Forwarded-For"])
+}
+
+// clientAddr() returns the address of a client connected from remote,
+// host:port, the same way as clientIP(). headers are the values of the
+// X-Forwarded-For headers it sent.
+func clientAddr(remote string, headers []string) string {
+	host, _, err := net.SplitHostPort(remote)
+	if err != nil {
+		host = remote
+	}
+	if len(trustedProxies) == 0 || !containsIP(trustedProxies, net.ParseIP(host)) {
+		return host
+	}
+
+	var forwarded []string
+	for _, h := range headers {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if ip == nil {
+			break
+		}
+		host = ip.String()
+		if !containsIP(trustedProxies, ip) {
+			break
+		}
+	}
+
+	return host
+}
+
+// requestScheme() returns whether request r was made with http or https,
+// as told by X-Forwarded-Proto when it came through a trusted proxy.
+func requestScheme(r *http.Request) string {
+	host, _, _ := net.SplitHostPort(r.RemoteAddr)
+	if p := r.Header.Get("X-Forwarded-Proto"); (p == "http" || p == "https") && containsIP(trustedProxies, net.ParseIP(host)) {
+		return p
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// A restrictedListener closes connections from clients that aren't allowed
+// as soon as they are accepted. See networkAllowed().
+type restrictedListener struct {
+	net.Listener
+}
+
+func (l restrictedListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return c, err
+		}
+
+		if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok && networkAllowed(addr.IP) {
+			return c, nil
+		}
+		c.Close()
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Parses list with parseNetworks(), failing t if it's invalid.
+func mustParseNetworks(t *testing.T, list string) []*net.IPNet {
+	t.Helper()
+	networks, err := parseNetworks(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return networks
+}
+
+func TestParseNetworks(t *testing.T) {
+	tests := []struct {
+		list string
+		want []string
+		ok   bool
+	}{
+		{"", nil, true},
+		{"192.168.1.0/24", []string{"192.168.1.0/24"}, true},
+		{" 192.168.1.7/24 , 10.8.0.0/16 ", []string{"192.168.1.0/24", "10.8.0.0/16"}, true},
+		{"203.0.113.7", []string{"203.0.113.7/32"}, true},
+		{"2001:db8::1", []string{"2001:db8::1/128"}, true},
+		{"2001:db8::/32,", []string{"2001:db8::/32"}, true},
+		{"203.0.113", nil, false},
+		{"192.168.1.0/33", nil, false},
+		{"192.168.1.0/24,lan", nil, false},
+	}
+
+	for _, tt := range tests {
+		networks, err := parseNetworks(tt.list)
+		if (err == nil) != tt.ok {
+			t.Errorf("parseNetworks(%q) returned error %v, want ok %v", tt.list, err, tt.ok)
+			continue
+		}
+		var got []string
+		for _, n := range networks {
+			got = append(got, n.String())
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("parseNetworks(%q) = %v, want %v", tt.list, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("parseNetworks(%q) = %v, want %v", tt.list, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestNetworkAllowed(t *testing.T) {
+	oldAllowed, oldDenied := allowedNetworks, deniedNetworks
+	defer func() { allowedNetworks, deniedNetworks = oldAllowed, oldDenied }()
+
+	tests := []struct {
+		allow, deny string
+		ip          string
+		want        bool
+	}{
+		{"", "", "203.0.113.7", true},
+		{"", "", "", true},
+		{"192.168.1.0/24,10.8.0.0/16", "", "192.168.1.20", true},
+		{"192.168.1.0/24,10.8.0.0/16", "", "10.8.3.4", true},
+		{"192.168.1.0/24,10.8.0.0/16", "", "203.0.113.7", false},
+		{"192.168.1.0/24", "", "", false},
+		{"192.168.1.0/24", "192.168.1.13", "192.168.1.13", false},
+		{"192.168.1.0/24", "192.168.1.13", "192.168.1.12", true},
+		{"", "203.0.113.0/24", "203.0.113.7", false},
+		{"", "203.0.113.0/24", "198.51.100.7", true},
+		{"192.168.1.0/24", "", "::ffff:192.168.1.20", true},
+		{"2001:db8::/32", "", "2001:db8::7", true},
+		{"2001:db8::/32", "", "192.168.1.20", false},
+	}
+
+	for _, tt := range tests {
+		allowedNetworks, deniedNetworks = mustParseNetworks(t, tt.allow), mustParseNetworks(t, tt.deny)
+		if got := networkAllowed(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("networkAllowed(%q) with -allow %q -deny %q = %v, want %v", tt.ip, tt.allow, tt.deny, got, tt.want)
+		}
+	}
+}
+
+func TestRestrictNetworks(t *testing.T) {
+	oldAllowed, oldDenied := allowedNetworks, deniedNetworks
+	defer func() { allowedNetworks, deniedNetworks = oldAllowed, oldDenied }()
+	allowedNetworks, deniedNetworks = mustParseNetworks(t, "192.168.1.0/24"), nil
+
+	h := restrictNetworks(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		remote string
+		want   int
+	}{
+		{"192.168.1.20:5000", http.StatusOK},
+		{"203.0.113.7:5000", http.StatusForbidden},
+		{"[2001:db8::7]:5000", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("Request from %s answered %d, want %d", tt.remote, w.Code, tt.want)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	oldProxies := trustedProxies
+	defer func() { trustedProxies = oldProxies }()
+
+	tests := []struct {
+		name      string
+		proxies   string
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{"direct", "", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"no port", "", "203.0.113.7", nil, "203.0.113.7"},
+		{"untrusted proxy", "", "10.0.0.2:5000", []string{"203.0.113.7"}, "10.0.0.2"},
+		{"not from a trusted proxy", "10.0.0.0/8", "198.51.100.9:5000", []string{"203.0.113.7"}, "198.51.100.9"},
+		{"trusted proxy", "10.0.0.0/8", "10.0.0.2:5000", []string{"203.0.113.7"}, "203.0.113.7"},
+		{"trusted proxy without header", "10.0.0.0/8", "10.0.0.2:5000", nil, "10.0.0.2"},
+		{"chain of trusted proxies", "10.0.0.0/8", "10.0.0.2:5000", []string{"203.0.113.7, 10.0.0.5, 10.0.0.3"}, "203.0.113.7"},
+		{"spoofed by client", "10.0.0.0/8", "10.0.0.2:5000", []string{"192.0.2.66, 203.0.113.7"}, "203.0.113.7"},
+		{"spoofed trusted address", "10.0.0.0/8", "10.0.0.2:5000", []string{"10.0.0.9, 203.0.113.7"}, "203.0.113.7"},
+		{"several headers", "10.0.0.0/8", "10.0.0.2:5000", []string{"192.0.2.66", "203.0.113.7, 10.0.0.3"}, "203.0.113.7"},
+		{"invalid entry", "10.0.0.0/8", "10.0.0.2:5000", []string{"203.0.113.7, unknown, 10.0.0.3"}, "10.0.0.3"},
+		{"only trusted", "10.0.0.0/8", "10.0.0.2:5000", []string{"10.0.0.5, 10.0.0.3"}, "10.0.0.5"},
+		{"IPv6", "2001:db8::/32", "[2001:db8::2]:5000", []string{"2001:DB8:1::7"}, "2001:db8:1::7"},
+	}
+
+	for _, tt := range tests {
+		trustedProxies = mustParseNetworks(t, tt.proxies)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		for _, h := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", h)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRequestScheme(t *testing.T) {
+	oldProxies := trustedProxies
+	defer func() { trustedProxies = oldProxies }()
+	trustedProxies = mustParseNetworks(t, "10.0.0.0/8")
+
+	tests := []struct {
+		remote string
+		proto  string
+		want   string
+	}{
+		{"203.0.113.7:5000", "", "http"},
+		{"203.0.113.7:5000", "https", "http"},
+		{"10.0.0.2:5000", "https", "https"},
+		{"10.0.0.2:5000", "ftp", "http"},
+		{"10.0.0.2:5000", "", "http"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.proto != "" {
+			r.Header.Set("X-Forwarded-Proto", tt.proto)
+		}
+		if got := requestScheme(r); got != tt.want {
+			t.Errorf("requestScheme() from %s with X-Forwarded-Proto %q = %q, want %q", tt.remote, tt.proto, got, tt.want)
+		}
+	}
+}
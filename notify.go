@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// A refreshMessage is sent to Kafka or NATS each time a report is refreshed.
+type refreshMessage struct {
+	Report   string    `json:"report"`
+	Version  time.Time `json:"version"`         // When the version was downloaded.
+	Path     string    `json:"path"`            // Relative to the cache directory, and the webserver root.
+	Location string    `json:"location"`        // The absolute path of the version file.
+	Start    string    `json:"start,omitempty"` // The first day the report covers, YYYY-MM-DD.
+	End      string    `json:"end,omitempty"`   // The last day the report covers, YYYY-MM-DD.
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum"` // Hex encoded SHA-256 of the contents.
+}
+
+// A notifier delivers refresh messages to an external system.
+type notifier interface {
+	notify(m refreshMessage) error
+}
+
+// notifyManager() sends a refreshMessage to every notifier each time a
+// report is refreshed. It can be stopped by close()ing the done channel.
+//     go notifyManager(notifiers, done)
+func notifyManager(notifiers []notifier, done <-chan bool) {
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	for {
+		select {
+		case e := <-ch:
+			if e.Type != eventRefreshed {
+				continue
+			}
+
+			m, err := newRefreshMessage(e)
+			if err != nil {
+				log.Println("Failed to describe " + e.Report + " for notifications. Error: " + err.Error())
+				continue
+			}
+
+			for _, n := range notifiers {
+				if err := n.notify(m); err != nil {
+					log.Println("Failed to send refresh notification. Error: " + err.Error())
+				}
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// Describes the version refreshed event e is about.
+func newRefreshMessage(e event) (refreshMessage, error) {
+	if e.Version == nil {
+		return refreshMessage{}, errors.New("The event doesn't say which version of " + e.Report + " was refreshed")
+	}
+	v, err := cache.VersionAt(e.Report, *e.Version)
+	if err != nil {
+		return refreshMessage{}, err
+	}
+
+	location, err := filepath.Abs(cache.FilePath(v))
+	if err != nil {
+		return refreshMessage{}, err
+	}
+
+	return refreshMessage{
+		Report:   v.Report,
+		Version:  v.Time,
+		Path:     v.Path,
+		Location: location,
+		Start:    v.Start,
+		End:      v.End,
+		Size:     v.Size,
+		Checksum: v.Checksum,
+	}, nil
+}
+
+// kafkaNotifier writes refresh messages to a Kafka topic, keyed by report name.
+type kafkaNotifier struct {
+	writer *kafka.Writer
+}
+
+// Returns a notifier writing to topic on the comma separated list of brokers.
+func newKafkaNotifier(brokers string, topic string) *kafkaNotifier {
+	return &kafkaNotifier{writer: &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		RequiredAcks: kafka.RequireOne,
+	}}
+}
+
+func (k *kafkaNotifier) notify(m refreshMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return k.writer.WriteMessages(ctx, kafka.Message{Key: []byte(m.Report), Value: data})
+}
+
+// natsNotifier publishes refresh messages to a NATS subject.
+// The report name is appended to the subject: report-cacher.refreshed.sold_items
+type natsNotifier struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// Returns a notifier publishing under subject on the NATS server at url.
+func newNATSNotifier(url string, subject string) (*natsNotifier, error) {
+	conn, err := nats.Connect(url, nats.Name("report-cacher"), nats.MaxReconnects(-1))
+	if err != nil {
+		return nil, errors.New("Failed to connect to NATS. " + err.Error())
+	}
+
+	return &natsNotifier{conn: conn, subject: subject}, nil
+}
+
+func (n *natsNotifier) notify(m refreshMessage) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return n.conn.Publish(n.subject+"."+m.Report, data)
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long a login through OpenID Connect lasts.
+const sessionAge = 12 * time.Hour
+
+// The cookies holding the logged in user and, during a login, its state.
+const (
+	sessionCookie = "report_cacher_session"
+	loginCookie   = "report_cacher_login"
+)
+
+// oidcLogin holds what's needed to log users in through -oidcissuer, or is
+// nil when OpenID Connect is disabled.
+var oidcLogin *struct {
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+	key      []byte // Signs session cookies. Made up at startup.
+}
+
+// setupOIDC() looks up -oidcissuer's endpoints and keys so users can log in
+// with their accounts there, such as Google Workspace accounts.
+func setupOIDC() error {
+	provider, err := oidc.NewProvider(context.Background(), *oidcIssuer)
+	if err != nil {
+		return errors.New("Failed to look up the OpenID Connect issuer " + *oidcIssuer + ". " + err.Error())
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+
+	oidcLogin = &struct {
+		config   oauth2.Config
+		verifier *oidc.IDTokenVerifier
+		key      []byte
+	}{
+		config: oauth2.Config{
+			ClientID:     *oidcClientID,
+			ClientSecret: *oidcSecret,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: *oidcClientID}),
+		key:      key,
+	}
+	return nil
+}
+
+// isLoginPath() reports whether urlPath is one of the pages used to log in,
+// which must be reachable without logging in.
+func isLoginPath(urlPath string) bool {
+	return urlPath == "/login" || urlPath == "/login/callback" || urlPath == "/logout"
+}
+
+// oidcLoginHandler() logs users in through -oidcissuer.
+//     GET /login?next=/dashboard/   Redirects to the issuer to log in.
+//     GET /login/callback           Where the issuer redirects back to.
+//     GET /logout
+func oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	secure := requestScheme(r) == "https"
+	config := oidcLogin.config
+	config.RedirectURL = requestScheme(r) + "://" + r.Host + "/login/callback"
+
+	switch r.URL.Path {
+	case "/logout":
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: "", Path: "/", MaxAge: -1, HttpOnly: true, Secure: secure})
+		w.Write([]byte("You are logged out."))
+
+	case "/login":
+		next := r.FormValue("next")
+		if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+			next = "/"
+		}
+		state, nonce := randomToken(), randomToken()
+		http.SetCookie(w, &http.Cookie{
+			Name:     loginCookie,
+			Value:    state + "." + nonce + "." + base64.RawURLEncoding.EncodeToString([]byte(next)),
+			Path:     "/login",
+			MaxAge:   600,
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+
+		options := []oauth2.AuthCodeOption{oidc.Nonce(nonce)}
+		if *oidcDomain != "" {
+			options = append(options, oauth2.SetAuthURLParam("hd", *oidcDomain))
+		}
+		http.Redirect(w, r, config.AuthCodeURL(state, options...), http.StatusFound)
+
+	case "/login/callback":
+		c, err := r.Cookie(loginCookie)
+		parts := []string{}
+		if err == nil {
+			parts = strings.Split(c.Value, ".")
+		}
+		if len(parts) != 3 || r.FormValue("state") != parts[0] {
+			http.Error(w, "The login expired. Try again.", http.StatusBadRequest)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: loginCookie, Value: "", Path: "/login", MaxAge: -1})
+
+		email, err := verifyLogin(r.Context(), &config, r.FormValue("code"), parts[1])
+		if err != nil {
+			logFor(r.Context()).Println("Failed login through " + *oidcIssuer + " from " + clientIP(r) + ". Error: " + err.Error())
+			http.Error(w, "Failed to log in. "+err.Error(), http.StatusForbidden)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:     sessionCookie,
+			Value:    signSession(email, time.Now().Add(sessionAge)),
+			Path:     "/",
+			MaxAge:   int(sessionAge.Seconds()),
+			HttpOnly: true,
+			Secure:   secure,
+			SameSite: http.SameSiteLaxMode,
+		})
+		next, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		http.Redirect(w, r, string(next), http.StatusFound)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// verifyLogin() exchanges the code the issuer sent back for an ID token and
+// returns the email address of the user it identifies, if they may log in.
+// See oidcUser().
+func verifyLogin(ctx context.Context, config *oauth2.Config, code string, nonce string) (string, error) {
+	token, err := config.Exchange(ctx, code)
+	if err != nil {
+		return "", errors.New("The code could not be exchanged. " + err.Error())
+	}
+
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", errors.New("No ID token was given.")
+	}
+	id, err := oidcLogin.verifier.Verify(ctx, raw)
+	if err != nil {
+		return "", errors.New("The ID token is invalid. " + err.Error())
+	}
+	if id.Nonce != nonce {
+		return "", errors.New("The ID token is for another login.")
+	}
+
+	var claims struct {
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		HostedDomain  string `json:"hd"`
+	}
+	if err := id.Claims(&claims); err != nil {
+		return "", err
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return "", errors.New("The account has no verified email address.")
+	}
+
+	email := strings.ToLower(claims.Email)
+	if users[email] == nil && (*oidcDomain == "" || !strings.EqualFold(claims.HostedDomain, *oidcDomain)) {
+		return "", errors.New(email + " may not use the report-cacher.")
+	}
+
+	return email, nil
+}
+
+// oidcUser() returns the user logged in through -oidcissuer as email: the
+// user of that name in -users, or else an administrator if -oidcdomain is
+// set, as their account was in it when they logged in.
+func oidcUser(email string) *user {
+	if u := users[email]; u != nil {
+		return u
+	}
+	if *oidcDomain != "" {
+		return &user{name: email}
+	}
+	return nil
+}
+
+// signSession() returns the value of a session cookie for email lasting
+// until expires.
+func signSession(email string, expires time.Time) string {
+	payload := email + "|" + strconv.FormatInt(expires.Unix(), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + base64.RawURLEncoding.EncodeToString(sessionMAC(payload))
+}
+
+// sessionUser() returns the user logged in by r's session cookie, or nil if
+// it has none, or one that is forged or has expired.
+func sessionUser(r *http.Request) *user {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return nil
+	}
+	return cookieUser(c.Value)
+}
+
+// cookieUser() returns the user logged in with the session cookie value,
+// or nil if it isn't valid or has expired.
+func cookieUser(value string) *user {
+	if oidcLogin == nil {
+		return nil
+	}
+
+	parts := strings.Split(value, ".")
+	if len(parts) != 2 {
+		return nil
+	}
+	payload, err1 := base64.RawURLEncoding.DecodeString(parts[0])
+	mac, err2 := base64.RawURLEncoding.DecodeString(parts[1])
+	if err1 != nil || err2 != nil || !hmac.Equal(mac, sessionMAC(string(payload))) {
+		return nil
+	}
+
+	i := strings.LastIndex(string(payload), "|")
+	expires, err := strconv.ParseInt(string(payload[i+1:]), 10, 64)
+	if i < 0 || err != nil || time.Now().Unix() > expires {
+		return nil
+	}
+	return oidcUser(string(payload[:i]))
+}
+
+// Returns the signature of a session cookie's payload.
+func sessionMAC(payload string) []byte {
+	m := hmac.New(sha256.New, oidcLogin.key)
+	m.Write([]byte(payload))
+	return m.Sum(nil)
+}
+
+// Returns a random string for the state and nonce of a login.
+func randomToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A fake issuer, which hands out the ID token claims in its codes map for
+// each code exchanged.
+type fakeIssuer struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	codes map[string]map[string]interface{}
+}
+
+// Starts a fake issuer and sets oidcLogin to log in through it, restoring
+// oidcLogin when t ends.
+func startIssuer(t *testing.T) *fakeIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &fakeIssuer{key: key, codes: map[string]map[string]interface{}{}}
+	iss.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := iss.codes[r.FormValue("code")]
+		if r.URL.Path != "/token" || !ok {
+			http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "token",
+			"token_type":   "Bearer",
+			"id_token":     iss.sign(t, claims),
+		})
+	}))
+
+	oldLogin, oldIssuer := oidcLogin, *oidcIssuer
+	t.Cleanup(func() {
+		iss.Close()
+		oidcLogin, *oidcIssuer = oldLogin, oldIssuer
+	})
+	*oidcIssuer = iss.URL
+	oidcLogin = &struct {
+		config   oauth2.Config
+		verifier *oidc.IDTokenVerifier
+		key      []byte
+	}{
+		config: oauth2.Config{
+			ClientID: "client",
+			Endpoint: oauth2.Endpoint{AuthURL: iss.URL + "/auth", TokenURL: iss.URL + "/token", AuthStyle: oauth2.AuthStyleInParams},
+			Scopes:   []string{oidc.ScopeOpenID, "email"},
+		},
+		verifier: oidc.NewVerifier(iss.URL, &oidc.StaticKeySet{PublicKeys: []crypto.PublicKey{&key.PublicKey}}, &oidc.Config{ClientID: "client"}),
+		key:      []byte("0123456789abcdef0123456789abcdef"),
+	}
+	return iss
+}
+
+// Returns an ID token for claims signed by the issuer.
+func (iss *fakeIssuer) sign(t *testing.T, claims map[string]interface{}) string {
+	full := map[string]interface{}{
+		"iss": iss.URL,
+		"aud": "client",
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range claims {
+		full[k] = v
+	}
+	payload, err := json.Marshal(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, iss.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// Returns the cookie named name set by w, or nil.
+func responseCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, c := range w.Result().Cookies() {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestLoginRedirect(t *testing.T) {
+	startIssuer(t)
+	oldDomain := *oidcDomain
+	defer func() { *oidcDomain = oldDomain }()
+	*oidcDomain = "jfmarket.org"
+
+	tests := []struct {
+		next string
+		want string
+	}{
+		{"", "/"},
+		{"/dashboard/", "/dashboard/"},
+		{"/vendors/hillside_farm/?sort=date", "/vendors/hillside_farm/?sort=date"},
+		{"https://evil.example/", "/"},
+		{"//evil.example/", "/"},
+		{"/\\evil.example/", "/"},
+		{"dashboard", "/"},
+		{"javascript:alert(1)", "/"},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/login?next="+url.QueryEscape(tt.next), nil)
+		w := httptest.NewRecorder()
+		oidcLoginHandler(w, r)
+
+		c := responseCookie(w, loginCookie)
+		if w.Code != http.StatusFound || c == nil {
+			t.Errorf("Login to %q answered %d with cookie %v, want a redirect setting %s", tt.next, w.Code, c, loginCookie)
+			continue
+		}
+		parts := strings.Split(c.Value, ".")
+		if len(parts) != 3 {
+			t.Errorf("Login to %q set cookie %q, want state.nonce.next", tt.next, c.Value)
+			continue
+		}
+		if next, _ := base64.RawURLEncoding.DecodeString(parts[2]); string(next) != tt.want {
+			t.Errorf("Login to %q will go on to %q, want %q", tt.next, next, tt.want)
+		}
+
+		to, err := url.Parse(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := to.Query()
+		if q.Get("state") != parts[0] || q.Get("nonce") != parts[1] || q.Get("hd") != "jfmarket.org" {
+			t.Errorf("Login to %q redirected to %s, want state %s, nonce %s and hd jfmarket.org", tt.next, to, parts[0], parts[1])
+		}
+	}
+
+	// Each login gets its own state and nonce.
+	var values []string
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		oidcLoginHandler(w, httptest.NewRequest("GET", "/login", nil))
+		values = append(values, responseCookie(w, loginCookie).Value)
+	}
+	if values[0][:22] == values[1][:22] {
+		t.Errorf("Two logins were given the same state, %q and %q", values[0], values[1])
+	}
+}
+
+func TestLoginCallback(t *testing.T) {
+	iss := startIssuer(t)
+	oldUsers, oldDomain := users, *oidcDomain
+	defer func() { users, *oidcDomain = oldUsers, oldDomain }()
+	users = map[string]*user{"treasurer@gmail.com": {name: "treasurer@gmail.com"}}
+
+	next := base64.RawURLEncoding.EncodeToString([]byte("/dashboard/"))
+	iss.codes["treasurer"] = map[string]interface{}{"nonce": "nonce", "email": "Treasurer@gmail.com", "email_verified": true}
+	iss.codes["board"] = map[string]interface{}{"nonce": "nonce", "email": "board@jfmarket.org", "email_verified": true, "hd": "jfmarket.org"}
+	iss.codes["other login"] = map[string]interface{}{"nonce": "other", "email": "treasurer@gmail.com", "email_verified": true}
+	iss.codes["unverified"] = map[string]interface{}{"nonce": "nonce", "email": "treasurer@gmail.com", "email_verified": false}
+	iss.codes["stranger"] = map[string]interface{}{"nonce": "nonce", "email": "stranger@gmail.com", "email_verified": true}
+	iss.codes["other domain"] = map[string]interface{}{"nonce": "nonce", "email": "board@example.org", "email_verified": true, "hd": "example.org"}
+
+	tests := []struct {
+		name   string
+		cookie string
+		state  string
+		code   string
+		domain string
+		want   int
+		user   string
+	}{
+		{"no login cookie", "", "state", "treasurer", "", http.StatusBadRequest, ""},
+		{"malformed cookie", "state.nonce", "state", "treasurer", "", http.StatusBadRequest, ""},
+		{"wrong state", "state.nonce." + next, "forged", "treasurer", "", http.StatusBadRequest, ""},
+		{"no state", "state.nonce." + next, "", "treasurer", "", http.StatusBadRequest, ""},
+		{"bad code", "state.nonce." + next, "state", "guess", "", http.StatusForbidden, ""},
+		{"nonce of another login", "state.nonce." + next, "state", "other login", "", http.StatusForbidden, ""},
+		{"unverified email", "state.nonce." + next, "state", "unverified", "", http.StatusForbidden, ""},
+		{"unknown user", "state.nonce." + next, "state", "stranger", "", http.StatusForbidden, ""},
+		{"unknown user in other domain", "state.nonce." + next, "state", "other domain", "jfmarket.org", http.StatusForbidden, ""},
+		{"domain without -oidcdomain", "state.nonce." + next, "state", "board", "", http.StatusForbidden, ""},
+		{"user", "state.nonce." + next, "state", "treasurer", "", http.StatusFound, "treasurer@gmail.com"},
+		{"domain", "state.nonce." + next, "state", "board", "jfmarket.org", http.StatusFound, "board@jfmarket.org"},
+	}
+
+	for _, tt := range tests {
+		*oidcDomain = tt.domain
+		r := httptest.NewRequest("GET", "/login/callback?"+url.Values{"state": {tt.state}, "code": {tt.code}}.Encode(), nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: loginCookie, Value: tt.cookie})
+		}
+		w := httptest.NewRecorder()
+		oidcLoginHandler(w, r)
+
+		session := responseCookie(w, sessionCookie)
+		if w.Code != tt.want {
+			t.Errorf("%s: callback answered %d with %q, want %d", tt.name, w.Code, w.Body.String(), tt.want)
+			continue
+		}
+		if tt.user == "" {
+			if session != nil {
+				t.Errorf("%s: callback set session %q, want none", tt.name, session.Value)
+			}
+			continue
+		}
+
+		if to := w.Header().Get("Location"); to != "/dashboard/" {
+			t.Errorf("%s: callback redirected to %q, want /dashboard/", tt.name, to)
+		}
+		if session == nil {
+			t.Errorf("%s: callback set no session", tt.name)
+			continue
+		}
+		r = httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(session)
+		if u := sessionUser(r); u == nil || u.name != tt.user {
+			t.Errorf("%s: session is for %+v, want %s", tt.name, u, tt.user)
+		}
+	}
+}
+
+func TestSessionUser(t *testing.T) {
+	startIssuer(t)
+	oldUsers, oldDomain := users, *oidcDomain
+	defer func() { users, *oidcDomain = oldUsers, oldDomain }()
+	users = map[string]*user{"treasurer@gmail.com": {name: "treasurer@gmail.com"}}
+	*oidcDomain = ""
+
+	valid := signSession("treasurer@gmail.com", time.Now().Add(time.Hour))
+	mac := valid[strings.Index(valid, ".")+1:]
+	oldKey := oidcLogin.key
+	oidcLogin.key = []byte("another key made up at another start")
+	otherKey := signSession("treasurer@gmail.com", time.Now().Add(time.Hour))
+	oidcLogin.key = oldKey
+
+	tests := []struct {
+		name   string
+		cookie string
+		want   string
+	}{
+		{"valid", valid, "treasurer@gmail.com"},
+		{"no cookie", "", ""},
+		{"expired", signSession("treasurer@gmail.com", time.Now().Add(-time.Minute)), ""},
+		{"unknown user", signSession("stranger@gmail.com", time.Now().Add(time.Hour)), ""},
+		{"other key", otherKey, ""},
+		{"changed user", base64.RawURLEncoding.EncodeToString([]byte("board@jfmarket.org|9999999999")) + "." + mac, ""},
+		{"changed expiry", base64.RawURLEncoding.EncodeToString([]byte("treasurer@gmail.com|9999999999")) + "." + mac, ""},
+		{"no signature", valid[:strings.Index(valid, ".")], ""},
+		{"empty signature", valid[:strings.Index(valid, ".")+1], ""},
+		{"extra part", valid + ".x", ""},
+		{"bad base64", "!!!." + mac, ""},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/", nil)
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.cookie})
+		}
+		u := sessionUser(r)
+		if (u == nil && tt.want != "") || (u != nil && u.name != tt.want) {
+			t.Errorf("%s: sessionUser() = %+v, want %q", tt.name, u, tt.want)
+		}
+	}
+
+	// Sessions are only accepted while OpenID Connect is enabled.
+	oidcLogin = nil
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: valid})
+	if u := sessionUser(r); u != nil {
+		t.Errorf("sessionUser() without -oidcissuer = %+v, want nil", u)
+	}
+}
+
+func TestRequireLoginOIDC(t *testing.T) {
+	startIssuer(t)
+	oldUsers, oldDomain := users, *oidcDomain
+	defer func() { users, *oidcDomain = oldUsers, oldDomain }()
+	users, *oidcDomain = map[string]*user{}, "jfmarket.org"
+
+	h := requireLogin(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	session := signSession("board@jfmarket.org", time.Now().Add(time.Hour))
+
+	tests := []struct {
+		method  string
+		path    string
+		session string
+		want    int
+		to      string
+	}{
+		{"GET", "/dashboard/?day=2014-03-25", "", http.StatusFound, "/login?next=%2Fdashboard%2F%3Fday%3D2014-03-25"},
+		{"POST", "/api/refresh", "", http.StatusUnauthorized, ""},
+		{"GET", "/api/reports", "", http.StatusUnauthorized, ""},
+		{"GET", "/login", "", http.StatusOK, ""},
+		{"GET", "/login/callback", "", http.StatusOK, ""},
+		{"GET", "/logout", "", http.StatusOK, ""},
+		{"GET", "/dashboard/", session, http.StatusOK, ""},
+		{"GET", "/api/reports", session, http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.session != "" {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: tt.session})
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want || w.Header().Get("Location") != tt.to {
+			t.Errorf("%s %s answered %d to %q, want %d to %q", tt.method, tt.path, w.Code, w.Header().Get("Location"), tt.want, tt.to)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/jfmarket/report-cacher/store"
+	"log"
+	"time"
+)
+
+// planUpdate() prints what an update would do now, for -dryrun: which
+// reports would be downloaded, covering which days, and where each would be
+// stored, without writing anything. With -dryrunlogin, it also logs in to
+// each provider a report would be downloaded from, to check its credentials.
+//     report-cacher -dryrun -dryrunlogin -email=user@domain.com -password=mypassword
+func planUpdate() error {
+	checkOptions()
+
+	// Only read the cache, so a new -directory isn't created.
+	var err error
+	if cache, err = store.New(*directory); err != nil {
+		return err
+	}
+
+	now := wallClock.Now()
+	if until, ok := blackoutUntil(businessNow()); ok {
+		fmt.Println("Scheduled updates are deferred until the blackout ends at " + until.Format("15:04") + ".")
+	} else if !marketActive(businessNow()) {
+		fmt.Println("Scheduled updates are skipped while the market is closed.")
+	}
+
+	// List the reports in the order they would be downloaded.
+	var ordered []*reportDefinition
+	for _, group := range byPriority(reports) {
+		ordered = append(ordered, group...)
+	}
+
+	logins := make(map[string]bool)
+	for _, r := range ordered {
+		p := providers[r.Provider]
+		if p == nil || !p.configured() {
+			fmt.Printf("%s: skip, %s is not configured.\n", r.Name, r.Provider)
+			continue
+		}
+
+		due, reason := r.dueReason(now, *force)
+		if !due {
+			if reason == "" {
+				reason = "It was downloaded less than " + r.Interval.String() + " ago."
+			}
+			fmt.Printf("%s: skip. %s\n", r.Name, reason)
+			continue
+		}
+
+		days := ""
+		if r.Dates != nil {
+			start, end := r.Dates()
+			days = " " + start + " to " + end
+		}
+		fmt.Printf("%s: download%s from %s to %s within %s.\n", r.Name, days, r.Provider, cache.Path(r.Name), r.timeout())
+		logins[r.Provider] = true
+	}
+
+	if *dryRunLogin {
+		for name := range logins {
+			checkLogin(name)
+		}
+	}
+
+	return nil
+}
+
+// checkLogin() logs in to provider name and out again, printing whether
+// it worked.
+func checkLogin(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		s, err := providers[name].login(ctx)
+		if l, ok := s.(logouter); ok && err == nil {
+			if err := l.Logout(); err != nil {
+				log.Println(err)
+			}
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			fmt.Println("Logging in to " + name + " failed. " + err.Error())
+		} else {
+			fmt.Println("Logging in to " + name + " worked.")
+		}
+	case <-ctx.Done():
+		fmt.Println("Logging in to " + name + " took over a minute.")
+	}
+}
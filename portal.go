@@ -0,0 +1,88 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/report"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// The page vendors see after logging in.
+var portalTemplate = template.Must(template.New("portal").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Vendor}} - Sales</title>
+</head>
+<body>
+<h1>{{.Vendor}}</h1>
+{{with .Payout}}
+<h2>Payout</h2>
+<table>
+<tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>{{end}}
+</table>
+{{end}}
+<h2>Reports</h2>
+<ul>
+{{range .Files}}<li><a href="/vendors/{{$.Slug}}/{{.}}">{{.}}</a></li>
+{{else}}<li>No reports are available yet.</li>
+{{end}}
+</ul>
+</body>
+</html>
+`))
+
+// portalHandler() shows a vendor their payout and the reports they may download.
+// Administrators are sent to the directory of every vendor's files.
+func portalHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r)
+	if u == nil || u.vendor == "" {
+		http.Redirect(w, r, "/vendors/", http.StatusFound)
+		return
+	}
+
+	slug := report.Slug(u.vendor)
+	dir := filepath.Join(*directory, "vendors", slug)
+
+	var files []string
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		logFor(r.Context()).Println("Failed to list " + dir + ". Error: " + err.Error())
+	}
+	for _, fi := range infos {
+		if !fi.IsDir() {
+			files = append(files, fi.Name())
+		}
+	}
+
+	payout, err := readTable(filepath.Join(dir, "payout.csv"))
+	if err != nil || !currentPayout(payout) {
+		payout = nil
+	}
+
+	err = portalTemplate.Execute(w, struct {
+		Vendor string
+		Slug   string
+		Payout *report.Table
+		Files  []string
+	}{u.vendor, slug, payout, files})
+	if err != nil {
+		logFor(r.Context()).Println("Failed to render portal. Error: " + err.Error())
+	}
+}
+
+// Reports whether payout covers the same days as the latest Sold Items
+// report. A vendor who sold nothing in it still has their last payout, which
+// isn't shown as though it were current.
+func currentPayout(payout *report.Table) bool {
+	versions, err := cache.Versions("sold_items")
+	if err != nil || len(versions) == 0 || len(payout.Rows) == 0 {
+		return false
+	}
+	latest := versions[len(versions)-1]
+
+	return payout.Value(0, payout.Column("From")) == latest.Start && payout.Value(0, payout.Column("To")) == latest.End
+}
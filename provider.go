@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// A provider is a point-of-sale system reports are downloaded from, such as
+// ShopKeep. Each registered report names the provider it comes from.
+type provider interface {
+	// configured() reports whether the flags the provider needs are set.
+	// Reports from unconfigured providers are not downloaded.
+	configured() bool
+
+	// login() starts a session used for one update's downloads. It gives
+	// up when ctx is canceled, such as by -deadline or stopping.
+	login(ctx context.Context) (session, error)
+}
+
+// A session is a logged in connection to a provider. It is passed to the
+// Fetch function of each of the provider's reports, which knows its type.
+type session interface{}
+
+// A logouter is a session that should be ended once its downloads are
+// done, such as a ShopKeep login, rather than left to expire.
+type logouter interface {
+	Logout() error
+}
+
+// openSessions are the sessions of the update under way, so they can be
+// ended even if the report-cacher stops during it.
+var openSessions struct {
+	sync.Mutex
+	list []session
+}
+
+// openSession() records a session to end with endSessions().
+func openSession(s session) {
+	openSessions.Lock()
+	openSessions.list = append(openSessions.list, s)
+	openSessions.Unlock()
+}
+
+// endSessions() logs out of every open session that can be logged out of.
+func endSessions() {
+	openSessions.Lock()
+	list := openSessions.list
+	openSessions.list = nil
+	openSessions.Unlock()
+
+	for _, s := range list {
+		if l, ok := s.(logouter); ok {
+			if err := l.Logout(); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// providers are the registered providers by name.
+var providers = make(map[string]provider)
+
+// registerProvider() adds a point-of-sale system reports can be downloaded from.
+func registerProvider(name string, p provider) {
+	if providers[name] != nil {
+		panic("provider " + name + " registered twice")
+	}
+
+	providers[name] = p
+}
+
+// providerNames() returns the names of the configured providers, sorted.
+func providerNames() []string {
+	var names []string
+	for name, p := range providers {
+		if p.configured() {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// downloadAll() orchestrates downloading all registered reports that are due.
+// Each configured provider is logged in to once, then the reports are
+// downloaded in order of priority, those of the same priority concurrently.
+// It returns an error if there is a problem logging in to any provider,
+// after downloading from the others. force downloads reports even when a
+// fresh copy is cached.
+func downloadAll(ctx context.Context, force bool) error {
+	now := wallClock.Now()
+	due := make(map[string][]*reportDefinition)
+	for _, r := range reports {
+		if r.due(ctx, now, force) {
+			due[r.Provider] = append(due[r.Provider], r)
+		}
+	}
+
+	var loginErr error
+	var ready []*reportDefinition
+	sessions := make(map[string]session)
+	for name, rs := range due {
+		p := providers[name]
+		if p == nil || !p.configured() {
+			continue
+		}
+
+		if until, open := circuitOpen(name); open {
+			err := errors.New("Logins to " + name + " are paused until " + until.Format(time.Kitchen) + ". See its circuit in /api/status.")
+			logFor(ctx).Println(err)
+			for _, r := range rs {
+				recordAttempt(r.Name, err)
+			}
+			continue
+		}
+
+		loginCtx, span := tracer.Start(ctx, "login "+name)
+		s, err := p.login(loginCtx)
+		endSpan(span, err)
+		recordLogin(name, err)
+		if err != nil {
+			failure := failureKind("login", err)
+			err = errors.New("Failed to log in to " + name + ": " + err.Error())
+			logFor(ctx).Println(err)
+			reportFailure(ctx, "", name, err)
+			for _, r := range rs {
+				recordAttempt(r.Name, err)
+				recordDownload(journalEntry{Time: now, Report: r.Name, Provider: name, Error: err.Error(), Failure: failure, Cycle: cycleID(ctx)})
+			}
+			if loginErr == nil {
+				loginErr = err
+			}
+			continue
+		}
+
+		openSession(s)
+		cancelStaleExports(ctx, s)
+		sessions[name] = s
+		ready = append(ready, rs...)
+	}
+
+	// Reports of the same priority are downloaded at once, and each
+	// priority after those above it. A sync.WaitGroup is used to make sure
+	// they are all finished before the next priority's begin.
+	for _, group := range byPriority(ready) {
+		var wg sync.WaitGroup
+		for _, r := range group {
+			wg.Add(1)
+			go func(r *reportDefinition) {
+				defer wg.Done()
+				defer reportPanics(ctx)
+				r.refresh(ctx, sessions[r.Provider])
+			}(r)
+		}
+		wg.Wait()
+	}
+	endSessions()
+
+	return loginErr
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jfmarket/report-cacher/client"
+	"github.com/jfmarket/report-cacher/report"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// queryCommand() asks a running report-cacher for the rows of a report and
+// prints them, for quick questions from the terminal.
+//     report-cacher query -server=http://host:8085 sold_items -item=Tomatoes -from=2014-03-01 -to=2014-03-31
+// Flags may come before or after the report name.
+func queryCommand(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	server := flags.String("server", "http://localhost:8085", "The address of the report-cacher to query.")
+	login := flags.String("login", "", "The username and password used when the report-cacher requires a login, as username:password.")
+	item := flags.String("item", "", "Only print rows whose item contains this text, ignoring case.")
+	from := flags.String("from", "", "The first day of sales to include, YYYY-MM-DD. Only for sold_items.")
+	to := flags.String("to", "", "The last day of sales to include, YYYY-MM-DD. Only for sold_items.")
+	format := flags.String("format", "table", "How rows are printed: table, csv or json.")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: report-cacher query [flags] <report>")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("A report name is required, such as sold_items.")
+	}
+	name := flags.Arg(0)
+	if err := flags.Parse(flags.Args()[1:]); err != nil {
+		return err
+	}
+	if flags.NArg() > 0 {
+		return errors.New("Unexpected arguments: " + strings.Join(flags.Args(), " "))
+	}
+
+	c := client.New(*server)
+	if *login != "" {
+		username, password := *login, ""
+		if i := strings.Index(*login, ":"); i >= 0 {
+			username, password = (*login)[:i], (*login)[i+1:]
+		}
+		c.SetLogin(username, password)
+	}
+
+	var rows *client.Rows
+	var err error
+	if *from != "" || *to != "" {
+		if name != "sold_items" {
+			return errors.New("-from and -to may only be used with sold_items.")
+		}
+		rows, err = querySoldItems(c, *from, *to)
+	} else {
+		rows, err = c.Rows(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if *item != "" {
+		rows.Rows = filterItems(rows, *item)
+	}
+
+	switch *format {
+	case "table":
+		return printTable(os.Stdout, rows)
+	case "csv":
+		return printCSV(os.Stdout, rows)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rows.Rows)
+	default:
+		return errors.New("Unknown format " + *format + ". Use table, csv or json.")
+	}
+}
+
+// Fetches sold items between the from and to dates. Either may be empty,
+// in which case the report-cacher's default applies: the week ending today.
+func querySoldItems(c *client.Client, from string, to string) (*client.Rows, error) {
+	end := time.Now()
+	if to != "" {
+		var err error
+		if end, err = time.Parse(report.DateLayout, to); err != nil {
+			return nil, errors.New("-to must be a date in the form YYYY-MM-DD")
+		}
+	}
+
+	start := end.AddDate(0, 0, -7)
+	if from != "" {
+		var err error
+		if start, err = time.Parse(report.DateLayout, from); err != nil {
+			return nil, errors.New("-from must be a date in the form YYYY-MM-DD")
+		}
+	}
+
+	return c.SoldItems(start, end)
+}
+
+// Returns the rows whose item contains text, ignoring case.
+func filterItems(rows *client.Rows, text string) []map[string]string {
+	column := ""
+	for _, name := range report.ItemColumns {
+		for _, c := range rows.Columns {
+			if column == "" && strings.EqualFold(c, name) {
+				column = c
+			}
+		}
+	}
+
+	text = strings.ToLower(text)
+	var matched []map[string]string
+	for _, r := range rows.Rows {
+		if strings.Contains(strings.ToLower(r[column]), text) {
+			matched = append(matched, r)
+		}
+	}
+
+	return matched
+}
+
+// Prints rows as aligned columns.
+func printTable(w io.Writer, rows *client.Rows) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(rows.Columns, "\t"))
+	for _, r := range rows.Rows {
+		values := make([]string, len(rows.Columns))
+		for i, c := range rows.Columns {
+			values[i] = r[c]
+		}
+		fmt.Fprintln(tw, strings.Join(values, "\t"))
+	}
+	return tw.Flush()
+}
+
+// Prints rows as a CSV with a header.
+func printCSV(w io.Writer, rows *client.Rows) error {
+	cw := csv.NewWriter(w)
+	cw.Write(rows.Columns)
+	for _, r := range rows.Rows {
+		values := make([]string, len(rows.Columns))
+		for i, c := range rows.Columns {
+			values[i] = r[c]
+		}
+		cw.Write(values)
+	}
+	cw.Flush()
+	return cw.Error()
+}
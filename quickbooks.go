@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/report"
+)
+
+// exportQuickBooks() writes the monthly sales of each department as
+// QuickBooks journal entries to quickbooks/sales_<YYYY-MM>.iif when
+// -quickbooks is set, one file per month so each is imported once.
+// The Sold Items report has no tenders, so every sale is deposited to the
+// one account given by -qbdeposit.
+func exportQuickBooks() error {
+	if !*quickbooks {
+		return nil
+	}
+
+	snapshots, err := soldItemsSnapshots()
+	if err != nil {
+		return err
+	}
+
+	months := make(map[string][]report.Summary)
+	for _, s := range report.Summarize(snapshots, report.Monthly, report.DepartmentColumns) {
+		months[s.Period] = append(months[s.Period], s)
+	}
+
+	for month, summaries := range months {
+		data := report.QuickBooksIIF(summaries, *qbDeposit, *qbIncome)
+		if err := cache.WriteFile("quickbooks/sales_"+month+".iif", data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
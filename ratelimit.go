@@ -0,0 +1,100 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A rateLimiter gives each client a bucket of tokens, refilled at rate per
+// second up to burst, and spends one on each request.
+type rateLimiter struct {
+	sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	swept   time.Time // When full buckets were last forgotten.
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time // When tokens was last refilled.
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket)}
+}
+
+// allow() spends a token of client key's bucket at now. When it is empty it
+// returns false and how long until a token is available.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.sweep(now)
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+
+	b.tokens--
+	return true, 0
+}
+
+// Forgets the buckets that have refilled, at most once a minute, so clients
+// that have gone away don't take up memory. The caller must hold l.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.swept) < time.Minute {
+		return
+	}
+	l.swept = now
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// requestLimiter limits each client's requests by -requestrate, after a
+// burst of -requestburst, or is nil when they are unlimited. The webserver
+// and gRPC share it, so a client's requests over both count together.
+var requestLimiter *rateLimiter
+
+// limitRequests() answers 429 Too Many Requests to clients making requests
+// of h faster than requestLimiter allows, so one polling too often can't
+// slow the server for everyone else. Clients are told by address; see
+// clientIP().
+func limitRequests(h http.Handler) http.Handler {
+	if requestLimiter == nil {
+		return h
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := requestLimiter.allow(clientIP(r), time.Now()); !ok {
+			w.Header().Set("Retry-After", retryAfter(wait))
+			http.Error(w, "Too many requests", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Returns wait in whole seconds, rounded up, as for a Retry-After header.
+func retryAfter(wait time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(wait.Seconds())))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	start := time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+
+	// Each step is a request from key at start+at, with 2 requests a second
+	// allowed after a burst of 3.
+	type step struct {
+		key  string
+		at   time.Duration
+		ok   bool
+		wait time.Duration
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"burst then empty", []step{
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+			{"a", 0, false, 500 * time.Millisecond},
+			{"a", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		}},
+		{"refills at rate", []step{
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+			{"a", 500 * time.Millisecond, true, 0},
+			{"a", 500 * time.Millisecond, false, 500 * time.Millisecond},
+			{"a", time.Second, true, 0},
+		}},
+		{"refills no more than burst", []step{
+			{"a", 0, true, 0},
+			{"a", time.Hour, true, 0},
+			{"a", time.Hour, true, 0},
+			{"a", time.Hour, true, 0},
+			{"a", time.Hour, false, 500 * time.Millisecond},
+		}},
+		{"buckets per client", []step{
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+			{"a", 0, true, 0},
+			{"a", 0, false, 500 * time.Millisecond},
+			{"b", 0, true, 0},
+			{"b", 0, true, 0},
+			{"b", 0, true, 0},
+			{"b", 0, false, 500 * time.Millisecond},
+		}},
+	}
+
+	for _, tt := range tests {
+		l := newRateLimiter(2, 3)
+		for i, s := range tt.steps {
+			ok, wait := l.allow(s.key, start.Add(s.at))
+			if ok != s.ok || wait != s.wait {
+				t.Errorf("%s: request %d from %s at %v returned %v, %v, want %v, %v", tt.name, i, s.key, s.at, ok, wait, s.ok, s.wait)
+			}
+		}
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	start := time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+	l := newRateLimiter(1, 5)
+
+	l.allow("idle", start)
+	l.allow("busy", start.Add(58*time.Second))
+	if len(l.buckets) != 2 {
+		t.Fatalf("%d buckets before a minute passed, want 2", len(l.buckets))
+	}
+
+	// The idle client's bucket refilled after 5s, so it is forgotten when
+	// the buckets are next swept. The busy one's hasn't yet.
+	l.allow("new", start.Add(61*time.Second))
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("Bucket of idle client was kept, want it forgotten")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("Bucket of busy client was forgotten, want it kept")
+	}
+}
+
+func TestLimitRequests(t *testing.T) {
+	old := requestLimiter
+	defer func() { requestLimiter = old }()
+	requestLimiter = newRateLimiter(0.5, 2)
+
+	h := limitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		remote     string
+		want       int
+		retryAfter string
+	}{
+		{"192.0.2.1:1000", http.StatusOK, ""},
+		{"192.0.2.1:1001", http.StatusOK, ""},
+		{"192.0.2.1:1002", http.StatusTooManyRequests, "2"},
+		{"192.0.2.2:1000", http.StatusOK, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/api/reports", nil)
+		r.RemoteAddr = tt.remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.want || w.Header().Get("Retry-After") != tt.retryAfter {
+			t.Errorf("Request from %s answered %d with Retry-After %q, want %d with %q", tt.remote, w.Code, w.Header().Get("Retry-After"), tt.want, tt.retryAfter)
+		}
+	}
+}
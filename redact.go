@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"strings"
+)
+
+// redacted are the columns hidden in every report by -redact.
+var redacted []string
+
+// redactor hides the value of a redacted column, as -redactmode says.
+var redactor = report.Mask
+
+// parseRedaction() reads -redact, a comma separated list of columns, and
+// -redactmode, mask or hash.
+func parseRedaction(columns string, mode string) error {
+	for _, c := range strings.Split(columns, ",") {
+		if c = strings.TrimSpace(c); c != "" {
+			redacted = append(redacted, c)
+		}
+	}
+
+	switch mode {
+	case "mask":
+		redactor = report.Mask
+	case "hash":
+		redactor = report.Hash(*redactSalt)
+	default:
+		return errors.New("Invalid -redactmode " + mode + ". Use mask or hash.")
+	}
+
+	return nil
+}
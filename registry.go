@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/jfmarket/report-cacher/download"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A reportDefinition describes a report the cacher keeps: how it is
+// downloaded, how often, and what is done with it afterwards.
+// Reports are added with registerReport(), generally from an init() next to
+// the functions that download them.
+type reportDefinition struct {
+	Name     string   // Used in file names, the API and events, such as sold_items.
+	Provider string   // The name of the provider the report is downloaded from.
+	Key      []string // The columns identifying a row when comparing versions.
+	Type     string   // The kind of report, such as sales. Reports of a type from several providers are merged. See mergeProviders().
+
+	// Fetch downloads the report using a session with its provider.
+	// start and end are the days it covers, YYYY-MM-DD, or empty if it
+	// does not cover a range of days.
+	Fetch func(ctx context.Context, s session) (data []byte, start string, end string, err error)
+
+	// Source returns the address Fetch downloads the report from using s,
+	// recorded in the report's metadata. It may be nil.
+	Source func(s session) string
+
+	// Dates returns the days the next download will cover, YYYY-MM-DD.
+	// Reports covering a range of days set it so a fresh download of the
+	// same days can be reused instead. See cached().
+	Dates func() (start string, end string)
+
+	// Interval is the least time between downloads. When zero, the report
+	// is downloaded on every update.
+	Interval time.Duration
+
+	// Stale is how old the current copy may be before it is served as
+	// stale. When zero, -stale is used.
+	Stale time.Duration
+
+	// Timeout is how long a download may take before it is abandoned.
+	// When zero, -downloadtimeout is used.
+	Timeout time.Duration
+
+	// PostProcess is run, in order, after each successful download.
+	PostProcess []func() error
+
+	// Priority orders the downloads of an update. Reports with a higher
+	// priority are downloaded first, and those of the same priority at
+	// once. Sales are prioritySales so they are freshest, and slow
+	// inventory exports priorityInventory. -priorities overrides it.
+	Priority int
+}
+
+// The priorities of the reports most and least worth having fresh.
+const (
+	prioritySales     = 10
+	priorityInventory = -10
+)
+
+// reports are the registered reports in the order they were registered.
+var reports []*reportDefinition
+
+// reportNames are the names of the registered reports.
+var reportNames []string
+
+// registerReport() adds a report to those the cacher keeps.
+// It panics if a report of the same name is already registered.
+func registerReport(r reportDefinition) {
+	if lookupReport(r.Name) != nil {
+		panic("report " + r.Name + " registered twice")
+	}
+
+	reports = append(reports, &r)
+	reportNames = append(reportNames, r.Name)
+}
+
+// lookupReport() returns the registered report named name, or nil if there is none.
+func lookupReport(name string) *reportDefinition {
+	for _, r := range reports {
+		if r.Name == name {
+			return r
+		}
+	}
+
+	return nil
+}
+
+// byPriority() groups rs by priority, highest first. Each group keeps the
+// order its reports were registered in.
+func byPriority(rs []*reportDefinition) [][]*reportDefinition {
+	order := make(map[string]int)
+	for i, r := range reports {
+		order[r.Name] = i
+	}
+
+	sorted := append([]*reportDefinition{}, rs...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Priority != sorted[j].Priority {
+			return sorted[i].Priority > sorted[j].Priority
+		}
+		return order[sorted[i].Name] < order[sorted[j].Name]
+	})
+
+	var groups [][]*reportDefinition
+	for i, r := range sorted {
+		if i == 0 || r.Priority != sorted[i-1].Priority {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], r)
+	}
+	return groups
+}
+
+// parsePriorities() sets the priorities of reports from a comma separated
+// list of report=priority, such as sold_items=10,stock_items=-5.
+func parsePriorities(s string) error {
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+
+		i := strings.Index(p, "=")
+		if i < 0 {
+			return errors.New("Invalid priority " + p + ". Use report=priority, such as sold_items=10.")
+		}
+		name := strings.TrimSpace(p[:i])
+		n, err := strconv.Atoi(strings.TrimSpace(p[i+1:]))
+		if err != nil {
+			return errors.New("Invalid priority " + p + ". Use report=priority, such as sold_items=10.")
+		}
+		r := lookupReport(name)
+		if r == nil {
+			return errors.New("Unknown report " + name + " in -priorities. Use one of " + strings.Join(reportNames, ", ") + ".")
+		}
+		r.Priority = n
+	}
+	return nil
+}
+
+// due() reports whether r should be downloaded now, which is when its
+// interval has passed since it was last downloaded and the days it would
+// cover are not already cached. force skips the cache check.
+func (r *reportDefinition) due(ctx context.Context, now time.Time, force bool) bool {
+	due, reason := r.dueReason(now, force)
+	if !due && reason != "" {
+		logFor(ctx).Println("Skipping " + r.Name + ". " + reason)
+	}
+	return due
+}
+
+// dueReason() is due(), also returning why r isn't due when it isn't and
+// that is worth logging.
+func (r *reportDefinition) dueReason(now time.Time, force bool) (bool, string) {
+	versions, err := cache.Versions(r.Name)
+	if err != nil || len(versions) == 0 {
+		return true, ""
+	}
+
+	if r.Interval > 0 && now.Sub(versions[len(versions)-1].Time) < r.Interval {
+		return false, ""
+	}
+
+	if force {
+		return true, ""
+	}
+
+	if v, ok := r.cached(versions, now); ok {
+		return false, "The download of " + v.Start + " to " + v.End + " from " + v.Time.Format(time.Kitchen) + " is still fresh."
+	}
+
+	return true, ""
+}
+
+// cached() returns a version that covers the days r's next download would
+// and is still fresh at now: it was downloaded within -fresh, or after the
+// last of its days had ended so its contents are final.
+func (r *reportDefinition) cached(versions []store.Version, now time.Time) (store.Version, bool) {
+	if r.Dates == nil {
+		return store.Version{}, false
+	}
+
+	start, end := r.Dates()
+	final, err := time.ParseInLocation(report.DateLayout, end, businessZone)
+	if err != nil {
+		return store.Version{}, false
+	}
+	final = final.AddDate(0, 0, 1)
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		v := versions[i]
+		if v.Start != start || v.End != end {
+			continue
+		}
+		if now.Sub(v.Time) < *freshAge || v.Time.After(final) {
+			return v, true
+		}
+	}
+
+	return store.Version{}, false
+}
+
+// refresh() downloads and stores r, then runs its post-processors.
+// Each attempt is recorded in the download journal and traced as a span of
+// ctx's. The report.refreshed event is published last, so subscribers
+// find the new version's hot copy, metadata and processed files. Nothing
+// more is written once another instance has taken the lead.
+func (r *reportDefinition) refresh(ctx context.Context, s session) {
+	logFor(ctx).Println("Downloading " + r.Name)
+	defer beat()
+
+	ctx, span := tracer.Start(ctx, "refresh "+r.Name, trace.WithAttributes(
+		attribute.String("report", r.Name),
+		attribute.String("provider", r.Provider),
+	))
+	var err error
+	defer func() { endSpan(span, err) }()
+
+	began := wallClock.Now()
+	timeout := r.timeout()
+	fetchCtx, cancel := context.WithTimeout(ctx, timeout)
+	fetchCtx, fetchSpan := tracer.Start(fetchCtx, "fetch")
+	data, start, end, err := r.Fetch(fetchCtx, s)
+	if err == nil && download.MaxReportSize > 0 && int64(len(data)) > download.MaxReportSize {
+		data, err = nil, download.ErrTooLarge
+	}
+	if err == download.ErrTooLarge {
+		alert(r.Name, "The download was larger than -maxreportsize="+strconv.Itoa(*maxReportSize)+" MB and was abandoned. Check the days it covers, or whether an error page was sent instead.")
+	}
+	switch {
+	case err == nil:
+	case ctx.Err() == context.DeadlineExceeded:
+		err = errors.New("The update ran out of time. " + err.Error())
+	case fetchCtx.Err() == context.DeadlineExceeded:
+		err = errors.New("Gave up after " + timeout.String() + ". " + err.Error())
+	}
+	cancel()
+	fetchSpan.SetAttributes(attribute.Int("bytes", len(data)))
+	endSpan(fetchSpan, err)
+	entry := journalEntry{
+		Time:     began,
+		Report:   r.Name,
+		Provider: r.Provider,
+		Start:    start,
+		End:      end,
+		Duration: wallClock.Now().Sub(began).Seconds(),
+		Bytes:    int64(len(data)),
+		Cycle:    cycleID(ctx),
+	}
+	if err != nil {
+		entry.Failure = failureKind("fetch", err)
+	}
+	_, normalizeSpan := tracer.Start(ctx, "normalize")
+	if err == nil {
+		err = report.CheckCSV(data)
+	}
+	if err == nil {
+		if data, err = report.Normalize(data); err != nil {
+			err = errors.New("Failed to normalize the CSV. " + err.Error())
+		}
+	}
+	if err == nil {
+		if data, err = transformColumns(r.Name, data); err != nil {
+			err = errors.New("Failed to transform columns. " + err.Error())
+		}
+	}
+	var t *report.Table
+	if err == nil {
+		t, err = validateReport(data)
+	}
+	if t != nil {
+		normalizeSpan.SetAttributes(attribute.Int("rows", len(t.Rows)))
+	}
+	endSpan(normalizeSpan, err)
+	if err != nil {
+		logFor(ctx).Println("Failed to download " + r.Name + ". Error: " + err.Error())
+		reportFailure(ctx, r.Name, r.Provider, err)
+		recordAttempt(r.Name, err)
+		entry.Error = err.Error()
+		if entry.Failure == "" {
+			entry.Failure = failedScrape
+		}
+		recordDownload(entry)
+		return
+	}
+
+	// Leadership may have passed to another instance during the download,
+	// so the fencing token is checked before each write to the cache.
+	if !stillLeader() {
+		logFor(ctx).Println("Not storing " + r.Name + ". " + errNotLeader.Error())
+		err = errNotLeader
+		return
+	}
+	_, writeSpan := tracer.Start(ctx, "write")
+	v, err := cache.Save(r.Name, data, start, end)
+	if err != nil {
+		logFor(ctx).Println("Failed to store " + r.Name + ". Error: " + err.Error())
+		reportFailure(ctx, r.Name, r.Provider, err)
+		entry.Error = err.Error()
+		entry.Failure = failedWrite
+	}
+	recordAttempt(r.Name, err)
+	entry.OK = err == nil
+	recordDownload(entry)
+	if err != nil {
+		endSpan(writeSpan, err)
+		return
+	}
+
+	swapCurrentReport(&hotReport{Version: v, Data: data, Table: t})
+	if !stillLeader() {
+		logFor(ctx).Println("Not finishing " + r.Name + ". " + errNotLeader.Error())
+		err = errNotLeader
+		endSpan(writeSpan, err)
+		return
+	}
+	r.writeMeta(ctx, s, v, len(t.Rows))
+	writeSpan.End()
+
+	if *anomalyFactor > 0 {
+		checkAnomalies(ctx, r, v)
+	}
+
+	_, postSpan := tracer.Start(ctx, "post-process")
+	for _, p := range r.PostProcess {
+		if err := p(); err != nil {
+			logFor(ctx).Println("Failed to process " + r.Name + ". Error: " + err.Error())
+			postSpan.RecordError(err)
+		}
+	}
+	postSpan.End()
+
+	events.publish(event{Type: eventRefreshed, Report: r.Name, Version: &v.Time})
+}
+
+// timeout() returns how long a download of r may take.
+func (r *reportDefinition) timeout() time.Duration {
+	if r.Timeout > 0 {
+		return r.Timeout
+	}
+	return *downloadTimeout
+}
+
+// validateReport() parses a downloaded report, failing if it isn't a CSV
+// with a header, so a broken download never replaces the current copy.
+func validateReport(data []byte) (*report.Table, error) {
+	t, err := report.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(t.Header) == 0 {
+		return nil, errors.New("The report is empty.")
+	}
+	for _, h := range t.Header {
+		if h != "" {
+			return t, nil
+		}
+	}
+	return nil, errors.New("The report has no column names.")
+}
+
+// writeMeta() writes the metadata of version v of r, holding rows rows,
+// next to its files. See store.Meta.
+func (r *reportDefinition) writeMeta(ctx context.Context, s session, v store.Version, rows int) {
+	var source string
+	if r.Source != nil {
+		source = r.Source(s)
+	}
+
+	if err := cache.WriteMeta(v, source, rows); err != nil {
+		logFor(ctx).Println("Failed to write the metadata of " + r.Name + ". Error: " + err.Error())
+	}
+}
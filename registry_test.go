@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"github.com/jfmarket/report-cacher/clock"
+	"github.com/jfmarket/report-cacher/store"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRefreshPublishesLast(t *testing.T) {
+	start := time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	oldCache, oldClock := cache, wallClock
+	defer func() { cache, wallClock = oldCache, oldClock }()
+	wallClock = c
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	cache.SetClock(c)
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	// The post-processor sees no event yet, and the new version served.
+	var processed bool
+	r := &reportDefinition{
+		Name:     "refresh_test",
+		Provider: "test",
+		Fetch: func(context.Context, session) ([]byte, string, string, error) {
+			return []byte("Item,Quantity\nTomatoes," + c.Now().Format("1504") + "\n"), "", "", nil
+		},
+	}
+	r.PostProcess = append(r.PostProcess, func() error {
+		processed = true
+		select {
+		case e := <-ch:
+			t.Errorf("Published %s before post-processing", e.Type)
+		default:
+		}
+		h, err := currentReport(r.Name)
+		if err != nil || h.Table.Rows[0][1] != c.Now().Format("1504") {
+			t.Errorf("Post-processing found %+v, %v, want the new version", h, err)
+		}
+		return nil
+	})
+
+	for i := 0; i < 2; i++ {
+		r.refresh(context.Background(), nil)
+		if !processed {
+			t.Fatal("refresh() didn't post-process the report")
+		}
+
+		select {
+		case e := <-ch:
+			if e.Type != eventRefreshed || e.Report != r.Name || e.Version == nil || !e.Version.Equal(c.Now()) {
+				t.Errorf("Published %+v, want %s of %s downloaded at %v", e, eventRefreshed, r.Name, c.Now())
+			}
+
+			// The message describes the event's version, not whichever
+			// is newest when it is sent.
+			c.Advance(time.Hour)
+			if _, err := cache.Save(r.Name, []byte("Item,Quantity\nHoney,1\n"), "", ""); err != nil {
+				t.Fatal(err)
+			}
+			m, err := newRefreshMessage(e)
+			if err != nil || !m.Version.Equal(*e.Version) {
+				t.Errorf("Refresh message is %+v, %v, want version %v", m, err, *e.Version)
+			}
+		default:
+			t.Fatal("refresh() published nothing")
+		}
+		c.Advance(time.Hour)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jung-kurt/gofpdf"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The printable HTML view of a report.
+var viewTemplate = template.Must(template.New("view").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; font-size: 11pt; }
+table { border-collapse: collapse; width: 100%; }
+th, td { border: 1px solid #999; padding: 2px 6px; }
+th { background: #eee; }
+tr:nth-child(even) td { background: #f7f7f7; }
+.generated { color: #666; font-size: 9pt; }
+@media print { tr { page-break-inside: avoid; } thead { display: table-header-group; } }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="generated">Downloaded {{.Updated}}</p>
+<table>
+<thead><tr>{{range .Table.Header}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Table.Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+// viewHandler() renders the current copy of a report as an HTML table for
+// printing, or as a PDF when format=pdf.
+//     GET /api/reports/sold_items/view?format=pdf
+func viewHandler(w http.ResponseWriter, r *http.Request, name string) {
+	h, err := currentReport(name)
+	if err != nil {
+		http.Error(w, name+" has not been downloaded yet", http.StatusNotFound)
+		return
+	}
+	t := h.Table
+
+	title := reportTitle(name)
+	updated := h.Version.Time.Local().Format("Monday, January 2, 2006 at 3:04 PM")
+
+	switch r.FormValue("format") {
+	case "", "html":
+		err = viewTemplate.Execute(w, struct {
+			Title   string
+			Updated string
+			Table   *report.Table
+		}{title, updated, t})
+	case "pdf":
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", `inline; filename="`+name+`.pdf"`)
+		err = renderPDF(w, title, "Downloaded "+updated, t)
+	default:
+		http.Error(w, "format must be html or pdf", http.StatusBadRequest)
+		return
+	}
+
+	if err != nil {
+		logFor(r.Context()).Println("Failed to render " + name + ". Error: " + err.Error())
+	}
+}
+
+// Writes a report to w as a landscape PDF table. Columns are sized in
+// proportion to their longest value.
+func renderPDF(w http.ResponseWriter, title string, subtitle string, t *report.Table) error {
+	pdf := gofpdf.New("L", "mm", "Letter", "")
+	pdf.SetTitle(title, true)
+	pdf.SetCreationDate(time.Now())
+	pdf.SetAutoPageBreak(true, 10)
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+
+	// Work out the width of each column.
+	pageWidth, _ := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	widths := make([]float64, len(t.Header))
+	total := 0.0
+	for i, h := range t.Header {
+		n := len(h)
+		for _, row := range t.Rows {
+			if i < len(row) && len(row[i]) > n {
+				n = len(row[i])
+			}
+		}
+		if n > 40 {
+			n = 40
+		}
+		widths[i] = float64(n + 2)
+		total += widths[i]
+	}
+	for i := range widths {
+		widths[i] = widths[i] / total * (pageWidth - left - right)
+	}
+
+	header := func() {
+		pdf.SetFont("Helvetica", "B", 8)
+		pdf.SetFillColor(238, 238, 238)
+		for i, h := range t.Header {
+			pdf.CellFormat(widths[i], 6, tr(h), "1", 0, "L", true, 0, "")
+		}
+		pdf.Ln(-1)
+		pdf.SetFont("Helvetica", "", 8)
+	}
+	pdf.SetHeaderFunc(func() {
+		if pdf.PageNo() > 1 {
+			header()
+		}
+	})
+
+	pdf.AddPage()
+	pdf.SetFont("Helvetica", "B", 14)
+	pdf.CellFormat(0, 8, tr(title), "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 9)
+	pdf.CellFormat(0, 6, tr(subtitle), "", 1, "L", false, 0, "")
+	header()
+
+	for _, row := range t.Rows {
+		for i := range t.Header {
+			v := ""
+			if i < len(row) {
+				v = row[i]
+			}
+			pdf.CellFormat(widths[i], 5, tr(v), "1", 0, "L", false, 0, "")
+		}
+		pdf.Ln(-1)
+	}
+
+	return pdf.Output(w)
+}
+
+// Turns a report name such as sold_items into a title such as Sold Items.
+func reportTitle(name string) string {
+	words := strings.Split(name, "_")
+	for i, w := range words {
+		if w != "" {
+			words[i] = strings.ToUpper(w[:1]) + w[1:]
+		}
+	}
+	return strings.Join(words, " ")
+}
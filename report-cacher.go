@@ -2,164 +2,795 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"github.com/jfmarket/report-cacher/database"
 	"github.com/jfmarket/report-cacher/download"
+	"github.com/jfmarket/report-cacher/download/fixture"
+	"github.com/jfmarket/report-cacher/store"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"log"
+	"math/rand"
 	"net/http"
 	"os"
 	"os/signal"
-	"path"
 	"sync"
+	"syscall"
 	"time"
 )
 
 // Define program flags.
 var (
 	interval  = flag.Duration("interval", 6*time.Hour, "The interval at which reports will be retrieved. 30 minutes would be 30m or 0.5h. (Required)")
+	jitter    = flag.Duration("jitter", 0, "Up to how long to randomly delay each scheduled update, so deployments don't all download at the same moment.")
 	site      = flag.String("site", "https://jonesboroughfarmersmkt.shopkeepapp.com", "The address of the ShopKeep site reports will be retrieved from.")
 	email     = flag.String("email", "", "The email used to login. (Required)")
 	password  = flag.String("password", "", "The password used to login. (Required)")
 	directory = flag.String("directory", "files", "The directory where reports will be placed.")
 	port      = flag.Int("port", 8085, "The port the webserver will listen on to serve reports.")
 	noweb     = flag.Bool("noweb", false, "When true, the webserver is disabled.")
+	grpcPort  = flag.Int("grpcport", 0, "The port the ReportCache gRPC service listens on. 0 disables it.")
+	staleAge  = flag.Duration("stale", 0, "How old a report may be before it is served as stale. Defaults to twice -interval.")
+	force     = flag.Bool("force", false, "When true, reports are downloaded even when a fresh download of the same days is cached.")
+	cooldown  = flag.Duration("cooldown", time.Hour, "How long logins to a provider are paused after it rejects the credentials three times in a row.")
+	freshAge  = flag.Duration("fresh", 15*time.Minute, "How long a download stays fresh. A fresh download covering the same days as the next is reused instead of downloading again.")
+	timezone  = flag.String("timezone", "", "The market's timezone, such as America/New_York. Report dates are worked out in it. Defaults to the server's.")
+
+	downloadTimeout = flag.Duration("downloadtimeout", 10*time.Minute, "How long each report's download may take before it is abandoned.")
+	cycleDeadline   = flag.Duration("deadline", 0, "How long an update may take before its unfinished downloads are abandoned, so it ends before the next. Defaults to -interval.")
+
+	marketDays = flag.String("marketdays", "", "A comma separated list of the days the market operates, such as Wed,Sat. Scheduled downloads are skipped on other days. When unset, every day.")
+	blackout   = flag.String("blackout", "", "A comma separated list of times scheduled downloads are deferred through, such as market 08:00-14:00,Sun 10:00-12:00. Prefix a range with market for market days or a weekday.")
+	season     = flag.String("season", "", "The months the market operates, such as May-Oct. Scheduled downloads are skipped out of season. When unset, all year.")
+
+	stableLayout = flag.Bool("stablelayout", false, "When true, files in -directory keep their dates until their contents change, and each version is dated when it was downloaded, so rsync and rclone only copy what changed.")
+
+	maxReportSize = flag.Int("maxreportsize", 100, "The largest a downloaded report may be, in megabytes. Larger downloads are abandoned and an alert sent, such as when a whole history is exported by mistake. 0 is unlimited.")
+
+	minFree = flag.Int("minfree", 200, "The least free space, in megabytes, the volume holding -directory must have for an update to run. Below it updates are skipped and an alert sent. 0 disables the check.")
+
+	debugDir   = flag.String("debugdir", "", "A directory pages ShopKeep sends in place of a report or the page expected are saved to, with tokens and email addresses replaced, to diagnose changes to its site. Empty saves nothing.")
+	debugLimit = flag.Int("debuglimit", 20, "The most pages kept in -debugdir. The oldest are removed to make room.")
+
+	traceHTTP       = flag.Bool("tracehttp", false, "When true, every request to a provider is logged: its method and address, the status, redirect and size of the response, and how long it took. Tokens are replaced.")
+	traceHTTPBodies = flag.Bool("tracehttpbodies", false, "When true with -tracehttp, the start of each web page, form and JSON body is logged too, with passwords, tokens and email addresses replaced.")
+
+	userAgent = flag.String("useragent", "", "The User-Agent sent with every request to ShopKeep, such as a browser's. Empty sends Go's. See also -header.")
+
+	staleExports = flag.Duration("staleexports", 0, "When set, exports ShopKeep has been making for longer, such as 1h, are canceled after each login, since they were most likely left by a run that crashed. 0 leaves them. See the exports command.")
+
+	priorities = flag.String("priorities", "", "A comma separated list of report=priority, such as sold_items=10,stock_items=-5. Reports with higher priorities are downloaded first each update. Sales default to 10, inventory to -10 and the rest to 0.")
+
+	logOutput = flag.String("log", "stderr", "Where log lines are written: stderr, syslog, or journald when run by systemd. Syslog and journald are given each line's priority.")
+
+	sentryDSN         = flag.String("sentrydsn", "", "The DSN of a Sentry project panics and failed downloads are reported to, without passwords or tokens.")
+	sentryEnvironment = flag.String("sentryenv", "production", "The environment reported to Sentry, to tell test deployments apart.")
+
+	otlpEndpoint   = flag.String("otlp", "", "The address of an OpenTelemetry collector spans of each update and download are sent to over OTLP/HTTP, such as http://localhost:4318.")
+	rateLimit      = flag.Int("ratelimit", 0, "The most kilobytes per second downloads may use between them, to leave the internet connection free for others. 0 is unlimited.")
+	leaderLock     = flag.String("leaderlock", "", "A file on storage shared by replicas of the report-cacher. The replica holding it downloads reports while every replica serves them.")
+	leaderTTL      = flag.Duration("leaderttl", 30*time.Second, "Up to how long after the leader stops another replica takes over, as replicas try to take -leaderlock every third of it.")
+	pidFile        = flag.String("pidfile", "", "A file the process ID is written to while the report-cacher runs.")
+	encryptKeyFile = flag.String("encryptkey", "", "A file holding the 64 hex character key reports are encrypted with on disk. REPORT_CACHER_KEY may hold the key instead. Keep it outside -directory.")
+
+	shopkeepToken  = flag.String("shopkeeptoken", "", "A ShopKeep API token. When set, reports are downloaded through ShopKeep's API instead of the BackOffice website.")
+	shopkeepMode   = flag.String("shopkeepmode", "auto", "How ShopKeep reports are downloaded: api, scrape, or auto to use the API when -shopkeeptoken works and the website otherwise.")
+	shopkeepGroups = flag.String("shopkeepgroups", "", "A comma separated list of the groupings the Sold Items report is also kept in, such as department,supplier. Each is kept as sold_items_by_department and so on.")
+
+	squareToken     = flag.String("squaretoken", "", "A Square access token. When set, orders, payments and the catalog are also downloaded from Square.")
+	squareLocations = flag.String("squarelocations", "", "A comma separated list of the Square location IDs to report on. When unset, every location is used.")
+	squareSite      = flag.String("squaresite", "", "The address of Square's API. Defaults to production; use https://connect.squareupsandbox.com for testing.")
+
+	cloverToken     = flag.String("clovertoken", "", "A Clover API token. When set with -clovermerchant, sales and inventory are also downloaded from Clover.")
+	cloverMerchant  = flag.String("clovermerchant", "", "The Clover merchant ID -clovertoken belongs to.")
+	cloverClientID  = flag.String("cloverclientid", "", "A Clover app ID. When set, visit /oauth/clover to connect a merchant instead of using -clovertoken.")
+	cloverSecret    = flag.String("cloversecret", "", "The Clover app secret.")
+	cloverTokenFile = flag.String("clovertokenfile", "clover_token.json", "Where the token granted through /oauth/clover is kept. Keep it outside -directory.")
+	cloverSite      = flag.String("cloversite", "", "The address of Clover's API. Defaults to production; use https://apisandbox.dev.clover.com for testing.")
+	cloverAuthSite  = flag.String("cloverauthsite", "", "The address of Clover's OAuth pages. Defaults to production; use https://sandbox.dev.clover.com for testing.")
+
+	vendorsFile = flag.String("vendors", "", "A CSV mapping items and departments to the vendors they are sold for. When set, per-vendor sales and payouts are generated.")
+	commission  = flag.Float64("commission", 0, "The percentage of vendor sales the market keeps as commission.")
+
+	columnsFile = flag.String("columns", "", "A CSV of columns dropped or renamed from reports before they are stored, such as to remove customers' contact details.")
+	redact      = flag.String("redact", "", "A comma separated list of columns hidden in every report before it is stored, such as Customer Name,Email,Card Last 4. Use when vendors can log in.")
+	redactMode  = flag.String("redactmode", "mask", "How -redact hides values: mask replaces them with REDACTED, hash with a short hash so rows of the same customer can still be matched.")
+	redactSalt  = flag.String("redactsalt", "", "A secret mixed into -redactmode=hash hashes so values can't be guessed from them.")
+
+	quickbooks = flag.Bool("quickbooks", false, "When true, monthly department sales are written as QuickBooks IIF files to the quickbooks directory.")
+	qbDeposit  = flag.String("qbdeposit", "Undeposited Funds", "The QuickBooks account sales are deposited to.")
+	qbIncome   = flag.String("qbincome", "Sales", "The QuickBooks income account sales are credited to. Each department is a subaccount of it.")
+
+	usersFile    = flag.String("users", "", "A CSV of users who may log in to the webserver. Vendors only see their own sales. When unset, no login is required.")
+	auditFile    = flag.String("audit", "audit.jsonl", "Where requests for report data are logged, with who made them and when. Keep it outside -directory. Empty disables the log.")
+	hashpassword = flag.String("hashpassword", "", "Print the hash of the given password for use in the users file and exit.")
+	showVersion  = flag.Bool("version", false, "Print the version of the report-cacher and exit.")
+	dryRun       = flag.Bool("dryrun", false, "Print which reports an update would download now, covering which days and stored where, then exit without writing anything.")
+	dryRunLogin  = flag.Bool("dryrunlogin", false, "With -dryrun, also log in to each provider reports would be downloaded from, to check the credentials.")
+	output       = flag.String("output", "text", "How the fetch command reports what became of each report: text, or json for scripts.")
+
+	oidcIssuer   = flag.String("oidcissuer", "", "An OpenID Connect issuer users log in to the webserver through, such as https://accounts.google.com. Requires -oidcclientid and -oidcsecret.")
+	oidcClientID = flag.String("oidcclientid", "", "The client ID of the report-cacher at -oidcissuer.")
+	oidcSecret   = flag.String("oidcsecret", "", "The client secret of the report-cacher at -oidcissuer.")
+	oidcDomain   = flag.String("oidcdomain", "", "A Google Workspace domain, such as jfmarket.org, whose accounts may log in through -oidcissuer as administrators. Others must be in -users, named by email address.")
+
+	requestRate  = flag.Float64("requestrate", 0, "The most requests per second each client may make of the webserver, on average. Faster clients are answered 429 Too Many Requests. 0 is unlimited.")
+	requestBurst = flag.Int("requestburst", 20, "How many requests a client may make at once before -requestrate applies.")
+
+	allowFrom = flag.String("allow", "", "A comma separated list of the CIDR ranges and addresses the webserver and gRPC service answer, such as 192.168.1.0/24,10.8.0.0/16. When unset, every address.")
+	denyFrom  = flag.String("deny", "", "A comma separated list of CIDR ranges and addresses the webserver and gRPC service never answer, even when in -allow.")
+
+	proxies = flag.String("trustedproxies", "", "A comma separated list of the CIDR ranges and addresses of proxies, such as nginx, whose X-Forwarded-For header gives the client's address for logs, -requestrate and -allow.")
+
+	mailFile     = flag.String("mail", "", "A CSV of reports to email on a schedule. Requires -smtp and -mailfrom.")
+	smtpServer   = flag.String("smtp", "", "The SMTP server used to send email, host:port.")
+	smtpUser     = flag.String("smtpuser", "", "The username used to authenticate with the SMTP server, if it requires one.")
+	smtpPassword = flag.String("smtppassword", "", "The password used to authenticate with the SMTP server.")
+	mailFrom     = flag.String("mailfrom", "", "The address emails are sent from.")
+
+	alertTo       = flag.String("alertto", "", "A comma separated list of addresses emailed when a report's contents look wrong. Requires -smtp and -mailfrom.")
+	anomalyFactor = flag.Float64("anomalyfactor", 10, "How many times higher or lower than recent versions a report's row count or total sales must be to raise an alert. 0 disables checks.")
+
+	sqliteFile = flag.String("sqlite", "", "A SQLite database every report version is loaded into. When set, POST /api/query answers SQL queries.")
+	analytics  = flag.String("analytics", "go", "The engine that computes stats: go, or duckdb when built with -tags duckdb.")
+
+	exportDB     = flag.String("exportdb", "", "The type of an external database reports are upserted into after each update: postgres or mysql. Requires -exportdsn.")
+	exportDSN    = flag.String("exportdsn", "", "The data source name used to connect to the export database.")
+	exportTables = flag.String("exporttables", "", "Maps reports to export tables, such as sold_items=pos_sold_items,stock_items=pos_stock. Unmapped reports use their own names.")
+
+	archive       = flag.Bool("archive", false, "When true, each month's report versions are bundled into archive/2014-03.zip in -directory a week after the month ends.")
+	archiveUpload = flag.String("archiveupload", "", "An address each monthly archive is uploaded to with PUT, such as a WebDAV folder. Credentials may be given in it. Requires -archive.")
+	compressAfter = flag.Int("compressafter", 0, "How many days old report versions are gzipped in place to save space. The newest version of each report never is. 0 never compresses them.")
+	archiveAfter  = flag.Int("archiveafter", 0, "How many days old the last version of a month that has closed must be for the month's versions to be moved into its archive, archive/2014-03.zip, and no longer served. 0 keeps them.")
+
+	kafkaBrokers = flag.String("kafka", "", "A comma separated list of Kafka brokers, host:port, notified each time a report is refreshed.")
+	kafkaTopic   = flag.String("kafkatopic", "report-cacher", "The Kafka topic refresh notifications are written to.")
+	natsURL      = flag.String("nats", "", "The address of a NATS server notified each time a report is refreshed, such as nats://localhost:4222.")
+	natsSubject  = flag.String("natssubject", "report-cacher.refreshed", "The NATS subject refresh notifications are published under. The report name is appended.")
+	mqttBroker   = flag.String("mqtt", "", "The address of an MQTT broker sent refresh notifications and sales totals, such as tcp://localhost:1883.")
+	mqttTopic    = flag.String("mqtttopic", "report-cacher", "The MQTT topic messages are published under.")
+	mqttUser     = flag.String("mqttuser", "", "The username used to authenticate with the MQTT broker, if it requires one.")
+	mqttPassword = flag.String("mqttpassword", "", "The password used to authenticate with the MQTT broker.")
+	hook         = flag.String("hook", "", "A shell command run each time a report is refreshed. The report is described by REPORT_* environment variables.")
+
+	record = flag.String("record", "", "A directory every response downloaded is saved to, sanitized, as fixtures for -replay.")
+	replay = flag.String("replay", "", "A directory of responses saved by -record that answer every download instead of the network.")
 )
 
+// hiddenFlags are left out of -help. They are for working on the
+// report-cacher rather than running it. See "Recording fixtures" in the Readme.
+var hiddenFlags = map[string]bool{"record": true, "replay": true}
+
+// usage() prints the flags that aren't hidden, as flag.PrintDefaults() would.
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	shown := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	shown.SetOutput(flag.CommandLine.Output())
+	flag.VisitAll(func(f *flag.Flag) {
+		if !hiddenFlags[f.Name] {
+			shown.Var(f.Value, f.Name, f.Usage)
+		}
+	})
+	shown.PrintDefaults()
+}
+
+// cache holds every downloaded report and its previous versions.
+var cache *store.Store
+
+// commands are run instead of the report-cacher when named as the first argument.
+//     report-cacher query -server=http://localhost:8085 sold_items
+var commands = map[string]func(args []string) error{
+	"query":        queryCommand,
+	"fetch":        fetchCommand,
+	"service":      serviceCommand,
+	"backup":       backupCommand,
+	"restore":      restoreCommand,
+	"check-config": checkConfigCommand,
+	"import":       importCommand,
+	"exports":      exportsCommand,
+	"schedule":     scheduleCommand,
+}
+
 func main() {
+	prepareService()
+
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			if err := command(os.Args[2:]); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				if e, ok := err.(exitCodeError); ok {
+					os.Exit(e.code)
+				}
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	// Parse and verify required options are set.
+	flag.Usage = usage
 	flag.Parse()
 
-	if *email == "" {
-		log.Fatalln("An email is required. -email='x@yz.com'")
+	if err := setupLogOutput(*logOutput); err != nil {
+		log.Fatalln(err)
 	}
 
-	if *password == "" {
-		log.Fatalln("A password is required. -password=mypassword")
+	if *showVersion {
+		fmt.Println(currentBuild())
+		return
 	}
 
-	ensureDirectoryExists(*directory)
+	if *hashpassword != "" {
+		hash, err := hashPassword(*hashpassword)
+		if err != nil {
+			log.Fatalln("Failed to hash password. " + err.Error())
+		}
+		fmt.Println(hash)
+		return
+	}
 
-	log.Println("Starting...")
+	if *dryRun {
+		if err := planUpdate(); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	setup()
+
+	if *usersFile != "" {
+		if err := loadUsers(*usersFile); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if *oidcIssuer != "" {
+		if *oidcClientID == "" || *oidcSecret == "" {
+			log.Fatalln("Logging in through -oidcissuer requires -oidcclientid and -oidcsecret.")
+		}
+		if err := setupOIDC(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var err error
+	if allowedNetworks, err = parseNetworks(*allowFrom); err != nil {
+		log.Fatalln("Failed to read -allow. " + err.Error())
+	}
+	if deniedNetworks, err = parseNetworks(*denyFrom); err != nil {
+		log.Fatalln("Failed to read -deny. " + err.Error())
+	}
+	if trustedProxies, err = parseNetworks(*proxies); err != nil {
+		log.Fatalln("Failed to read -trustedproxies. " + err.Error())
+	}
+	if *requestRate > 0 {
+		requestLimiter = newRateLimiter(*requestRate, *requestBurst)
+	}
+
+	log.Println("Starting " + currentBuild().String() + "...")
 	log.Println("Reports will be stored in: " + *directory)
 
 	done := make(chan bool)
 
+	// Only the leader downloads when replicas share the cache.
+	if *leaderLock != "" {
+		elect()
+		go leaderManager(done)
+	}
+
 	// Update on the interval specified on the command line.
 	// close()ing the done channel stops the download manager.
 	go downloadManager(*interval, done)
 
+	// Email reports on their schedules.
+	if *mailFile != "" {
+		if *smtpServer == "" || *mailFrom == "" {
+			log.Fatalln("Emailing reports requires -smtp and -mailfrom.")
+		}
+
+		deliveries, err := loadDeliveries(*mailFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		go mailManager(deliveries, done)
+	}
+
+	// Notify Kafka, NATS, MQTT and the hook of refreshed reports.
+	var notifiers []notifier
+	if *kafkaBrokers != "" {
+		notifiers = append(notifiers, newKafkaNotifier(*kafkaBrokers, *kafkaTopic))
+	}
+	if *natsURL != "" {
+		n, err := newNATSNotifier(*natsURL, *natsSubject)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		notifiers = append(notifiers, n)
+	}
+	if *mqttBroker != "" {
+		n, err := newMQTTNotifier(*mqttBroker, *mqttTopic, *mqttUser, *mqttPassword)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		notifiers = append(notifiers, n)
+	}
+	if *hook != "" {
+		notifiers = append(notifiers, hookNotifier{command: *hook})
+	}
+	if len(notifiers) > 0 {
+		go notifyManager(notifiers, done)
+	}
+
 	// Gracefully handle Ctrl-C
 	catchCtrlC(done)
 
 	if !*noweb {
 		// launch webserver. goroutine for now.
+		// Use the socket systemd passed, if it started the report-cacher.
+		l, err := activatedListener()
+		if err != nil {
+			log.Fatalln("Failed to use the socket passed by systemd. " + err.Error())
+		}
+
 		go func() {
-			log.Printf("Listenting on port %[1]d. Visit http://localhost:%[1]d in your browser.", *port)
-			err := http.ListenAndServe(fmt.Sprintf(":%d", *port), http.FileServer(http.Dir(*directory)))
+			if l != nil {
+				log.Println("Listening on the socket passed by systemd.")
+				err = http.Serve(l, newServer())
+			} else {
+				log.Printf("Listenting on port %[1]d. Visit http://localhost:%[1]d in your browser.", *port)
+				err = http.ListenAndServe(fmt.Sprintf(":%d", *port), newServer())
+			}
 			if err != nil {
 				log.Fatalln("ListenAndServe: ", err)
 			}
 		}()
 	}
 
+	if *grpcPort != 0 {
+		go func() {
+			if err := serveRPC(*grpcPort); err != nil {
+				log.Fatalln("gRPC: ", err)
+			}
+		}()
+	}
+
 	// Limit the downloadManager to 3 minutes to avoid
 	// bugging ShopKeep
 	// time.Sleep(3 * time.Minute)
 	// close(done)
 
+	// Tell systemd the report-cacher is ready and ping its watchdog.
+	sdNotify("READY=1")
+	go watchdog(done)
+
+	// Run until the Windows service is stopped.
+	if inService() {
+		err := runService(done)
+		removePIDFile()
+		if err != nil {
+			log.Fatalln("Service: ", err)
+		}
+		return
+	}
+
 	// Run until ctrl-c
 	<-make(chan bool)
 }
 
+// setup() checks the options and opens the cache, analytics engine and
+// databases the reports are kept in.
+func setup() {
+	checkOptions()
+	openCache()
+}
+
+// checkOptions() exits if an option is missing or wrong, and otherwise
+// applies those that need no files, such as -timezone.
+func checkOptions() {
+	requireCredentials()
+	applyOptions()
+}
+
+// requireCredentials() exits unless some provider has the credentials it
+// needs to log in.
+func requireCredentials() {
+	// ShopKeep is required unless reports come from another provider.
+	otherProviders := *squareToken != "" || *cloverToken != "" || *cloverClientID != ""
+	if *shopkeepToken == "" && (*email != "" || *password != "" || !otherProviders) {
+		if *email == "" {
+			log.Fatalln("An email is required. -email='x@yz.com'")
+		}
+
+		if *password == "" {
+			log.Fatalln("A password is required. -password=mypassword")
+		}
+	}
+}
+
+// applyOptions() exits if an option is wrong, and otherwise applies those
+// that need no files, such as -timezone.
+func applyOptions() {
+	if *timezone != "" {
+		zone, err := time.LoadLocation(*timezone)
+		if err != nil {
+			log.Fatalln("Unknown timezone " + *timezone + ". " + err.Error())
+		}
+		businessZone = zone
+	}
+
+	if err := parseMarketDays(*marketDays, *season); err != nil {
+		log.Fatalln(err)
+	}
+	if err := parseBlackouts(*blackout); err != nil {
+		log.Fatalln(err)
+	}
+	download.MaxReportSize = int64(*maxReportSize) << 20
+	download.DebugDir, download.DebugLimit = *debugDir, *debugLimit
+	if err := applyHeaders(); err != nil {
+		log.Fatalln(err)
+	}
+	if err := registerGroupedSoldItems(*shopkeepGroups); err != nil {
+		log.Fatalln(err)
+	}
+	if err := parsePriorities(*priorities); err != nil {
+		log.Fatalln(err)
+	}
+	if *columnsFile != "" {
+		if err := loadColumns(*columnsFile); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := parseRedaction(*redact, *redactMode); err != nil {
+		log.Fatalln(err)
+	}
+
+	if *replay != "" {
+		http.DefaultTransport = &fixture.Replayer{Dir: *replay}
+	} else if *record != "" {
+		http.DefaultTransport = &fixture.Recorder{Base: http.DefaultTransport, Dir: *record}
+	}
+	if *traceHTTP {
+		logRequests(*traceHTTPBodies)
+	}
+	if *rateLimit > 0 {
+		throttleDownloads(*rateLimit)
+	}
+	if os.Getenv("WATCHDOG_USEC") != "" {
+		beatWhileDownloading()
+	}
+	if *otlpEndpoint != "" {
+		if err := setupTracing(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *sentryDSN != "" {
+		if err := setupSentry(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// openCache() takes the cache directory, creating it if needed, and opens
+// the cache, analytics engine and databases.
+func openCache() {
+	ensureDirectoryExists(*directory)
+
+	// Replicas sharing the directory elect a leader instead.
+	if *leaderLock == "" {
+		if err := lockCache(*directory); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if err := writePIDFile(); err != nil {
+		log.Fatalln(err)
+	}
+
+	var err error
+	cache, err = store.New(*directory)
+	if err != nil {
+		log.Fatalln("Failed to open the report cache. " + err.Error())
+	}
+
+	cache.SetStable(*stableLayout)
+	cache.SetClock(wallClock)
+
+	key, err := encryptionKey()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if key != nil {
+		if err := cache.SetKey(key); err != nil {
+			log.Fatalln(err)
+		}
+		if err := encryptedConflict(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	newAnalyzer, ok := analyticsEngines[*analytics]
+	if !ok {
+		log.Fatalln("Unknown analytics engine " + *analytics + ". Was report-cacher built with -tags " + *analytics + "?")
+	}
+	analyzer, err = newAnalyzer()
+	if err != nil {
+		log.Fatalln("Failed to start the " + *analytics + " analytics engine. " + err.Error())
+	}
+
+	if *sqliteFile != "" {
+		db, err = database.Open(*sqliteFile)
+		if err != nil {
+			log.Fatalln("Failed to open the SQLite database. " + err.Error())
+		}
+	}
+
+	if *exportDB != "" {
+		tables, err := parseExportTables(*exportTables)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		exporter, err = database.NewExporter(*exportDB, *exportDSN, tables)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
 // downloadManager() is responsible for refreshing reports at the given interval.
-// It can be stopped by close()ing the done channel.
+// Reports are downloaded when it starts, and an update missed while the
+// machine slept runs when it wakes. Refresh jobs run in between, one at a
+// time. See requestRefresh(). It can be stopped by close()ing the done
+// channel.
 //     go downloadManager(1*time.Hour, done)
 func downloadManager(updateInterval time.Duration, done <-chan bool) {
 	log.Println("Update interval is: " + updateInterval.String())
 
 	// Perform initial download when downloadManager starts.
-	update()
+	runUpdate(*force)
 
-	// Perform updates at the given interval
+	// Beat while idle so the systemd watchdog knows the scheduler is alive.
+	alive := wallClock.NewTicker(heartbeatInterval)
+	defer alive.Stop()
+
+	// Perform updates at the given interval, or when a refresh is requested.
+	wait := jittered(updateInterval)
+	next := wallClock.NewTimer(wait)
+	defer next.Stop()
+	nextAt := wallClock.Now().Add(wait).Round(0)
+	setNextUpdate(nextAt)
 	for {
+		scheduled := false
 		select {
-		case <-time.Tick(updateInterval):
-			update()
+		case <-alive.C():
+			beat()
+
+			// Timers pause while the machine sleeps, so check the clock to
+			// catch up on an update missed while asleep.
+			if !wallClock.Now().Round(0).After(nextAt.Add(time.Minute)) {
+				continue
+			}
+			log.Println("Catching up on the update missed at " + nextAt.Format(time.Kitchen) + ".")
+			scheduled = true
+		case <-next.C():
+			scheduled = true
+		case j := <-refresh:
+			// Jobs run in between scheduled updates without moving them,
+			// or refreshing often would put them off forever.
+			runJob(j)
+			beat()
+			continue
 		case <-done:
 			log.Println("Stopping...")
 			return
 		}
+
+		if scheduled {
+			if until, ok := blackoutUntil(businessNow()); ok {
+				log.Println("Deferring scheduled update until the blackout ends at " + until.Format("15:04") + ".")
+				next.Reset(until.Sub(wallClock.Now()))
+				nextAt = until.Round(0)
+				setNextUpdate(until)
+				continue
+			}
+
+			if isPaused() {
+				log.Println("Skipping scheduled update while paused.")
+			} else if !marketActive(businessNow()) {
+				log.Println("Skipping scheduled update while the market is closed.")
+			} else {
+				runUpdate(*force)
+			}
+		}
+
+		beat()
+		wait = jittered(updateInterval)
+		next.Reset(wait)
+		nextAt = wallClock.Now().Add(wait).Round(0)
+		setNextUpdate(nextAt)
 	}
 }
 
-// downloadAll() orchestrates downloading all known reports concurrently.
-// It returns an error if there is a problem logging in.
-func downloadAll() error {
-	downloader, err := download.New(*site, *email, *password)
-	if err != nil {
-		return errors.New("Failed to initialize downloader: " + err.Error())
+// jittered() adds a random delay of up to -jitter to d, so deployments
+// started together don't all download at the same moment.
+func jittered(d time.Duration) time.Duration {
+	if *jitter <= 0 {
+		return d
 	}
+	return d + time.Duration(rand.Int63n(int64(*jitter)))
+}
 
-	var wg sync.WaitGroup
+// Records when the next scheduled update will happen.
+func setNextUpdate(t time.Time) {
+	status.Lock()
+	status.nextUpdate = t
+	status.Unlock()
+}
 
-	// Store download functions in a slice to simplify concurrent downloading.
-	downloadFunctions := []func(*download.Downloader){
-		downloadSoldItemsReport,
-		downloadStockItemsReport,
-	}
+// running is the update in progress, if there is one, so shutting down can
+// cancel it and wait for it to finish. See stopUpdates().
+var running struct {
+	sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	stopped bool // No more updates may start.
+}
 
-	// Call each download function concurrently.
-	// A sync.WaitGroup is used to make sure the function does not return
-	// until all downloads are finished.
-	for _, df := range downloadFunctions {
-		wg.Add(1)
-		go func(f func(*download.Downloader)) {
-			defer wg.Done()
-			f(downloader)
-		}(df)
+// Records that an update canceled by cancel is starting, returning a func
+// to call when it has finished, or false if updates have been stopped.
+func startUpdate(cancel context.CancelFunc) (func(), bool) {
+	running.Lock()
+	defer running.Unlock()
+	if running.stopped {
+		return nil, false
 	}
 
-	wg.Wait()
+	done := make(chan struct{})
+	running.cancel, running.done = cancel, done
+	return func() {
+		running.Lock()
+		running.cancel, running.done = nil, nil
+		running.Unlock()
+		close(done)
+	}, true
+}
 
-	return nil
+// stopUpdates() keeps further updates from starting and cancels the one in
+// progress, waiting up to wait for it to return. It reports whether no
+// update is still running, so sessions can be logged out of safely.
+func stopUpdates(wait time.Duration) bool {
+	running.Lock()
+	running.stopped = true
+	cancel, done := running.cancel, running.done
+	running.Unlock()
+	if cancel == nil {
+		return true
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return true
+	case <-time.After(wait):
+		return false
+	}
 }
 
-// Run downloadAll() and handle error
-func update() {
-	log.Println("Updating...")
-	err := downloadAll()
+// runUpdate is the update the download manager and refresh jobs run, so
+// tests can watch the scheduler without downloading.
+var runUpdate = update
+
+// Errors update() returns when it skips the update, downloading nothing.
+var (
+	errNotLeader = errors.New("Another instance is the leader.")
+	errStopping  = errors.New("The report-cacher is shutting down.")
+)
+
+// Run downloadAll() and handle error. The error is logged and returned.
+// It is errNotLeader or errStopping when the update is skipped.
+func update(force bool) error {
+	if !isLeader() {
+		log.Println("Skipping update. " + errNotLeader.Error())
+		return errNotLeader
+	}
+
+	unlock, err := lockWrites(cache.Dir())
 	if err != nil {
-		log.Fatalln(err)
+		log.Println("Update failed. Error: " + err.Error())
+		return err
 	}
-	log.Println("Reports updated.")
-}
+	defer unlock()
 
-// downloadSoldItemsReport() downloads the Sold Items report for the past week.
-// This may need to be adjusted for more configurability.
-func downloadSoldItemsReport(d *download.Downloader) {
-	log.Println("Inside downloadSoldItemsReport")
+	// Leadership may have passed to another instance while waiting.
+	if !stillLeader() {
+		log.Println("Skipping update. " + errNotLeader.Error())
+		return errNotLeader
+	}
 
-	// Calculate and format the date a week ago and today.
-	const timeLayout = "2006-01-02"
-	t := time.Now()
-	today := t.Format(timeLayout)
-	aWeekAgo := t.AddDate(0, 0, -7).Format(timeLayout)
+	ctx, cancel := context.WithTimeout(context.Background(), cycleBudget())
+	defer cancel()
+	finished, ok := startUpdate(cancel)
+	if !ok {
+		log.Println("Skipping update while shutting down.")
+		return errStopping
+	}
+	defer finished()
+	ctx, cycle := withCycle(ctx)
+	defer reportPanics(ctx)
+	logFor(ctx).Println("Updating...")
+	if err := checkDiskSpace(ctx); err != nil {
+		logFor(ctx).Println("Skipping update. " + err.Error())
+		return err
+	}
+	status.Lock()
+	status.updating = true
+	status.Unlock()
+	events.publish(event{Type: eventUpdateStarted, Cycle: cycle})
 
-	err := d.GetSoldItemsReport(path.Join(*directory, "sold_items.csv"), aWeekAgo, today)
+	ctx, span := tracer.Start(ctx, "update", trace.WithAttributes(
+		attribute.Bool("force", force),
+		attribute.String("cycle", cycle),
+	))
+	defer span.End()
+
+	err = downloadAll(ctx, force)
 	if err != nil {
-		log.Println("Failed to download sold items report. Error: " + err.Error())
+		span.SetStatus(codes.Error, err.Error())
 	}
-}
 
-// downloadStockItemsReport() downloads the Stock Items report.
-func downloadStockItemsReport(d *download.Downloader) {
-	log.Println("Inside downloadStockItemsReport")
+	status.Lock()
+	status.updating = false
+	status.lastUpdate = wallClock.Now()
+	status.Unlock()
 
-	err := d.GetStockItemsReport(path.Join(*directory, "stock_items.csv"))
 	if err != nil {
-		log.Println("Failed to download stock items report. Error: " + err.Error())
+		events.publish(event{Type: eventUpdateFinished, Cycle: cycle, Error: err.Error()})
+		logFor(ctx).Println("Update failed. Error: " + err.Error())
+	} else {
+		events.publish(event{Type: eventUpdateFinished, Cycle: cycle})
+		logFor(ctx).Println("Reports updated.")
+	}
+
+	if db != nil {
+		if err := loadDatabase(); err != nil {
+			logFor(ctx).Println("Failed to load reports into the database. Error: " + err.Error())
+		}
+	}
+
+	if exporter != nil {
+		if err := exportReports(); err != nil {
+			logFor(ctx).Println("Failed to export reports. Error: " + err.Error())
+		}
+	}
+
+	if err := mergeProviders(); err != nil {
+		logFor(ctx).Println("Failed to merge reports across providers. Error: " + err.Error())
+	}
+
+	if *archive {
+		if err := archiveMonths(); err != nil {
+			logFor(ctx).Println("Failed to archive reports. Error: " + err.Error())
+		}
+	}
+
+	if *compressAfter > 0 || *archiveAfter > 0 {
+		if err := applyRetention(); err != nil {
+			logFor(ctx).Println("Failed to trim old versions. Error: " + err.Error())
+		}
 	}
+
+	return err
+}
+
+// cycleBudget() returns how long an update may take: -deadline, or else
+// -interval, so it is over before the next scheduled update.
+func cycleBudget() time.Duration {
+	if *cycleDeadline > 0 {
+		return *cycleDeadline
+	}
+	return *interval
 }
 
 // If the given directory structure does not exist,
@@ -182,10 +813,21 @@ func ensureDirectoryExists(d string) {
 // Catches Ctrl-C and cleans up
 func catchCtrlC(done chan bool) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
+		sdNotify("STOPPING=1")
 		close(done)
+		removePIDFile()
+		// Logging out while downloads are using a session would pull it
+		// out from under them.
+		if stopUpdates(5 * time.Second) {
+			endSessions()
+		} else {
+			log.Println("Not logging out, as downloads are still running.")
+		}
+		flushTracing()
+		flushSentry()
 		time.Sleep(8 * time.Second)
 		os.Exit(1)
 	}()
@@ -0,0 +1,196 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The layout of the dates bounding a report.
+const DateLayout = "2006-01-02"
+
+// A Snapshot is a downloaded Sold Items report covering Start through End.
+type Snapshot struct {
+	Start   time.Time   // The first day covered.
+	End     time.Time   // The last day covered.
+	Fetched time.Time   // When the report was downloaded.
+	Days    []time.Time // The days of the range counted by Summarize(), as picked by Tile(). Every day when empty.
+	Table   *Table
+}
+
+// A Summary holds the sales of one group (an item or department) in one period.
+type Summary struct {
+	Period   string  `json:"period,omitempty"`
+	Group    string  `json:"name"`
+	Quantity float64 `json:"quantity"`
+	Sales    float64 `json:"sales"`
+}
+
+// Daily() names the day t, such as 2014-03-05.
+func Daily(t time.Time) string {
+	return t.Format(DateLayout)
+}
+
+// Weekly() names the week containing t by the Monday it begins on, such as
+// 2014-03-03.
+func Weekly(t time.Time) string {
+	return t.AddDate(0, 0, -(int(t.Weekday())+6)%7).Format(DateLayout)
+}
+
+// Monthly() names the month containing t, such as 2014-03.
+func Monthly(t time.Time) string {
+	return t.Format("2006-01")
+}
+
+// Yearly() names the year containing t, such as 2014.
+func Yearly(t time.Time) string {
+	return t.Format("2006")
+}
+
+// Tile() picks which snapshot each day is counted from so that no day is
+// counted twice. A day is taken from the newest snapshot covering it that
+// was downloaded after the day ended, in loc, the market's timezone, so it
+// holds the day's final totals. Days that haven't ended since any download
+// are taken from the newest snapshot covering them. Each snapshot is
+// returned with the Days it is counted for, oldest range first, and those
+// counted for no day are dropped.
+func Tile(snapshots []Snapshot, loc *time.Location) []Snapshot {
+	byFetch := make([]Snapshot, 0, len(snapshots))
+	var first, last time.Time
+	for _, s := range snapshots {
+		if s.End.Before(s.Start) {
+			continue
+		}
+		s.Days = nil
+		byFetch = append(byFetch, s)
+		if first.IsZero() || s.Start.Before(first) {
+			first = s.Start
+		}
+		if s.End.After(last) {
+			last = s.End
+		}
+	}
+	sort.SliceStable(byFetch, func(i, j int) bool {
+		return byFetch[i].Fetched.After(byFetch[j].Fetched)
+	})
+
+	// The day each snapshot was downloaded on, in the same form as its range.
+	fetchedOn := make([]time.Time, len(byFetch))
+	for i, s := range byFetch {
+		fetchedOn[i], _ = time.ParseInLocation(DateLayout, s.Fetched.In(loc).Format(DateLayout), loc)
+	}
+
+	for d := first; len(byFetch) > 0 && !d.After(last); d = d.AddDate(0, 0, 1) {
+		best := -1
+		for i, s := range byFetch {
+			if d.Before(s.Start) || d.After(s.End) {
+				continue
+			}
+			if best < 0 {
+				best = i
+			}
+			if fetchedOn[i].After(d) {
+				best = i
+				break
+			}
+		}
+		if best >= 0 {
+			byFetch[best].Days = append(byFetch[best].Days, d)
+		}
+	}
+
+	var kept []Snapshot
+	for _, s := range byFetch {
+		if len(s.Days) > 0 {
+			kept = append(kept, s)
+		}
+	}
+	sort.SliceStable(kept, func(i, j int) bool {
+		return kept[i].Start.Before(kept[j].Start)
+	})
+
+	return kept
+}
+
+// Summarize() totals the quantity and sales of each group in each period.
+// period names the period a day falls in, such as Monthly or Yearly, and
+// group lists the names of the column to group by. A snapshot spanning
+// several days is shared between them equally, and only the shares of its
+// Days are counted, each in the period the day falls in.
+// Results are ordered by period, then group.
+func Summarize(snapshots []Snapshot, period func(time.Time) string, group []string) []Summary {
+	totals := make(map[[2]string]*Summary)
+
+	for _, s := range snapshots {
+		t := s.Table
+		if t == nil || len(t.Rows) == 0 {
+			continue
+		}
+
+		// Work out the share of the snapshot belonging to each period.
+		days := 0
+		for d := s.Start; !d.After(s.End); d = d.AddDate(0, 0, 1) {
+			days++
+		}
+		counted := s.Days
+		if len(counted) == 0 {
+			for d := s.Start; !d.After(s.End); d = d.AddDate(0, 0, 1) {
+				counted = append(counted, d)
+			}
+		}
+		share := make(map[string]float64)
+		for _, d := range counted {
+			share[period(d)]++
+		}
+
+		g, q, a := t.Find(group...), t.Find(QuantityColumns...), t.Find(SalesColumns...)
+		for r := range t.Rows {
+			name := t.Value(r, g)
+			quantity, sales := Number(t.Value(r, q)), Number(t.Value(r, a))
+
+			for p, n := range share {
+				k := [2]string{p, name}
+				if totals[k] == nil {
+					totals[k] = &Summary{Period: p, Group: name}
+				}
+				totals[k].Quantity += quantity * n / float64(days)
+				totals[k].Sales += sales * n / float64(days)
+			}
+		}
+	}
+
+	summaries := make([]Summary, 0, len(totals))
+	for _, s := range totals {
+		summaries = append(summaries, *s)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		if summaries[i].Period != summaries[j].Period {
+			return summaries[i].Period < summaries[j].Period
+		}
+		return summaries[i].Group < summaries[j].Group
+	})
+
+	return summaries
+}
+
+// SummaryCSV() formats summaries as a CSV with the group column named groupName.
+func SummaryCSV(summaries []Summary, groupName string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write([]string{"Period", groupName, "Quantity", "Sales"})
+	for _, s := range summaries {
+		w.Write([]string{
+			s.Period,
+			s.Group,
+			strconv.FormatFloat(s.Quantity, 'f', 2, 64),
+			strconv.FormatFloat(s.Sales, 'f', 2, 64),
+		})
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,85 @@
+package report
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(DateLayout, s)
+		return d
+	}
+	week, _ := Parse(strings.NewReader("Item,Department,Quantity,Net Sales\nTomatoes,Produce,10,$20.00\n"))
+
+	// The older download is only counted for the day the newer doesn't cover.
+	snapshots := Tile([]Snapshot{
+		{Start: day("2014-03-24"), End: day("2014-04-02"), Fetched: day("2014-04-02"), Table: week},
+		{Start: day("2014-03-23"), End: day("2014-04-01"), Fetched: day("2014-04-01"), Table: week},
+	}, time.UTC)
+	if len(snapshots) != 2 || len(snapshots[0].Days) != 1 || len(snapshots[1].Days) != 10 {
+		t.Fatalf("Tile kept %+v, want 1 day of the older snapshot and 10 of the newer", snapshots)
+	}
+
+	s := Summarize(snapshots, Monthly, DepartmentColumns)
+	if len(s) != 2 || s[0].Period != "2014-03" || s[0].Quantity != 9 || s[1].Sales != 4 {
+		t.Errorf("Summarize = %+v, want 9 in March and $4 in April", s)
+	}
+}
+
+func TestTileOverlappingWeeks(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(DateLayout, s)
+		return d
+	}
+	at := func(s string) time.Time {
+		t, _ := time.Parse("2006-01-02 15:04", s)
+		return t
+	}
+	week, _ := Parse(strings.NewReader("Item,Department,Quantity,Net Sales\nTomatoes,Produce,70,$140.00\n"))
+
+	// The past week, downloaded each day, twice on the 17th.
+	tiles := Tile([]Snapshot{
+		{Start: day("2014-03-10"), End: day("2014-03-16"), Fetched: at("2014-03-16 12:00"), Table: week},
+		{Start: day("2014-03-11"), End: day("2014-03-17"), Fetched: at("2014-03-17 06:00"), Table: week},
+		{Start: day("2014-03-11"), End: day("2014-03-17"), Fetched: at("2014-03-17 20:00"), Table: week},
+	}, time.UTC)
+
+	// The 10th is only in the first. The others are taken from the last
+	// download, made after each but the 17th ended.
+	if len(tiles) != 2 {
+		t.Fatalf("Tile kept %d snapshots, want 2", len(tiles))
+	}
+	if first := tiles[0]; !first.Fetched.Equal(at("2014-03-16 12:00")) || len(first.Days) != 1 || !first.Days[0].Equal(day("2014-03-10")) {
+		t.Errorf("Tile counted the first download for %v, want the 10th", first.Days)
+	}
+	if last := tiles[1]; !last.Fetched.Equal(at("2014-03-17 20:00")) || len(last.Days) != 7 {
+		t.Errorf("Tile counted the download from %v for %v, want the last for the 11th through 17th", last.Fetched, last.Days)
+	}
+
+	s := Summarize(tiles, Monthly, DepartmentColumns)
+	if len(s) != 1 || s[0].Quantity != 80 || s[0].Sales != 160 {
+		t.Errorf("Summarize = %+v, want 80 sold for $160 in March", s)
+	}
+
+	// The download made mid-day on the 16th isn't counted at all, as every
+	// day it covers was downloaded again after it ended.
+	tiles = Tile([]Snapshot{
+		{Start: day("2014-03-10"), End: day("2014-03-16"), Fetched: at("2014-03-17 01:00"), Table: week},
+		{Start: day("2014-03-11"), End: day("2014-03-17"), Fetched: at("2014-03-17 12:00"), Table: week},
+		{Start: day("2014-03-10"), End: day("2014-03-16"), Fetched: at("2014-03-16 12:00"), Table: week},
+	}, time.UTC)
+	if len(tiles) != 2 || !tiles[0].Fetched.Equal(at("2014-03-17 01:00")) || len(tiles[0].Days) != 1 {
+		t.Errorf("Tile kept %+v, want the final download of the 10th and the newest for the rest", tiles)
+	}
+}
+
+func TestWeekly(t *testing.T) {
+	for day, want := range map[string]string{"2014-03-03": "2014-03-03", "2014-03-05": "2014-03-03", "2014-03-09": "2014-03-03", "2014-03-10": "2014-03-10"} {
+		d, _ := time.Parse(DateLayout, day)
+		if got := Weekly(d); got != want {
+			t.Errorf("Weekly(%s) = %s, want %s", day, got, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package report
+
+import (
+	"strconv"
+)
+
+// Metrics are the figures compared between versions of a report to catch
+// problems with the data it was downloaded from.
+type Metrics struct {
+	Rows     int
+	Sales    float64
+	HasSales bool // Whether the report has a sales column.
+}
+
+// Measure() counts the rows of t and totals its sales column, if it has one.
+func Measure(t *Table) Metrics {
+	m := Metrics{Rows: len(t.Rows)}
+
+	sales := t.Find(SalesColumns...)
+	if sales < 0 {
+		return m
+	}
+
+	m.HasSales = true
+	for r := range t.Rows {
+		m.Sales += Number(t.Value(r, sales))
+	}
+
+	return m
+}
+
+// Deviations() compares m to the average of history and describes each
+// figure that is more than factor times higher or lower. It returns nothing
+// when there is no history to compare against.
+func Deviations(m Metrics, history []Metrics, factor float64) []string {
+	if len(history) == 0 || factor <= 1 {
+		return nil
+	}
+
+	var rows, sales float64
+	for _, h := range history {
+		rows += float64(h.Rows)
+		sales += h.Sales
+	}
+	rows /= float64(len(history))
+	sales /= float64(len(history))
+
+	var deviations []string
+	if d := deviation("Row count", float64(m.Rows), rows, factor); d != "" {
+		deviations = append(deviations, d)
+	}
+	if m.HasSales {
+		if d := deviation("Total sales", m.Sales, sales, factor); d != "" {
+			deviations = append(deviations, d)
+		}
+	}
+
+	return deviations
+}
+
+// Describes value if it is more than factor times higher or lower than
+// average, or returns "" if it is not.
+func deviation(name string, value float64, average float64, factor float64) string {
+	if average <= 0 || value < 0 {
+		return ""
+	}
+
+	if value > average*factor || value < average/factor {
+		return name + " of " + strconv.FormatFloat(value, 'f', 2, 64) + " is far from the recent average of " + strconv.FormatFloat(average, 'f', 2, 64)
+	}
+
+	return ""
+}
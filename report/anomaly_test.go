@@ -0,0 +1,48 @@
+package report
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMeasure(t *testing.T) {
+	table, err := Parse(strings.NewReader("Item,Net Sales\nApples,$10.00\nPears,\"$1,000.50\"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := Measure(table)
+	if m.Rows != 2 || !m.HasSales || m.Sales != 1010.5 {
+		t.Errorf("Measure() = %+v", m)
+	}
+
+	table, _ = Parse(strings.NewReader("Item,Quantity\nApples,3\n"))
+	if m := Measure(table); m.HasSales {
+		t.Errorf("Measure() found sales in a report without them: %+v", m)
+	}
+}
+
+func TestDeviations(t *testing.T) {
+	history := []Metrics{
+		{Rows: 10, Sales: 100, HasSales: true},
+		{Rows: 12, Sales: 120, HasSales: true},
+	}
+
+	if d := Deviations(Metrics{Rows: 11, Sales: 90, HasSales: true}, history, 10); len(d) != 0 {
+		t.Errorf("Deviations() of normal metrics = %v", d)
+	}
+
+	d := Deviations(Metrics{Rows: 11, Sales: 5000, HasSales: true}, history, 10)
+	if len(d) != 1 || !strings.HasPrefix(d[0], "Total sales") {
+		t.Errorf("Deviations() of a spike = %v", d)
+	}
+
+	d = Deviations(Metrics{Rows: 1, Sales: 5, HasSales: true}, history, 10)
+	if len(d) != 2 {
+		t.Errorf("Deviations() of a drop = %v", d)
+	}
+
+	if d := Deviations(Metrics{Rows: 1000}, nil, 10); len(d) != 0 {
+		t.Errorf("Deviations() without history = %v", d)
+	}
+}
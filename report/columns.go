@@ -0,0 +1,54 @@
+package report
+
+import (
+	"strings"
+)
+
+// A Transform drops and renames the columns of a report, such as to remove
+// customers' contact details before it is shared. Column names are matched
+// ignoring case.
+type Transform struct {
+	Drop   []string          // The columns removed.
+	Rename map[string]string // New names of columns, by their old name.
+}
+
+// Apply() returns a copy of t with the columns dropped and renamed.
+func (x Transform) Apply(t *Table) *Table {
+	var keep []int
+	out := &Table{}
+	for i, h := range t.Header {
+		if x.drops(h) {
+			continue
+		}
+		keep = append(keep, i)
+
+		for old, name := range x.Rename {
+			if strings.EqualFold(h, old) {
+				h = name
+				break
+			}
+		}
+		out.Header = append(out.Header, h)
+	}
+
+	for r := range t.Rows {
+		row := make([]string, len(keep))
+		for j, i := range keep {
+			row[j] = t.Value(r, i)
+		}
+		out.Rows = append(out.Rows, row)
+	}
+
+	return out
+}
+
+// Reports whether x drops column name.
+func (x Transform) drops(name string) bool {
+	for _, d := range x.Drop {
+		if strings.EqualFold(d, name) {
+			return true
+		}
+	}
+
+	return false
+}
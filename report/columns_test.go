@@ -0,0 +1,23 @@
+package report
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTransform(t *testing.T) {
+	table, err := Parse(strings.NewReader("Name,Email,Phone,Total\nAnn,ann@example.com,555-0100,$10.00\nBob,bob@example.com\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x := Transform{Drop: []string{"email", "Phone"}, Rename: map[string]string{"name": "Customer"}}
+	data, err := x.Apply(table).CSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := "Customer,Total\nAnn,$10.00\nBob,\n"; string(data) != want {
+		t.Errorf("Apply() = %q, want %q", data, want)
+	}
+}
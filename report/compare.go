@@ -0,0 +1,99 @@
+package report
+
+import (
+	"math"
+	"sort"
+)
+
+// A Comparison sets the sales of one period, A, against those of another,
+// B, such as a month against the same month a year earlier.
+type Comparison struct {
+	A        Totals      `json:"a"`
+	B        Totals      `json:"b"`
+	Quantity Delta       `json:"quantity"`
+	Sales    Delta       `json:"sales"`
+	Items    []ItemDelta `json:"items"` // Every item sold in either period, biggest change in sales first.
+}
+
+// Totals are the quantity and sales of a period.
+type Totals struct {
+	Period   string  `json:"period"`
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Quantity float64 `json:"quantity"`
+	Sales    float64 `json:"sales"`
+}
+
+// A Delta is how a figure changed from period B to period A.
+type Delta struct {
+	Change  float64  `json:"change"`            // A minus B.
+	Percent *float64 `json:"percent,omitempty"` // The change as a percentage of B, unless B is zero.
+}
+
+// An ItemDelta compares the sales of one item in the two periods.
+type ItemDelta struct {
+	Name      string  `json:"name"`
+	QuantityA float64 `json:"quantity_a"`
+	QuantityB float64 `json:"quantity_b"`
+	SalesA    float64 `json:"sales_a"`
+	SalesB    float64 `json:"sales_b"`
+	Quantity  Delta   `json:"quantity_change"`
+	Sales     Delta   `json:"sales_change"`
+}
+
+// ComparePeriods() compares the totals of each item sold in period a with
+// those of period b, such as from Summarize(). The periods' names and days
+// are left for the caller to fill in.
+func ComparePeriods(a []Summary, b []Summary) *Comparison {
+	c := &Comparison{Items: []ItemDelta{}}
+	items := make(map[string]*ItemDelta)
+	item := func(name string) *ItemDelta {
+		if items[name] == nil {
+			items[name] = &ItemDelta{Name: name}
+		}
+		return items[name]
+	}
+
+	for _, s := range a {
+		c.A.Quantity += s.Quantity
+		c.A.Sales += s.Sales
+		i := item(s.Group)
+		i.QuantityA += s.Quantity
+		i.SalesA += s.Sales
+	}
+	for _, s := range b {
+		c.B.Quantity += s.Quantity
+		c.B.Sales += s.Sales
+		i := item(s.Group)
+		i.QuantityB += s.Quantity
+		i.SalesB += s.Sales
+	}
+
+	c.Quantity = delta(c.A.Quantity, c.B.Quantity)
+	c.Sales = delta(c.A.Sales, c.B.Sales)
+	for _, i := range items {
+		i.Quantity = delta(i.QuantityA, i.QuantityB)
+		i.Sales = delta(i.SalesA, i.SalesB)
+		c.Items = append(c.Items, *i)
+	}
+
+	sort.SliceStable(c.Items, func(i, j int) bool {
+		di, dj := math.Abs(c.Items[i].Sales.Change), math.Abs(c.Items[j].Sales.Change)
+		if di != dj {
+			return di > dj
+		}
+		return c.Items[i].Name < c.Items[j].Name
+	})
+
+	return c
+}
+
+// Returns how a figure changed from b to a.
+func delta(a float64, b float64) Delta {
+	d := Delta{Change: a - b}
+	if b != 0 {
+		p := (a - b) / math.Abs(b) * 100
+		d.Percent = &p
+	}
+	return d
+}
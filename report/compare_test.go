@@ -0,0 +1,28 @@
+package report
+
+import (
+	"testing"
+)
+
+func TestComparePeriods(t *testing.T) {
+	a := []Summary{{Group: "Apples", Quantity: 30, Sales: 60}, {Group: "Pears", Quantity: 5, Sales: 10}}
+	b := []Summary{{Group: "Apples", Quantity: 20, Sales: 40}, {Group: "Plums", Quantity: 4, Sales: 30}}
+
+	c := ComparePeriods(a, b)
+	if c.A.Sales != 70 || c.B.Sales != 70 || c.Sales.Change != 0 || c.Quantity.Change != 11 {
+		t.Errorf("ComparePeriods() totals = %+v %+v, changes %+v %+v", c.A, c.B, c.Quantity, c.Sales)
+	}
+
+	if len(c.Items) != 3 {
+		t.Fatalf("ComparePeriods() items = %+v", c.Items)
+	}
+	if i := c.Items[0]; i.Name != "Plums" || i.SalesA != 0 || i.Sales.Change != -30 || *i.Sales.Percent != -100 {
+		t.Errorf("first item = %+v", i)
+	}
+	if i := c.Items[1]; i.Name != "Apples" || i.Sales.Change != 20 || *i.Sales.Percent != 50 {
+		t.Errorf("second item = %+v", i)
+	}
+	if i := c.Items[2]; i.Name != "Pears" || i.Sales.Percent != nil {
+		t.Errorf("an item new in A has a percentage change: %+v", i)
+	}
+}
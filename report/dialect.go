@@ -0,0 +1,60 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// A Dialect is how a CSV is written for software expecting something other
+// than commas and decimal points, such as European bookkeeping software
+// expecting semicolons and decimal commas.
+type Dialect struct {
+	Delimiter rune // Separates fields. Zero means a comma.
+	Decimal   rune // Separates whole numbers from fractions, '.' or ','. Zero means a point.
+}
+
+// Matches amounts such as 3, -1204.50, $1,204.50 or (3.00).
+var numberPattern = regexp.MustCompile(`^\(?[-+]?\$?-?(\d{1,3}(,\d{3})+|\d+)(\.\d+)?\)?$`)
+
+// Standard() reports whether d writes the usual comma separated values.
+func (d Dialect) Standard() bool {
+	return (d.Delimiter == 0 || d.Delimiter == ',') && (d.Decimal == 0 || d.Decimal == '.')
+}
+
+// Convert() rewrites the CSV data in dialect d. When Decimal is a comma,
+// the separators of numbers are swapped, so $1,204.50 becomes $1.204,50.
+func (d Dialect) Convert(data []byte) ([]byte, error) {
+	if d.Decimal != 0 && d.Decimal != '.' && d.Decimal != ',' {
+		return nil, errors.New("The decimal separator must be . or ,")
+	}
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, errors.New("Failed to parse report. " + err.Error())
+	}
+
+	if d.Decimal == ',' {
+		swap := strings.NewReplacer(".", ",", ",", ".")
+		for _, record := range records {
+			for i, v := range record {
+				if numberPattern.MatchString(v) {
+					record[i] = swap.Replace(v)
+				}
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if d.Delimiter != 0 {
+		w.Comma = d.Delimiter
+	}
+	w.WriteAll(records)
+
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,33 @@
+package report
+
+import (
+	"testing"
+)
+
+func TestDialect(t *testing.T) {
+	in := []byte("Item,Date,Quantity,Net Sales\nApples,2014-03-01,3,\"$1,204.50\"\n\"Pears; ripe\",2014-03-02,1.5,(3.00)\n")
+
+	got, err := Dialect{Delimiter: ';', Decimal: ','}.Convert(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Item;Date;Quantity;Net Sales\nApples;2014-03-01;3;$1.204,50\n\"Pears; ripe\";2014-03-02;1,5;(3,00)\n"
+	if string(got) != want {
+		t.Errorf("Convert() = %q, want %q", got, want)
+	}
+
+	got, err = Dialect{Delimiter: '\t'}.Convert(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Item\tDate\tQuantity\tNet Sales\nApples\t2014-03-01\t3\t$1,204.50\nPears; ripe\t2014-03-02\t1.5\t(3.00)\n"; string(got) != want {
+		t.Errorf("Convert() = %q, want %q", got, want)
+	}
+
+	if !(Dialect{}).Standard() || (Dialect{Delimiter: ';'}).Standard() {
+		t.Error("Standard() is wrong")
+	}
+	if _, err := (Dialect{Decimal: '/'}).Convert(in); err == nil {
+		t.Error("Convert() accepted a decimal separator of /")
+	}
+}
@@ -0,0 +1,131 @@
+package report
+
+import (
+	"strings"
+)
+
+// A Diff describes how one version of a report differs from another.
+type Diff struct {
+	Added   []Row    `json:"added"`
+	Removed []Row    `json:"removed"`
+	Changed []Change `json:"changed"`
+}
+
+// A Change is a row present in both versions whose values differ.
+type Change struct {
+	Key  string `json:"key"`
+	From Row    `json:"from"`
+	To   Row    `json:"to"`
+}
+
+// Compare() determines which rows were added, removed or changed between
+// two versions of a report. Rows are matched on the given key columns.
+// Key columns missing from a report are ignored; if none are present the
+// first column is used.
+func Compare(from, to *Table, key ...string) *Diff {
+	d := &Diff{
+		Added:   []Row{},
+		Removed: []Row{},
+		Changed: []Change{},
+	}
+
+	old, cur := from.index(key), to.index(key)
+	seen := make(map[string]bool)
+
+	for _, k := range to.keys(key) {
+		i := cur[k]
+		seen[k] = true
+
+		j, ok := old[k]
+		if !ok {
+			d.Added = append(d.Added, to.Row(i))
+			continue
+		}
+
+		a, b := from.Row(j), to.Row(i)
+		if !equal(a, b) {
+			d.Changed = append(d.Changed, Change{Key: k, From: a, To: b})
+		}
+	}
+
+	for _, k := range from.keys(key) {
+		if !seen[k] {
+			d.Removed = append(d.Removed, from.Row(old[k]))
+		}
+	}
+
+	return d
+}
+
+// Returns the key of every row in the order the rows appear.
+func (t *Table) keys(key []string) []string {
+	cols := t.keyColumns(key)
+	keys := make([]string, 0, len(t.Rows))
+	seen := make(map[string]bool)
+	for _, r := range t.Rows {
+		k := rowKey(r, cols)
+		if !seen[k] {
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+
+	return keys
+}
+
+// Maps row keys to row indexes. When keys repeat the first row wins.
+func (t *Table) index(key []string) map[string]int {
+	cols := t.keyColumns(key)
+	idx := make(map[string]int, len(t.Rows))
+	for i, r := range t.Rows {
+		k := rowKey(r, cols)
+		if _, ok := idx[k]; !ok {
+			idx[k] = i
+		}
+	}
+
+	return idx
+}
+
+// Resolves key column names to indexes.
+func (t *Table) keyColumns(key []string) []int {
+	var cols []int
+	for _, k := range key {
+		if i := t.Column(k); i >= 0 {
+			cols = append(cols, i)
+		}
+	}
+
+	if len(cols) == 0 {
+		cols = []int{0}
+	}
+
+	return cols
+}
+
+// Joins the key columns of a row into a single string.
+func rowKey(r []string, cols []int) string {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		if c < len(r) {
+			parts[i] = r[c]
+		}
+	}
+
+	return strings.Join(parts, " | ")
+}
+
+// Reports whether two rows hold the same values.
+func equal(a, b Row) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+
+	return true
+}
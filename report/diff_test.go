@@ -0,0 +1,23 @@
+package report
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	from, _ := Parse(strings.NewReader("Item,Quantity\nTomatoes,4\nEggs,12\nHoney,1\n"))
+	to, _ := Parse(strings.NewReader("Item,Quantity\nTomatoes,6\nEggs,12\nBread,2\n"))
+
+	d := Compare(from, to, "Item")
+
+	if len(d.Added) != 1 || d.Added[0]["Item"] != "Bread" {
+		t.Errorf("Added = %v, want Bread", d.Added)
+	}
+	if len(d.Removed) != 1 || d.Removed[0]["Item"] != "Honey" {
+		t.Errorf("Removed = %v, want Honey", d.Removed)
+	}
+	if len(d.Changed) != 1 || d.Changed[0].Key != "Tomatoes" || d.Changed[0].To["Quantity"] != "6" {
+		t.Errorf("Changed = %v, want Tomatoes 4 -> 6", d.Changed)
+	}
+}
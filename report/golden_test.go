@@ -0,0 +1,95 @@
+package report
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run go test ./report -update after changing the parser to rewrite the
+// golden files, then review their diff.
+var update = flag.Bool("update", false, "Rewrite the golden files in testdata from the current parser.")
+
+// Each testdata/*.csv is a report as downloaded from ShopKeep, parsed as
+// refresh() does, and compared to the .golden file next to it. A golden
+// file holds the table as the cacher stores it, followed by the columns
+// found of each kind and the report's metrics.
+func TestGolden(t *testing.T) {
+	samples, err := filepath.Glob("testdata/*.csv")
+	if err != nil || len(samples) == 0 {
+		t.Fatal("No sample reports in testdata.", err)
+	}
+
+	for _, sample := range samples {
+		t.Run(filepath.Base(sample), func(t *testing.T) {
+			checkGolden(t, strings.TrimSuffix(sample, ".csv")+".golden", parseSample(t, sample))
+		})
+	}
+}
+
+// parseSample() normalizes and parses the sample report at p, and describes
+// the result as its golden file does.
+func parseSample(t *testing.T, p string) []byte {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = Normalize(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	table, err := Parse(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := table.CSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out = append(out, '\n')
+	for _, kind := range []struct {
+		name    string
+		columns []string
+	}{
+		{"Item", ItemColumns},
+		{"Department", DepartmentColumns},
+		{"Quantity", QuantityColumns},
+		{"Sales", SalesColumns},
+	} {
+		found := "none"
+		if i := table.Find(kind.columns...); i >= 0 {
+			found = table.Header[i]
+		}
+		out = append(out, fmt.Sprintf("%s column: %s\n", kind.name, found)...)
+	}
+	m := Measure(table)
+	out = append(out, fmt.Sprintf("Rows: %d\nSales: %.2f\n", m.Rows, m.Sales)...)
+
+	return out
+}
+
+// checkGolden() compares got to the golden file p, or rewrites p with it
+// when -update is given.
+func checkGolden(t *testing.T, p string, got []byte) {
+	t.Helper()
+
+	if *update {
+		if err := ioutil.WriteFile(p, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatalf("%v. Run go test ./report -update to create it.", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("Parsed output differs from %s. If the change is intended, run go test ./report -update and review the diff.\ngot:\n%s\nwant:\n%s", p, got, want)
+	}
+}
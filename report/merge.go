@@ -0,0 +1,34 @@
+package report
+
+// Merge() combines tables with a column, named column, holding the name of
+// the table each row came from, such as the provider it was downloaded from. The merged
+// header is column followed by every other column of the tables, in the
+// order they first appear; names are matched ignoring case.
+func Merge(column string, names []string, tables []*Table) *Table {
+	merged := &Table{Header: []string{column}}
+	for _, t := range tables {
+		for _, h := range t.Header {
+			if merged.Column(h) < 0 {
+				merged.Header = append(merged.Header, h)
+			}
+		}
+	}
+
+	for i, t := range tables {
+		cols := make([]int, len(merged.Header))
+		for j, h := range merged.Header {
+			cols[j] = t.Column(h)
+		}
+
+		for r := range t.Rows {
+			row := make([]string, len(merged.Header))
+			row[0] = names[i]
+			for j := 1; j < len(row); j++ {
+				row[j] = t.Value(r, cols[j])
+			}
+			merged.Rows = append(merged.Rows, row)
+		}
+	}
+
+	return merged
+}
@@ -0,0 +1,24 @@
+package report
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	shopkeep, _ := Parse(strings.NewReader("Item,Quantity,Net Sales\nApples,3,$3.00\n"))
+	square, _ := Parse(strings.NewReader("Order ID,Item,net sales,Tax\n1,Pears,2.00,0.10\n2,Plums,1.00,0.05\n"))
+
+	data, err := Merge("Provider", []string{"shopkeep", "square"}, []*Table{shopkeep, square}).CSV()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Provider,Item,Quantity,Net Sales,Order ID,Tax\n" +
+		"shopkeep,Apples,3,$3.00,,\n" +
+		"square,Pears,,2.00,1,0.10\n" +
+		"square,Plums,,1.00,2,0.05\n"
+	if string(data) != want {
+		t.Errorf("Merge() = %q, want %q", data, want)
+	}
+}
@@ -0,0 +1,75 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"regexp"
+	"strconv"
+	"unicode/utf8"
+)
+
+// ErrHTML is returned by CheckCSV() for a web page, such as an error page
+// served in place of a report.
+var ErrHTML = errors.New("The download is a web page, not a CSV")
+
+// Matches the title of a web page.
+var titlePattern = regexp.MustCompile(`(?is)<title[^>]*>\s*(.*?)\s*</title>`)
+
+// CheckCSV() returns an error if data, a downloaded report, is a web page
+// rather than a CSV. The error names the page's title, if it has one, which
+// usually says what went wrong.
+func CheckCSV(data []byte) error {
+	start := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\ufeff")), " \t\r\n")
+	if len(start) > 512 {
+		start = start[:512]
+	}
+	lower := bytes.ToLower(start)
+	if !bytes.HasPrefix(lower, []byte("<!doctype")) && !bytes.HasPrefix(lower, []byte("<html")) &&
+		!bytes.HasPrefix(lower, []byte("<?xml")) && !bytes.HasPrefix(lower, []byte("<head")) && !bytes.HasPrefix(lower, []byte("<body")) {
+		return nil
+	}
+
+	if m := titlePattern.FindSubmatch(data); m != nil && len(m[1]) > 0 {
+		return errors.New(ErrHTML.Error() + ". Its title is " + strconv.Quote(string(m[1])) + ".")
+	}
+	return ErrHTML
+}
+
+// Normalize() rewrites a downloaded CSV report as UTF-8 without a byte
+// order mark, with \n line endings and quoting as RFC 4180 describes, which
+// Excel and most CSV libraries read without trouble. UTF-16 reports are
+// converted, as are Windows-1252 ones, which is assumed of any report that
+// isn't valid UTF-8. Stray quotes inside unquoted fields are kept.
+func Normalize(data []byte) ([]byte, error) {
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}), bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		data, err = unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, errors.New("Failed to decode UTF-16. " + err.Error())
+		}
+	case !utf8.Valid(data):
+		data, err = charmap.Windows1252.NewDecoder().Bytes(data)
+		if err != nil {
+			return nil, errors.New("Failed to decode Windows-1252. " + err.Error())
+		}
+	}
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, errors.New("Failed to parse report. " + err.Error())
+	}
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.WriteAll(records)
+
+	return buf.Bytes(), w.Error()
+}
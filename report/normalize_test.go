@@ -0,0 +1,52 @@
+package report
+
+import (
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"BOM and CRLF", "\ufeffItem,Quantity\r\nApples,3\r\n", "Item,Quantity\nApples,3\n"},
+		{"quoting", "Item,Note\nPears,\"a, b\"\nPlums,say \"hi\"\n", "Item,Note\nPears,\"a, b\"\nPlums,\"say \"\"hi\"\"\"\n"},
+		{"Windows-1252", "Item\nCr\xe8me br\xfbl\xe9e \x96 large\n", "Item\nCrème brûlée – large\n"},
+		{"UTF-16", "\xff\xfeI\x00t\x00e\x00m\x00\r\x00\n\x00\xe9\x00\n\x00", "Item\né\n"},
+		{"no trailing newline", "Item\nApples", "Item\nApples\n"},
+	}
+
+	for _, test := range tests {
+		got, err := Normalize([]byte(test.in))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: Normalize() = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCheckCSV(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Item,Quantity\nApples,3\n", ""},
+		{"\ufeffItem,Note\nApples,<b>ripe</b>\n", ""},
+		{"\n  <!DOCTYPE html>\n<html><head><title>We're sorry, but something went wrong (500)</title></head></html>", `The download is a web page, not a CSV. Its title is "We're sorry, but something went wrong (500)".`},
+		{"<html><body>Down for maintenance</body></html>", "The download is a web page, not a CSV"},
+	}
+
+	for _, test := range tests {
+		var got string
+		if err := CheckCSV([]byte(test.in)); err != nil {
+			got = err.Error()
+		}
+		if got != test.want {
+			t.Errorf("CheckCSV(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package report
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuickBooksIIF() formats the sales of each department in each period as
+// general journal entries QuickBooks can import. Each period, as named by
+// Monthly() or Yearly(), becomes one entry dated its last day. The deposit
+// account is debited the period's total and each department's income
+// account, named income:<department>, is credited its sales.
+func QuickBooksIIF(summaries []Summary, deposit string, income string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("!TRNS\tTRNSID\tTRNSTYPE\tDATE\tACCNT\tAMOUNT\tMEMO\n")
+	buf.WriteString("!SPL\tSPLID\tTRNSTYPE\tDATE\tACCNT\tAMOUNT\tMEMO\n")
+	buf.WriteString("!ENDTRNS\n")
+
+	for i := 0; i < len(summaries); {
+		period := summaries[i].Period
+		date := periodEnd(period).Format("01/02/2006")
+
+		// Round each department first so the entry balances to the cent.
+		var splits bytes.Buffer
+		var total int64
+		for ; i < len(summaries) && summaries[i].Period == period; i++ {
+			department := iifField(summaries[i].Group)
+			if department == "" {
+				department = "Uncategorized"
+			}
+			cents := int64(summaries[i].Sales*100 + 0.5)
+			if summaries[i].Sales < 0 {
+				cents = int64(summaries[i].Sales*100 - 0.5)
+			}
+			total += cents
+
+			splits.WriteString("SPL\t\tGENERAL JOURNAL\t" + date + "\t" + iifField(income) + ":" + department + "\t" + amount(-cents) + "\t" + department + " sales\n")
+		}
+
+		buf.WriteString("TRNS\t\tGENERAL JOURNAL\t" + date + "\t" + iifField(deposit) + "\t" + amount(total) + "\tSales for " + period + "\n")
+		buf.Write(splits.Bytes())
+		buf.WriteString("ENDTRNS\n")
+	}
+
+	return buf.Bytes()
+}
+
+// Returns the last day of a period named by Monthly() or Yearly().
+func periodEnd(period string) time.Time {
+	if t, err := time.Parse("2006-01", period); err == nil {
+		return t.AddDate(0, 1, -1)
+	}
+	if t, err := time.Parse("2006", period); err == nil {
+		return t.AddDate(1, 0, -1)
+	}
+	return time.Time{}
+}
+
+// Formats an amount in cents as dollars and cents, such as -12.50
+func amount(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	c := strconv.FormatInt(cents%100, 10)
+	if len(c) == 1 {
+		c = "0" + c
+	}
+	return sign + strconv.FormatInt(cents/100, 10) + "." + c
+}
+
+// Removes the tabs and line breaks IIF uses as separators from a field.
+func iifField(s string) string {
+	return strings.TrimSpace(strings.NewReplacer("\t", " ", "\r", " ", "\n", " ").Replace(s))
+}
@@ -0,0 +1,28 @@
+package report
+
+import (
+	"testing"
+)
+
+func TestQuickBooksIIF(t *testing.T) {
+	summaries := []Summary{
+		{Period: "2014-02", Group: "Produce", Sales: 100.004},
+		{Period: "2014-02", Group: "", Sales: 25.5},
+		{Period: "2014-03", Group: "Bakery", Sales: 12},
+	}
+
+	want := "!TRNS\tTRNSID\tTRNSTYPE\tDATE\tACCNT\tAMOUNT\tMEMO\n" +
+		"!SPL\tSPLID\tTRNSTYPE\tDATE\tACCNT\tAMOUNT\tMEMO\n" +
+		"!ENDTRNS\n" +
+		"TRNS\t\tGENERAL JOURNAL\t02/28/2014\tUndeposited Funds\t125.50\tSales for 2014-02\n" +
+		"SPL\t\tGENERAL JOURNAL\t02/28/2014\tSales:Produce\t-100.00\tProduce sales\n" +
+		"SPL\t\tGENERAL JOURNAL\t02/28/2014\tSales:Uncategorized\t-25.50\tUncategorized sales\n" +
+		"ENDTRNS\n" +
+		"TRNS\t\tGENERAL JOURNAL\t03/31/2014\tUndeposited Funds\t12.00\tSales for 2014-03\n" +
+		"SPL\t\tGENERAL JOURNAL\t03/31/2014\tSales:Bakery\t-12.00\tBakery sales\n" +
+		"ENDTRNS\n"
+
+	if got := string(QuickBooksIIF(summaries, "Undeposited Funds", "Sales")); got != want {
+		t.Errorf("QuickBooksIIF() =\n%s\nwant\n%s", got, want)
+	}
+}
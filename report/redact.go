@@ -0,0 +1,41 @@
+package report
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// Redact() replaces every value of the named columns of t, ignoring case,
+// with hide(value), such as Mask or Hash(). Empty values are left empty.
+func (t *Table) Redact(columns []string, hide func(string) string) {
+	var cols []int
+	for _, c := range columns {
+		if i := t.Column(c); i >= 0 {
+			cols = append(cols, i)
+		}
+	}
+
+	for _, row := range t.Rows {
+		for _, i := range cols {
+			if i < len(row) && strings.TrimSpace(row[i]) != "" {
+				row[i] = hide(row[i])
+			}
+		}
+	}
+}
+
+// Mask() hides a value entirely.
+func Mask(string) string {
+	return "REDACTED"
+}
+
+// Hash() returns a function that replaces a value with a short hash of it
+// and salt, so rows of the same customer can still be matched up. Without a
+// secret salt, common values such as names can be guessed from their hash.
+func Hash(salt string) func(string) string {
+	return func(s string) string {
+		sum := sha256.Sum256([]byte(salt + strings.ToLower(strings.TrimSpace(s))))
+		return hex.EncodeToString(sum[:6])
+	}
+}
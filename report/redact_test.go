@@ -0,0 +1,26 @@
+package report
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	table, err := Parse(strings.NewReader("Customer,Email,Total\nAnn,ann@example.com,$10.00\nBob,,$5.00\nann ,ann@example.com,$1.00\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table.Redact([]string{"email"}, Mask)
+	if table.Rows[0][1] != "REDACTED" || table.Rows[1][1] != "" || table.Rows[0][2] != "$10.00" {
+		t.Errorf("Redact(Mask) = %v", table.Rows)
+	}
+
+	table.Redact([]string{"Customer"}, Hash("salt"))
+	if table.Rows[0][0] == "Ann" || table.Rows[0][0] != table.Rows[2][0] || table.Rows[0][0] == table.Rows[1][0] {
+		t.Errorf("Redact(Hash()) = %v", table.Rows)
+	}
+	if Hash("salt")("Ann") == Hash("pepper")("Ann") {
+		t.Error("Hash() ignored the salt")
+	}
+}
@@ -0,0 +1,140 @@
+// This package parses cached ShopKeep reports so they can be queried
+// and compared. It does not download or store reports.
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The names ShopKeep uses for the columns of the Sold Items report.
+var (
+	ItemColumns       = []string{"Item", "Description", "Item Name", "Name"}
+	DepartmentColumns = []string{"Department", "Department Name"}
+	QuantityColumns   = []string{"Quantity", "Quantity Sold", "Qty", "Sold"}
+	SalesColumns      = []string{"Net Sales", "Sales", "Total", "Gross Sales", "Amount"}
+)
+
+// A Table is a parsed CSV report.
+type Table struct {
+	Header []string
+	Rows   [][]string
+}
+
+// A Row maps column names to the values of a single report row.
+type Row map[string]string
+
+// Parse() reads a CSV report. The first record is treated as the header.
+func Parse(r io.Reader) (*Table, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, errors.New("Failed to parse report. " + err.Error())
+	}
+
+	if len(records) == 0 {
+		return &Table{}, nil
+	}
+
+	header := records[0]
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	return &Table{Header: header, Rows: records[1:]}, nil
+}
+
+// ReadFile() parses the CSV report stored at path p.
+func ReadFile(p string) (*Table, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// CSV() formats the table as a CSV.
+func (t *Table) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write(t.Header)
+	w.WriteAll(t.Rows)
+
+	return buf.Bytes(), w.Error()
+}
+
+// Column() returns the index of the named column, ignoring case, or -1 if
+// the report has no such column.
+func (t *Table) Column(name string) int {
+	for i, h := range t.Header {
+		if strings.EqualFold(h, name) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Find() returns the index of the first of the named columns the report
+// has, or -1 if it has none of them. ShopKeep has renamed columns over time,
+// so callers pass every name a column has been known by.
+func (t *Table) Find(names ...string) int {
+	for _, n := range names {
+		if i := t.Column(n); i >= 0 {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Value() returns the value of column i in row r, or "" if it is missing.
+func (t *Table) Value(r int, i int) string {
+	if i < 0 || i >= len(t.Rows[r]) {
+		return ""
+	}
+
+	return t.Rows[r][i]
+}
+
+// Number() parses a quantity or currency amount such as "$1,204.50" or
+// "(3.00)". Values that cannot be parsed count as zero.
+func Number(s string) float64 {
+	s = strings.TrimSpace(s)
+	negative := strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")")
+	s = strings.NewReplacer("$", "", ",", "", "(", "", ")", "", " ", "").Replace(s)
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+
+	if negative {
+		return -n
+	}
+	return n
+}
+
+// Row() returns row i keyed by column name.
+func (t *Table) Row(i int) Row {
+	row := make(Row, len(t.Header))
+	for j, h := range t.Header {
+		if j < len(t.Rows[i]) {
+			row[h] = t.Rows[i][j]
+		} else {
+			row[h] = ""
+		}
+	}
+
+	return row
+}
@@ -0,0 +1,89 @@
+package report
+
+import (
+	"sort"
+	"time"
+)
+
+// Stats are summary statistics of the Sold Items report over a window of days.
+type Stats struct {
+	From          string    `json:"from"`
+	To            string    `json:"to"`
+	Quantity      float64   `json:"quantity"`
+	Sales         float64   `json:"sales"`
+	Items         int       `json:"items"` // The number of distinct items sold.
+	TopByQuantity []Summary `json:"top_by_quantity"`
+	TopBySales    []Summary `json:"top_by_sales"`
+	Departments   []Summary `json:"departments"` // Ordered by sales, highest first.
+}
+
+// Statistics() computes totals, the top n items by quantity and by sales, and
+// per-department totals for the days from through to.
+func Statistics(snapshots []Snapshot, from time.Time, to time.Time, n int) *Stats {
+	window := func(d time.Time) string {
+		if d.Before(from) || d.After(to) {
+			return ""
+		}
+		return from.Format(DateLayout)
+	}
+
+	var items, departments []Summary
+	for _, s := range Summarize(snapshots, window, ItemColumns) {
+		if s.Period != "" {
+			s.Period = ""
+			items = append(items, s)
+		}
+	}
+	for _, s := range Summarize(snapshots, window, DepartmentColumns) {
+		if s.Period != "" {
+			s.Period = ""
+			departments = append(departments, s)
+		}
+	}
+
+	return NewStats(from, to, items, departments, n)
+}
+
+// NewStats() builds Stats from the totals of each item and department sold
+// from through to, keeping the top n items by quantity and by sales.
+// It is used by analytics engines that total the report themselves.
+func NewStats(from time.Time, to time.Time, items []Summary, departments []Summary, n int) *Stats {
+	st := &Stats{
+		From:          from.Format(DateLayout),
+		To:            to.Format(DateLayout),
+		Items:         len(items),
+		TopByQuantity: []Summary{},
+		TopBySales:    []Summary{},
+		Departments:   append([]Summary{}, departments...),
+	}
+
+	for _, s := range items {
+		st.Quantity += s.Quantity
+		st.Sales += s.Sales
+	}
+
+	sort.SliceStable(st.Departments, func(i, j int) bool {
+		return st.Departments[i].Sales > st.Departments[j].Sales
+	})
+
+	items = append([]Summary{}, items...)
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Quantity > items[j].Quantity
+	})
+	st.TopByQuantity = append(st.TopByQuantity, items[:min(n, len(items))]...)
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].Sales > items[j].Sales
+	})
+	st.TopBySales = append(st.TopBySales, items[:min(n, len(items))]...)
+
+	return st
+}
+
+// Returns the smaller of a and b.
+func min(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,57 @@
+package report
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatistics(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(DateLayout, s)
+		return d
+	}
+	week, _ := Parse(strings.NewReader("Item,Department,Quantity,Net Sales\nTomatoes,Produce,70,$140.00\nHoney,Pantry,7,$70.00\nCarrots,Produce,14,$14.00\n"))
+	snapshots := []Snapshot{{Start: day("2014-03-10"), End: day("2014-03-16"), Table: week}}
+
+	// Three of the week's seven days are in the window.
+	st := Statistics(snapshots, day("2014-03-14"), day("2014-03-20"), 2)
+	if st.From != "2014-03-14" || st.To != "2014-03-20" || st.Items != 3 || st.Quantity != 39 || st.Sales != 96 {
+		t.Errorf("Statistics() = %+v, want 39 of 3 items sold for $96", st)
+	}
+	if len(st.TopByQuantity) != 2 || st.TopByQuantity[0].Group != "Tomatoes" || st.TopByQuantity[1].Group != "Carrots" {
+		t.Errorf("TopByQuantity = %+v, want Tomatoes then Carrots", st.TopByQuantity)
+	}
+	if len(st.TopBySales) != 2 || st.TopBySales[0].Group != "Tomatoes" || st.TopBySales[1].Group != "Honey" {
+		t.Errorf("TopBySales = %+v, want Tomatoes then Honey", st.TopBySales)
+	}
+	if len(st.Departments) != 2 || st.Departments[0].Group != "Produce" || st.Departments[0].Sales != 66 {
+		t.Errorf("Departments = %+v, want Produce first with $66", st.Departments)
+	}
+}
+
+func TestStatisticsEdges(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse(DateLayout, s)
+		return d
+	}
+	week, _ := Parse(strings.NewReader("Item,Department,Quantity,Net Sales\nTomatoes,Produce,70,$140.00\n"))
+	snapshots := []Snapshot{{Start: day("2014-03-10"), End: day("2014-03-16"), Table: week}}
+
+	// No snapshot covers the window. Lists are empty rather than nil, so
+	// they are served as [] rather than null.
+	for _, st := range []*Stats{
+		Statistics(snapshots, day("2014-04-01"), day("2014-04-07"), 10),
+		Statistics(nil, day("2014-03-10"), day("2014-03-16"), 10),
+	} {
+		if st.Items != 0 || st.Quantity != 0 || st.TopByQuantity == nil || st.TopBySales == nil || st.Departments == nil || len(st.Departments) != 0 {
+			t.Errorf("Statistics() of nothing = %+v, want zeros and empty lists", st)
+		}
+	}
+
+	// A window of a single day, and more top items asked for than were sold.
+	st := Statistics(snapshots, day("2014-03-16"), day("2014-03-16"), 10)
+	if st.Quantity != 10 || st.Sales != 20 || len(st.TopByQuantity) != 1 || len(st.TopBySales) != 1 {
+		t.Errorf("Statistics() of one day = %+v, want Tomatoes, 10 for $20", st)
+	}
+}
@@ -0,0 +1,65 @@
+package report
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+)
+
+// A Reader reads a CSV report a row at a time, so large reports can be
+// served without holding every row in memory.
+//     r, err := report.NewReader(f)
+//     for row, err := r.Read(); err == nil; row, err = r.Read() { ... }
+type Reader struct {
+	Header []string
+	cr     *csv.Reader
+}
+
+// NewReader() returns a Reader of the CSV report read from r, having read
+// its header. The first record is treated as the header, as by Parse().
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return &Reader{cr: cr}, nil
+	} else if err != nil {
+		return nil, errors.New("Failed to parse report. " + err.Error())
+	}
+
+	h := make([]string, len(header))
+	for i := range header {
+		h[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+
+	return &Reader{Header: h, cr: cr}, nil
+}
+
+// Read() returns the next row keyed by column name, or io.EOF after the
+// last.
+func (r *Reader) Read() (Row, error) {
+	if r.Header == nil {
+		return nil, io.EOF
+	}
+
+	record, err := r.cr.Read()
+	if err == io.EOF {
+		return nil, err
+	} else if err != nil {
+		return nil, errors.New("Failed to parse report. " + err.Error())
+	}
+
+	row := make(Row, len(r.Header))
+	for i, h := range r.Header {
+		if i < len(record) {
+			row[h] = record[i]
+		} else {
+			row[h] = ""
+		}
+	}
+
+	return row, nil
+}
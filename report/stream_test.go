@@ -0,0 +1,40 @@
+package report
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	r, err := NewReader(strings.NewReader("\ufeffItem, Quantity\nTomatoes,4\nEggs\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Header) != 2 || r.Header[0] != "Item" || r.Header[1] != "Quantity" {
+		t.Errorf("Header = %q, want [Item Quantity]", r.Header)
+	}
+
+	var rows []Row
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+
+	if len(rows) != 2 || rows[0]["Quantity"] != "4" || rows[1]["Item"] != "Eggs" || rows[1]["Quantity"] != "" {
+		t.Errorf("rows = %v, want Tomatoes 4 and Eggs with no quantity", rows)
+	}
+
+	empty, err := NewReader(strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := empty.Read(); err != io.EOF {
+		t.Errorf("Read() of an empty report = %v, want io.EOF", err)
+	}
+}
@@ -0,0 +1,199 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// A VendorMap assigns the items sold by the market to the vendors they are
+// sold on behalf of. Items are matched by name first, then by department.
+// Generally, it should be created with LoadVendors()
+type VendorMap struct {
+	items       map[string]string  // Lower case item name to vendor.
+	departments map[string]string  // Lower case department name to vendor.
+	commission  map[string]float64 // Vendor to commission percentage, when it differs from the default.
+}
+
+// A Payout is what one vendor is owed for their sales.
+type Payout struct {
+	Vendor     string  `json:"vendor"`
+	Quantity   float64 `json:"quantity"`
+	Sales      float64 `json:"sales"`
+	Rate       float64 `json:"commission_rate"` // The market's commission as a percentage.
+	Commission float64 `json:"commission"`
+	Payout     float64 `json:"payout"`
+}
+
+// LoadVendors() reads a vendor mapping CSV with the columns Vendor, Item,
+// Department and, optionally, Commission. Each row maps either an item or a
+// whole department to a vendor. A Commission overrides the default
+// percentage for that vendor. Vendor names must differ by more than case
+// and punctuation, as each has a folder named by Slug().
+//     Vendor,Item,Department,Commission
+//     Hillside Farm,,Produce,
+//     Bee Happy,Wildflower Honey,,10
+func LoadVendors(p string) (*VendorMap, error) {
+	t, err := ReadFile(p)
+	if err != nil {
+		return nil, errors.New("Failed to read vendor mapping. " + err.Error())
+	}
+
+	v, item, dept, rate := t.Column("Vendor"), t.Column("Item"), t.Column("Department"), t.Column("Commission")
+	if v < 0 || (item < 0 && dept < 0) {
+		return nil, errors.New("Vendor mapping must have a Vendor column and an Item or Department column")
+	}
+
+	m := &VendorMap{
+		items:       make(map[string]string),
+		departments: make(map[string]string),
+		commission:  make(map[string]float64),
+	}
+
+	slugs := make(map[string]string)
+	for r := range t.Rows {
+		vendor := strings.TrimSpace(t.Value(r, v))
+		if vendor == "" {
+			continue
+		}
+
+		// Each vendor's files are kept under their slug, so two can't share one.
+		slug := Slug(vendor)
+		if slug == "" {
+			return nil, errors.New("Vendor " + vendor + " needs a letter or digit in its name")
+		}
+		if other, ok := slugs[slug]; ok && other != vendor {
+			return nil, errors.New("Vendors " + other + " and " + vendor + " would share the folder vendors/" + slug + ". Rename one")
+		}
+		slugs[slug] = vendor
+
+		if i := strings.TrimSpace(t.Value(r, item)); i != "" {
+			m.items[strings.ToLower(i)] = vendor
+		} else if d := strings.TrimSpace(t.Value(r, dept)); d != "" {
+			m.departments[strings.ToLower(d)] = vendor
+		}
+
+		if c := strings.TrimSpace(strings.TrimSuffix(t.Value(r, rate), "%")); c != "" {
+			n, err := strconv.ParseFloat(c, 64)
+			if err != nil {
+				return nil, errors.New("Invalid commission for " + vendor + ": " + c)
+			}
+			m.commission[vendor] = n
+		}
+	}
+
+	return m, nil
+}
+
+// Vendor() returns the vendor an item is sold on behalf of, or "" if it
+// belongs to the market.
+func (m *VendorMap) Vendor(item string, department string) string {
+	if v, ok := m.items[strings.ToLower(strings.TrimSpace(item))]; ok {
+		return v
+	}
+
+	return m.departments[strings.ToLower(strings.TrimSpace(department))]
+}
+
+// Split() divides the rows of a Sold Items report by vendor. Each vendor's
+// table has the same header as t. Rows belonging to the market are dropped.
+func (m *VendorMap) Split(t *Table) map[string]*Table {
+	item, dept := t.Find(ItemColumns...), t.Find(DepartmentColumns...)
+
+	tables := make(map[string]*Table)
+	for r, row := range t.Rows {
+		vendor := m.Vendor(t.Value(r, item), t.Value(r, dept))
+		if vendor == "" {
+			continue
+		}
+
+		if tables[vendor] == nil {
+			tables[vendor] = &Table{Header: t.Header}
+		}
+		tables[vendor].Rows = append(tables[vendor].Rows, row)
+	}
+
+	return tables
+}
+
+// Payouts() totals each vendor's sales in t and deducts the market's
+// commission. rate is the default commission percentage.
+// Payouts are ordered by vendor.
+func (m *VendorMap) Payouts(t *Table, rate float64) []Payout {
+	var payouts []Payout
+	for vendor, vt := range m.Split(t) {
+		p := Payout{Vendor: vendor, Rate: rate}
+		if r, ok := m.commission[vendor]; ok {
+			p.Rate = r
+		}
+
+		q, s := vt.Find(QuantityColumns...), vt.Find(SalesColumns...)
+		for r := range vt.Rows {
+			p.Quantity += Number(vt.Value(r, q))
+			p.Sales += Number(vt.Value(r, s))
+		}
+
+		p.Commission = round(p.Sales * p.Rate / 100)
+		p.Payout = round(p.Sales - p.Commission)
+		payouts = append(payouts, p)
+	}
+
+	sort.Slice(payouts, func(i, j int) bool {
+		return payouts[i].Vendor < payouts[j].Vendor
+	})
+
+	return payouts
+}
+
+// PayoutCSV() formats payouts for the days from through to as a CSV.
+func PayoutCSV(payouts []Payout, from string, to string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write([]string{"From", "To", "Vendor", "Quantity", "Sales", "Commission Rate", "Commission", "Payout"})
+	for _, p := range payouts {
+		w.Write([]string{
+			from,
+			to,
+			p.Vendor,
+			strconv.FormatFloat(p.Quantity, 'f', 2, 64),
+			strconv.FormatFloat(p.Sales, 'f', 2, 64),
+			strconv.FormatFloat(p.Rate, 'f', -1, 64),
+			strconv.FormatFloat(p.Commission, 'f', 2, 64),
+			strconv.FormatFloat(p.Payout, 'f', 2, 64),
+		})
+	}
+
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// Slug() turns a vendor name into a name safe to use as a file or directory
+// name: "Bee Happy, LLC" becomes "bee_happy_llc".
+func Slug(name string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+			underscore = false
+		} else if !underscore && b.Len() > 0 {
+			b.WriteRune('_')
+			underscore = true
+		}
+	}
+
+	return strings.TrimSuffix(b.String(), "_")
+}
+
+// Rounds an amount of money to the nearest cent.
+func round(n float64) float64 {
+	if n < 0 {
+		return -round(-n)
+	}
+	return float64(int64(n*100+0.5)) / 100
+}
@@ -0,0 +1,63 @@
+package report
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPayouts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := filepath.Join(dir, "vendors.csv")
+	ioutil.WriteFile(p, []byte("Vendor,Item,Department,Commission\nHillside Farm,,Produce,\nBee Happy,Wildflower Honey,,10\n"), 0644)
+
+	vendors, err := LoadVendors(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sold, _ := Parse(strings.NewReader("Item,Department,Quantity,Net Sales\n" +
+		"Tomatoes,Produce,10,$20.00\n" +
+		"Wildflower Honey,Pantry,2,$16.00\n" +
+		"Tote Bag,Market,1,$5.00\n"))
+
+	payouts := vendors.Payouts(sold, 20)
+	if len(payouts) != 2 {
+		t.Fatalf("Payouts = %+v, want 2 vendors", payouts)
+	}
+	if p := payouts[0]; p.Vendor != "Bee Happy" || p.Commission != 1.6 || p.Payout != 14.4 {
+		t.Errorf("Bee Happy payout = %+v, want 10%% commission on $16.00", p)
+	}
+	if p := payouts[1]; p.Vendor != "Hillside Farm" || p.Commission != 4 || p.Payout != 16 {
+		t.Errorf("Hillside Farm payout = %+v, want 20%% commission on $20.00", p)
+	}
+}
+
+func TestLoadVendorsSlugs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vendors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for mapping, ok := range map[string]bool{
+		"Vendor,Department\nHillside Farm,Produce\nHillside Farm,Dairy\n":  true,
+		"Vendor,Department\nHillside Farm,Produce\nHillside farm!,Dairy\n": false,
+		"Vendor,Department\nHillside Farm,Produce\nHillside-Farm,Dairy\n":  false,
+		"Vendor,Department\n***,Produce\n":                                 false,
+		"Vendor,Department\nHillside Farm,Produce\nHillside Farms,Dairy\n": true,
+	} {
+		p := filepath.Join(dir, "vendors.csv")
+		ioutil.WriteFile(p, []byte(mapping), 0644)
+		if _, err := LoadVendors(p); (err == nil) != ok {
+			t.Errorf("LoadVendors(%q) returned %v, want ok %v", mapping, err, ok)
+		}
+	}
+}
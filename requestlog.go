@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"github.com/jfmarket/report-cacher/download/fixture"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The most bytes of each body -tracehttpbodies logs.
+const loggedBodyLimit = 4096
+
+// Form fields whose values -tracehttpbodies never logs.
+var loggedSecrets = []string{"password", "login", "email", "token", "secret"}
+
+// logRequests() logs every request made through http.DefaultTransport, which
+// the providers use: its method and address, the status, redirect and size
+// of the response, and how long it took to start and finish. bodies also
+// logs the start of text bodies, with secrets replaced.
+func logRequests(bodies bool) {
+	http.DefaultTransport = &loggedTransport{base: http.DefaultTransport, bodies: bodies}
+}
+
+// A loggedTransport logs the requests made through it. See logRequests().
+type loggedTransport struct {
+	base   http.RoundTripper
+	bodies bool
+}
+
+func (t *loggedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	line := "HTTP " + req.Method + " " + string(fixture.Sanitize([]byte(req.URL.String())))
+	if req.ContentLength > 0 {
+		line += " (" + strconv.FormatInt(req.ContentLength, 10) + " bytes)"
+	}
+	if t.bodies && req.GetBody != nil && loggable(req.Header.Get("Content-Type")) {
+		if body, err := req.GetBody(); err == nil {
+			data, _ := ioutil.ReadAll(io.LimitReader(body, loggedBodyLimit))
+			body.Close()
+			log.Println(line + " sent: " + redactBody(req.Header.Get("Content-Type"), data))
+		}
+	}
+
+	began := time.Now()
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		log.Println(line + ": failed after " + since(began) + ". Error: " + err.Error())
+		return res, err
+	}
+
+	line += ": " + res.Status
+	if loc := res.Header.Get("Location"); loc != "" {
+		line += " to " + string(fixture.Sanitize([]byte(loc)))
+	}
+	line += ", headers after " + since(began)
+
+	b := &loggedBody{ReadCloser: res.Body, line: line, began: began}
+	if t.bodies && loggable(res.Header.Get("Content-Type")) {
+		b.contentType = res.Header.Get("Content-Type")
+		b.keep = true
+	}
+	res.Body = b
+	return res, nil
+}
+
+// A loggedBody logs its request's line once it has been read or closed.
+type loggedBody struct {
+	io.ReadCloser
+	line        string
+	began       time.Time
+	n           int64
+	keep        bool // Whether to log the start of the body.
+	contentType string
+	start       bytes.Buffer
+
+	once sync.Once
+}
+
+func (b *loggedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if b.keep && b.start.Len() < loggedBodyLimit {
+		rest := loggedBodyLimit - b.start.Len()
+		if rest > n {
+			rest = n
+		}
+		b.start.Write(p[:rest])
+	}
+	if err == io.EOF {
+		b.log()
+	}
+	return n, err
+}
+
+func (b *loggedBody) Close() error {
+	b.log()
+	return b.ReadCloser.Close()
+}
+
+// log() logs the request once, with the bytes of the body read so far.
+func (b *loggedBody) log() {
+	b.once.Do(func() {
+		line := b.line + ", " + strconv.FormatInt(b.n, 10) + " bytes in " + since(b.began)
+		if b.keep {
+			line += ". Received: " + redactBody(b.contentType, b.start.Bytes())
+		}
+		log.Println(line)
+	})
+}
+
+// Returns how long it has been since t, to the millisecond.
+func since(t time.Time) string {
+	return time.Since(t).Round(time.Millisecond).String()
+}
+
+// loggable() reports whether a body of contentType is text worth logging,
+// rather than a report or an image.
+func loggable(contentType string) bool {
+	t, _, _ := mime.ParseMediaType(contentType)
+	return t == "text/html" || t == "application/json" || t == "application/x-www-form-urlencoded" || strings.HasSuffix(t, "+json")
+}
+
+// redactBody() returns data, a body of contentType, with secrets replaced:
+// the values of form fields such as password, and whatever
+// fixture.Sanitize() replaces.
+func redactBody(contentType string, data []byte) string {
+	if t, _, _ := mime.ParseMediaType(contentType); t == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(string(data)); err == nil {
+			for k := range form {
+				for _, s := range loggedSecrets {
+					if strings.Contains(strings.ToLower(k), s) {
+						form[k] = []string{fixture.Replaced}
+					}
+				}
+			}
+			data = []byte(form.Encode())
+		}
+	}
+
+	return strings.Join(strings.Fields(string(fixture.Sanitize(data))), " ")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"github.com/jfmarket/report-cacher/store"
+	"log"
+	"strconv"
+	"time"
+)
+
+// applyRetention() trims old report versions to save disk space: those
+// older than -compressafter days are gzipped in place, and those covering
+// months that have closed are moved into the months' archives, such as
+// archive/2014-03.zip, once the last version covering each is older than
+// -archiveafter days. See archiveMonth(). Months are archived whole, so
+// each month's archive is written once per report. The newest version of
+// each report is always kept as it is, along with the rest of the months it
+// covers.
+func applyRetention() error {
+	now := wallClock.Now()
+	for _, name := range reportNames {
+		versions, err := cache.Versions(name)
+		if err != nil || len(versions) < 2 {
+			continue
+		}
+
+		// When the last version covering each month was downloaded. The
+		// months the newest version covers haven't closed.
+		open := make(map[time.Time]bool)
+		for _, m := range versionMonths(versions[len(versions)-1]) {
+			open[m] = true
+		}
+		last := make(map[time.Time]time.Time)
+		for _, v := range versions {
+			for _, m := range versionMonths(v) {
+				last[m] = v.Time
+			}
+		}
+		closed := func(months []time.Time) bool {
+			for _, m := range months {
+				if open[m] || now.Sub(last[m]) <= days(*archiveAfter) {
+					return false
+				}
+			}
+			return true
+		}
+
+		byMonth := make(map[time.Time][]store.Version)
+		var archived []store.Version
+		for _, v := range versions[:len(versions)-1] {
+			months := versionMonths(v)
+			switch {
+			case *archiveAfter > 0 && closed(months):
+				for _, m := range months {
+					byMonth[m] = append(byMonth[m], v)
+				}
+				archived = append(archived, v)
+			case *compressAfter > 0 && now.Sub(v.Time) > days(*compressAfter):
+				if _, err := cache.Compress(v); err != nil {
+					return errors.New("Failed to compress " + v.Path + ". " + err.Error())
+				}
+			}
+		}
+
+		for month, vs := range byMonth {
+			if err := archiveMonth(month, vs); err != nil {
+				return err
+			}
+		}
+		if len(archived) > 0 {
+			log.Println("Moved " + strconv.Itoa(len(archived)) + " old versions of " + name + " into archive/")
+		}
+		for _, v := range archived {
+			if err := cache.Delete(v); err != nil {
+				return errors.New("Failed to remove " + v.Path + " once archived. " + err.Error())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the first day of each month version v covers, or of the month it
+// was downloaded in if it doesn't cover a range of days, matching the
+// versions archiveMonths() bundles. See coversDays().
+func versionMonths(v store.Version) []time.Time {
+	start, err1 := businessDate(v.Start)
+	end, err2 := businessDate(v.End)
+	if err1 != nil || err2 != nil {
+		start = v.Time.In(businessZone)
+		end = start
+	}
+
+	var months []time.Time
+	for m := time.Date(start.Year(), start.Month(), 1, 0, 0, 0, 0, businessZone); !m.After(end); m = m.AddDate(0, 1, 0) {
+		months = append(months, m)
+	}
+	return months
+}
+
+// Returns n days as a duration.
+func days(n int) time.Duration {
+	return time.Duration(n) * 24 * time.Hour
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"archive/zip"
+	"github.com/jfmarket/report-cacher/clock"
+	"github.com/jfmarket/report-cacher/store"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the versions of sold_items archived in month's zip, by time.
+func archivedVersions(t *testing.T, month string) []string {
+	t.Helper()
+	z, err := zip.OpenReader(filepath.Join(cache.Dir(), "archive", month+".zip"))
+	if err != nil {
+		return nil
+	}
+	defer z.Close()
+
+	var got []string
+	for _, f := range z.File {
+		if !strings.HasSuffix(f.Name, ".json") {
+			got = append(got, strings.TrimSuffix(filepath.Base(f.Name), ".csv"))
+		}
+	}
+	sort.Strings(got)
+	return got
+}
+
+func TestApplyRetention(t *testing.T) {
+	c := clock.NewFake(time.Date(2014, 6, 10, 12, 0, 0, 0, time.UTC))
+	oldCache, oldClock, oldZone, oldArchive, oldCompress := cache, wallClock, businessZone, *archiveAfter, *compressAfter
+	defer func() {
+		cache, wallClock, businessZone, *archiveAfter, *compressAfter = oldCache, oldClock, oldZone, oldArchive, oldCompress
+	}()
+	wallClock, businessZone, *archiveAfter, *compressAfter = c, time.UTC, 30, 0
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	for _, day := range []string{"2014-03-05", "2014-03-28", "2014-04-10", "2014-05-01", "2014-05-25", "2014-06-02"} {
+		d, _ := time.Parse("2006-01-02", day)
+		if _, err := cache.Import("sold_items", []byte("Item\n"+day+"\n"), day, day, d.Add(8*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Each step runs applyRetention() after the clock advances.
+	tests := []struct {
+		advance time.Duration
+		kept    []string
+		zips    map[string][]string
+	}{
+		// May's last version is too new, so none of May is archived.
+		{0, []string{"20140501T080000Z", "20140525T080000Z", "20140602T080000Z"}, map[string][]string{
+			"2014-03": {"20140305T080000Z", "20140328T080000Z"},
+			"2014-04": {"20140410T080000Z"},
+			"2014-05": nil,
+		}},
+		// Until it is, and all of it is. June holds the newest version.
+		{20 * 24 * time.Hour, []string{"20140602T080000Z"}, map[string][]string{
+			"2014-03": {"20140305T080000Z", "20140328T080000Z"},
+			"2014-05": {"20140501T080000Z", "20140525T080000Z"},
+			"2014-06": nil,
+		}},
+	}
+
+	for i, tt := range tests {
+		c.Advance(tt.advance)
+		if err := applyRetention(); err != nil {
+			t.Fatal(err)
+		}
+
+		versions, _ := cache.Versions("sold_items")
+		var kept []string
+		for _, v := range versions {
+			kept = append(kept, v.Time.Format("20060102T150405Z"))
+		}
+		if strings.Join(kept, ",") != strings.Join(tt.kept, ",") {
+			t.Errorf("Step %d: kept %v, want %v", i, kept, tt.kept)
+		}
+		for month, want := range tt.zips {
+			if got := archivedVersions(t, month); strings.Join(got, ",") != strings.Join(want, ",") {
+				t.Errorf("Step %d: archived %v in %s, want %v", i, got, month, want)
+			}
+		}
+	}
+}
+
+func TestApplyRetentionWritesOnce(t *testing.T) {
+	c := clock.NewFake(time.Date(2014, 6, 10, 12, 0, 0, 0, time.UTC))
+	oldCache, oldClock, oldZone, oldArchive, oldCompress := cache, wallClock, businessZone, *archiveAfter, *compressAfter
+	defer func() {
+		cache, wallClock, businessZone, *archiveAfter, *compressAfter = oldCache, oldClock, oldZone, oldArchive, oldCompress
+	}()
+	wallClock, businessZone, *archiveAfter, *compressAfter = c, time.UTC, 1, 0
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	p := filepath.Join(cache.Dir(), "archive", "2014-06.zip")
+
+	// Versions downloaded through June stay out of its zip until it has
+	// closed, however old they are.
+	for day := 1; day <= 30; day++ {
+		at := time.Date(2014, 6, day, 8, 0, 0, 0, time.UTC)
+		c.Advance(at.Sub(c.Now()))
+		if _, err := cache.Import("sold_items", []byte("Item\n"+at.String()+"\n"), "", "", at); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyRetention(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadFile(p); err == nil {
+			t.Fatalf("June was archived on June %d, want it kept until it has closed", day)
+		}
+	}
+
+	// Once July's first version is more than a day old, the whole of June
+	// is archived at once.
+	at := time.Date(2014, 7, 1, 8, 0, 0, 0, time.UTC)
+	c.Advance(at.Sub(c.Now()))
+	if _, err := cache.Import("sold_items", []byte("Item\nJuly\n"), "", "", at); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyRetention(); err != nil {
+		t.Fatal(err)
+	}
+	if got := archivedVersions(t, "2014-06"); got != nil {
+		t.Fatalf("June's last version was less than a day old, but %d versions were archived", len(got))
+	}
+	c.Advance(2 * 24 * time.Hour)
+	if err := applyRetention(); err != nil {
+		t.Fatal(err)
+	}
+	if got := archivedVersions(t, "2014-06"); len(got) != 30 {
+		t.Errorf("Archived %d versions of June, want 30", len(got))
+	}
+	if versions, _ := cache.Versions("sold_items"); len(versions) != 1 {
+		t.Errorf("Kept %d versions, want only July's", len(versions))
+	}
+}
+
+func TestApplyRetentionSpansMonths(t *testing.T) {
+	c := clock.NewFake(time.Date(2014, 6, 10, 12, 0, 0, 0, time.UTC))
+	oldCache, oldClock, oldZone, oldArchive, oldCompress := cache, wallClock, businessZone, *archiveAfter, *compressAfter
+	defer func() {
+		cache, wallClock, businessZone, *archiveAfter, *compressAfter = oldCache, oldClock, oldZone, oldArchive, oldCompress
+	}()
+	wallClock, businessZone, *archiveAfter, *compressAfter = c, time.UTC, 30, 0
+
+	var err error
+	if cache, err = store.New(filepath.Join(t.TempDir(), "reports")); err != nil {
+		t.Fatal(err)
+	}
+	// A week spanning March and April, then one in June.
+	for _, week := range [][2]string{{"2014-03-28", "2014-04-03"}, {"2014-06-01", "2014-06-07"}} {
+		d, _ := time.Parse("2006-01-02", week[1])
+		if _, err := cache.Import("sold_items", []byte("Item\n"+week[0]+"\n"), week[0], week[1], d.Add(8*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := applyRetention(); err != nil {
+		t.Fatal(err)
+	}
+	for _, month := range []string{"2014-03", "2014-04"} {
+		if got := archivedVersions(t, month); strings.Join(got, ",") != "20140403T080000Z" {
+			t.Errorf("Archived %v in %s, want the week spanning it", got, month)
+		}
+	}
+	if versions, _ := cache.Versions("sold_items"); len(versions) != 1 {
+		t.Errorf("Kept %d versions, want only June's", len(versions))
+	}
+}
@@ -0,0 +1,123 @@
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"google.golang.org/grpc"
+	"io"
+)
+
+// A Client calls the ReportCache service.
+// Generally, it should be created with NewClient()
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// Returns a Client that calls the service over conn.
+//     conn, err := grpc.Dial("localhost:8086", grpc.WithTransportCredentials(insecure.NewCredentials()))
+//     client := rpc.NewClient(conn)
+func NewClient(conn *grpc.ClientConn) *Client {
+	return &Client{conn: conn}
+}
+
+// BasicAuth is the username and password sent with every call, for a cache
+// started with -users. Pass it to grpc.Dial() with
+// grpc.WithPerRPCCredentials(). Without TLS the password is sent in the
+// clear, as with HTTP Basic Auth.
+//     grpc.WithPerRPCCredentials(rpc.BasicAuth{Username: "manager", Password: "mypassword"})
+type BasicAuth struct {
+	Username string
+	Password string
+}
+
+func (a BasicAuth) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	login := base64.StdEncoding.EncodeToString([]byte(a.Username + ":" + a.Password))
+	return map[string]string{"authorization": "Basic " + login}, nil
+}
+
+func (BasicAuth) RequireTransportSecurity() bool {
+	return false
+}
+
+// Selects the JSON codec the service speaks.
+var jsonCodec = grpc.CallContentSubtype("json")
+
+// ListReports() lists every report the cache knows about.
+func (c *Client) ListReports(ctx context.Context) ([]ReportInfo, error) {
+	out := new(ListReportsResponse)
+	err := c.conn.Invoke(ctx, "/"+ServiceName+"/ListReports", &Empty{}, out, jsonCodec)
+	if err != nil {
+		return nil, err
+	}
+	return out.Reports, nil
+}
+
+// TriggerRefresh() asks the cache to download every report now.
+// It returns false if a refresh was already waiting.
+func (c *Client) TriggerRefresh(ctx context.Context) (bool, error) {
+	out := new(TriggerRefreshResponse)
+	err := c.conn.Invoke(ctx, "/"+ServiceName+"/TriggerRefresh", &Empty{}, out, jsonCodec)
+	if err != nil {
+		return false, err
+	}
+	return out.Queued, nil
+}
+
+// GetReport() writes the current copy of report name to w and returns its details.
+func (c *Client) GetReport(ctx context.Context, name string, w io.Writer) (*ReportInfo, error) {
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/GetReport", jsonCodec)
+	if err != nil {
+		return nil, err
+	}
+	if err := stream.SendMsg(&GetReportRequest{Name: name}); err != nil {
+		return nil, err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	var info *ReportInfo
+	for {
+		chunk := new(ReportChunk)
+		if err := stream.RecvMsg(chunk); err == io.EOF {
+			return info, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if chunk.Info != nil {
+			info = chunk.Info
+		}
+		if _, err := w.Write(chunk.Data); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// WatchEvents() calls f with every event until ctx is cancelled, the
+// stream fails or f returns false.
+func (c *Client) WatchEvents(ctx context.Context, f func(*Event) bool) error {
+	stream, err := c.conn.NewStream(ctx, &serviceDesc.Streams[1], "/"+ServiceName+"/WatchEvents", jsonCodec)
+	if err != nil {
+		return err
+	}
+	if err := stream.SendMsg(&Empty{}); err != nil {
+		return err
+	}
+	if err := stream.CloseSend(); err != nil {
+		return err
+	}
+
+	for {
+		e := new(Event)
+		if err := stream.RecvMsg(e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if !f(e) {
+			return nil
+		}
+	}
+}
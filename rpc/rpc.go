@@ -0,0 +1,177 @@
+// This package defines the ReportCache gRPC service used by Go programs to
+// consume the cache with typed clients.
+//
+// Messages are encoded as JSON rather than protocol buffers (the gRPC
+// content-subtype is "json"), so the service needs no generated code.
+// Clients made with NewClient() select the codec automatically.
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"time"
+)
+
+// The full name of the service.
+const ServiceName = "reportcacher.ReportCache"
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// codec marshals messages as JSON.
+type codec struct{}
+
+func (codec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (codec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (codec) Name() string                               { return "json" }
+
+// An empty request or response.
+type Empty struct{}
+
+// ReportInfo describes the current copy of a report.
+type ReportInfo struct {
+	Name      string    `json:"name"`
+	Updated   time.Time `json:"updated"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// ListReportsResponse lists every report the cache knows about.
+type ListReportsResponse struct {
+	Reports []ReportInfo `json:"reports"`
+}
+
+// GetReportRequest names the report to fetch.
+type GetReportRequest struct {
+	Name string `json:"name"`
+}
+
+// ReportChunk is one piece of a report's contents. The first chunk of a
+// stream also carries the report's details.
+type ReportChunk struct {
+	Info *ReportInfo `json:"info,omitempty"`
+	Data []byte      `json:"data"`
+}
+
+// TriggerRefreshResponse reports whether a refresh was queued. It is false
+// when a refresh was already waiting.
+type TriggerRefreshResponse struct {
+	Queued bool `json:"queued"`
+}
+
+// Event is a change to the cache, as published on /api/events.
+type Event struct {
+	ID     int64     `json:"id"`
+	Type   string    `json:"type"`
+	Time   time.Time `json:"time"`
+	Report string    `json:"report,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// ReportCacheServer is implemented by the cache.
+type ReportCacheServer interface {
+	ListReports(context.Context, *Empty) (*ListReportsResponse, error)
+	GetReport(*GetReportRequest, ReportCache_GetReportServer) error
+	TriggerRefresh(context.Context, *Empty) (*TriggerRefreshResponse, error)
+	WatchEvents(*Empty, ReportCache_WatchEventsServer) error
+}
+
+// ReportCache_GetReportServer sends the chunks of a report.
+type ReportCache_GetReportServer interface {
+	Send(*ReportChunk) error
+	grpc.ServerStream
+}
+
+// ReportCache_WatchEventsServer sends events as they happen.
+type ReportCache_WatchEventsServer interface {
+	Send(*Event) error
+	grpc.ServerStream
+}
+
+// RegisterReportCacheServer() registers srv with s.
+func RegisterReportCacheServer(s *grpc.Server, srv ReportCacheServer) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ReportCacheServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListReports",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(Empty)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(ReportCacheServer).ListReports(ctx, req.(*Empty))
+				}
+				if interceptor == nil {
+					return call(ctx, in)
+				}
+				return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/ListReports"}, call)
+			},
+		},
+		{
+			MethodName: "TriggerRefresh",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(Empty)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(ReportCacheServer).TriggerRefresh(ctx, req.(*Empty))
+				}
+				if interceptor == nil {
+					return call(ctx, in)
+				}
+				return interceptor(ctx, in, &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/TriggerRefresh"}, call)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetReport",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := new(GetReportRequest)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(ReportCacheServer).GetReport(in, &getReportServer{stream})
+			},
+		},
+		{
+			StreamName:    "WatchEvents",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := new(Empty)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(ReportCacheServer).WatchEvents(in, &watchEventsServer{stream})
+			},
+		},
+	},
+}
+
+type getReportServer struct {
+	grpc.ServerStream
+}
+
+func (s *getReportServer) Send(c *ReportChunk) error {
+	return s.ServerStream.SendMsg(c)
+}
+
+type watchEventsServer struct {
+	grpc.ServerStream
+}
+
+func (s *watchEventsServer) Send(e *Event) error {
+	return s.ServerStream.SendMsg(e)
+}
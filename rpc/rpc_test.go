@@ -0,0 +1,63 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"net"
+	"testing"
+)
+
+type fakeServer struct{}
+
+func (fakeServer) ListReports(context.Context, *Empty) (*ListReportsResponse, error) {
+	return &ListReportsResponse{Reports: []ReportInfo{{Name: "sold_items", Size: 11}}}, nil
+}
+
+func (fakeServer) GetReport(req *GetReportRequest, stream ReportCache_GetReportServer) error {
+	stream.Send(&ReportChunk{Info: &ReportInfo{Name: req.Name}, Data: []byte("Item,")})
+	return stream.Send(&ReportChunk{Data: []byte("Quantity\n")})
+}
+
+func (fakeServer) TriggerRefresh(context.Context, *Empty) (*TriggerRefreshResponse, error) {
+	return &TriggerRefreshResponse{Queued: true}, nil
+}
+
+func (fakeServer) WatchEvents(*Empty, ReportCache_WatchEventsServer) error {
+	return nil
+}
+
+func TestClient(t *testing.T) {
+	l := bufconn.Listen(1 << 16)
+	s := grpc.NewServer()
+	RegisterReportCacheServer(s, fakeServer{})
+	go s.Serve(l)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewClient(conn)
+	ctx := context.Background()
+
+	reports, err := client.ListReports(ctx)
+	if err != nil || len(reports) != 1 || reports[0].Name != "sold_items" {
+		t.Errorf("ListReports() = %v, %v", reports, err)
+	}
+
+	var buf bytes.Buffer
+	info, err := client.GetReport(ctx, "sold_items", &buf)
+	if err != nil || info == nil || info.Name != "sold_items" || buf.String() != "Item,Quantity\n" {
+		t.Errorf("GetReport() = %v, %q, %v", info, buf.String(), err)
+	}
+
+	if queued, err := client.TriggerRefresh(ctx); err != nil || !queued {
+		t.Errorf("TriggerRefresh() = %v, %v", queued, err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"github.com/jfmarket/report-cacher/rpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	grpcstatus "google.golang.org/grpc/status"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// rpcPaths are the API paths whose logins, vendor limits, rate limits and
+// audit logging each gRPC method follows. GetReport's is followed by the
+// name of the report asked for.
+var rpcPaths = map[string]string{
+	"/" + rpc.ServiceName + "/ListReports":    "/api/reports",
+	"/" + rpc.ServiceName + "/GetReport":      "/api/reports/",
+	"/" + rpc.ServiceName + "/TriggerRefresh": "/api/refresh",
+	"/" + rpc.ServiceName + "/WatchEvents":    "/api/events",
+}
+
+// newRPCServer() returns a gRPC server whose calls are checked by gateRPC().
+func newRPCServer() *grpc.Server {
+	return grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			var res interface{}
+			err := gateRPC(ctx, info.FullMethod, "", func(ctx context.Context) error {
+				var err error
+				res, err = handler(ctx, req)
+				return err
+			})
+			return res, err
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			// The report asked for is only known from the request, so it
+			// is read before the call is checked.
+			name, s := "", &rpcStream{ServerStream: ss}
+			if info.FullMethod == "/"+rpc.ServiceName+"/GetReport" {
+				s.first = new(rpc.GetReportRequest)
+				if err := ss.RecvMsg(s.first); err != nil {
+					return err
+				}
+				name = s.first.Name
+			}
+			return gateRPC(ss.Context(), info.FullMethod, name, func(ctx context.Context) error {
+				s.ctx = ctx
+				return handler(srv, s)
+			})
+		}),
+	)
+}
+
+// gateRPC() checks the gRPC call to method, made with ctx, the same way the
+// webserver checks a request for its path in rpcPaths: by requestLimiter,
+// by -users or -oidcissuer logins given in the call's metadata, limiting
+// vendors to their own files, and recording report downloads in -audit. It
+// runs the call with the user's context if it is let through. report is
+// the name of the report the call reads, if it reads one.
+func gateRPC(ctx context.Context, method string, report string, run func(ctx context.Context) error) error {
+	p, ok := rpcPaths[method]
+	if !ok {
+		return grpcstatus.Error(codes.Unimplemented, "Unknown method "+method)
+	}
+	p += url.PathEscape(report)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	var remote string
+	if pr, ok := peer.FromContext(ctx); ok {
+		remote = pr.Addr.String()
+	}
+	ip := clientAddr(remote, md.Get("X-Forwarded-For"))
+
+	if requestLimiter != nil {
+		if ok, wait := requestLimiter.allow(ip, time.Now()); !ok {
+			return grpcstatus.Error(codes.ResourceExhausted, "Too many requests. Retry after "+retryAfter(wait)+" seconds.")
+		}
+	}
+
+	u, err := rpcUser(ctx, md, ip)
+	if err != nil {
+		return err
+	}
+	if u != nil {
+		if u.vendor != "" && !vendorMayAccess(u, p) {
+			return grpcstatus.Error(codes.PermissionDenied, "Forbidden")
+		}
+		ctx = context.WithValue(ctx, userKey{}, u)
+	}
+
+	err = run(ctx)
+
+	if name, ok := auditedReport(p); *auditFile != "" && ok {
+		e := auditEntry{
+			Time:   time.Now(),
+			Remote: ip,
+			Report: name,
+			Path:   p,
+			Status: rpcStatus(err),
+		}
+		if u != nil {
+			e.User = u.name
+		}
+		writeAudit(ctx, e)
+	}
+
+	return err
+}
+
+// rpcUser() returns the user making the gRPC call with metadata md from
+// remote: the user of its session cookie, or of the Basic Auth login in its
+// authorization. It is nil when logins are disabled. Calls without either
+// are refused as Unauthenticated.
+func rpcUser(ctx context.Context, md metadata.MD, remote string) (*user, error) {
+	if len(users) == 0 && oidcLogin == nil {
+		return nil, nil
+	}
+
+	for _, h := range md.Get("Cookie") {
+		for _, c := range strings.Split(h, ";") {
+			kv := strings.SplitN(strings.TrimSpace(c), "=", 2)
+			if len(kv) != 2 || kv[0] != sessionCookie {
+				continue
+			}
+			if u := cookieUser(kv[1]); u != nil {
+				return u, nil
+			}
+		}
+	}
+
+	for _, h := range md.Get("Authorization") {
+		name, password, ok := parseBasicAuth(h)
+		if !ok {
+			continue
+		}
+		if u := passwordUser(ctx, name, password, remote); u != nil {
+			return u, nil
+		}
+	}
+
+	return nil, grpcstatus.Error(codes.Unauthenticated, "Unauthorized")
+}
+
+// Returns the username and password of an Authorization header value using
+// HTTP Basic Auth, such as "Basic bWFuYWdlcjpzZWNyZXQ=".
+func parseBasicAuth(h string) (string, string, bool) {
+	const prefix = "Basic "
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", "", false
+	}
+	login, err := base64.StdEncoding.DecodeString(h[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+
+	parts := strings.SplitN(string(login), ":", 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// rpcStatus() returns the HTTP status closest to a gRPC call's outcome, err,
+// for the audit log.
+func rpcStatus(err error) int {
+	switch grpcstatus.Code(err) {
+	case codes.OK:
+		return http.StatusOK
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// An rpcStream is a gRPC stream carrying the user's context, whose first
+// request, if set, was already read by the stream interceptor.
+type rpcStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	first *rpc.GetReportRequest
+}
+
+func (s *rpcStream) Context() context.Context {
+	if s.ctx == nil {
+		return s.ServerStream.Context()
+	}
+	return s.ctx
+}
+
+func (s *rpcStream) RecvMsg(m interface{}) error {
+	if req, ok := m.(*rpc.GetReportRequest); ok && s.first != nil {
+		*req, s.first = *s.first, nil
+		return nil
+	}
+	return s.ServerStream.RecvMsg(m)
+}
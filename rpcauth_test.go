@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/jfmarket/report-cacher/rpc"
+	"github.com/jfmarket/report-cacher/store"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestRPCLogin(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	oldUsers, oldCache, oldAudit := users, cache, *auditFile
+	defer func() { users, cache, *auditFile = oldUsers, oldCache, oldAudit }()
+	users = map[string]*user{
+		"manager":  {name: "manager", hash: hash},
+		"hillside": {name: "hillside", hash: hash, vendor: "Hillside Farm"},
+	}
+	if cache, err = store.New(filepath.Join(dir, "reports")); err != nil {
+		t.Fatal(err)
+	}
+	*auditFile = filepath.Join(dir, "audit.jsonl")
+
+	l := bufconn.Listen(1 << 16)
+	s := newRPCServer()
+	rpc.RegisterReportCacheServer(s, rpcServer{})
+	go s.Serve(l)
+	defer s.Stop()
+
+	dial := func(opts ...grpc.DialOption) *rpc.Client {
+		opts = append(opts,
+			grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+			grpc.WithTransportCredentials(insecure.NewCredentials()))
+		conn, err := grpc.Dial("bufnet", opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return rpc.NewClient(conn)
+	}
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		login *rpc.BasicAuth
+		want  codes.Code
+	}{
+		{"no login", nil, codes.Unauthenticated},
+		{"wrong password", &rpc.BasicAuth{Username: "manager", Password: "guess"}, codes.Unauthenticated},
+		{"unknown user", &rpc.BasicAuth{Username: "nobody", Password: "secret"}, codes.Unauthenticated},
+		{"vendor", &rpc.BasicAuth{Username: "hillside", Password: "secret"}, codes.PermissionDenied},
+		{"administrator", &rpc.BasicAuth{Username: "manager", Password: "secret"}, codes.OK},
+	}
+	for _, tt := range tests {
+		var opts []grpc.DialOption
+		if tt.login != nil {
+			opts = append(opts, grpc.WithPerRPCCredentials(*tt.login))
+		}
+		c := dial(opts...)
+
+		if _, err := c.ListReports(ctx); grpcstatus.Code(err) != tt.want {
+			t.Errorf("%s: ListReports() returned %v, want %v", tt.name, err, tt.want)
+		}
+		if _, err := c.GetReport(ctx, "sold_items", &bytes.Buffer{}); tt.want != codes.OK && grpcstatus.Code(err) != tt.want {
+			t.Errorf("%s: GetReport() returned %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Only the administrator's request got through, for a report that
+	// hasn't been downloaded.
+	data, err := ioutil.ReadFile(*auditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e auditEntry
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatalf("Audit log is %q, want one entry. %v", data, err)
+	}
+	if e.User != "manager" || e.Report != "sold_items" || e.Status != 404 {
+		t.Errorf("Audit entry is %+v, want manager's request for sold_items answered 404", e)
+	}
+}
+
+func TestRPCSharesRateLimit(t *testing.T) {
+	old := requestLimiter
+	defer func() { requestLimiter = old }()
+	requestLimiter = newRateLimiter(0.01, 1)
+
+	l := bufconn.Listen(1 << 16)
+	s := newRPCServer()
+	rpc.RegisterReportCacheServer(s, rpcServer{})
+	go s.Serve(l)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The client's one request is spent on the webserver.
+	r := httptest.NewRequest("GET", "/api/reports", nil)
+	r.RemoteAddr = "bufconn"
+	w := httptest.NewRecorder()
+	limitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("First request answered %d, want 200", w.Code)
+	}
+
+	if _, err := rpc.NewClient(conn).ListReports(context.Background()); grpcstatus.Code(err) != codes.ResourceExhausted {
+		t.Errorf("ListReports() after the webserver request returned %v, want %v", err, codes.ResourceExhausted)
+	}
+}
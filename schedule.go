@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/jfmarket/report-cacher/store"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The most runs of each report a schedule preview lists.
+const maxScheduledRuns = 100
+
+// How many scheduled updates a preview looks through for runs, so a report
+// that never runs, such as out of season, doesn't loop forever.
+const maxScheduledUpdates = 10000
+
+// When a report is expected to be downloaded next, as listed by
+// /api/schedule and the schedule command.
+type reportSchedule struct {
+	Name     string      `json:"name"`
+	Provider string      `json:"provider"`
+	Interval string      `json:"interval,omitempty"` // The report's least time between downloads, if it has one.
+	Runs     []time.Time `json:"runs"`
+}
+
+// What /api/schedule returns.
+type scheduleResponse struct {
+	Interval string           `json:"interval"`         // -interval.
+	Jitter   string           `json:"jitter,omitempty"` // Each run may be up to this much later.
+	Paused   bool             `json:"paused"`           // Nothing runs until updates are resumed.
+	Reports  []reportSchedule `json:"reports"`
+}
+
+// previewSchedule() returns the next n times each report of a configured
+// provider is expected to be downloaded, by following the scheduler from
+// the update at first: updates every -interval, deferred through
+// blackouts and skipped while the market is closed, each downloading the
+// reports whose own Interval has passed. When initial is true, the update
+// at first runs regardless, as the one made on starting does. Reports that
+// are skipped because a fresh copy of the same days is cached, and the
+// delay -jitter adds, can't be foreseen.
+func previewSchedule(first time.Time, initial bool, n int) []reportSchedule {
+	schedules := []reportSchedule{}
+	last := make(map[string]time.Time)
+	for _, r := range reports {
+		if p := providers[r.Provider]; p == nil || !p.configured() {
+			continue
+		}
+		rs := reportSchedule{Name: r.Name, Provider: r.Provider, Runs: []time.Time{}}
+		if r.Interval > 0 {
+			rs.Interval = r.Interval.String()
+		}
+		schedules = append(schedules, rs)
+		if versions, err := cache.Versions(r.Name); err == nil && len(versions) > 0 {
+			last[r.Name] = versions[len(versions)-1].Time
+		}
+	}
+
+	t := first.In(businessZone)
+	for i := 0; i < maxScheduledUpdates && *interval > 0; i++ {
+		if !initial || i > 0 {
+			for {
+				until, ok := blackoutUntil(t)
+				if !ok {
+					break
+				}
+				t = until
+			}
+		}
+
+		if (initial && i == 0) || marketActive(t) {
+			full := true
+			for j := range schedules {
+				s := &schedules[j]
+				if len(s.Runs) >= n {
+					continue
+				}
+				r := lookupReport(s.Name)
+				if r.Interval == 0 || t.Sub(last[s.Name]) >= r.Interval {
+					s.Runs = append(s.Runs, t)
+					last[s.Name] = t
+				}
+				full = full && len(s.Runs) >= n
+			}
+			if full {
+				break
+			}
+		}
+
+		t = t.Add(*interval)
+	}
+
+	return schedules
+}
+
+// scheduleHandler() lists when each report is expected to be downloaded
+// next, n times, 5 by default, following -interval, each report's own
+// interval, -blackout and -marketdays. See previewSchedule().
+//     GET /api/schedule?n=10
+func scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	n, err := scheduledRuns(r.FormValue("n"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s := currentStatus()
+	first, initial := s.NextUpdate, false
+	if first.IsZero() {
+		first, initial = wallClock.Now(), true
+	}
+
+	res := scheduleResponse{
+		Interval: interval.String(),
+		Paused:   s.Paused,
+		Reports:  previewSchedule(first, initial, n),
+	}
+	if *jitter > 0 {
+		res.Jitter = jitter.String()
+	}
+
+	writeJSON(w, res)
+}
+
+// Parses the number of runs a schedule preview asks for, 5 when empty.
+func scheduledRuns(s string) (int, error) {
+	if s == "" {
+		return 5, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxScheduledRuns {
+		return 0, errors.New("Invalid n " + s + ". Use 1 to " + strconv.Itoa(maxScheduledRuns) + ".")
+	}
+	return n, nil
+}
+
+// scheduleCommand() prints when each report would be downloaded were the
+// report-cacher started now with the same options, -n times each. See
+// previewSchedule().
+//     report-cacher schedule -n=10 -interval=6h -blackout='market 08:00-14:00' -marketdays=Sat
+func scheduleCommand(args []string) error {
+	flags := flag.NewFlagSet("schedule", flag.ContinueOnError)
+	flag.VisitAll(func(f *flag.Flag) {
+		flags.Var(f.Value, f.Name, f.Usage)
+	})
+	runs := flags.String("n", "5", "How many runs of each report to list, up to "+strconv.Itoa(maxScheduledRuns)+".")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: report-cacher schedule [-n=<runs>] [options]")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	n, err := scheduledRuns(*runs)
+	if err != nil {
+		return err
+	}
+	applyOptions()
+
+	// Only read the cache, so a new -directory isn't created.
+	if cache, err = store.New(*directory); err != nil {
+		return err
+	}
+
+	for _, s := range previewSchedule(wallClock.Now(), true, n) {
+		fmt.Println(s.Name + ":")
+		if len(s.Runs) == 0 {
+			fmt.Println("  never")
+		}
+		for _, t := range s.Runs {
+			fmt.Println("  " + t.Format("Mon Jan 2 15:04 MST"))
+		}
+	}
+	if *jitter > 0 {
+		fmt.Println("Each may be up to " + jitter.String() + " later, by -jitter.")
+	}
+
+	return nil
+}
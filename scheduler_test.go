@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/clock"
+	"testing"
+	"time"
+)
+
+// Starts downloadManager() on fake clock c, returning a channel receiving
+// the time of each update it runs and a func stopping it.
+func startManager(t *testing.T, c *clock.Fake, interval time.Duration) (<-chan time.Time, func()) {
+	oldClock, oldUpdate, oldZone := wallClock, runUpdate, businessZone
+	wallClock, businessZone = c, time.UTC
+	ran := make(chan time.Time, 10)
+	runUpdate = func(bool) error {
+		ran <- wallClock.Now()
+		return nil
+	}
+	setNextUpdate(time.Time{})
+
+	done, stopped := make(chan bool), make(chan bool)
+	go func() {
+		downloadManager(interval, done)
+		close(stopped)
+	}()
+
+	return ran, func() {
+		close(done)
+		<-stopped
+		wallClock, runUpdate, businessZone = oldClock, oldUpdate, oldZone
+	}
+}
+
+// Waits for the download manager to schedule its next update at want.
+func waitNextUpdate(t *testing.T, want time.Time) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		status.RLock()
+		next := status.nextUpdate
+		status.RUnlock()
+		if next.Equal(want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Next update is %v, want %v", next, want)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// Waits for the download manager to run an update at want.
+func waitUpdate(t *testing.T, ran <-chan time.Time, want time.Time) {
+	t.Helper()
+	select {
+	case got := <-ran:
+		if !got.Equal(want) {
+			t.Fatalf("Updated at %v, want %v", got, want)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("No update, want one at %v", want)
+	}
+}
+
+// Fails if the download manager ran an update.
+func noUpdate(t *testing.T, ran <-chan time.Time) {
+	t.Helper()
+	select {
+	case got := <-ran:
+		t.Fatalf("Updated at %v, want no update", got)
+	default:
+	}
+}
+
+func TestSchedulerInterval(t *testing.T) {
+	start := time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	ran, stop := startManager(t, c, time.Hour)
+	defer stop()
+
+	waitUpdate(t, ran, start)
+	for i := 1; i <= 3; i++ {
+		waitNextUpdate(t, start.Add(time.Duration(i)*time.Hour))
+		c.Advance(30 * time.Minute)
+		noUpdate(t, ran)
+		c.Advance(30 * time.Minute)
+		waitUpdate(t, ran, start.Add(time.Duration(i)*time.Hour))
+	}
+}
+
+func TestSchedulerBlackout(t *testing.T) {
+	if err := parseBlackouts("08:00-14:00"); err != nil {
+		t.Fatal(err)
+	}
+	defer parseBlackouts("")
+
+	start := time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	ran, stop := startManager(t, c, 4*time.Hour)
+	defer stop()
+
+	// The update made on starting runs regardless.
+	waitUpdate(t, ran, start)
+	waitNextUpdate(t, start.Add(4*time.Hour))
+
+	// 10:00 is in the blackout, so the update waits for it to end at 14:00.
+	c.Advance(4 * time.Hour)
+	waitNextUpdate(t, start.Add(8*time.Hour))
+	noUpdate(t, ran)
+
+	c.Advance(4 * time.Hour)
+	waitUpdate(t, ran, start.Add(8*time.Hour))
+	waitNextUpdate(t, start.Add(12*time.Hour))
+}
+
+func TestSchedulerCatchUp(t *testing.T) {
+	start := time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	ran, stop := startManager(t, c, time.Hour)
+	defer stop()
+
+	waitUpdate(t, ran, start)
+	waitNextUpdate(t, start.Add(time.Hour))
+
+	// Timers pause while the machine sleeps past the 07:00 update. The next
+	// heartbeat notices it was missed.
+	c.Sleep(3 * time.Hour)
+	noUpdate(t, ran)
+	c.Advance(heartbeatInterval)
+	woke := start.Add(3*time.Hour + heartbeatInterval)
+	waitUpdate(t, ran, woke)
+	waitNextUpdate(t, woke.Add(time.Hour))
+}
+
+func TestSchedulerRefresh(t *testing.T) {
+	resetJobs(t)
+	start := time.Date(2014, 3, 25, 6, 0, 0, 0, time.UTC)
+	c := clock.NewFake(start)
+	ran, stop := startManager(t, c, time.Hour)
+	defer stop()
+
+	waitUpdate(t, ran, start)
+	waitNextUpdate(t, start.Add(time.Hour))
+
+	// Refreshes at 06:30 run at once. Once the second has been taken, the
+	// manager is done with the first.
+	c.Advance(30 * time.Minute)
+	for i := 0; i < 2; i++ {
+		requestRefresh(false)
+		waitUpdate(t, ran, start.Add(30*time.Minute))
+	}
+
+	// The update scheduled for 07:00 still happens then.
+	c.Advance(30 * time.Minute)
+	waitUpdate(t, ran, start.Add(time.Hour))
+	waitNextUpdate(t, start.Add(2*time.Hour))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/report"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The periods an item's sales can be totalled by, by granularity.
+var granularities = map[string]func(time.Time) string{
+	"day":   report.Daily,
+	"week":  report.Weekly,
+	"month": report.Monthly,
+	"year":  report.Yearly,
+}
+
+// itemsHandler() serves an item's quantity and sales in each period, put
+// together from every cached Sold Items report, for trend charts.
+//     GET /api/items/Tomatoes/series?granularity=week&from=2014-01-01&to=2014-03-31
+// granularity is day, week, month or year and defaults to week. Weeks are
+// named by the Monday they begin on. from and to are optional, and periods
+// the item wasn't sold in are left out.
+func itemsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Item names may hold an escaped /.
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), "/api/items/"), "/"), "/")
+	if len(parts) != 2 || parts[1] != "series" {
+		http.NotFound(w, r)
+		return
+	}
+	item, err := url.PathUnescape(parts[0])
+	if err != nil || item == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	granularity := r.FormValue("granularity")
+	if granularity == "" {
+		granularity = "week"
+	}
+	period := granularities[granularity]
+	if period == nil {
+		http.Error(w, "granularity must be day, week, month or year", http.StatusBadRequest)
+		return
+	}
+
+	var from, to time.Time
+	if d := r.FormValue("from"); d != "" {
+		if from, err = businessDate(d); err != nil {
+			http.Error(w, "from must be a date in the form YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+	if d := r.FormValue("to"); d != "" {
+		if to, err = businessDate(d); err != nil {
+			http.Error(w, "to must be a date in the form YYYY-MM-DD", http.StatusBadRequest)
+			return
+		}
+	}
+
+	snapshots, err := soldItemsSnapshots()
+	if err != nil {
+		logFor(r.Context()).Println("Failed to read sold items. Error: " + err.Error())
+		http.Error(w, "Failed to read sold items", http.StatusInternalServerError)
+		return
+	}
+
+	within := func(d time.Time) string {
+		if d.Before(from) || (!to.IsZero() && d.After(to)) {
+			return ""
+		}
+		return period(d)
+	}
+
+	points := []report.Summary{}
+	for _, s := range report.Summarize(snapshots, within, report.ItemColumns) {
+		if s.Period != "" && strings.EqualFold(s.Group, item) {
+			points = append(points, s)
+		}
+	}
+
+	writeJSON(w, struct {
+		Item        string           `json:"item"`
+		Granularity string           `json:"granularity"`
+		Points      []report.Summary `json:"points"`
+	}{item, granularity, points})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+)
+
+// newServer() builds the handler used by the webserver.
+// Cached reports are served as static files, the dashboard from /dashboard/
+// and the JSON API lives under /api/.
+// When users are configured every request requires a login.
+func newServer() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", staleHandler(etagHandler(dialectHandler(snapshotHandler(http.FileServer(cache.FileSystem()))))))
+	mux.Handle("/dashboard/", dashboardHandler())
+	mux.HandleFunc("/portal", portalHandler)
+	mux.HandleFunc("/api/status", statusHandler)
+	mux.HandleFunc("/api/version", versionHandler)
+	mux.HandleFunc("/api/refresh", refreshHandler)
+	mux.HandleFunc("/api/pause", pauseHandler)
+	mux.HandleFunc("/api/resume", pauseHandler)
+	mux.HandleFunc("/api/events", eventsHandler)
+	mux.HandleFunc("/api/ws", websocketHandler)
+	mux.HandleFunc("/api/reports", reportsHandler)
+	mux.HandleFunc("/api/reports/", reportsHandler)
+	mux.HandleFunc("/api/stats/", statsHandler)
+	mux.HandleFunc("/api/compare", compareHandler)
+	mux.HandleFunc("/api/items/", itemsHandler)
+	mux.HandleFunc("/api/charts/", chartsHandler)
+	mux.HandleFunc("/api/query", sqlQueryHandler)
+	mux.HandleFunc("/api/history", historyHandler)
+	mux.HandleFunc("/api/audit", auditHandler)
+	mux.HandleFunc("/api/bundle.zip", bundleHandler)
+	mux.HandleFunc("/api/sync", syncHandler)
+	mux.HandleFunc("/api/schedule", scheduleHandler)
+	mux.HandleFunc("/api/jobs/", jobHandler)
+	if oidcLogin != nil {
+		mux.HandleFunc("/login", oidcLoginHandler)
+		mux.HandleFunc("/login/callback", oidcLoginHandler)
+		mux.HandleFunc("/logout", oidcLoginHandler)
+	}
+	if *cloverClientID != "" {
+		mux.HandleFunc("/oauth/clover", cloverOAuthHandler)
+		mux.HandleFunc("/oauth/clover/callback", cloverOAuthHandler)
+	}
+	return tagRequests(reportHandlerPanics(restrictNetworks(limitRequests(requireLogin(auditRequests(mux))))))
+}
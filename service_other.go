@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+)
+
+// serviceCommand() is only supported on Windows. Elsewhere, run the
+// report-cacher under the system's service manager, such as systemd.
+func serviceCommand(args []string) error {
+	return errors.New("Services are only supported on Windows. Use your system's service manager, such as systemd, instead.")
+}
+
+// inService() reports whether the service manager started the process,
+// which is never outside Windows.
+func inService() bool {
+	return false
+}
+
+func prepareService() {}
+
+func runService(done chan bool) error {
+	return errors.New("Services are only supported on Windows")
+}
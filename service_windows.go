@@ -0,0 +1,137 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The name the Windows service is installed under.
+const serviceName = "report-cacher"
+
+// serviceCommand() installs, uninstalls, starts or stops the report-cacher
+// as a Windows service, so it runs without a console window left open.
+// The options given to install are used each time the service starts.
+//     report-cacher service install -email=user@domain.com -password=mypassword -directory=C:\Reports
+//     report-cacher service start
+func serviceCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New("Usage: report-cacher service install|uninstall|start|stop [options]")
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return errors.New("Failed to connect to the service manager. Run as an administrator. " + err.Error())
+	}
+	defer m.Disconnect()
+
+	if args[0] == "install" {
+		return installService(m, args[1:])
+	}
+
+	s, err := m.OpenService(serviceName)
+	if err != nil {
+		return errors.New("The service is not installed. " + err.Error())
+	}
+	defer s.Close()
+
+	switch args[0] {
+	case "uninstall":
+		return s.Delete()
+	case "start":
+		return s.Start()
+	case "stop":
+		_, err := s.Control(svc.Stop)
+		return err
+	default:
+		return errors.New("Unknown service command " + args[0] + ". Use install, uninstall, start or stop.")
+	}
+}
+
+// Installs the service to start automatically with args.
+func installService(m *mgr.Mgr, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	if s, err := m.OpenService(serviceName); err == nil {
+		s.Close()
+		return errors.New("The service is already installed. Uninstall it first.")
+	}
+
+	s, err := m.CreateService(serviceName, exe, mgr.Config{
+		DisplayName: "Report Cacher",
+		Description: "Downloads point-of-sale reports and serves them to other applications.",
+		StartType:   mgr.StartAutomatic,
+	}, args...)
+	if err != nil {
+		return errors.New("Failed to install the service. " + err.Error())
+	}
+	s.Close()
+
+	log.Println("Installed the " + serviceName + " service. Start it with: report-cacher service start")
+	return nil
+}
+
+// inService() reports whether the service manager started the process.
+func inService() bool {
+	ok, err := svc.IsWindowsService()
+	return err == nil && ok
+}
+
+// prepareService() runs services from the directory of the executable,
+// rather than the system directory, so relative paths such as -directory
+// are kept next to it, and logs to report-cacher.log there.
+func prepareService() {
+	if !inService() {
+		return
+	}
+
+	if exe, err := os.Executable(); err == nil {
+		os.Chdir(filepath.Dir(exe))
+	}
+
+	f, err := os.OpenFile("report-cacher.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err == nil {
+		log.SetOutput(f)
+	}
+}
+
+// runService() reports to the service manager until it asks the service to
+// stop, then close()s done.
+func runService(done chan bool) error {
+	return svc.Run(serviceName, serviceHandler{done})
+}
+
+type serviceHandler struct {
+	done chan bool
+}
+
+func (h serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, changes chan<- svc.Status) (bool, uint32) {
+	changes <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for c := range requests {
+		switch c.Cmd {
+		case svc.Interrogate:
+			changes <- c.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			log.Println("Stopping the service...")
+			changes <- svc.Status{State: svc.StopPending}
+			close(h.done)
+
+			// Give downloads a moment to stop, as catchCtrlC() does.
+			time.Sleep(2 * time.Second)
+			return false, 0
+		}
+	}
+
+	return false, 0
+}
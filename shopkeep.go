@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/jfmarket/report-cacher/download"
+	"strings"
+)
+
+// ShopKeep and the reports the cacher keeps from it.
+func init() {
+	registerProvider("shopkeep", shopkeepProvider{})
+
+	registerReport(reportDefinition{
+		Name:        "sold_items",
+		Provider:    "shopkeep",
+		Type:        "sales",
+		Priority:    prioritySales,
+		Key:         []string{"Item", "Description", "UPC"},
+		Fetch:       fetchSoldItems,
+		Source:      shopkeepSource("/api/v2/exports/sold_items.csv", "/sold_items/create_export"),
+		Dates:       pastWeek,
+		PostProcess: []func() error{summarizeSoldItems, processVendors, exportQuickBooks},
+	})
+
+	registerReport(reportDefinition{
+		Name:     "stock_items",
+		Provider: "shopkeep",
+		Type:     "inventory",
+		Priority: priorityInventory,
+		Key:      []string{"Item", "Description", "UPC"},
+		Fetch:    fetchStockItems,
+		Source:   shopkeepSource("/api/v2/exports/stock_items.csv", "/create_stock_items_export"),
+	})
+}
+
+// shopkeepProvider downloads reports from the ShopKeep site given by -site,
+// through its API or its BackOffice website as -shopkeepmode allows.
+// Its sessions are a download.Source.
+type shopkeepProvider struct{}
+
+func (shopkeepProvider) configured() bool {
+	return *shopkeepToken != "" || (*email != "" && *password != "")
+}
+
+func (shopkeepProvider) login(ctx context.Context) (session, error) {
+	return download.Connect(ctx, *shopkeepMode, *site, *shopkeepToken, *email, *password)
+}
+
+// shopkeepSource() returns the Source of a ShopKeep report downloaded from
+// path api through the API, or path web on the BackOffice website.
+func shopkeepSource(api string, web string) func(session) string {
+	return func(s session) string {
+		if _, ok := s.(*download.APIClient); ok {
+			return strings.TrimSuffix(*site, "/") + api
+		}
+		return strings.TrimSuffix(*site, "/") + web
+	}
+}
+
+// registerGroupedSoldItems() registers a copy of the Sold Items report
+// for each of groupings, a comma separated list such as department,supplier.
+// Each is named for its grouping, such as sold_items_by_department.
+func registerGroupedSoldItems(groupings string) error {
+	for _, name := range strings.Split(groupings, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		g, err := download.ParseGrouping(name)
+		if err != nil {
+			return errors.New("Failed to read -shopkeepgroups. " + err.Error())
+		}
+
+		registerReport(reportDefinition{
+			Name:     "sold_items_by_" + string(g),
+			Provider: "shopkeep",
+			Key:      []string{g.Column(), "Item", "Description", "UPC"},
+			Fetch:    fetchSoldItemsAs(download.ReportOptions{GroupedBy: g}),
+			Source:   shopkeepSource("/api/v2/exports/sold_items.csv?grouped_by="+string(g), "/sold_items/create_export"),
+			Dates:    pastWeek,
+		})
+	}
+
+	return nil
+}
+
+// fetchSoldItems() downloads the Sold Items report for the past week.
+// This may need to be adjusted for more configurability.
+var fetchSoldItems = fetchSoldItemsAs(download.ReportOptions{})
+
+// fetchSoldItemsAs() returns a function downloading the Sold Items report
+// for the past week as o asks, such as grouped by department.
+func fetchSoldItemsAs(o download.ReportOptions) func(ctx context.Context, s session) ([]byte, string, string, error) {
+	return func(ctx context.Context, s session) ([]byte, string, string, error) {
+		d := s.(download.Source)
+
+		today := businessNow()
+		aWeekAgo := today.AddDate(0, 0, -7)
+
+		data, err := d.SoldItemsReport(ctx, aWeekAgo, today, o)
+		return data, aWeekAgo.Format(download.DateLayout), today.Format(download.DateLayout), err
+	}
+}
+
+// fetchStockItems() downloads the Stock Items report.
+func fetchStockItems(ctx context.Context, s session) ([]byte, string, string, error) {
+	data, err := s.(download.Source).StockItemsReport(ctx)
+	return data, "", "", err
+}
+
+// processVendors() breaks out vendor sales when -vendors is set.
+func processVendors() error {
+	if *vendorsFile == "" {
+		return nil
+	}
+
+	return breakOutVendors()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/jfmarket/report-cacher/database"
+	"net/http"
+	"time"
+)
+
+// db holds every cached report version when -sqlite is set. Otherwise it is nil.
+var db *database.DB
+
+// loadDatabase() adds any cached versions missing from the database,
+// including those downloaded before the database was enabled.
+func loadDatabase() error {
+	for _, name := range reportNames {
+		versions, err := cache.Versions(name)
+		if err != nil {
+			return err
+		}
+
+		for _, v := range versions {
+			loaded, err := db.Loaded(v)
+			if err != nil {
+				return err
+			}
+			if loaded {
+				continue
+			}
+
+			t, err := readTable(cache.FilePath(v))
+			if err != nil {
+				return errors.New("Failed to read " + v.Path + ". " + err.Error())
+			}
+			if err := db.Load(v, t); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// sqlQueryHandler() answers a read-only SQL query against the database.
+//     POST /api/query {"query": "SELECT Item, COUNT(*) FROM sold_items GROUP BY Item"}
+func sqlQueryHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if db == nil {
+		http.Error(w, "Queries require the report-cacher to be started with -sqlite", http.StatusNotFound)
+		return
+	}
+
+	var req struct {
+		Query string `json:"query"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "The request must be JSON with a query.", http.StatusBadRequest)
+		return
+	}
+	if _, err := database.ReadOnly(req.Query); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	res, err := db.Query(ctx, req.Query)
+	if err != nil {
+		http.Error(w, "Query failed. "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeJSON(w, res)
+}
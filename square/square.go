@@ -0,0 +1,331 @@
+// This package downloads sales data from Square's REST API and formats it as
+// CSV reports laid out like ShopKeep's, so both can be cached side by side.
+//     c := square.New(token)
+//     orders, err := c.OrdersReport(ctx, start, end)
+package square
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The version of the Square API the client is written against.
+const apiVersion = "2024-01-18"
+
+// A Client downloads reports from Square.
+// Generally, it should be created with New()
+type Client struct {
+	HTTPClient *http.Client // Defaults to one with a 60 second timeout.
+	Site       string       // Defaults to https://connect.squareup.com. Use https://connect.squareupsandbox.com for testing.
+	Locations  []string     // The IDs of the locations to report on. When empty, every location is used.
+	token      string
+}
+
+// Returns a Client authenticating with an access token.
+func New(token string) *Client {
+	return &Client{
+		HTTPClient: &http.Client{Timeout: 60 * time.Second},
+		Site:       "https://connect.squareup.com",
+		token:      token,
+	}
+}
+
+// money is an amount in the smallest unit of a currency, such as cents.
+type money struct {
+	Amount   int64  `json:"amount"`
+	Currency string `json:"currency"`
+}
+
+// Formats m in dollars and cents, such as 12.50
+func (m *money) String() string {
+	if m == nil {
+		return "0.00"
+	}
+	return strconv.FormatFloat(float64(m.Amount)/100, 'f', 2, 64)
+}
+
+// OrdersReport() returns every line item of the orders completed from start
+// until end, one row per line item:
+//     Order ID,Closed At,Location,Item,Variation,Quantity,Gross Sales,Discounts,Net Sales,Tax
+// The Item, Quantity and Net Sales columns match ShopKeep's Sold Items report.
+func (c *Client) OrdersReport(ctx context.Context, start time.Time, end time.Time) ([]byte, error) {
+	locations, err := c.locationIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{{"Order ID", "Closed At", "Location", "Item", "Variation", "Quantity", "Gross Sales", "Discounts", "Net Sales", "Tax"}}
+
+	cursor := ""
+	for {
+		req := map[string]interface{}{
+			"location_ids": locations,
+			"limit":        500,
+			"query": map[string]interface{}{
+				"filter": map[string]interface{}{
+					"state_filter": map[string]interface{}{"states": []string{"COMPLETED"}},
+					"date_time_filter": map[string]interface{}{
+						"closed_at": map[string]string{
+							"start_at": start.Format(time.RFC3339),
+							"end_at":   end.Format(time.RFC3339),
+						},
+					},
+				},
+				"sort": map[string]string{"sort_field": "CLOSED_AT", "sort_order": "ASC"},
+			},
+		}
+		if cursor != "" {
+			req["cursor"] = cursor
+		}
+
+		var res struct {
+			Orders []struct {
+				ID         string `json:"id"`
+				LocationID string `json:"location_id"`
+				ClosedAt   string `json:"closed_at"`
+				LineItems  []struct {
+					Name               string `json:"name"`
+					VariationName      string `json:"variation_name"`
+					Quantity           string `json:"quantity"`
+					GrossSalesMoney    *money `json:"gross_sales_money"`
+					TotalDiscountMoney *money `json:"total_discount_money"`
+					TotalTaxMoney      *money `json:"total_tax_money"`
+				} `json:"line_items"`
+			} `json:"orders"`
+			Cursor string `json:"cursor"`
+		}
+		if err := c.do(ctx, "POST", "/v2/orders/search", req, &res); err != nil {
+			return nil, errors.New("Failed to search orders. " + err.Error())
+		}
+
+		for _, o := range res.Orders {
+			for _, li := range o.LineItems {
+				net := &money{}
+				if li.GrossSalesMoney != nil {
+					net.Amount = li.GrossSalesMoney.Amount
+				}
+				if li.TotalDiscountMoney != nil {
+					net.Amount -= li.TotalDiscountMoney.Amount
+				}
+
+				rows = append(rows, []string{
+					o.ID, o.ClosedAt, o.LocationID, li.Name, li.VariationName, li.Quantity,
+					li.GrossSalesMoney.String(), li.TotalDiscountMoney.String(), net.String(), li.TotalTaxMoney.String(),
+				})
+			}
+		}
+
+		if res.Cursor == "" {
+			break
+		}
+		cursor = res.Cursor
+	}
+
+	return toCSV(rows)
+}
+
+// PaymentsReport() returns every payment made from start until end:
+//     Payment ID,Created At,Location,Status,Source,Card Brand,Amount,Tip,Total,Refunded
+func (c *Client) PaymentsReport(ctx context.Context, start time.Time, end time.Time) ([]byte, error) {
+	locations, err := c.locationIDs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := [][]string{{"Payment ID", "Created At", "Location", "Status", "Source", "Card Brand", "Amount", "Tip", "Total", "Refunded"}}
+
+	for _, location := range locations {
+		cursor := ""
+		for {
+			q := url.Values{
+				"begin_time":  {start.Format(time.RFC3339)},
+				"end_time":    {end.Format(time.RFC3339)},
+				"location_id": {location},
+				"sort_order":  {"ASC"},
+			}
+			if cursor != "" {
+				q.Set("cursor", cursor)
+			}
+
+			var res struct {
+				Payments []struct {
+					ID            string `json:"id"`
+					CreatedAt     string `json:"created_at"`
+					LocationID    string `json:"location_id"`
+					Status        string `json:"status"`
+					SourceType    string `json:"source_type"`
+					AmountMoney   *money `json:"amount_money"`
+					TipMoney      *money `json:"tip_money"`
+					TotalMoney    *money `json:"total_money"`
+					RefundedMoney *money `json:"refunded_money"`
+					CardDetails   *struct {
+						Card struct {
+							Brand string `json:"card_brand"`
+						} `json:"card"`
+					} `json:"card_details"`
+				} `json:"payments"`
+				Cursor string `json:"cursor"`
+			}
+			if err := c.do(ctx, "GET", "/v2/payments?"+q.Encode(), nil, &res); err != nil {
+				return nil, errors.New("Failed to list payments. " + err.Error())
+			}
+
+			for _, p := range res.Payments {
+				brand := ""
+				if p.CardDetails != nil {
+					brand = p.CardDetails.Card.Brand
+				}
+				rows = append(rows, []string{
+					p.ID, p.CreatedAt, p.LocationID, p.Status, p.SourceType, brand,
+					p.AmountMoney.String(), p.TipMoney.String(), p.TotalMoney.String(), p.RefundedMoney.String(),
+				})
+			}
+
+			if res.Cursor == "" {
+				break
+			}
+			cursor = res.Cursor
+		}
+	}
+
+	return toCSV(rows)
+}
+
+// CatalogReport() returns every item variation in the catalog:
+//     Item,Variation,SKU,Price,Item ID,Variation ID
+func (c *Client) CatalogReport(ctx context.Context) ([]byte, error) {
+	rows := [][]string{{"Item", "Variation", "SKU", "Price", "Item ID", "Variation ID"}}
+
+	cursor := ""
+	for {
+		q := url.Values{"types": {"ITEM"}}
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+
+		var res struct {
+			Objects []struct {
+				ID       string `json:"id"`
+				ItemData struct {
+					Name       string `json:"name"`
+					Variations []struct {
+						ID                string `json:"id"`
+						ItemVariationData struct {
+							Name       string `json:"name"`
+							SKU        string `json:"sku"`
+							PriceMoney *money `json:"price_money"`
+						} `json:"item_variation_data"`
+					} `json:"variations"`
+				} `json:"item_data"`
+			} `json:"objects"`
+			Cursor string `json:"cursor"`
+		}
+		if err := c.do(ctx, "GET", "/v2/catalog/list?"+q.Encode(), nil, &res); err != nil {
+			return nil, errors.New("Failed to list catalog. " + err.Error())
+		}
+
+		for _, o := range res.Objects {
+			for _, v := range o.ItemData.Variations {
+				d := v.ItemVariationData
+				rows = append(rows, []string{o.ItemData.Name, d.Name, d.SKU, d.PriceMoney.String(), o.ID, v.ID})
+			}
+		}
+
+		if res.Cursor == "" {
+			break
+		}
+		cursor = res.Cursor
+	}
+
+	return toCSV(rows)
+}
+
+// Returns the configured locations, or every location of the account if none are.
+func (c *Client) locationIDs(ctx context.Context) ([]string, error) {
+	if len(c.Locations) > 0 {
+		return c.Locations, nil
+	}
+
+	var res struct {
+		Locations []struct {
+			ID string `json:"id"`
+		} `json:"locations"`
+	}
+	if err := c.do(ctx, "GET", "/v2/locations", nil, &res); err != nil {
+		return nil, errors.New("Failed to list locations. " + err.Error())
+	}
+
+	var ids []string
+	for _, l := range res.Locations {
+		ids = append(ids, l.ID)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("The Square account has no locations")
+	}
+
+	return ids, nil
+}
+
+// Makes a request with body encoded as JSON, if it is not nil, and decodes
+// the JSON response into v.
+func (c *Client) do(ctx context.Context, method string, path string, body interface{}, v interface{}) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.Site, "/")+path, r)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Square-Version", apiVersion)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		var e struct {
+			Errors []struct {
+				Code   string `json:"code"`
+				Detail string `json:"detail"`
+			} `json:"errors"`
+		}
+		data, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		if json.Unmarshal(data, &e) == nil && len(e.Errors) > 0 {
+			return errors.New("Square responded with " + res.Status + ": " + e.Errors[0].Code + " " + e.Errors[0].Detail)
+		}
+		return errors.New("Square responded with " + res.Status)
+	}
+
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// Formats rows as CSV.
+func toCSV(rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.WriteAll(rows)
+	return buf.Bytes(), w.Error()
+}
@@ -0,0 +1,62 @@
+package square
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOrdersReport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"errors":[{"code":"UNAUTHORIZED","detail":"Bad token"}]}`))
+			return
+		}
+
+		switch r.URL.Path {
+		case "/v2/locations":
+			w.Write([]byte(`{"locations":[{"id":"L1"}]}`))
+		case "/v2/orders/search":
+			var req struct {
+				Cursor string `json:"cursor"`
+			}
+			json.NewDecoder(r.Body).Decode(&req)
+			if req.Cursor == "" {
+				w.Write([]byte(`{"orders":[{"id":"O1","location_id":"L1","closed_at":"2014-03-08T10:00:00Z","line_items":[{"name":"Tomatoes","quantity":"2","gross_sales_money":{"amount":600},"total_discount_money":{"amount":100}}]}],"cursor":"next"}`))
+			} else {
+				w.Write([]byte(`{"orders":[{"id":"O2","location_id":"L1","closed_at":"2014-03-08T11:00:00Z","line_items":[{"name":"Eggs","quantity":"1","gross_sales_money":{"amount":450}}]}]}`))
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	c := New("secret")
+	c.Site = ts.URL
+
+	data, err := c.OrdersReport(context.Background(), time.Now().AddDate(0, 0, -7), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("OrdersReport() returned %d lines, want a header and a row from each page:\n%s", len(lines), data)
+	}
+	if want := "O1,2014-03-08T10:00:00Z,L1,Tomatoes,,2,6.00,1.00,5.00,0.00"; lines[1] != want {
+		t.Errorf("OrdersReport() row = %s, want %s", lines[1], want)
+	}
+
+	c = New("wrong")
+	c.Site = ts.URL
+	_, err = c.OrdersReport(context.Background(), time.Now(), time.Now())
+	if err == nil || !strings.Contains(err.Error(), "UNAUTHORIZED") {
+		t.Errorf("OrdersReport() with a bad token returned %v, want Square's error", err)
+	}
+}
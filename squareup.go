@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"github.com/jfmarket/report-cacher/report"
+	"github.com/jfmarket/report-cacher/square"
+	"strings"
+	"time"
+)
+
+// Square and the reports the cacher keeps from it.
+func init() {
+	registerProvider("square", squareProvider{})
+
+	registerReport(reportDefinition{
+		Name:     "square_orders",
+		Provider: "square",
+		Type:     "sales",
+		Priority: prioritySales,
+		Key:      []string{"Order ID", "Item", "Variation"},
+		Fetch:    fetchSquareOrders,
+		Source:   squareSource("/v2/orders/search"),
+		Dates:    pastWeek,
+	})
+
+	registerReport(reportDefinition{
+		Name:     "square_payments",
+		Provider: "square",
+		Type:     "payments",
+		Key:      []string{"Payment ID"},
+		Fetch:    fetchSquarePayments,
+		Source:   squareSource("/v2/payments"),
+		Dates:    pastWeek,
+	})
+
+	registerReport(reportDefinition{
+		Name:     "square_catalog",
+		Provider: "square",
+		Type:     "inventory",
+		Priority: priorityInventory,
+		Key:      []string{"Variation ID"},
+		Fetch:    fetchSquareCatalog,
+		Source:   squareSource("/v2/catalog/list"),
+	})
+}
+
+// squareProvider downloads reports from Square's API using -squaretoken.
+// Its sessions are a *square.Client.
+type squareProvider struct{}
+
+func (squareProvider) configured() bool {
+	return *squareToken != ""
+}
+
+func (squareProvider) login(ctx context.Context) (session, error) {
+	c := square.New(*squareToken)
+	if *squareSite != "" {
+		c.Site = *squareSite
+	}
+	for _, l := range strings.Split(*squareLocations, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			c.Locations = append(c.Locations, l)
+		}
+	}
+
+	return c, nil
+}
+
+// Returns the start of the day a week ago and now, the same days the Sold
+// Items report covers.
+func squareWeek() (time.Time, time.Time) {
+	now := businessNow()
+	y, m, d := now.AddDate(0, 0, -7).Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, now.Location()), now
+}
+
+// squareSource() returns the Source of a Square report downloaded from the
+// API's path, such as /v2/payments.
+func squareSource(path string) func(session) string {
+	return func(s session) string {
+		return strings.TrimSuffix(s.(*square.Client).Site, "/") + path
+	}
+}
+
+// fetchSquareOrders() downloads the line items of the past week's orders.
+func fetchSquareOrders(ctx context.Context, s session) ([]byte, string, string, error) {
+	start, end := squareWeek()
+	data, err := s.(*square.Client).OrdersReport(ctx, start, end)
+	return data, start.Format(report.DateLayout), end.Format(report.DateLayout), err
+}
+
+// fetchSquarePayments() downloads the past week's payments.
+func fetchSquarePayments(ctx context.Context, s session) ([]byte, string, string, error) {
+	start, end := squareWeek()
+	data, err := s.(*square.Client).PaymentsReport(ctx, start, end)
+	return data, start.Format(report.DateLayout), end.Format(report.DateLayout), err
+}
+
+// fetchSquareCatalog() downloads the catalog.
+func fetchSquareCatalog(ctx context.Context, s session) ([]byte, string, string, error) {
+	data, err := s.(*square.Client).CatalogReport(ctx)
+	return data, "", "", err
+}
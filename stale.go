@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// staleAfter() returns how old r's current copy may be before it is stale:
+// r.Stale if it is set, or else -stale, or else twice the update interval.
+func (r *reportDefinition) staleAfter() time.Duration {
+	switch {
+	case r.Stale > 0:
+		return r.Stale
+	case *staleAge > 0:
+		return *staleAge
+	}
+	return 2 * *interval
+}
+
+// isStale() reports whether the current copy of r, downloaded at updated,
+// is older than its freshness threshold at now.
+func (r *reportDefinition) isStale(updated time.Time, now time.Time) bool {
+	return !updated.IsZero() && now.Sub(updated) > r.staleAfter()
+}
+
+// setStaleHeaders() marks a response serving the current copy of report
+// name as stale if it is, with X-Report-Stale and a Warning header.
+func setStaleHeaders(w http.ResponseWriter, name string) {
+	r := lookupReport(name)
+	if r == nil {
+		return
+	}
+
+	versions, _ := cache.Versions(name)
+	if len(versions) == 0 {
+		return
+	}
+
+	updated := versions[len(versions)-1].Time
+	if r.isStale(updated, wallClock.Now()) {
+		w.Header().Set("X-Report-Stale", "true")
+		w.Header().Set("Warning", `110 report-cacher "Report was last downloaded `+updated.Format(time.RFC1123)+`"`)
+	}
+}
+
+// staleHandler() adds stale headers to the current copies of reports
+// served by h, such as /sold_items.csv
+func staleHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name := currentReportName(r.URL.Path); name != "" {
+			setStaleHeaders(w, name)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// status tracks what the download manager is doing so it can be reported
+// by the API and dashboard.
+var status = struct {
+	sync.RWMutex
+	updating   bool
+	paused     bool // Scheduled updates are skipped while paused.
+	lastUpdate time.Time
+	nextUpdate time.Time
+	errors     map[string]string    // The error from the last attempt to download each report.
+	attempts   map[string]time.Time // When each report was last attempted.
+	degraded   string               // Why updates are being skipped, such as low disk space, if they are.
+}{
+	errors:   make(map[string]string),
+	attempts: make(map[string]time.Time),
+}
+
+// A snapshot of the status of a single report.
+type reportStatus struct {
+	Name        string    `json:"name"`
+	Updated     time.Time `json:"updated"`              // When the current copy was downloaded.
+	Size        int64     `json:"size"`                 // The size of the current copy in bytes.
+	LastAttempt time.Time `json:"last_attempt"`         // When a download was last attempted.
+	LastError   string    `json:"last_error,omitempty"` // Why the last attempt failed, if it did.
+	Stale       bool      `json:"stale"`                // Whether the current copy is older than its freshness threshold.
+}
+
+// A snapshot of the status of the download manager and every report.
+type cacheStatus struct {
+	Updating   bool             `json:"updating"`
+	Paused     bool             `json:"paused"`
+	LastUpdate time.Time        `json:"last_update"`
+	NextUpdate time.Time        `json:"next_update"`
+	Degraded   string           `json:"degraded,omitempty"` // Why updates are being skipped, if they are.
+	Reports    []reportStatus   `json:"reports"`
+	Providers  []providerStatus `json:"providers"`
+}
+
+// recordAttempt() notes the outcome of an attempt to download a report.
+// err is nil when the download succeeded. Failures are published as they
+// are recorded; refresh() publishes successes once the version is in place.
+func recordAttempt(name string, err error) {
+	status.Lock()
+	status.attempts[name] = wallClock.Now()
+	if err != nil {
+		status.errors[name] = err.Error()
+	} else {
+		delete(status.errors, name)
+	}
+	status.Unlock()
+
+	if err != nil {
+		events.publish(event{Type: eventFailed, Report: name, Error: err.Error()})
+	}
+}
+
+// setPaused() pauses or resumes scheduled updates. Refreshes requested
+// through the API still happen while paused.
+func setPaused(paused bool) {
+	status.Lock()
+	changed := status.paused != paused
+	status.paused = paused
+	status.Unlock()
+
+	if changed && paused {
+		log.Println("Scheduled updates paused.")
+		events.publish(event{Type: eventPaused})
+	} else if changed {
+		log.Println("Scheduled updates resumed.")
+		events.publish(event{Type: eventResumed})
+	}
+}
+
+// isPaused() reports whether scheduled updates are paused.
+func isPaused() bool {
+	status.RLock()
+	defer status.RUnlock()
+	return status.paused
+}
+
+// currentStatus() returns a snapshot of the status of the cache.
+func currentStatus() cacheStatus {
+	status.RLock()
+	defer status.RUnlock()
+
+	s := cacheStatus{
+		Updating:   status.updating,
+		Paused:     status.paused,
+		LastUpdate: status.lastUpdate,
+		NextUpdate: status.nextUpdate,
+		Degraded:   status.degraded,
+		Reports:    []reportStatus{},
+		Providers:  providerStatuses(),
+	}
+
+	for _, name := range reportNames {
+		rs := reportStatus{
+			Name:        name,
+			LastAttempt: status.attempts[name],
+			LastError:   status.errors[name],
+		}
+
+		if versions, _ := cache.Versions(name); len(versions) > 0 {
+			latest := versions[len(versions)-1]
+			rs.Updated = latest.Time
+			rs.Size = latest.Size
+			rs.Stale = lookupReport(name).isStale(latest.Time, wallClock.Now())
+		}
+
+		s.Reports = append(s.Reports, rs)
+	}
+
+	return s
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Every encrypted file starts with this header, followed by the nonce and
+// the sealed contents.
+var encryptedHeader = []byte("report-cacher encrypted v1\n")
+
+// SetKey() makes s encrypt the reports and derived files it writes from
+// now on with AES-256-GCM using key, which must be 32 bytes. The manifest is
+// not encrypted. Files written before a key was set are still read as is.
+func (s *Store) SetKey(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return errors.New("Invalid encryption key. " + err.Error())
+	}
+	if len(key) != 32 {
+		return errors.New("Invalid encryption key. It must be 32 bytes.")
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	s.gcm = gcm
+	return nil
+}
+
+// Encrypts data if s has a key.
+func (s *Store) seal(data []byte) ([]byte, error) {
+	if s.gcm == nil {
+		return data, nil
+	}
+
+	nonce := make([]byte, s.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, errors.New("Failed to encrypt. " + err.Error())
+	}
+
+	sealed := append(append([]byte{}, encryptedHeader...), nonce...)
+	return s.gcm.Seal(sealed, nonce, data, nil), nil
+}
+
+// Decrypts data if it is encrypted.
+func (s *Store) unseal(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedHeader) {
+		return data, nil
+	}
+	if s.gcm == nil {
+		return nil, errors.New("The file is encrypted and no key was given")
+	}
+
+	data = data[len(encryptedHeader):]
+	if len(data) < s.gcm.NonceSize() {
+		return nil, errors.New("The encrypted file is truncated")
+	}
+
+	plain, err := s.gcm.Open(nil, data[:s.gcm.NonceSize()], data[s.gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, errors.New("Failed to decrypt. " + err.Error())
+	}
+	return plain, nil
+}
+
+// ReadFile() returns the contents of the cached file at path p, such as
+// one returned by Path() or FilePath(), decrypting and decompressing it if
+// necessary.
+func (s *Store) ReadFile(p string) ([]byte, error) {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = s.unseal(data); err != nil {
+		return nil, err
+	}
+	return gunzip(p, data)
+}
+
+// Open() opens the cached file at path p for reading, decrypting and
+// decompressing it if necessary.
+func (s *Store) Open(p string) (io.ReadCloser, error) {
+	if s.gcm == nil && !strings.HasSuffix(p, gzipExt) {
+		return os.Open(p)
+	}
+
+	data, err := s.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// FileSystem() returns the cache directory for serving with
+// http.FileServer(). Encrypted files are decrypted as they are served.
+func (s *Store) FileSystem() http.FileSystem {
+	return fileSystem{s}
+}
+
+type fileSystem struct {
+	s *Store
+}
+
+func (fs fileSystem) Open(name string) (http.File, error) {
+	f, err := http.Dir(fs.s.dir).Open(name)
+	if err != nil || fs.s.gcm == nil {
+		return f, err
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		if err != nil {
+			f.Close()
+		}
+		return f, err
+	}
+
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if data, err = fs.s.unseal(data); err != nil {
+		return nil, err
+	}
+
+	return &plainFile{Reader: bytes.NewReader(data), info: plainInfo{info, int64(len(data))}}, nil
+}
+
+// A plainFile is a decrypted file served from memory.
+type plainFile struct {
+	*bytes.Reader
+	info os.FileInfo
+}
+
+func (f *plainFile) Close() error {
+	return nil
+}
+
+func (f *plainFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("Not a directory")
+}
+
+func (f *plainFile) Stat() (os.FileInfo, error) {
+	return f.info, nil
+}
+
+// plainInfo describes a decrypted file, which is smaller than it is on disk.
+type plainInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i plainInfo) Size() int64 {
+	return i.size
+}
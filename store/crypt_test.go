@@ -0,0 +1,65 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestEncryption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKey([]byte("short")); err == nil {
+		t.Error("SetKey() accepted a short key")
+	}
+	if err := s.SetKey(bytes.Repeat([]byte{7}, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	report := []byte("Item,Quantity\nApples,3\n")
+	if _, err := s.Save("sold_items", report, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := ioutil.ReadFile(s.Path("sold_items"))
+	if bytes.Contains(raw, []byte("Apples")) {
+		t.Error("Save() wrote the report unencrypted")
+	}
+
+	data, err := s.ReadFile(s.Path("sold_items"))
+	if err != nil || !bytes.Equal(data, report) {
+		t.Errorf("ReadFile() = %q, %v", data, err)
+	}
+
+	f, err := s.FileSystem().Open("/sold_items.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	served, _ := ioutil.ReadAll(f)
+	info, _ := f.Stat()
+	if !bytes.Equal(served, report) || info.Size() != int64(len(report)) {
+		t.Errorf("FileSystem() served %q of size %d", served, info.Size())
+	}
+	var _ http.File = f
+
+	// Files written before a key was set are still readable.
+	plain, _ := New(dir)
+	if err := ioutil.WriteFile(s.Path("stock_items"), report, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := s.ReadFile(s.Path("stock_items")); err != nil || !bytes.Equal(data, report) {
+		t.Errorf("ReadFile() of an unencrypted file = %q, %v", data, err)
+	}
+	if _, err := plain.ReadFile(s.Path("sold_items")); err == nil {
+		t.Error("ReadFile() without a key read an encrypted file")
+	}
+}
@@ -0,0 +1,62 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// The name of the file in each report's versions directory naming its
+// newest version, in the stable layout.
+const latestName = ".latest"
+
+// SetStable() turns the stable layout on or off. In it, files only change
+// when their contents do, so tools such as rsync and rclone mirror the cache
+// without copying everything after each update: a version's file, its
+// metadata and the current copy are dated when the version was downloaded
+// rather than written, files derived from reports, such as summaries, are
+// left alone when rewritten unchanged, and versions/<report>/.latest names
+// the newest version of each report.
+func (s *Store) SetStable(on bool) {
+	s.mu.Lock()
+	s.stable = on
+	s.mu.Unlock()
+}
+
+// LatestPath() returns the path of the file naming the newest version of
+// report name in the stable layout. It holds the version's path relative to
+// the cache directory, as in the manifest.
+func (s *Store) LatestPath(name string) string {
+	return filepath.Join(s.dir, "versions", name, latestName)
+}
+
+// Dates the files of version v, the newest of its report, when it was
+// downloaded and records it as the latest. The caller must hold s.mu.
+func (s *Store) markLatest(v Version) error {
+	if err := writeFile(s.LatestPath(v.Report), []byte(v.Path+"\n")); err != nil {
+		return err
+	}
+	return touch(v.Time, s.FilePath(v), s.Path(v.Report), s.LatestPath(v.Report))
+}
+
+// Sets the modification time of each of paths to t.
+func touch(t time.Time, paths ...string) error {
+	for _, p := range paths {
+		if err := os.Chtimes(p, t, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reports whether the file at p already holds data, once decrypted.
+func (s *Store) unchanged(p string, data []byte) bool {
+	old, err := ioutil.ReadFile(p)
+	if err != nil {
+		return false
+	}
+	old, err = s.unseal(old)
+	return err == nil && bytes.Equal(old, data)
+}
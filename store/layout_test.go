@@ -0,0 +1,57 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStableLayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetStable(true)
+
+	v, err := s.Save("sold_items", []byte("Item,Quantity\nApples,3\n"), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteMeta(v, "", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{s.FilePath(v), s.Path("sold_items"), MetaPath(s.FilePath(v)), MetaPath(s.Path("sold_items")), s.LatestPath("sold_items")} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.ModTime().Equal(v.Time) {
+			t.Errorf("%s is dated %v, want %v", p, info.ModTime(), v.Time)
+		}
+	}
+	if latest, _ := ioutil.ReadFile(s.LatestPath("sold_items")); string(latest) != v.Path+"\n" {
+		t.Errorf(".latest = %q, want %q", latest, v.Path)
+	}
+
+	if err := s.WriteFile("summaries/daily.csv", []byte("Day,Sales\n")); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := touch(past, dir+"/summaries/daily.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteFile("summaries/daily.csv", []byte("Day,Sales\n")); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(dir + "/summaries/daily.csv"); !info.ModTime().Equal(past) {
+		t.Errorf("Rewriting an unchanged file dated it %v, want %v", info.ModTime(), past)
+	}
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// The extension of the metadata written next to each report file.
+const metaExt = ".meta.json"
+
+// Meta describes where a version of a report came from. It is written next
+// to the version's file and the current copy of the report so consumers of
+// the files alone, such as rsync or SFTP, know their provenance.
+type Meta struct {
+	Report   string    `json:"report"`
+	Start    string    `json:"start,omitempty"`  // The first day the report covers, YYYY-MM-DD.
+	End      string    `json:"end,omitempty"`    // The last day the report covers, YYYY-MM-DD.
+	Fetched  time.Time `json:"fetched"`          // When the report was downloaded.
+	Source   string    `json:"source,omitempty"` // The address it was downloaded from.
+	Rows     int       `json:"rows"`
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum"` // Hex encoded SHA-256 of the contents.
+}
+
+// MetaPath() returns the path of the metadata written next to the report
+// file at path p, such as one returned by Path() or FilePath().
+//     sold_items.csv -> sold_items.meta.json
+//     versions/sold_items/20140325T080000Z.csv.gz -> versions/sold_items/20140325T080000Z.meta.json
+func MetaPath(p string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(p, gzipExt), ".csv") + metaExt
+}
+
+// WriteMeta() writes the metadata of version v, downloaded from source and
+// holding rows rows, next to its file. When v is the newest version of its
+// report it is also written next to the current copy. Metadata holds none of
+// the report's contents so it is not encrypted, even when a key is set.
+func (s *Store) WriteMeta(v Version, source string, rows int) error {
+	data, err := json.MarshalIndent(Meta{
+		Report:   v.Report,
+		Start:    v.Start,
+		End:      v.End,
+		Fetched:  v.Time,
+		Source:   source,
+		Rows:     rows,
+		Size:     v.Size,
+		Checksum: v.Checksum,
+	}, "", "  ")
+	if err != nil {
+		return errors.New("Failed to encode metadata. " + err.Error())
+	}
+
+	if err := s.writeMeta(MetaPath(s.FilePath(v)), data, v.Time); err != nil {
+		return err
+	}
+
+	versions, err := s.Versions(v.Report)
+	if err != nil || len(versions) == 0 || versions[len(versions)-1].Time.After(v.Time) {
+		return err
+	}
+
+	return s.writeMeta(MetaPath(s.Path(v.Report)), data, v.Time)
+}
+
+// Writes metadata to p, dating it fetched in the stable layout.
+func (s *Store) writeMeta(p string, data []byte, fetched time.Time) error {
+	if err := writeFile(p, data); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	stable := s.stable
+	s.mu.RUnlock()
+	if stable {
+		return touch(fetched, p)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestWriteMeta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.Save("sold_items", []byte("Item,Quantity\nApples,3\n"), "2020-06-01", "2020-06-07")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.WriteMeta(v, "https://example.com/sold_items", 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, p := range []string{MetaPath(s.Path("sold_items")), MetaPath(s.FilePath(v))} {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var m Meta
+		if err := json.Unmarshal(data, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.Report != "sold_items" || m.Start != "2020-06-01" || m.End != "2020-06-07" || m.Rows != 1 ||
+			m.Checksum != v.Checksum || !m.Fetched.Equal(v.Time) || m.Source != "https://example.com/sold_items" {
+			t.Errorf("%s = %+v", p, m)
+		}
+	}
+}
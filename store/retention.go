@@ -0,0 +1,119 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// The extension given to version files compressed by Compress().
+const gzipExt = ".gz"
+
+// Compress() gzips the file of version v in place, to save space on old
+// versions, and returns v with its new path. ReadFile() decompresses it.
+// A version that is already compressed is returned as it is.
+func (s *Store) Compress(v Version) (Version, error) {
+	if strings.HasSuffix(v.Path, gzipExt) {
+		return v, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(v)
+	if i < 0 {
+		return v, ErrNoVersion
+	}
+
+	old := s.FilePath(v)
+	data, err := s.ReadFile(old)
+	if err != nil {
+		return v, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		return v, errors.New("Failed to compress " + old + ". " + err.Error())
+	}
+	data, err = s.seal(buf.Bytes())
+	if err != nil {
+		return v, err
+	}
+
+	v.Path += gzipExt
+	if err := writeFile(s.FilePath(v), data); err != nil {
+		return v, err
+	}
+	if s.stable {
+		if err := touch(v.Time, s.FilePath(v)); err != nil {
+			return v, err
+		}
+	}
+
+	s.versions[i] = v
+	if err := s.writeManifest(); err != nil {
+		return v, err
+	}
+
+	return v, os.Remove(old)
+}
+
+// Delete() forgets version v and removes its file and metadata, such as
+// once it has been archived. The current copy of its report is left alone.
+func (s *Store) Delete(v Version) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.index(v)
+	if i < 0 {
+		return ErrNoVersion
+	}
+
+	s.versions = append(s.versions[:i], s.versions[i+1:]...)
+	if err := s.writeManifest(); err != nil {
+		return err
+	}
+
+	for _, p := range []string{s.FilePath(v), MetaPath(s.FilePath(v))} {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the index of version v in s.versions, or -1 if it isn't there.
+// The caller must hold s.mu.
+func (s *Store) index(v Version) int {
+	for i, w := range s.versions {
+		if w.Report == v.Report && w.Time.Equal(v.Time) && w.Path == v.Path {
+			return i
+		}
+	}
+	return -1
+}
+
+// Decompresses data, the contents of the file at path p, if p was
+// compressed by Compress().
+func gunzip(p string, data []byte) ([]byte, error) {
+	if !strings.HasSuffix(p, gzipExt) {
+		return data, nil
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("Failed to decompress " + p + ". " + err.Error())
+	}
+	defer zr.Close()
+
+	data, err = ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, errors.New("Failed to decompress " + p + ". " + err.Error())
+	}
+	return data, nil
+}
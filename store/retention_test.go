@@ -0,0 +1,66 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCompressAndDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetKey(make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+
+	data := "Item,Quantity\nApples,3\n"
+	v, err := s.Save("sold_items", []byte(data), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := s.FilePath(v)
+
+	v, err = s.Compress(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(v.Path, ".csv.gz") {
+		t.Errorf("Compress() path = %s, want a .csv.gz", v.Path)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("%s was left after compressing it", old)
+	}
+	if got, err := s.ReadFile(s.FilePath(v)); err != nil || string(got) != data {
+		t.Errorf("ReadFile() of the compressed version = %q, %v, want %q", got, err, data)
+	}
+
+	reopened, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := reopened.Versions("sold_items"); len(versions) != 1 || versions[0].Path != v.Path {
+		t.Errorf("The manifest lists %+v, want %s", versions, v.Path)
+	}
+
+	if err := s.Delete(v); err != nil {
+		t.Fatal(err)
+	}
+	if versions, _ := s.Versions("sold_items"); len(versions) != 0 {
+		t.Errorf("Versions() after Delete() = %+v, want none", versions)
+	}
+	if _, err := os.Stat(s.FilePath(v)); !os.IsNotExist(err) {
+		t.Error("Delete() left the version's file")
+	}
+	if got, err := s.ReadFile(s.Path("sold_items")); err != nil || string(got) != data {
+		t.Errorf("Delete() changed the current copy to %q, %v", got, err)
+	}
+}
@@ -0,0 +1,338 @@
+// This package manages the on-disk cache of downloaded reports.
+// The latest copy of each report is kept at the top of the cache directory
+// where it is served to other applications. Every download is also kept as
+// a timestamped version, recorded in manifest.json, so older copies can be
+// compared against newer ones.
+package store
+
+import (
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/jfmarket/report-cacher/clock"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The layout used to name version files. It sorts lexically in time order.
+const versionLayout = "20060102T150405Z"
+
+// The name of the file every version is recorded in.
+const manifestName = "manifest.json"
+
+// ErrNoVersion is returned when a requested version of a report does not exist.
+var ErrNoVersion = errors.New("No such report version")
+
+// A Store is a directory of cached reports.
+// Generally, it should be created with New()
+type Store struct {
+	dir      string // The directory reports are cached in.
+	mu       sync.RWMutex
+	versions []Version   // Every stored version, oldest first.
+	gcm      cipher.AEAD // Encrypts files when a key is set. See SetKey().
+	stable   bool        // Whether files keep their dates until they change. See SetStable().
+	clock    clock.Clock // Tells Save() the time. See SetClock().
+}
+
+// A Version is a single cached copy of a report.
+type Version struct {
+	Report   string    `json:"report"`
+	Time     time.Time `json:"time"`            // When the report was downloaded.
+	Path     string    `json:"path"`            // Relative to the cache directory.
+	Start    string    `json:"start,omitempty"` // The first day the report covers, YYYY-MM-DD.
+	End      string    `json:"end,omitempty"`   // The last day the report covers, YYYY-MM-DD.
+	Size     int64     `json:"size"`
+	Checksum string    `json:"checksum"` // Hex encoded SHA-256 of the contents.
+}
+
+// Returns a Store rooted at directory dir, loading its manifest if one exists.
+func New(dir string) (*Store, error) {
+	s := &Store{dir: dir}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, errors.New("Failed to read manifest. " + err.Error())
+	}
+
+	if err := json.Unmarshal(data, &s.versions); err != nil {
+		return nil, errors.New("Failed to parse manifest. " + err.Error())
+	}
+
+	return s, nil
+}
+
+// Reload() rereads the manifest, picking up versions saved by another
+// process sharing the directory.
+func (s *Store) Reload() error {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, manifestName))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return errors.New("Failed to read manifest. " + err.Error())
+	}
+
+	var versions []Version
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return errors.New("Failed to parse manifest. " + err.Error())
+	}
+
+	s.mu.Lock()
+	s.versions = versions
+	s.mu.Unlock()
+	return nil
+}
+
+// SetClock() sets the clock Save() dates versions by, the system clock by
+// default, so tests with a clock.Fake see versions from their own time.
+func (s *Store) SetClock(c clock.Clock) {
+	s.mu.Lock()
+	s.clock = c
+	s.mu.Unlock()
+}
+
+// Returns the time by the Store's clock.
+func (s *Store) now() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.clock == nil {
+		return time.Now()
+	}
+	return s.clock.Now()
+}
+
+// Dir() returns the directory reports are cached in.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Path() returns the path of the current copy of report name.
+func (s *Store) Path(name string) string {
+	return filepath.Join(s.dir, name+".csv")
+}
+
+// FilePath() returns the path of the file holding version v.
+func (s *Store) FilePath(v Version) string {
+	return filepath.Join(s.dir, filepath.FromSlash(v.Path))
+}
+
+// Save() stores data as the current copy of report name and records it as a
+// new version. start and end are the days the report covers and may be empty.
+// Versions are timed to the second, so when another version of the report
+// was stored in the same second, such as by a forced refresh racing the
+// schedule, this one is timed a second later.
+func (s *Store) Save(name string, data []byte, start string, end string) (Version, error) {
+	sum := sha256.Sum256(data)
+	v := Version{
+		Report:   name,
+		Time:     s.now().UTC().Truncate(time.Second),
+		Start:    start,
+		End:      end,
+		Size:     int64(len(data)),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for taken := true; taken; {
+		taken = false
+		for _, w := range s.versions {
+			if w.Report == name && w.Time.Equal(v.Time) {
+				v.Time, taken = v.Time.Add(time.Second), true
+				break
+			}
+		}
+	}
+	v.Path = "versions/" + name + "/" + v.Time.Format(versionLayout) + ".csv"
+
+	p := s.FilePath(v)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return v, errors.New("Failed to create version directory. " + err.Error())
+	}
+
+	data, err := s.seal(data)
+	if err != nil {
+		return v, err
+	}
+
+	if err := writeFile(p, data); err != nil {
+		return v, err
+	}
+
+	if err := writeFile(s.Path(name), data); err != nil {
+		return v, err
+	}
+
+	s.versions = append(s.versions, v)
+	if err := s.writeManifest(); err != nil {
+		return v, err
+	}
+
+	if s.stable {
+		if err := s.markLatest(v); err != nil {
+			return v, err
+		}
+	}
+
+	return v, nil
+}
+
+// Import() stores data as a version of report name downloaded at t, such as
+// a report exported by hand before the cache was set up. Unlike Save(), it
+// only replaces the current copy when t is after every other version of the
+// report. It fails if a version of the report was stored in the same second.
+func (s *Store) Import(name string, data []byte, start string, end string, t time.Time) (Version, error) {
+	sum := sha256.Sum256(data)
+	v := Version{
+		Report:   name,
+		Time:     t.UTC().Truncate(time.Second),
+		Start:    start,
+		End:      end,
+		Size:     int64(len(data)),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+	v.Path = "versions/" + name + "/" + v.Time.Format(versionLayout) + ".csv"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	newest := true
+	for _, w := range s.versions {
+		if w.Report != name {
+			continue
+		}
+		if w.Time.Equal(v.Time) {
+			return v, errors.New("A version of " + name + " from " + v.Time.Format(time.RFC3339) + " is already stored.")
+		}
+		if w.Time.After(v.Time) {
+			newest = false
+		}
+	}
+
+	p := s.FilePath(v)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return v, errors.New("Failed to create version directory. " + err.Error())
+	}
+
+	data, err := s.seal(data)
+	if err != nil {
+		return v, err
+	}
+
+	if err := writeFile(p, data); err != nil {
+		return v, err
+	}
+	if newest {
+		if err := writeFile(s.Path(name), data); err != nil {
+			return v, err
+		}
+	}
+
+	s.versions = append(s.versions, v)
+	sort.SliceStable(s.versions, func(i, j int) bool {
+		return s.versions[i].Time.Before(s.versions[j].Time)
+	})
+	if err := s.writeManifest(); err != nil {
+		return v, err
+	}
+
+	if s.stable && newest {
+		return v, s.markLatest(v)
+	} else if s.stable {
+		return v, touch(v.Time, p)
+	}
+	return v, nil
+}
+
+// Versions() lists every cached version of report name, oldest first.
+func (s *Store) Versions(name string) ([]Version, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var versions []Version
+	for _, v := range s.versions {
+		if v.Report == name {
+			versions = append(versions, v)
+		}
+	}
+
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].Time.Before(versions[j].Time)
+	})
+
+	return versions, nil
+}
+
+// VersionAt() returns the newest version of report name stored at or before t.
+func (s *Store) VersionAt(name string, t time.Time) (Version, error) {
+	versions, err := s.Versions(name)
+	if err != nil {
+		return Version{}, err
+	}
+
+	for i := len(versions) - 1; i >= 0; i-- {
+		if !versions[i].Time.After(t) {
+			return versions[i], nil
+		}
+	}
+
+	return Version{}, ErrNoVersion
+}
+
+// WriteFile() atomically writes a file derived from the cached reports, such
+// as a summary, to path p relative to the cache directory. In the stable
+// layout, a file already holding data is left as it is.
+func (s *Store) WriteFile(p string, data []byte) error {
+	full := filepath.Join(s.dir, filepath.FromSlash(p))
+	s.mu.RLock()
+	stable := s.stable
+	s.mu.RUnlock()
+	if stable && s.unchanged(full, data) {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return errors.New("Failed to create " + filepath.Dir(full) + ". " + err.Error())
+	}
+
+	data, err := s.seal(data)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(full, data)
+}
+
+// Writes the list of versions to the manifest. The caller must hold s.mu.
+func (s *Store) writeManifest() error {
+	data, err := json.MarshalIndent(s.versions, "", "  ")
+	if err != nil {
+		return errors.New("Failed to encode manifest. " + err.Error())
+	}
+
+	return writeFile(filepath.Join(s.dir, manifestName), data)
+}
+
+// Writes data to p by way of a temporary file so readers never see a partial file.
+func writeFile(p string, data []byte) error {
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return errors.New("Failed to write file to " + tmp + " Error: " + err.Error())
+	}
+
+	if err := os.Rename(tmp, p); err != nil {
+		os.Remove(tmp)
+		return errors.New("Failed to move " + tmp + " to " + p + " Error: " + err.Error())
+	}
+
+	return nil
+}
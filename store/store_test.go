@@ -0,0 +1,46 @@
+package store
+
+import (
+	"github.com/jfmarket/report-cacher/clock"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSaveSameSecond(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetClock(clock.NewFake(time.Date(2014, 3, 25, 8, 0, 0, 500, time.UTC)))
+
+	for _, report := range []string{"Item\nApples\n", "Item\nPears\n", "Item\nPlums\n"} {
+		if _, err := s.Save("sold_items", []byte(report), "", ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions, _ := s.Versions("sold_items")
+	if len(versions) != 3 {
+		t.Fatalf("Saved %d versions, want 3", len(versions))
+	}
+	for i, want := range []string{"Item\nApples\n", "Item\nPears\n", "Item\nPlums\n"} {
+		v := versions[i]
+		if !v.Time.Equal(time.Date(2014, 3, 25, 8, 0, i, 0, time.UTC)) {
+			t.Errorf("Version %d is from %v, want a second after the last", i, v.Time)
+		}
+		if data, err := s.ReadFile(s.FilePath(v)); err != nil || string(data) != want {
+			t.Errorf("Version %d holds %q, %v, want %q", i, data, err, want)
+		}
+	}
+	if data, _ := s.ReadFile(s.Path("sold_items")); string(data) != "Item\nPlums\n" {
+		t.Errorf("Current copy is %q, want the last saved", data)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+	"time"
+)
+
+// summarizeSoldItems() regenerates the monthly and yearly Sold Items
+// summaries from every cached version of the report. They are written to
+// the summaries directory of the cache, where they are served alongside the
+// raw reports.
+func summarizeSoldItems() error {
+	snapshots, err := soldItemsSnapshots()
+	if err != nil {
+		return err
+	}
+
+	summaries := []struct {
+		file   string
+		period func(time.Time) string
+		group  []string
+		column string
+	}{
+		{"summaries/sold_items_monthly_by_item.csv", report.Monthly, report.ItemColumns, "Item"},
+		{"summaries/sold_items_monthly_by_department.csv", report.Monthly, report.DepartmentColumns, "Department"},
+		{"summaries/sold_items_yearly_by_item.csv", report.Yearly, report.ItemColumns, "Item"},
+		{"summaries/sold_items_yearly_by_department.csv", report.Yearly, report.DepartmentColumns, "Department"},
+	}
+
+	for _, s := range summaries {
+		data, err := report.SummaryCSV(report.Summarize(snapshots, s.period, s.group), s.column)
+		if err != nil {
+			return errors.New("Failed to format " + s.file + ". " + err.Error())
+		}
+
+		if err := cache.WriteFile(s.file, data); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// soldItemsSnapshots() loads the cached versions of the Sold Items report
+// counting each day of its history once. See report.Tile().
+func soldItemsSnapshots() ([]report.Snapshot, error) {
+	versions, err := cache.Versions("sold_items")
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []report.Snapshot
+	paths := make(map[time.Time]string)
+	for _, v := range versions {
+		start, err1 := businessDate(v.Start)
+		end, err2 := businessDate(v.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+
+		snapshots = append(snapshots, report.Snapshot{Start: start, End: end, Fetched: v.Time})
+		paths[v.Time] = cache.FilePath(v)
+	}
+
+	snapshots = report.Tile(snapshots, businessZone)
+	for i := range snapshots {
+		t, err := readTable(paths[snapshots[i].Fetched])
+		if err != nil {
+			return nil, errors.New("Failed to read sold items version. " + err.Error())
+		}
+		snapshots[i].Table = t
+	}
+
+	return snapshots, nil
+}
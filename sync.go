@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// A report whose contents changed, as listed by /api/sync.
+type syncedReport struct {
+	Name       string    `json:"name"`
+	Updated    time.Time `json:"updated"`         // When the current copy was downloaded.
+	Start      string    `json:"start,omitempty"` // The first day the report covers, YYYY-MM-DD.
+	End        string    `json:"end,omitempty"`   // The last day the report covers, YYYY-MM-DD.
+	Size       int64     `json:"size"`
+	Checksum   string    `json:"checksum"`    // Hex encoded SHA-256 of the contents.
+	URL        string    `json:"url"`         // The current copy, which is replaced by each download.
+	VersionURL string    `json:"version_url"` // This version, which never changes.
+}
+
+// What /api/sync returns.
+type syncResponse struct {
+	AsOf    time.Time      `json:"as_of"` // Pass as since on the next request.
+	Reports []syncedReport `json:"reports"`
+}
+
+// syncHandler() lists the reports whose contents changed since a time,
+// with their checksums and where to download them, so a mirror of the cache
+// only downloads what changed. Downloads that turned out the same as the
+// copy before since aren't listed. Without since, every report is. A report
+// downloaded during the second of since may be listed twice.
+//     GET /api/sync?since=2014-03-25T00:00:00Z
+func syncHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var since time.Time
+	if ts := r.FormValue("since"); ts != "" {
+		var err error
+		if since, err = parseTimestamp(ts); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	res := syncResponse{AsOf: time.Now().UTC().Truncate(time.Second), Reports: []syncedReport{}}
+	for _, name := range reportNames {
+		versions, _ := cache.Versions(name)
+		if len(versions) == 0 {
+			continue
+		}
+		latest := versions[len(versions)-1]
+		if latest.Time.Before(since) {
+			continue
+		}
+
+		// Compare with the copy a mirror synced up to since has.
+		changed := true
+		for i := len(versions) - 1; i >= 0; i-- {
+			if versions[i].Time.Before(since) {
+				changed = versions[i].Checksum != latest.Checksum
+				break
+			}
+		}
+		if !changed {
+			continue
+		}
+
+		res.Reports = append(res.Reports, syncedReport{
+			Name:       name,
+			Updated:    latest.Time,
+			Start:      latest.Start,
+			End:        latest.End,
+			Size:       latest.Size,
+			Checksum:   latest.Checksum,
+			URL:        "/" + name + ".csv",
+			VersionURL: "/" + latest.Path,
+		})
+	}
+
+	writeJSON(w, res)
+}
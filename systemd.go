@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sdNotify() tells systemd about the report-cacher's state, such as
+// READY=1, when it was started as a Type=notify service. It does nothing
+// otherwise.
+func sdNotify(state string) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Println("Failed to notify systemd. Error: " + err.Error())
+		return
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Println("Failed to notify systemd. Error: " + err.Error())
+	}
+}
+
+// The scheduler's heartbeat. The watchdog is only pinged while it beats.
+var heartbeat = struct {
+	sync.Mutex
+	last time.Time
+}{last: time.Now()}
+
+// How often the idle scheduler beats.
+const heartbeatInterval = 10 * time.Second
+
+// beat() records that the scheduler is still making progress.
+func beat() {
+	heartbeat.Lock()
+	heartbeat.last = time.Now()
+	heartbeat.Unlock()
+}
+
+// beatWhileDownloading() beats whenever bytes arrive through
+// http.DefaultTransport, which the providers use, so a slow download that
+// is still making progress isn't mistaken for a wedged scheduler.
+func beatWhileDownloading() {
+	http.DefaultTransport = &beatingTransport{base: http.DefaultTransport}
+}
+
+// A beatingTransport beats as response bodies are read through it.
+type beatingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *beatingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	beat()
+	if err != nil {
+		return res, err
+	}
+
+	res.Body = beatingBody{res.Body}
+	return res, nil
+}
+
+type beatingBody struct {
+	io.ReadCloser
+}
+
+func (b beatingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		beat()
+	}
+	return n, err
+}
+
+// watchdog() pings systemd's watchdog when WatchdogSec is set for the
+// service, as long as the scheduler has beaten within WatchdogSec. If the
+// scheduler wedges the pings stop and systemd restarts the report-cacher.
+// It can be stopped by close()ing the done channel.
+func watchdog(done <-chan bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+
+	timeout := time.Duration(usec) * time.Microsecond
+	ticker := time.NewTicker(timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			heartbeat.Lock()
+			last := heartbeat.last
+			heartbeat.Unlock()
+
+			if time.Since(last) < timeout {
+				sdNotify("WATCHDOG=1")
+			} else {
+				log.Println("The scheduler has not made progress since " + last.Format(time.RFC1123) + ". Leaving systemd to restart the report-cacher.")
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// activatedListener() returns the socket systemd passed the report-cacher
+// when it was started by a .socket unit, or nil if it was not.
+func activatedListener() (net.Listener, error) {
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	if n, _ := strconv.Atoi(os.Getenv("LISTEN_FDS")); n < 1 {
+		return nil, nil
+	}
+
+	// Passed sockets start at file descriptor 3.
+	f := os.NewFile(3, "LISTEN_FD_3")
+	defer f.Close()
+	return net.FileListener(f)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBeatingTransport(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("Tomatoes,10,$20.00\n", 1000)))
+	}))
+	defer ts.Close()
+
+	c := &http.Client{Transport: &beatingTransport{base: http.DefaultTransport}}
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	long := time.Now().Add(-time.Hour)
+	heartbeat.Lock()
+	heartbeat.last = long
+	heartbeat.Unlock()
+
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	heartbeat.Lock()
+	last := heartbeat.last
+	heartbeat.Unlock()
+	if !last.After(long) {
+		t.Error("Reading a download didn't beat")
+	}
+}
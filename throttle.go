@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// A throttle limits the combined rate every download is read at, so
+// downloads don't saturate the market's internet connection.
+type throttle struct {
+	sync.Mutex
+	rate float64   // Bytes per second.
+	next time.Time // When the bytes read so far will have been paid for.
+}
+
+// wait() blocks until n more bytes may be read.
+func (t *throttle) wait(n int) {
+	t.Lock()
+	now := time.Now()
+	if t.next.Before(now) {
+		t.next = now
+	}
+	t.next = t.next.Add(time.Duration(float64(n) / t.rate * float64(time.Second)))
+	until := t.next
+	t.Unlock()
+
+	time.Sleep(time.Until(until))
+}
+
+// throttleDownloads() limits every request made through
+// http.DefaultTransport, which the providers use, to kbps kilobytes per
+// second between them.
+func throttleDownloads(kbps int) {
+	http.DefaultTransport = &throttledTransport{
+		base:     http.DefaultTransport,
+		throttle: &throttle{rate: float64(kbps) * 1024},
+	}
+}
+
+// A throttledTransport reads response bodies no faster than its throttle allows.
+type throttledTransport struct {
+	base     http.RoundTripper
+	throttle *throttle
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return res, err
+	}
+
+	res.Body = &throttledBody{ReadCloser: res.Body, throttle: t.throttle}
+	return res, nil
+}
+
+type throttledBody struct {
+	io.ReadCloser
+	throttle *throttle
+}
+
+func (b *throttledBody) Read(p []byte) (int, error) {
+	// Read in small pieces so the rate stays even.
+	if max := int(b.throttle.rate / 10); max > 0 && len(p) > max {
+		p = p[:max]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.throttle.wait(n)
+	}
+	return n, err
+}
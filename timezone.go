@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/clock"
+	"github.com/jfmarket/report-cacher/report"
+	"time"
+)
+
+// businessZone is the market's timezone, given by -timezone. "Today" and
+// "a week ago" are worked out in it, so reports cover the market's days even
+// when the cacher is hosted elsewhere.
+var businessZone = time.Local
+
+// wallClock tells the time to the scheduler and the dates reports cover.
+// Tests may replace it with a clock.Fake.
+var wallClock clock.Clock = clock.Real
+
+// businessNow() returns the current time in the market's timezone.
+func businessNow() time.Time {
+	return wallClock.Now().In(businessZone)
+}
+
+// pastWeek() returns the days from a week ago through today in the market's
+// timezone, YYYY-MM-DD, which the weekly sales reports cover.
+func pastWeek() (string, string) {
+	now := businessNow()
+	return now.AddDate(0, 0, -7).Format(report.DateLayout), now.Format(report.DateLayout)
+}
+
+// businessToday() returns the market's current date at midnight in its
+// timezone, the same form as dates parsed with businessDate().
+func businessToday() time.Time {
+	today, _ := businessDate(businessNow().Format(report.DateLayout))
+	return today
+}
+
+// businessDate() parses d, a YYYY-MM-DD date, as midnight in the market's
+// timezone. Date windows and the days reports cover are compared in this
+// form, so a day's bounds line up wherever the cacher is hosted.
+func businessDate(d string) (time.Time, error) {
+	return time.ParseInLocation(report.DateLayout, d, businessZone)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// tracer starts the spans of update cycles and downloads. They go nowhere
+// unless -otlp is set.
+var tracer = otel.Tracer("github.com/jfmarket/report-cacher")
+
+// tracerProvider exports spans to -otlp, or is nil when it isn't set.
+var tracerProvider *sdktrace.TracerProvider
+
+// setupTracing() exports spans of each update cycle, report download and
+// request made to a provider to the OTLP/HTTP collector at -otlp.
+func setupTracing() error {
+	exporter, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(*otlpEndpoint))
+	if err != nil {
+		return errors.New("Failed to set up tracing. " + err.Error())
+	}
+
+	tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "report-cacher"),
+			attribute.String("service.version", currentBuild().Version),
+		)),
+	)
+	otel.SetTracerProvider(tracerProvider)
+	http.DefaultTransport = &tracedTransport{base: http.DefaultTransport}
+	return nil
+}
+
+// flushTracing() exports the spans not yet sent, such as before exiting.
+func flushTracing() {
+	if tracerProvider == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Println("Failed to export traces. Error: " + err.Error())
+	}
+}
+
+// endSpan() ends span, marking it failed if err isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// A tracedTransport records a span for each request, lasting until its
+// response has been read, as a child of the span in the request's context.
+type tracedTransport struct {
+	base http.RoundTripper
+}
+
+func (t *tracedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := tracer.Start(req.Context(), req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.request.method", req.Method),
+			attribute.String("server.address", req.URL.Host),
+			attribute.String("url.path", req.URL.Path),
+		))
+
+	res, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		endSpan(span, err)
+		return res, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", res.StatusCode))
+	if res.StatusCode >= 400 {
+		span.SetStatus(codes.Error, res.Status)
+	}
+	res.Body = &tracedBody{ReadCloser: res.Body, span: span}
+	return res, nil
+}
+
+// A tracedBody ends its request's span once it is closed.
+type tracedBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (b *tracedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(func() { b.span.End() })
+	return err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStopUpdates(t *testing.T) {
+	defer func() { running.stopped = false }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	finished, ok := startUpdate(cancel)
+	if !ok {
+		t.Fatal("startUpdate() refused an update before stopping")
+	}
+	returned := make(chan bool)
+	go func() {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		close(returned)
+		finished()
+	}()
+
+	if !stopUpdates(time.Second) {
+		t.Fatal("stopUpdates() = false, want true once the update returns")
+	}
+	select {
+	case <-returned:
+	default:
+		t.Error("stopUpdates() returned before the update did")
+	}
+	if _, ok := startUpdate(func() {}); ok {
+		t.Error("startUpdate() started an update after stopUpdates()")
+	}
+}
+
+func TestStopUpdatesTimeout(t *testing.T) {
+	defer func() { running.stopped = false }()
+
+	finished, _ := startUpdate(func() {})
+	defer finished()
+
+	if stopUpdates(10 * time.Millisecond) {
+		t.Error("stopUpdates() = true while an update ignoring cancelation still runs")
+	}
+}
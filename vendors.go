@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"github.com/jfmarket/report-cacher/report"
+)
+
+// breakOutVendors() splits the latest Sold Items report by vendor using the
+// mapping given by -vendors. Each vendor's sales are written to
+// vendors/<vendor>/sold_items.csv and what each is owed, after the market's
+// commission, to vendors/<vendor>/payout.csv and vendors/payouts.csv. The
+// files of vendors without sales in the report are kept; the portal doesn't
+// show their old payout as current.
+func breakOutVendors() error {
+	vendors, err := report.LoadVendors(*vendorsFile)
+	if err != nil {
+		return err
+	}
+
+	versions, err := cache.Versions("sold_items")
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		return errors.New("No sold items report has been downloaded yet")
+	}
+	latest := versions[len(versions)-1]
+
+	t, err := readTable(cache.FilePath(latest))
+	if err != nil {
+		return errors.New("Failed to read sold items report. " + err.Error())
+	}
+
+	for vendor, vt := range vendors.Split(t) {
+		data, err := vt.CSV()
+		if err != nil {
+			return errors.New("Failed to format sales for " + vendor + ". " + err.Error())
+		}
+
+		if err := cache.WriteFile("vendors/"+report.Slug(vendor)+"/sold_items.csv", data); err != nil {
+			return err
+		}
+	}
+
+	// Each vendor also gets their own payout so it can be shared with them
+	// without revealing what other vendors are paid.
+	payouts := vendors.Payouts(t, *commission)
+	for _, p := range payouts {
+		data, err := report.PayoutCSV([]report.Payout{p}, latest.Start, latest.End)
+		if err != nil {
+			return errors.New("Failed to format payout for " + p.Vendor + ". " + err.Error())
+		}
+
+		if err := cache.WriteFile("vendors/"+report.Slug(p.Vendor)+"/payout.csv", data); err != nil {
+			return err
+		}
+	}
+
+	data, err := report.PayoutCSV(payouts, latest.Start, latest.End)
+	if err != nil {
+		return errors.New("Failed to format payouts. " + err.Error())
+	}
+
+	return cache.WriteFile("vendors/payouts.csv", data)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"github.com/jfmarket/report-cacher/store"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBreakOutVendors(t *testing.T) {
+	dir := t.TempDir()
+	oldCache, oldVendors := cache, *vendorsFile
+	defer func() { cache, *vendorsFile = oldCache, oldVendors }()
+
+	var err error
+	if cache, err = store.New(filepath.Join(dir, "reports")); err != nil {
+		t.Fatal(err)
+	}
+	*vendorsFile = filepath.Join(dir, "vendors.csv")
+	ioutil.WriteFile(*vendorsFile, []byte("Vendor,Department\nHillside Farm,Produce\nBee Happy,Honey\n"), 0644)
+
+	// Bee Happy sold something last week but nothing this week.
+	old := filepath.Join(cache.Dir(), "vendors", "bee_happy", "payout.csv")
+	os.MkdirAll(filepath.Dir(old), 0755)
+	ioutil.WriteFile(old, []byte("From,To,Vendor,Sales\n2014-03-11,2014-03-18,Bee Happy,10.00\n"), 0644)
+
+	data := []byte("Item,Department,Quantity,Net Sales\nTomatoes,Produce,10,$20.00\n")
+	if _, err := cache.Save("sold_items", data, "2014-03-18", "2014-03-25"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := breakOutVendors(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(cache.Dir(), "vendors", "hillside_farm", "payout.csv")); err != nil {
+		t.Errorf("Hillside Farm's payout wasn't written. %v", err)
+	}
+
+	// Bee Happy's files are kept, but their payout isn't shown as current.
+	for _, tt := range []struct {
+		path    string
+		current bool
+	}{
+		{old, false},
+		{filepath.Join(cache.Dir(), "vendors", "hillside_farm", "payout.csv"), true},
+	} {
+		payout, err := readTable(tt.path)
+		if err != nil {
+			t.Fatalf("%s was removed. %v", tt.path, err)
+		}
+		if currentPayout(payout) != tt.current {
+			t.Errorf("currentPayout(%s) = %v, want %v", tt.path, !tt.current, tt.current)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// The version of the report-cacher, the commit it was built from and when,
+// set when building a release:
+//     go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+// Builds from a git checkout fill in the commit and date themselves.
+var (
+	version   = "dev"
+	commit    = ""
+	buildDate = ""
+)
+
+// buildInfo describes the running build of the report-cacher.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"` // When the commit was made if it wasn't set.
+	GoVersion string `json:"goVersion"`
+	Modified  bool   `json:"modified,omitempty"` // True when built with uncommitted changes.
+}
+
+// currentBuild() describes the running build.
+func currentBuild() buildInfo {
+	b := buildInfo{Version: version, Commit: commit, BuildDate: buildDate, GoVersion: runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if b.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		b.Version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch {
+		case s.Key == "vcs.revision" && b.Commit == "":
+			b.Commit = s.Value
+		case s.Key == "vcs.time" && b.BuildDate == "":
+			b.BuildDate = s.Value
+		case s.Key == "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+
+	return b
+}
+
+// String() formats the build for -version.
+//     report-cacher 1.4.0 (commit 9cb165b, built 2014-03-01T12:00:00Z, go1.22.1)
+func (b buildInfo) String() string {
+	c := b.Commit
+	if len(c) > 7 {
+		c = c[:7]
+	}
+	if c == "" {
+		c = "unknown"
+	}
+	if b.Modified {
+		c += "-modified"
+	}
+
+	date := b.BuildDate
+	if date == "" {
+		date = "unknown"
+	}
+
+	return fmt.Sprintf("report-cacher %s (commit %s, built %s, %s)", b.Version, c, date, b.GoVersion)
+}
+
+// versionHandler() reports which build of the report-cacher is running.
+//     GET /api/version
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writeJSON(w, currentBuild())
+}
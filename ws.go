@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"github.com/gorilla/websocket"
+	"github.com/jfmarket/report-cacher/report"
+	"net/http"
+	"time"
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+}
+
+// A message sent over the WebSocket: an event and, when deltas were
+// requested, how a refreshed report changed.
+type wsMessage struct {
+	event
+	Delta *report.Diff `json:"delta,omitempty"`
+}
+
+// websocketHandler() carries the same events as /api/events over a WebSocket.
+// With deltas=true, report.refreshed events also include the rows that were
+// added, removed and changed since the previous version.
+//     GET /api/ws?deltas=true
+func websocketHandler(w http.ResponseWriter, r *http.Request) {
+	deltas := r.FormValue("deltas") == "true"
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade() has already replied to the client.
+		return
+	}
+	defer conn.Close()
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	// Read from the connection so pings are answered and a close is noticed.
+	closed := make(chan bool)
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ping := time.NewTicker(30 * time.Second)
+	defer ping.Stop()
+
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			msg := wsMessage{event: e}
+			if deltas && e.Type == eventRefreshed {
+				if msg.Delta, err = versionDelta(e); err != nil {
+					logFor(r.Context()).Println("Failed to compute delta for " + e.Report + ". Error: " + err.Error())
+				}
+			}
+
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// Compares the version refreshed event e is about with the one before it.
+func versionDelta(e event) (*report.Diff, error) {
+	versions, err := cache.Versions(e.Report)
+	if err != nil {
+		return nil, err
+	}
+	i := len(versions) - 1
+	for e.Version != nil && i >= 0 && versions[i].Time.After(*e.Version) {
+		i--
+	}
+	if i < 1 {
+		return nil, errors.New("There is no earlier version to compare with")
+	}
+
+	a, err := readTable(cache.FilePath(versions[i-1]))
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := readTable(cache.FilePath(versions[i]))
+	if err != nil {
+		return nil, err
+	}
+
+	return report.Compare(a, b, lookupReport(e.Report).Key...), nil
+}